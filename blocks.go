@@ -0,0 +1,261 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/vemolista/chirpy/v2/internal/database"
+	"github.com/vemolista/chirpy/v2/internal/store"
+)
+
+// viewer returns the user making a request that may be anonymous, or
+// uuid.Nil if it has no valid token. A missing, invalid or expired token
+// only means the request is served as it would be to anyone, so that
+// public reads keep working for clients holding a stale token.
+func (cfg *apiConfig) viewer(r *http.Request) uuid.UUID {
+	userId, err := cfg.authenticate(r)
+	if err != nil {
+		return uuid.Nil
+	}
+
+	return userId
+}
+
+// hidesChirps reports whether the chirps of authorId are kept from viewerId:
+// always if the author blocked the viewer, and in timelines if the viewer
+// muted the author. A failed lookup is logged and hides the chirps.
+func (cfg *apiConfig) hidesChirps(ctx context.Context, viewerId, authorId uuid.UUID, timeline bool) bool {
+	if viewerId == uuid.Nil || viewerId == authorId {
+		return false
+	}
+
+	relationship, err := cfg.db.GetRelationship(ctx, database.GetRelationshipParams{
+		UserID:  viewerId,
+		OtherID: authorId,
+	})
+	if err != nil {
+		log.Printf("request %s: error getting relationship: %v", requestID(ctx), err)
+		return true
+	}
+
+	return relationship.BlockedBy || (timeline && relationship.Muting)
+}
+
+// relationshipFilter hides chirps from a stream or realtime connection as
+// hidesChirps does, but loads who blocked the viewer and who they muted
+// once, and again after a relationship event about them, rather than
+// querying for every event. It is not safe for concurrent use.
+type relationshipFilter struct {
+	cfg       *apiConfig
+	viewerId  uuid.UUID
+	loaded    bool
+	blockedBy map[uuid.UUID]bool
+	muting    map[uuid.UUID]bool
+}
+
+func (cfg *apiConfig) newRelationshipFilter(viewerId uuid.UUID) *relationshipFilter {
+	return &relationshipFilter{cfg: cfg, viewerId: viewerId}
+}
+
+// load reads the viewer's blockers and muted users, if it has any.
+func (f *relationshipFilter) load(ctx context.Context) error {
+	f.loaded = true
+	if f.viewerId == uuid.Nil {
+		return nil
+	}
+
+	blockers, err := f.cfg.db.ListBlockers(ctx, f.viewerId)
+	if err != nil {
+		f.loaded = false
+		return fmt.Errorf("error getting blockers: %w", err)
+	}
+
+	muted, err := f.cfg.db.ListMutedUsers(ctx, f.viewerId)
+	if err != nil {
+		f.loaded = false
+		return fmt.Errorf("error getting muted users: %w", err)
+	}
+
+	f.blockedBy = make(map[uuid.UUID]bool, len(blockers))
+	for _, id := range blockers {
+		f.blockedBy[id] = true
+	}
+
+	f.muting = make(map[uuid.UUID]bool, len(muted))
+	for _, id := range muted {
+		f.muting[id] = true
+	}
+
+	return nil
+}
+
+// changed has the filter reload before it next hides chirps, after a
+// relationship event about the viewer.
+func (f *relationshipFilter) changed() {
+	f.loaded = false
+}
+
+// hidesChirps reports whether the chirps of authorId are kept from the
+// viewer, like apiConfig.hidesChirps. Unlike it, a failed lookup is
+// returned: the caller ends the connection so that the client reconnects
+// instead of missing events.
+func (f *relationshipFilter) hidesChirps(ctx context.Context, authorId uuid.UUID, timeline bool) (bool, error) {
+	if !f.loaded {
+		err := f.load(ctx)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	return f.blockedBy[authorId] || (timeline && f.muting[authorId]), nil
+}
+
+// blockUserHandler blocks a user, who also stops following the caller and
+// is unfollowed by them, in the same transaction.
+func (cfg *apiConfig) blockUserHandler(w http.ResponseWriter, r *http.Request) {
+	userId, err := cfg.authenticate(r)
+	if err != nil {
+		respondWithError(w, r, err)
+		return
+	}
+
+	blockedId, err := parseUUID("userId", r.PathValue("userId"))
+	if err != nil {
+		respondWithError(w, r, err)
+		return
+	}
+
+	if blockedId == userId {
+		respondWithError(w, r, validationError("cannot_block_self", "Users cannot block themselves"))
+		return
+	}
+
+	err = cfg.db.InTx(r.Context(), func(ctx context.Context) error {
+		_, err := cfg.db.BlockUser(ctx, database.BlockUserParams{
+			BlockerID: userId,
+			BlockedID: blockedId,
+		})
+		if store.IsForeignKeyViolation(err) {
+			return notFoundError("user_not_found", "User does not exist", err)
+		}
+		if err != nil {
+			return internalError("Error blocking user", err)
+		}
+
+		for _, follow := range []database.UnfollowUserParams{
+			{FollowerID: blockedId, FolloweeID: userId},
+			{FollowerID: userId, FolloweeID: blockedId},
+		} {
+			err = cfg.db.UnfollowUser(ctx, follow)
+			if err != nil {
+				return internalError("Error unfollowing blocked user", err)
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		respondWithError(w, r, err)
+		return
+	}
+
+	cfg.publishEvent(r.Context(), relationshipEventChanged, blockedId, struct{}{})
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (cfg *apiConfig) unblockUserHandler(w http.ResponseWriter, r *http.Request) {
+	userId, err := cfg.authenticate(r)
+	if err != nil {
+		respondWithError(w, r, err)
+		return
+	}
+
+	blockedId, err := parseUUID("userId", r.PathValue("userId"))
+	if err != nil {
+		respondWithError(w, r, err)
+		return
+	}
+
+	err = cfg.db.UnblockUser(r.Context(), database.UnblockUserParams{
+		BlockerID: userId,
+		BlockedID: blockedId,
+	})
+	if err != nil {
+		respondWithError(w, r, internalError("Error unblocking user", err))
+		return
+	}
+
+	cfg.publishEvent(r.Context(), relationshipEventChanged, blockedId, struct{}{})
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// muteUserHandler hides a user's chirps from the caller's timelines,
+// without them knowing.
+func (cfg *apiConfig) muteUserHandler(w http.ResponseWriter, r *http.Request) {
+	userId, err := cfg.authenticate(r)
+	if err != nil {
+		respondWithError(w, r, err)
+		return
+	}
+
+	mutedId, err := parseUUID("userId", r.PathValue("userId"))
+	if err != nil {
+		respondWithError(w, r, err)
+		return
+	}
+
+	if mutedId == userId {
+		respondWithError(w, r, validationError("cannot_mute_self", "Users cannot mute themselves"))
+		return
+	}
+
+	_, err = cfg.db.MuteUser(r.Context(), database.MuteUserParams{
+		MuterID: userId,
+		MutedID: mutedId,
+	})
+	if err != nil {
+		if store.IsForeignKeyViolation(err) {
+			respondWithError(w, r, notFoundError("user_not_found", "User does not exist", err))
+			return
+		}
+
+		respondWithError(w, r, internalError("Error muting user", err))
+		return
+	}
+
+	cfg.publishEvent(r.Context(), relationshipEventChanged, userId, struct{}{})
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (cfg *apiConfig) unmuteUserHandler(w http.ResponseWriter, r *http.Request) {
+	userId, err := cfg.authenticate(r)
+	if err != nil {
+		respondWithError(w, r, err)
+		return
+	}
+
+	mutedId, err := parseUUID("userId", r.PathValue("userId"))
+	if err != nil {
+		respondWithError(w, r, err)
+		return
+	}
+
+	err = cfg.db.UnmuteUser(r.Context(), database.UnmuteUserParams{
+		MuterID: userId,
+		MutedID: mutedId,
+	})
+	if err != nil {
+		respondWithError(w, r, internalError("Error unmuting user", err))
+		return
+	}
+
+	cfg.publishEvent(r.Context(), relationshipEventChanged, userId, struct{}{})
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/google/uuid"
+	"github.com/vemolista/chirpy/v2/internal/database"
+	"github.com/vemolista/chirpy/v2/internal/entities"
+)
+
+// Entities are the parts of a chirp's body that clients link, by byte
+// offset into the body.
+type Entities struct {
+	Mentions []MentionEntity `json:"mentions"`
+	Hashtags []HashtagEntity `json:"hashtags"`
+	Urls     []UrlEntity     `json:"urls"`
+}
+
+type MentionEntity struct {
+	Start  int    `json:"start"`
+	End    int    `json:"end"`
+	Handle string `json:"handle"`
+	// UserId is the mentioned user, or nil if the handle matches nobody.
+	UserId *uuid.UUID `json:"user_id"`
+}
+
+type HashtagEntity struct {
+	Start int    `json:"start"`
+	End   int    `json:"end"`
+	Tag   string `json:"tag"`
+}
+
+type UrlEntity struct {
+	Start int    `json:"start"`
+	End   int    `json:"end"`
+	Url   string `json:"url"`
+}
+
+func newEntities(rows []database.ChirpEntity) Entities {
+	e := Entities{
+		Mentions: []MentionEntity{},
+		Hashtags: []HashtagEntity{},
+		Urls:     []UrlEntity{},
+	}
+
+	for _, row := range rows {
+		start, end := int(row.StartOffset), int(row.EndOffset)
+
+		switch row.Type {
+		case entities.Mention:
+			mention := MentionEntity{Start: start, End: end, Handle: row.Text}
+			if row.UserID.Valid {
+				mention.UserId = &row.UserID.UUID
+			}
+			e.Mentions = append(e.Mentions, mention)
+		case entities.Hashtag:
+			e.Hashtags = append(e.Hashtags, HashtagEntity{Start: start, End: end, Tag: row.Text})
+		case entities.URL:
+			e.Urls = append(e.Urls, UrlEntity{Start: start, End: end, Url: row.Text})
+		}
+	}
+
+	return e
+}
+
+// storeChirpEntities extracts the entities in a new or edited chirp and
+// stores them.
+func (cfg *apiConfig) storeChirpEntities(ctx context.Context, chirp database.Chirp) ([]database.ChirpEntity, error) {
+	var rows []database.ChirpEntity
+	mentioned := map[string]uuid.NullUUID{}
+	for _, entity := range entities.Extract(chirp.Body) {
+		row := database.ChirpEntity{
+			ChirpID:     chirp.ID,
+			Type:        entity.Type,
+			StartOffset: int32(entity.Start),
+			EndOffset:   int32(entity.End),
+			Text:        entity.Text,
+		}
+
+		if entity.Type == entities.Mention {
+			userId, ok := mentioned[entity.Text]
+			if !ok {
+				userId = cfg.resolveHandle(ctx, entity.Text, chirp.UserID)
+				mentioned[entity.Text] = userId
+			}
+			row.UserID = userId
+		}
+
+		rows = append(rows, row)
+	}
+
+	for _, row := range rows {
+		err := cfg.db.CreateChirpEntity(ctx, database.CreateChirpEntityParams(row))
+		if err != nil {
+			return rows, fmt.Errorf("error storing entities of chirp %s: %w", chirp.ID, err)
+		}
+	}
+
+	return rows, nil
+}
+
+// announceChirpEntities queues previews of the URLs in a chirp's entity
+// rows and, if the chirp is published, notifies the users it mentions.
+func (cfg *apiConfig) announceChirpEntities(ctx context.Context, chirp database.Chirp, rows, previous []database.ChirpEntity) {
+	if chirp.Status == chirpStatusPublished {
+		cfg.notifyMentions(ctx, chirp, rows, previous)
+	}
+
+	cfg.queueLinkPreviews(ctx, rows)
+}
+
+// notifyMentions notifies the users mentioned in a chirp's entity rows that
+// previous, the entities of the chirp before an edit, did not.
+func (cfg *apiConfig) notifyMentions(ctx context.Context, chirp database.Chirp, rows, previous []database.ChirpEntity) {
+	notified := map[uuid.UUID]bool{}
+	for _, row := range previous {
+		if row.Type == entities.Mention && row.UserID.Valid {
+			notified[row.UserID.UUID] = true
+		}
+	}
+
+	for _, row := range rows {
+		if row.Type != entities.Mention || !row.UserID.Valid || notified[row.UserID.UUID] {
+			continue
+		}
+
+		notified[row.UserID.UUID] = true
+		cfg.notify(ctx, row.UserID.UUID, chirp.UserID, notificationMention, uuid.NullUUID{UUID: chirp.ID, Valid: true})
+	}
+}
+
+// resolveHandle returns the user with the username handle whom a chirp by
+// authorId mentions, if there is one. A user who blocked the author is left
+// unresolved, so that the mention neither links to nor notifies them.
+// Lookup failures are logged and leave the mention unresolved.
+func (cfg *apiConfig) resolveHandle(ctx context.Context, handle string, authorId uuid.UUID) uuid.NullUUID {
+	user, err := cfg.db.GetUserByUsername(ctx, sql.NullString{String: handle, Valid: true})
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			log.Printf("request %s: error resolving @%s: %v", requestID(ctx), handle, err)
+		}
+		return uuid.NullUUID{}
+	}
+
+	if user.ID != authorId {
+		relationship, err := cfg.db.GetRelationship(ctx, database.GetRelationshipParams{
+			UserID:  authorId,
+			OtherID: user.ID,
+		})
+		if err != nil {
+			log.Printf("request %s: error resolving @%s: %v", requestID(ctx), handle, err)
+			return uuid.NullUUID{}
+		}
+
+		if relationship.BlockedBy {
+			return uuid.NullUUID{}
+		}
+	}
+
+	return uuid.NullUUID{UUID: user.ID, Valid: true}
+}
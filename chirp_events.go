@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/vemolista/chirpy/v2/internal/database"
+)
+
+// Event types, as sent in the event field of the stream. Notification
+// events share the event log with chirp events so that they reach every
+// replica the same way, but are only delivered to their recipient.
+// Relationship events are never delivered: they tell the streams and
+// realtime connections of their user to reload who blocked and who is
+// muted by them.
+const (
+	chirpEventCreated        = "chirp.created"
+	chirpEventDeleted        = "chirp.deleted"
+	notificationEventCreated = "notification.created"
+	relationshipEventChanged = "relationship.changed"
+)
+
+// chirpEventRetention is how long events are kept for clients resuming a
+// stream with Last-Event-ID.
+const chirpEventRetention = 24 * time.Hour
+
+// deletedChirp is the data of a chirp.deleted event.
+type deletedChirp struct {
+	Id     uuid.UUID `json:"id"`
+	UserId uuid.UUID `json:"user_id"`
+}
+
+// publishEvent records an event about userId and delivers it, for changes
+// that aren't made in a transaction with their event. A failure is logged
+// rather than failing the request, whose change has already been made.
+func (cfg *apiConfig) publishEvent(ctx context.Context, eventType string, userId uuid.UUID, data any) {
+	event, err := cfg.recordEvent(ctx, eventType, userId, data)
+	if err != nil {
+		log.Printf("request %s: %v", requestID(ctx), err)
+		return
+	}
+
+	cfg.deliverEvent(event)
+}
+
+// recordEvent adds an event about userId to the event log. Changes to
+// chirps record their event in the transaction that makes them and call
+// deliverEvent once it commits, so that streams get an event for every
+// change and for nothing that was rolled back.
+func (cfg *apiConfig) recordEvent(ctx context.Context, eventType string, userId uuid.UUID, data any) (database.ChirpEvent, error) {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return database.ChirpEvent{}, fmt.Errorf("error encoding %s event: %w", eventType, err)
+	}
+
+	event, err := cfg.db.CreateChirpEvent(ctx, database.CreateChirpEventParams{
+		Type:   eventType,
+		UserID: userId,
+		Data:   string(payload),
+	})
+	if err != nil {
+		return database.ChirpEvent{}, fmt.Errorf("error creating %s event: %w", eventType, err)
+	}
+
+	return event, nil
+}
+
+// deliverEvent delivers a recorded event to the streams of this replica.
+// With Postgres, the insert notifies every replica instead once it commits,
+// including this one.
+func (cfg *apiConfig) deliverEvent(event database.ChirpEvent) {
+	if !cfg.notifyEvents {
+		cfg.events.Publish(event)
+	}
+}
+
+// sweepChirpEvents deletes events older than chirpEventRetention every
+// interval until ctx is done.
+func (cfg *apiConfig) sweepChirpEvents(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		err := cfg.db.DeleteChirpEventsBefore(ctx, time.Now().UTC().Add(-chirpEventRetention))
+		if err != nil {
+			log.Printf("Error deleting old chirp events: %v", err)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/vemolista/chirpy/v2/internal/database"
+)
+
+// chirpCacheControl lets clients and shared caches keep chirps but makes
+// them revalidate every use, which is cheap with the ETags below.
+// privateChirpCacheControl does the same for chirps that depend on who is
+// asking, keeping them out of shared caches.
+const (
+	chirpCacheControl        = "public, no-cache"
+	privateChirpCacheControl = "private, no-cache"
+)
+
+// chirpsCacheControl returns the Cache-Control of chirps served to
+// viewerId. What a signed-in viewer sees depends on their blocks, mutes
+// and votes, and a poll shows its results to those who voted, so only
+// anonymous responses without polls are public.
+func chirpsCacheControl(viewerId uuid.UUID, chirps []Chirp) string {
+	if viewerId != uuid.Nil {
+		return privateChirpCacheControl
+	}
+
+	for _, chirp := range chirps {
+		if chirp.Poll != nil {
+			return privateChirpCacheControl
+		}
+	}
+
+	return chirpCacheControl
+}
+
+// chirpETag is a weak validator for a chirp as one viewer sees it. It
+// starts with the version of the stored chirp, for checkIfMatch, and ends
+// with a hash of the whole representation, which also changes when a link
+// preview is fetched, the author edits their profile or a poll gets votes.
+func chirpETag(chirp Chirp) string {
+	return `W/"` + chirpVersion(chirp.Id, chirp.UpdatedAt) + "-" + representationHash(chirp) + `"`
+}
+
+// chirpsETag is a weak validator for a list of chirps as one viewer sees
+// it, a hash of the whole representation.
+func chirpsETag(chirps []Chirp) string {
+	return `W/"` + representationHash(chirps) + `"`
+}
+
+// chirpVersion identifies a version of a stored chirp.
+func chirpVersion(id uuid.UUID, updatedAt time.Time) string {
+	return fmt.Sprintf("%s-%x", id, updatedAt.UnixNano())
+}
+
+// representationHash hashes the JSON encoding of a response.
+func representationHash(response any) string {
+	hash := sha256.New()
+	json.NewEncoder(hash).Encode(response)
+
+	return hex.EncodeToString(hash.Sum(nil)[:16])
+}
+
+// notModified sets the validator and Cache-Control header for a
+// representation and, if the request's If-None-Match shows the client already has it,
+// responds with 304 and reports true. Chirps have no Last-Modified: their
+// authors and link previews change without updated_at, and deleting a
+// chirp changes a list without making anything in it newer.
+func notModified(w http.ResponseWriter, r *http.Request, etag, cacheControl string) bool {
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", cacheControl)
+
+	inm := r.Header.Get("If-None-Match")
+	if inm == "" || !etagMatches(inm, etag) {
+		return false
+	}
+
+	w.WriteHeader(http.StatusNotModified)
+	return true
+}
+
+// checkIfMatch enforces an If-Match header against the current version of
+// a chirp, so a client can only change the version it has seen. Chirp
+// ETags are weak, so rather than comparing them whole, a tag matches when
+// it starts with the chirp's version: a link preview or profile change
+// since the client read the chirp doesn't conflict with an edit.
+func checkIfMatch(r *http.Request, chirp database.Chirp) error {
+	ifMatch := r.Header.Get("If-Match")
+	if ifMatch == "" || strings.TrimSpace(ifMatch) == "*" {
+		return nil
+	}
+
+	version := chirpVersion(chirp.ID, chirp.UpdatedAt)
+	for _, candidate := range strings.Split(ifMatch, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if strings.HasPrefix(candidate, `"`+version+"-") {
+			return nil
+		}
+	}
+
+	return preconditionFailedError()
+}
+
+func preconditionFailedError() *apiError {
+	return &apiError{
+		Status: http.StatusPreconditionFailed,
+		Code:   "precondition_failed",
+		Detail: "The chirp has changed since it was read",
+	}
+}
+
+// etagMatches reports whether the comma-separated list of entity tags in
+// header, or "*", matches etag by weak comparison, as If-None-Match does.
+func etagMatches(header, etag string) bool {
+	if strings.TrimSpace(header) == "*" {
+		return true
+	}
+
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, candidate := range strings.Split(header, ",") {
+		if strings.TrimPrefix(strings.TrimSpace(candidate), "W/") == etag {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/vemolista/chirpy/v2/internal/database"
+)
+
+// deletedChirpForModerator returns a deleted chirp if the request is from a
+// moderator, and sql.ErrNoRows otherwise, so that deleted chirps look
+// missing to everyone else.
+func (cfg *apiConfig) deletedChirpForModerator(r *http.Request, id uuid.UUID) (database.Chirp, error) {
+	moderator, err := cfg.isModerator(r)
+	if err != nil {
+		return database.Chirp{}, err
+	}
+
+	if !moderator {
+		return database.Chirp{}, sql.ErrNoRows
+	}
+
+	return cfg.db.GetDeletedChirp(r.Context(), id)
+}
+
+// restoreChirpHandler undeletes a chirp its author deleted within the
+// restore window. Chirps hidden by a moderator stay deleted.
+func (cfg *apiConfig) restoreChirpHandler(w http.ResponseWriter, r *http.Request) {
+	userId, err := cfg.authenticate(r)
+	if err != nil {
+		respondWithError(w, r, err)
+		return
+	}
+
+	chirpId, err := parseUUID("chirpId", r.PathValue("chirpId"))
+	if err != nil {
+		respondWithError(w, r, err)
+		return
+	}
+
+	notFound := notFoundError("chirp_not_found", fmt.Sprintf("No deleted chirp with Id %s", chirpId), nil)
+
+	chirpData, err := cfg.db.GetDeletedChirp(r.Context(), chirpId)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && chirpData.UserID != userId) {
+		respondWithError(w, r, notFound)
+		return
+	}
+	if err != nil {
+		respondWithError(w, r, internalError("Error getting chirp from db", err))
+		return
+	}
+
+	if chirpData.Hidden {
+		respondWithError(w, r, forbiddenError("chirp_hidden", "Chirp was hidden by a moderator"))
+		return
+	}
+
+	deletedAfter := time.Now().UTC().Add(-cfg.chirps.RestoreWindow)
+	if !chirpData.DeletedAt.Time.After(deletedAfter) {
+		respondWithError(w, r, conflictError("restore_window_expired", "Chirp was deleted too long ago to restore", nil))
+		return
+	}
+
+	var chirp Chirp
+	var event database.ChirpEvent
+	err = cfg.db.InTx(r.Context(), func(ctx context.Context) error {
+		restored, err := cfg.db.RestoreChirp(ctx, database.RestoreChirpParams{
+			ID:           chirpData.ID,
+			DeletedAfter: deletedAfter,
+		})
+		if errors.Is(err, sql.ErrNoRows) {
+			// Restored or purged since we read it.
+			return notFound
+		}
+		if err != nil {
+			return internalError("Error restoring chirp", err)
+		}
+
+		// The chirp is also announced to everyone, so its poll is shown to
+		// nobody in particular.
+		chirps, err := cfg.chirpResponses(ctx, []database.Chirp{restored}, uuid.Nil)
+		if err != nil {
+			return internalError("Error getting chirp", err)
+		}
+
+		chirp = chirps[0]
+		if chirp.Status == chirpStatusPublished {
+			event, err = cfg.recordEvent(ctx, chirpEventCreated, userId, chirp)
+			if err != nil {
+				return internalError("Error creating chirp event", err)
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		respondWithError(w, r, err)
+		return
+	}
+
+	if chirp.Status == chirpStatusPublished {
+		cfg.deliverEvent(event)
+	}
+
+	w.Header().Set("ETag", chirpETag(chirp))
+	respondWithJson(w, http.StatusOK, chirp)
+}
+
+// purgeDeletedChirps deletes for good the chirps deleted longer than the
+// retention period ago, every interval until ctx is done. Their media are
+// left unattached and swept by sweepMedia.
+func (cfg *apiConfig) purgeDeletedChirps(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		purged, err := cfg.db.PurgeDeletedChirps(ctx, time.Now().UTC().Add(-cfg.chirps.DeletedRetention))
+		if err != nil {
+			log.Printf("Error purging deleted chirps: %v", err)
+			continue
+		}
+
+		if purged > 0 {
+			log.Printf("Purged %d deleted chirps", purged)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// apiError is an error that knows how it should be shown to a client. Code
+// is a stable, machine-readable identifier; Detail is for humans and may
+// change. Err is the underlying cause and is only ever logged.
+type apiError struct {
+	Status int
+	Code   string
+	Detail string
+	Fields []fieldError
+	Err    error
+}
+
+// fieldError describes a problem with one field of a request.
+type fieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *apiError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("%s: %s: %v", e.Code, e.Detail, e.Err)
+	}
+
+	return fmt.Sprintf("%s: %s", e.Code, e.Detail)
+}
+
+func (e *apiError) Unwrap() error {
+	return e.Err
+}
+
+// validationError is a 400 for a request that is malformed or breaks a rule.
+func validationError(code, detail string, fields ...fieldError) *apiError {
+	return &apiError{Status: http.StatusBadRequest, Code: code, Detail: detail, Fields: fields}
+}
+
+// unauthorizedError is a 401 for a missing or invalid credential.
+func unauthorizedError(code, detail string, err error) *apiError {
+	return &apiError{Status: http.StatusUnauthorized, Code: code, Detail: detail, Err: err}
+}
+
+// forbiddenError is a 403 for an authenticated caller who may not do this.
+func forbiddenError(code, detail string) *apiError {
+	return &apiError{Status: http.StatusForbidden, Code: code, Detail: detail}
+}
+
+// suspendedError is returned to suspended users for anything that needs
+// them to be logged in.
+func suspendedError() *apiError {
+	return forbiddenError("account_suspended", "This account is suspended")
+}
+
+func notFoundError(code, detail string, err error) *apiError {
+	return &apiError{Status: http.StatusNotFound, Code: code, Detail: detail, Err: err}
+}
+
+func conflictError(code, detail string, err error) *apiError {
+	return &apiError{Status: http.StatusConflict, Code: code, Detail: detail, Err: err}
+}
+
+// internalError hides err from the client behind a generic 500.
+func internalError(detail string, err error) *apiError {
+	return &apiError{Status: http.StatusInternalServerError, Code: "internal_error", Detail: detail, Err: err}
+}
+
+// asAPIError maps any error onto an apiError, treating unknown errors as
+// internal ones.
+func asAPIError(err error) *apiError {
+	var apiErr *apiError
+	if errors.As(err, &apiErr) {
+		return apiErr
+	}
+
+	return internalError("Something went wrong", err)
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/vemolista/chirpy/v2/internal/database"
+	"github.com/vemolista/chirpy/v2/internal/store"
+)
+
+const (
+	defaultBookmarksLimit = 20
+	maxBookmarksLimit     = 100
+)
+
+// bookmarkChirpHandler privately bookmarks a published chirp.
+func (cfg *apiConfig) bookmarkChirpHandler(w http.ResponseWriter, r *http.Request) {
+	userId, err := cfg.authenticate(r)
+	if err != nil {
+		respondWithError(w, r, err)
+		return
+	}
+
+	chirpId, err := parseUUID("chirpId", r.PathValue("chirpId"))
+	if err != nil {
+		respondWithError(w, r, err)
+		return
+	}
+
+	notFound := notFoundError("chirp_not_found", fmt.Sprintf("No chirp with Id %s", chirpId), nil)
+
+	chirp, err := cfg.db.GetChirp(r.Context(), chirpId)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && (chirp.Status != chirpStatusPublished || !cfg.canSeeChirp(r, chirp))) {
+		respondWithError(w, r, notFound)
+		return
+	}
+	if err != nil {
+		respondWithError(w, r, internalError("Error getting chirp from db", err))
+		return
+	}
+
+	_, err = cfg.db.BookmarkChirp(r.Context(), database.BookmarkChirpParams{
+		UserID:  userId,
+		ChirpID: chirp.ID,
+	})
+	if store.IsForeignKeyViolation(err) {
+		// Purged since we read it.
+		respondWithError(w, r, notFound)
+		return
+	}
+	if err != nil {
+		respondWithError(w, r, internalError("Error bookmarking chirp", err))
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (cfg *apiConfig) unbookmarkChirpHandler(w http.ResponseWriter, r *http.Request) {
+	userId, err := cfg.authenticate(r)
+	if err != nil {
+		respondWithError(w, r, err)
+		return
+	}
+
+	chirpId, err := parseUUID("chirpId", r.PathValue("chirpId"))
+	if err != nil {
+		respondWithError(w, r, err)
+		return
+	}
+
+	err = cfg.db.UnbookmarkChirp(r.Context(), database.UnbookmarkChirpParams{
+		UserID:  userId,
+		ChirpID: chirpId,
+	})
+	if err != nil {
+		respondWithError(w, r, internalError("Error removing bookmark", err))
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// listBookmarksHandler lists the user's bookmarked chirps, newest bookmark
+// first, a page at a time.
+func (cfg *apiConfig) listBookmarksHandler(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		Bookmarks []Chirp `json:"bookmarks"`
+		// NextBefore is the before parameter for the next page, if any.
+		NextBefore *uuid.UUID `json:"next_before,omitempty"`
+	}
+
+	userId, err := cfg.authenticate(r)
+	if err != nil {
+		respondWithError(w, r, err)
+		return
+	}
+
+	limit, err := parseLimit(r, defaultBookmarksLimit, maxBookmarksLimit)
+	if err != nil {
+		respondWithError(w, r, err)
+		return
+	}
+
+	var chirpsData []database.Chirp
+	if value := r.URL.Query().Get("before"); value != "" {
+		beforeId, err := parseUUID("before", value)
+		if err != nil {
+			respondWithError(w, r, err)
+			return
+		}
+
+		before, err := cfg.db.GetBookmark(r.Context(), database.GetBookmarkParams{
+			UserID:  userId,
+			ChirpID: beforeId,
+		})
+		if errors.Is(err, sql.ErrNoRows) {
+			respondWithError(w, r, validationError("invalid_query", "Unknown before parameter", fieldError{
+				Field:   "before",
+				Code:    "not_found",
+				Message: "must be the id of a chirp you bookmarked",
+			}))
+			return
+		}
+		if err != nil {
+			respondWithError(w, r, internalError("Error getting bookmark", err))
+			return
+		}
+
+		chirpsData, err = cfg.db.ListBookmarkedChirpsBefore(r.Context(), database.ListBookmarkedChirpsBeforeParams{
+			UserID:    userId,
+			Limit:     int32(limit),
+			CreatedAt: before.CreatedAt,
+			ChirpID:   before.ChirpID,
+		})
+		if err != nil {
+			respondWithError(w, r, internalError("Error getting bookmarks", err))
+			return
+		}
+	} else {
+		chirpsData, err = cfg.db.ListBookmarkedChirps(r.Context(), database.ListBookmarkedChirpsParams{
+			UserID: userId,
+			Limit:  int32(limit),
+		})
+		if err != nil {
+			respondWithError(w, r, internalError("Error getting bookmarks", err))
+			return
+		}
+	}
+
+	chirps, err := cfg.chirpResponses(r.Context(), chirpsData, userId)
+	if err != nil {
+		respondWithError(w, r, internalError("Error getting bookmarks", err))
+		return
+	}
+
+	res := response{Bookmarks: chirps}
+	if len(chirpsData) == limit {
+		res.NextBefore = &chirpsData[len(chirpsData)-1].ID
+	}
+
+	respondWithJson(w, http.StatusOK, res)
+}
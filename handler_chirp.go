@@ -1,17 +1,17 @@
 package main
 
 import (
+	"context"
 	"database/sql"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
 	"sort"
 	"strings"
 	"time"
+	"unicode/utf8"
 
 	"github.com/google/uuid"
-	"github.com/vemolista/chirpy/v2/internal/auth"
 	"github.com/vemolista/chirpy/v2/internal/database"
 )
 
@@ -21,66 +21,260 @@ type Chirp struct {
 	UpdatedAt time.Time `json:"updatedAt"`
 	UserId    uuid.UUID `json:"user_id"`
 	Body      string    `json:"body"`
+	// Status is draft, scheduled or published. PublishAt is when a
+	// scheduled chirp is, or was, due.
+	Status    string     `json:"status"`
+	PublishAt *time.Time `json:"publish_at"`
+	// DeletedAt is only set on deleted chirps, which only moderators see.
+	DeletedAt *time.Time `json:"deleted_at"`
+	Author    Author     `json:"author"`
+	Entities  Entities   `json:"entities"`
+	Media     []Media    `json:"media"`
+	// LinkPreviews are fetched in the background after the chirp is
+	// saved, so they may appear later.
+	LinkPreviews []LinkPreview `json:"link_previews"`
+	// Pinned is only set in listings of the author's chirps.
+	Pinned bool `json:"pinned,omitempty"`
+	// Poll depends on who is asking, see Poll.
+	Poll *Poll `json:"poll,omitempty"`
+}
+
+func newChirp(chirp database.Chirp, entities []database.ChirpEntity) Chirp {
+	response := Chirp{
+		Id:           chirp.ID,
+		CreatedAt:    chirp.CreatedAt,
+		UpdatedAt:    chirp.UpdatedAt,
+		UserId:       chirp.UserID,
+		Body:         chirp.Body,
+		Status:       chirp.Status,
+		Entities:     newEntities(entities),
+		Media:        []Media{},
+		LinkPreviews: []LinkPreview{},
+	}
+	if chirp.PublishAt.Valid {
+		response.PublishAt = &chirp.PublishAt.Time
+	}
+	if chirp.DeletedAt.Valid {
+		response.DeletedAt = &chirp.DeletedAt.Time
+	}
+
+	return response
+}
+
+// chirpResponses returns the API representation of chirps, with their
+// authors, media, link previews, polls and the entities stored for them.
+// viewerId is the user the polls are shown to, or uuid.Nil.
+func (cfg *apiConfig) chirpResponses(ctx context.Context, chirps []database.Chirp, viewerId uuid.UUID) ([]Chirp, error) {
+	ids := make([]uuid.UUID, len(chirps))
+	for i, chirp := range chirps {
+		ids[i] = chirp.ID
+	}
+
+	rows, err := cfg.db.ListChirpEntities(ctx, ids)
+	if err != nil {
+		return nil, fmt.Errorf("error getting chirp entities: %w", err)
+	}
+
+	authors, err := cfg.authors(ctx, chirps)
+	if err != nil {
+		return nil, fmt.Errorf("error getting chirp authors: %w", err)
+	}
+
+	media, err := cfg.db.ListMediaForChirps(ctx, ids)
+	if err != nil {
+		return nil, fmt.Errorf("error getting chirp media: %w", err)
+	}
+
+	previews, err := cfg.storedLinkPreviews(ctx, rows)
+	if err != nil {
+		return nil, fmt.Errorf("error getting link previews: %w", err)
+	}
+
+	polls, err := cfg.chirpPolls(ctx, ids, viewerId)
+	if err != nil {
+		return nil, err
+	}
+
+	mediaByChirp := map[uuid.UUID][]Media{}
+	for _, item := range media {
+		mediaByChirp[item.ChirpID.UUID] = append(mediaByChirp[item.ChirpID.UUID], newMedia(item))
+	}
+
+	byChirp := map[uuid.UUID][]database.ChirpEntity{}
+	for _, row := range rows {
+		byChirp[row.ChirpID] = append(byChirp[row.ChirpID], row)
+	}
+
+	response := make([]Chirp, len(chirps))
+	for i, chirp := range chirps {
+		response[i] = newChirp(chirp, byChirp[chirp.ID])
+		response[i].Author = authors[chirp.UserID]
+		response[i].LinkPreviews = chirpLinkPreviews(byChirp[chirp.ID], previews)
+		response[i].Poll = polls[chirp.ID]
+		if attached, ok := mediaByChirp[chirp.ID]; ok {
+			response[i].Media = attached
+		}
+	}
+
+	return response, nil
 }
 
 func (cfg *apiConfig) createChirpHandler(w http.ResponseWriter, r *http.Request) {
 	type parameters struct {
-		Body string `json:"body"`
+		Body     string      `json:"body" validate:"required"`
+		MediaIds []uuid.UUID `json:"media_ids"`
+		// Status defaults to scheduled when PublishAt is set and to
+		// published otherwise.
+		Status    string          `json:"status"`
+		PublishAt *time.Time      `json:"publish_at"`
+		Poll      *pollParameters `json:"poll"`
 	}
 
 	type response struct {
 		Chirp
 	}
 
-	token, err := auth.GetBearerToken(r.Header)
+	userId, err := cfg.authenticate(r)
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Error getting Bearer token", err)
+		respondWithError(w, r, err)
 		return
 	}
 
-	userId, err := auth.ValidateJWT(token, cfg.secret)
+	params := parameters{}
+	err = decodeJSON(w, r, &params)
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Error validating JWT", err)
+		respondWithError(w, r, err)
 		return
 	}
 
-	decoder := json.NewDecoder(r.Body)
-	params := parameters{}
-	err = decoder.Decode(&params)
+	err = checkChirpLength(params.Body)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Error decoding JSON", err)
+		respondWithError(w, r, err)
 		return
 	}
 
-	if len(params.Body) > 141 {
-		respondWithError(w, http.StatusBadRequest, "Chirp is too long", nil)
+	status, publishAt, err := chirpSchedule(params.Status, params.PublishAt, time.Now())
+	if err != nil {
+		respondWithError(w, r, err)
 		return
 	}
 
-	bad_words := []string{"kerfuffle", "sharbert", "fornax"}
-	cleaned_chirp := cleanChirp(bad_words, params.Body)
+	if params.Poll != nil {
+		err = checkPoll(params.Poll, status, publishAt, time.Now())
+		if err != nil {
+			respondWithError(w, r, err)
+			return
+		}
+	}
 
-	chirp, err := cfg.db.CreateChirp(r.Context(), database.CreateChirpParams{
-		Body:   cleaned_chirp,
-		UserID: userId,
-	})
+	author, err := cfg.db.GetUser(r.Context(), userId)
+	if err != nil {
+		respondWithError(w, r, internalError("Error getting chirp author", err))
+		return
+	}
 
+	attachments, err := cfg.checkAttachments(r.Context(), userId, params.MediaIds)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Error creating chirp", err)
+		respondWithError(w, r, err)
 		return
 	}
 
+	cleaned_chirp := cleanChirp(badWords, params.Body)
+
+	var chirp database.Chirp
+	var rows []database.ChirpEntity
+	var created Chirp
+	var event database.ChirpEvent
+	err = cfg.db.InTx(r.Context(), func(ctx context.Context) error {
+		var err error
+		chirp, err = cfg.db.CreateChirp(ctx, database.CreateChirpParams{
+			Body:      cleaned_chirp,
+			UserID:    userId,
+			Status:    status,
+			PublishAt: publishAt,
+		})
+		if err != nil {
+			return internalError("Error creating chirp", err)
+		}
+
+		err = cfg.attachMedia(ctx, chirp, attachments)
+		if err != nil {
+			return err
+		}
+
+		var poll *Poll
+		if params.Poll != nil {
+			poll, err = cfg.createPoll(ctx, chirp, params.Poll)
+			if err != nil {
+				return err
+			}
+		}
+
+		rows, err = cfg.storeChirpEntities(ctx, chirp)
+		if err != nil {
+			return internalError("Error storing chirp entities", err)
+		}
+
+		created = newChirp(chirp, rows)
+		created.Author = newAuthor(author)
+		created.LinkPreviews = cfg.knownLinkPreviews(ctx, rows)
+		for _, attachment := range attachments {
+			created.Media = append(created.Media, newMedia(attachment))
+		}
+		created.Poll = poll
+
+		// Drafts and scheduled chirps are announced when they are
+		// published.
+		if chirp.Status == chirpStatusPublished {
+			event, err = cfg.recordEvent(ctx, chirpEventCreated, userId, created)
+			if err != nil {
+				return internalError("Error creating chirp event", err)
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		respondWithError(w, r, err)
+		return
+	}
+
+	if chirp.Status == chirpStatusPublished {
+		cfg.deliverEvent(event)
+	}
+	cfg.announceChirpEntities(r.Context(), chirp, rows, nil)
+
+	if cleaned_chirp != params.Body {
+		cfg.reportFilteredChirp(r.Context(), chirp, params.Body)
+	}
+
+	w.Header().Set("ETag", chirpETag(created))
 	respondWithJson(w, http.StatusCreated, response{
-		Chirp: Chirp{
-			Id:        chirp.ID,
-			CreatedAt: chirp.CreatedAt,
-			UpdatedAt: chirp.UpdatedAt,
-			UserId:    chirp.UserID,
-			Body:      chirp.Body,
-		},
+		Chirp: created,
 	})
 }
 
+// maxChirpLength is in characters, not bytes.
+const maxChirpLength = 141
+
+// checkChirpLength rejects an over-length chirp body with the
+// chirp_too_long code clients rely on, rather than the validation_failed of
+// a max rule.
+func checkChirpLength(body string) error {
+	if utf8.RuneCountInString(body) > maxChirpLength {
+		return validationError("chirp_too_long", "Chirp is too long", fieldError{
+			Field:   "body",
+			Code:    "max_length",
+			Message: fmt.Sprintf("must be at most %d characters", maxChirpLength),
+		})
+	}
+
+	return nil
+}
+
+// badWords are censored out of chirps by cleanChirp.
+var badWords = []string{"kerfuffle", "sharbert", "fornax"}
+
 func cleanChirp(bad_words []string, chirp string) string {
 	tokens := strings.Split(chirp, " ")
 	for i, token := range tokens {
@@ -95,37 +289,39 @@ func cleanChirp(bad_words []string, chirp string) string {
 }
 
 func (cfg *apiConfig) listChirpsHandler(w http.ResponseWriter, r *http.Request) {
-	chirpsData, err := cfg.db.ListChirps(r.Context())
-	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Error getting chirps", err)
-		return
-	}
-
 	authorId := uuid.Nil
 	authorIdString := r.URL.Query().Get("author_id")
 	if authorIdString != "" {
-		authorId, err = uuid.Parse(authorIdString)
+		var err error
+		authorId, err = parseUUID("author_id", authorIdString)
 		if err != nil {
-			respondWithError(w, http.StatusBadRequest, "Error parsing uuid", err)
+			respondWithError(w, r, err)
 			return
 		}
 	}
 
-	response := []Chirp{}
-	for _, item := range chirpsData {
-		if authorId != uuid.Nil && item.UserID != authorId {
-			continue
-		}
+	viewerId := cfg.viewer(r)
 
-		chirp := Chirp{
-			Id:        item.ID,
-			CreatedAt: item.CreatedAt,
-			UpdatedAt: item.UpdatedAt,
-			UserId:    item.UserID,
-			Body:      item.Body,
-		}
+	// Muted users are left out of the timeline, but not when asked for.
+	var chirpsData []database.Chirp
+	var err error
+	if authorId != uuid.Nil {
+		chirpsData, err = cfg.db.ListChirpsForAuthor(r.Context(), database.ListChirpsForAuthorParams{
+			UserID:   authorId,
+			ViewerID: viewerId,
+		})
+	} else {
+		chirpsData, err = cfg.db.ListChirps(r.Context(), viewerId)
+	}
+	if err != nil {
+		respondWithError(w, r, internalError("Error getting chirps", err))
+		return
+	}
 
-		response = append(response, chirp)
+	response, err := cfg.chirpResponses(r.Context(), chirpsData, viewerId)
+	if err != nil {
+		respondWithError(w, r, internalError("Error getting chirps", err))
+		return
 	}
 
 	sortParam := r.URL.Query().Get("sort")
@@ -135,79 +331,277 @@ func (cfg *apiConfig) listChirpsHandler(w http.ResponseWriter, r *http.Request)
 		})
 	}
 
+	if authorId != uuid.Nil {
+		response, err = cfg.pinFirst(r.Context(), authorId, response)
+		if err != nil {
+			respondWithError(w, r, internalError("Error getting pinned chirp", err))
+			return
+		}
+	}
+
+	if notModified(w, r, chirpsETag(response), chirpsCacheControl(viewerId, response)) {
+		return
+	}
+
 	respondWithJson(w, http.StatusOK, response)
 }
 
 func (cfg *apiConfig) getChirpHandler(w http.ResponseWriter, r *http.Request) {
 	id := r.PathValue("chirpId")
 
-	parsedId, err := uuid.Parse(id)
+	parsedId, err := parseUUID("chirpId", id)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Error parsing ID of chirp", err)
+		respondWithError(w, r, err)
 		return
 	}
 
 	data, err := cfg.db.GetChirp(r.Context(), parsedId)
+	if errors.Is(err, sql.ErrNoRows) {
+		data, err = cfg.deletedChirpForModerator(r, parsedId)
+	}
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && !cfg.canSeeChirp(r, data)) {
+		respondWithError(w, r, notFoundError("chirp_not_found", fmt.Sprintf("No chirp with Id %s", id), nil))
+		return
+	}
+	if err != nil {
+		respondWithError(w, r, internalError("Error getting chirp", err))
+		return
+	}
+
+	viewerId := cfg.viewer(r)
+
+	chirps, err := cfg.chirpResponses(r.Context(), []database.Chirp{data}, viewerId)
+	if err != nil {
+		respondWithError(w, r, internalError("Error getting chirp", err))
+		return
+	}
+
+	chirp := chirps[0]
+	if chirp.Status != chirpStatusPublished || chirp.DeletedAt != nil || chirp.Poll != nil {
+		// Only the author or moderators see it, or its poll changes with
+		// votes and depends on the viewer, so shared caches must not keep
+		// it.
+		w.Header().Set("ETag", chirpETag(chirp))
+		w.Header().Set("Cache-Control", "private, no-store")
+		respondWithJson(w, http.StatusOK, chirp)
+		return
+	}
+
+	if notModified(w, r, chirpETag(chirp), chirpsCacheControl(viewerId, chirps)) {
+		return
+	}
+
+	respondWithJson(w, http.StatusOK, chirp)
+}
+
+func (cfg *apiConfig) updateChirpHandler(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Body string `json:"body" validate:"required"`
+	}
+
+	userId, err := cfg.authenticate(r)
+	if err != nil {
+		respondWithError(w, r, err)
+		return
+	}
+
+	chirpId, err := parseUUID("chirpId", r.PathValue("chirpId"))
+	if err != nil {
+		respondWithError(w, r, err)
+		return
+	}
+
+	params := parameters{}
+	err = decodeJSON(w, r, &params)
+	if err != nil {
+		respondWithError(w, r, err)
+		return
+	}
+
+	err = checkChirpLength(params.Body)
+	if err != nil {
+		respondWithError(w, r, err)
+		return
+	}
+
+	chirpData, err := cfg.db.GetChirp(r.Context(), chirpId)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			respondWithError(w, http.StatusNotFound, fmt.Sprintf("No chirp with Id %s", id), err)
+			respondWithError(w, r, notFoundError("chirp_not_found", "Chirp does not exist", nil))
 			return
 		}
 
-		respondWithError(w, http.StatusInternalServerError, "Something went wrong", err)
+		respondWithError(w, r, internalError("Error getting chirp from db", err))
 		return
 	}
 
-	respondWithJson(w, http.StatusOK, Chirp{
-		Id:        data.ID,
-		UserId:    data.UserID,
-		CreatedAt: data.CreatedAt,
-		UpdatedAt: data.UpdatedAt,
-		Body:      data.Body,
+	if chirpData.UserID != userId {
+		if chirpData.Status != chirpStatusPublished {
+			respondWithError(w, r, notFoundError("chirp_not_found", "Chirp does not exist", nil))
+			return
+		}
+
+		respondWithError(w, r, forbiddenError("not_chirp_author", "Cannot edit chirps of other users"))
+		return
+	}
+
+	err = checkIfMatch(r, chirpData)
+	if err != nil {
+		respondWithError(w, r, err)
+		return
+	}
+
+	author, err := cfg.db.GetUser(r.Context(), userId)
+	if err != nil {
+		respondWithError(w, r, internalError("Error getting chirp author", err))
+		return
+	}
+
+	attachments, err := cfg.db.ListMediaForChirps(r.Context(), []uuid.UUID{chirpData.ID})
+	if err != nil {
+		respondWithError(w, r, internalError("Error getting chirp media", err))
+		return
+	}
+
+	cleaned := cleanChirp(badWords, params.Body)
+
+	var updated database.Chirp
+	var rows, previous []database.ChirpEntity
+	err = cfg.db.InTx(r.Context(), func(ctx context.Context) error {
+		var err error
+		updated, err = cfg.db.UpdateChirp(ctx, database.UpdateChirpParams{
+			ID:        chirpData.ID,
+			Body:      cleaned,
+			UpdatedAt: chirpData.UpdatedAt,
+		})
+		if errors.Is(err, sql.ErrNoRows) {
+			// Someone else changed or deleted the chirp since we read it.
+			return preconditionFailedError()
+		}
+		if err != nil {
+			return internalError("Error updating chirp", err)
+		}
+
+		previous, err = cfg.db.ListChirpEntities(ctx, []uuid.UUID{updated.ID})
+		if err != nil {
+			return internalError("Error getting chirp entities", err)
+		}
+
+		err = cfg.db.DeleteChirpEntities(ctx, updated.ID)
+		if err != nil {
+			return internalError("Error updating chirp entities", err)
+		}
+
+		rows, err = cfg.storeChirpEntities(ctx, updated)
+		if err != nil {
+			return internalError("Error storing chirp entities", err)
+		}
+
+		return nil
 	})
-}
+	if err != nil {
+		respondWithError(w, r, err)
+		return
+	}
 
-func (cfg *apiConfig) deleteChirpHandler(w http.ResponseWriter, r *http.Request) {
-	accessToken, err := auth.GetBearerToken(r.Header)
+	cfg.announceChirpEntities(r.Context(), updated, rows, previous)
+
+	if cleaned != params.Body {
+		cfg.reportFilteredChirp(r.Context(), updated, params.Body)
+	}
+
+	polls, err := cfg.chirpPolls(r.Context(), []uuid.UUID{updated.ID}, userId)
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Error getting token from header", err)
+		respondWithError(w, r, internalError("Error getting chirp poll", err))
 		return
 	}
 
-	userId, err := auth.ValidateJWT(accessToken, cfg.secret)
+	chirp := newChirp(updated, rows)
+	chirp.Poll = polls[updated.ID]
+	chirp.Author = newAuthor(author)
+	chirp.LinkPreviews = cfg.knownLinkPreviews(r.Context(), rows)
+	for _, attachment := range attachments {
+		chirp.Media = append(chirp.Media, newMedia(attachment))
+	}
+	w.Header().Set("ETag", chirpETag(chirp))
+	respondWithJson(w, http.StatusOK, chirp)
+}
+
+func (cfg *apiConfig) deleteChirpHandler(w http.ResponseWriter, r *http.Request) {
+	userId, err := cfg.authenticate(r)
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Error validating JWT", err)
+		respondWithError(w, r, err)
 		return
 	}
 
 	chirpId := r.PathValue("chirpId")
-	parsedChirpId, err := uuid.Parse(chirpId)
+	parsedChirpId, err := parseUUID("chirpId", chirpId)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Error parsing ID of chirp", err)
+		respondWithError(w, r, err)
 		return
 	}
 
 	chirpData, err := cfg.db.GetChirp(r.Context(), parsedChirpId)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			respondWithError(w, http.StatusNotFound, "Chirp does not exist", err)
+			respondWithError(w, r, notFoundError("chirp_not_found", "Chirp does not exist", nil))
 			return
 		}
 
-		respondWithError(w, http.StatusInternalServerError, "Error getting chirp from db", err)
+		respondWithError(w, r, internalError("Error getting chirp from db", err))
 		return
 	}
 
 	if chirpData.UserID != userId {
-		respondWithError(w, http.StatusForbidden, "Cannot delete chirps of other users", err)
+		if chirpData.Status != chirpStatusPublished {
+			respondWithError(w, r, notFoundError("chirp_not_found", "Chirp does not exist", nil))
+			return
+		}
+
+		respondWithError(w, r, forbiddenError("not_chirp_author", "Cannot delete chirps of other users"))
 		return
 	}
 
-	err = cfg.db.DeleteChirp(r.Context(), chirpData.ID)
+	err = checkIfMatch(r, chirpData)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Error deleting chirp from db", err)
+		respondWithError(w, r, err)
 		return
 	}
 
+	var event database.ChirpEvent
+	err = cfg.db.InTx(r.Context(), func(ctx context.Context) error {
+		deleted, err := cfg.db.SoftDeleteChirp(ctx, database.SoftDeleteChirpParams{
+			ID:        chirpData.ID,
+			UpdatedAt: chirpData.UpdatedAt,
+		})
+		if err != nil {
+			return internalError("Error deleting chirp from db", err)
+		}
+
+		if deleted == 0 {
+			return preconditionFailedError()
+		}
+
+		if chirpData.Status == chirpStatusPublished {
+			event, err = cfg.recordEvent(ctx, chirpEventDeleted, userId, deletedChirp{
+				Id:     chirpData.ID,
+				UserId: chirpData.UserID,
+			})
+			if err != nil {
+				return internalError("Error creating chirp event", err)
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		respondWithError(w, r, err)
+		return
+	}
+
+	if chirpData.Status == chirpStatusPublished {
+		cfg.deliverEvent(event)
+	}
+
 	w.WriteHeader(http.StatusNoContent)
 }
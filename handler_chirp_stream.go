@@ -0,0 +1,229 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/vemolista/chirpy/v2/internal/database"
+)
+
+const (
+	// streamHeartbeat is how often an idle stream sends a comment, so that
+	// proxies don't time it out.
+	streamHeartbeat = 15 * time.Second
+	// streamRetry is how long clients wait before reconnecting.
+	streamRetry = 3 * time.Second
+	// streamReplayPage is how many events are read at a time when resuming.
+	streamReplayPage = 100
+)
+
+// streamChirpsHandler streams chirp events as Server-Sent Events. The
+// stream can be limited to one author with author_id, or to the users the
+// caller follows with following=true. Like the timeline, it leaves out
+// users who blocked the caller and, unless asked for by author_id, users
+// the caller muted. A client reconnecting with Last-Event-ID first receives
+// the events it missed, as far back as chirpEventRetention.
+func (cfg *apiConfig) streamChirpsHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	var authorId uuid.UUID
+	if value := query.Get("author_id"); value != "" {
+		var err error
+		authorId, err = parseUUID("author_id", value)
+		if err != nil {
+			respondWithError(w, r, err)
+			return
+		}
+	}
+
+	viewerId := cfg.viewer(r)
+
+	var followees map[uuid.UUID]bool
+	if value := query.Get("following"); value != "" {
+		following, err := strconv.ParseBool(value)
+		if err != nil {
+			respondWithError(w, r, validationError("invalid_query", "Malformed following parameter", fieldError{
+				Field:   "following",
+				Code:    "boolean",
+				Message: "must be true or false",
+			}))
+			return
+		}
+
+		if following {
+			followees, err = cfg.followees(r)
+			if err != nil {
+				respondWithError(w, r, err)
+				return
+			}
+		}
+	}
+
+	var lastEventId int64
+	if value := r.Header.Get("Last-Event-ID"); value != "" {
+		var err error
+		lastEventId, err = strconv.ParseInt(value, 10, 64)
+		if err != nil || lastEventId < 0 {
+			respondWithError(w, r, validationError("invalid_last_event_id", "Malformed Last-Event-ID", fieldError{
+				Field:   "Last-Event-ID",
+				Code:    "integer",
+				Message: "must be an event id from this stream",
+			}))
+			return
+		}
+	}
+
+	relationships := cfg.newRelationshipFilter(viewerId)
+
+	matches := func(event database.ChirpEvent) (bool, error) {
+		if event.Type != chirpEventCreated && event.Type != chirpEventDeleted {
+			return false, nil
+		}
+
+		if authorId != uuid.Nil && event.UserID != authorId {
+			return false, nil
+		}
+
+		if followees != nil && !followees[event.UserID] {
+			return false, nil
+		}
+
+		hidden, err := relationships.hidesChirps(r.Context(), event.UserID, authorId == uuid.Nil)
+		return !hidden, err
+	}
+
+	// Subscribe before reading the missed events and the viewer's blocks
+	// and mutes, so that nothing published in between is lost. Live events
+	// already replayed are skipped.
+	sub := cfg.events.Subscribe()
+	defer sub.Close()
+
+	err := relationships.load(r.Context())
+	if err != nil {
+		respondWithError(w, r, internalError("Error getting blocked and muted users", err))
+		return
+	}
+
+	var missed []database.ChirpEvent
+	if lastEventId > 0 {
+		for {
+			events, err := cfg.db.ListChirpEventsAfter(r.Context(), database.ListChirpEventsAfterParams{
+				ID:    lastEventId,
+				Limit: streamReplayPage,
+			})
+			if err != nil {
+				respondWithError(w, r, internalError("Error getting chirp events", err))
+				return
+			}
+
+			if len(events) > 0 {
+				missed = append(missed, events...)
+				lastEventId = events[len(events)-1].ID
+			}
+			if len(events) < streamReplayPage {
+				break
+			}
+		}
+	}
+
+	// Streams outlive the server's write timeout.
+	rc := http.NewResponseController(w)
+	err = rc.SetWriteDeadline(time.Time{})
+	if err != nil {
+		log.Printf("request %s: error clearing write deadline: %v", requestID(r.Context()), err)
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	fmt.Fprintf(w, "retry: %d\n\n", streamRetry.Milliseconds())
+	// A failed lookup ends the stream before the event it was for, so that
+	// the client gets it when it resumes with Last-Event-ID.
+	for _, event := range missed {
+		send, err := matches(event)
+		if err != nil {
+			log.Printf("request %s: %v", requestID(r.Context()), err)
+			return
+		}
+		if send {
+			writeChirpEvent(w, event)
+		}
+	}
+
+	heartbeat := time.NewTicker(streamHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		err := rc.Flush()
+		if err != nil {
+			return
+		}
+
+		select {
+		case <-r.Context().Done():
+			return
+		case event, ok := <-sub.C:
+			// The subscription ends when the server shuts down or the
+			// client falls too far behind; either way it reconnects.
+			if !ok {
+				return
+			}
+
+			if event.ID <= lastEventId {
+				continue
+			}
+
+			if event.Type == relationshipEventChanged && event.UserID == viewerId {
+				relationships.changed()
+				continue
+			}
+
+			send, err := matches(event)
+			if err != nil {
+				log.Printf("request %s: %v", requestID(r.Context()), err)
+				return
+			}
+			if send {
+				writeChirpEvent(w, event)
+			}
+		case <-heartbeat.C:
+			io.WriteString(w, ": heartbeat\n\n")
+		}
+	}
+}
+
+// followees returns the set of users the caller follows.
+func (cfg *apiConfig) followees(r *http.Request) (map[uuid.UUID]bool, error) {
+	userId, err := cfg.authenticate(r)
+	if err != nil {
+		return nil, err
+	}
+
+	ids, err := cfg.db.ListFollowees(r.Context(), userId)
+	if err != nil {
+		return nil, internalError("Error getting followed users", err)
+	}
+
+	followees := make(map[uuid.UUID]bool, len(ids))
+	for _, id := range ids {
+		followees[id] = true
+	}
+
+	return followees, nil
+}
+
+func writeChirpEvent(w io.Writer, event database.ChirpEvent) {
+	fmt.Fprintf(w, "id: %d\nevent: %s\n", event.ID, event.Type)
+	for _, line := range strings.Split(event.Data, "\n") {
+		fmt.Fprintf(w, "data: %s\n", line)
+	}
+	io.WriteString(w, "\n")
+}
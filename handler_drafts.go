@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+
+	"github.com/vemolista/chirpy/v2/internal/database"
+)
+
+// listDraftChirpsHandler lists the user's drafts and scheduled chirps.
+func (cfg *apiConfig) listDraftChirpsHandler(w http.ResponseWriter, r *http.Request) {
+	userId, err := cfg.authenticate(r)
+	if err != nil {
+		respondWithError(w, r, err)
+		return
+	}
+
+	drafts, err := cfg.db.ListUnpublishedChirps(r.Context(), userId)
+	if err != nil {
+		respondWithError(w, r, internalError("Error getting drafts", err))
+		return
+	}
+
+	response, err := cfg.chirpResponses(r.Context(), drafts, userId)
+	if err != nil {
+		respondWithError(w, r, internalError("Error getting drafts", err))
+		return
+	}
+
+	respondWithJson(w, http.StatusOK, response)
+}
+
+// publishChirpHandler publishes a draft or scheduled chirp now.
+func (cfg *apiConfig) publishChirpHandler(w http.ResponseWriter, r *http.Request) {
+	userId, err := cfg.authenticate(r)
+	if err != nil {
+		respondWithError(w, r, err)
+		return
+	}
+
+	chirpId, err := parseUUID("chirpId", r.PathValue("chirpId"))
+	if err != nil {
+		respondWithError(w, r, err)
+		return
+	}
+
+	chirpData, err := cfg.db.GetChirp(r.Context(), chirpId)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && chirpData.UserID != userId && chirpData.Status != chirpStatusPublished) {
+		respondWithError(w, r, notFoundError("chirp_not_found", "Chirp does not exist", nil))
+		return
+	}
+	if err != nil {
+		respondWithError(w, r, internalError("Error getting chirp from db", err))
+		return
+	}
+
+	if chirpData.UserID != userId {
+		respondWithError(w, r, forbiddenError("not_chirp_author", "Cannot publish chirps of other users"))
+		return
+	}
+
+	if chirpData.Status == chirpStatusPublished {
+		respondWithError(w, r, conflictError("chirp_published", "Chirp is already published", nil))
+		return
+	}
+
+	err = checkIfMatch(r, chirpData)
+	if err != nil {
+		respondWithError(w, r, err)
+		return
+	}
+
+	var published database.Chirp
+	var chirps []Chirp
+	var events []database.ChirpEvent
+	err = cfg.db.InTx(r.Context(), func(ctx context.Context) error {
+		var err error
+		published, err = cfg.db.PublishChirp(ctx, database.PublishChirpParams{
+			ID:        chirpData.ID,
+			UpdatedAt: chirpData.UpdatedAt,
+		})
+		if errors.Is(err, sql.ErrNoRows) {
+			// Edited, deleted or published by the scheduler since we read it.
+			return preconditionFailedError()
+		}
+		if err != nil {
+			return internalError("Error publishing chirp", err)
+		}
+
+		chirps, events, err = cfg.recordPublished(ctx, []database.Chirp{published})
+		if err != nil {
+			return internalError("Error publishing chirp", err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		respondWithError(w, r, err)
+		return
+	}
+
+	cfg.announcePublished(r.Context(), []database.Chirp{published}, events)
+
+	chirp := chirps[0]
+	w.Header().Set("ETag", chirpETag(chirp))
+	respondWithJson(w, http.StatusOK, chirp)
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/vemolista/chirpy/v2/internal/database"
+	"github.com/vemolista/chirpy/v2/internal/store"
+)
+
+func (cfg *apiConfig) followUserHandler(w http.ResponseWriter, r *http.Request) {
+	userId, err := cfg.authenticate(r)
+	if err != nil {
+		respondWithError(w, r, err)
+		return
+	}
+
+	followeeId, err := parseUUID("userId", r.PathValue("userId"))
+	if err != nil {
+		respondWithError(w, r, err)
+		return
+	}
+
+	if followeeId == userId {
+		respondWithError(w, r, validationError("cannot_follow_self", "Users cannot follow themselves"))
+		return
+	}
+
+	relationship, err := cfg.db.GetRelationship(r.Context(), database.GetRelationshipParams{
+		UserID:  userId,
+		OtherID: followeeId,
+	})
+	if err != nil {
+		respondWithError(w, r, internalError("Error getting relationship", err))
+		return
+	}
+
+	if relationship.BlockedBy {
+		respondWithError(w, r, forbiddenError("blocked", "This user has blocked you"))
+		return
+	}
+
+	followed, err := cfg.db.FollowUser(r.Context(), database.FollowUserParams{
+		FollowerID: userId,
+		FolloweeID: followeeId,
+	})
+	if err != nil {
+		if store.IsForeignKeyViolation(err) {
+			respondWithError(w, r, notFoundError("user_not_found", "User does not exist", err))
+			return
+		}
+
+		respondWithError(w, r, internalError("Error following user", err))
+		return
+	}
+
+	// Following again is a no-op and doesn't notify again.
+	if followed > 0 {
+		cfg.notify(r.Context(), followeeId, userId, notificationFollow, uuid.NullUUID{})
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (cfg *apiConfig) unfollowUserHandler(w http.ResponseWriter, r *http.Request) {
+	userId, err := cfg.authenticate(r)
+	if err != nil {
+		respondWithError(w, r, err)
+		return
+	}
+
+	followeeId, err := parseUUID("userId", r.PathValue("userId"))
+	if err != nil {
+		respondWithError(w, r, err)
+		return
+	}
+
+	err = cfg.db.UnfollowUser(r.Context(), database.UnfollowUserParams{
+		FollowerID: userId,
+		FolloweeID: followeeId,
+	})
+	if err != nil {
+		respondWithError(w, r, internalError("Error unfollowing user", err))
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+
+	"github.com/vemolista/chirpy/v2/internal/database"
+	"github.com/vemolista/chirpy/v2/internal/entities"
+)
+
+func (cfg *apiConfig) listHashtagChirpsHandler(w http.ResponseWriter, r *http.Request) {
+	tag, ok := entities.NormalizeHashtag(r.PathValue("tag"))
+	if !ok {
+		respondWithError(w, r, validationError("invalid_hashtag", fmt.Sprintf("%q is not a hashtag", r.PathValue("tag"))))
+		return
+	}
+
+	viewerId := cfg.viewer(r)
+
+	chirpsData, err := cfg.db.ListChirpsForHashtag(r.Context(), database.ListChirpsForHashtagParams{
+		Text:     tag,
+		ViewerID: viewerId,
+	})
+	if err != nil {
+		respondWithError(w, r, internalError("Error getting chirps", err))
+		return
+	}
+
+	if r.URL.Query().Get("sort") == "desc" {
+		slices.Reverse(chirpsData)
+	}
+
+	response, err := cfg.chirpResponses(r.Context(), chirpsData, viewerId)
+	if err != nil {
+		respondWithError(w, r, internalError("Error getting chirps", err))
+		return
+	}
+
+	if notModified(w, r, chirpsETag(response), chirpsCacheControl(viewerId, response)) {
+		return
+	}
+
+	respondWithJson(w, http.StatusOK, response)
+}
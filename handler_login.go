@@ -1,50 +1,55 @@
 package main
 
 import (
-	"encoding/json"
 	"net/http"
 	"time"
 
-	"github.com/google/uuid"
 	"github.com/vemolista/chirpy/v2/internal/auth"
 	"github.com/vemolista/chirpy/v2/internal/database"
 )
 
 func (cfg *apiConfig) loginHandler(w http.ResponseWriter, r *http.Request) {
 	type parameters struct {
-		Email    string `json:"email"`
-		Password string `json:"password"`
+		Email    string `json:"email" validate:"required"`
+		Password string `json:"password" validate:"required"`
 	}
 
 	var params parameters
-	decoder := json.NewDecoder(r.Body)
-	err := decoder.Decode(&params)
+	err := decodeJSON(w, r, &params)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Error decoding JSON", err)
+		respondWithError(w, r, err)
 		return
 	}
 
 	userData, err := cfg.db.GetUserByEmail(r.Context(), params.Email)
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Incorrect email or password", err)
+		cfg.metrics.LoginFailed()
+		respondWithError(w, r, unauthorizedError("invalid_credentials", "Incorrect email or password", err))
 		return
 	}
 
-	err = auth.CheckPasswordHash(params.Password, userData.HashedPassword)
+	err = auth.CheckPasswordHashContext(r.Context(), params.Password, userData.HashedPassword)
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Incorrect email or password", err)
+		cfg.metrics.LoginFailed()
+		respondWithError(w, r, unauthorizedError("invalid_credentials", "Incorrect email or password", err))
 		return
 	}
 
-	token, err := auth.MakeJWT(userData.ID, cfg.secret, time.Hour)
+	if userData.SuspendedAt.Valid {
+		cfg.metrics.LoginFailed()
+		respondWithError(w, r, suspendedError())
+		return
+	}
+
+	token, err := auth.MakeJWTContext(r.Context(), userData.ID, cfg.secret, time.Hour)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Error creating JWT", err)
+		respondWithError(w, r, internalError("Error creating JWT", err))
 		return
 	}
 
 	refreshToken, err := auth.MakeRefreshToken()
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Error making refresh token", err)
+		respondWithError(w, r, internalError("Error making refresh token", err))
 		return
 	}
 
@@ -56,26 +61,21 @@ func (cfg *apiConfig) loginHandler(w http.ResponseWriter, r *http.Request) {
 		ExpiresAt: time.Now().Add(sixtyDays),
 	})
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Error creating refresh token in db", err)
+		respondWithError(w, r, internalError("Error creating refresh token in db", err))
+		return
 	}
 
+	cfg.metrics.LoginSucceeded()
+
 	type response struct {
-		Id           uuid.UUID `json:"id"`
-		CreatedAt    time.Time `json:"created_at"`
-		UpdatedAt    time.Time `json:"updated_at"`
-		Email        string    `json:"email"`
-		Token        string    `json:"token"`
-		RefreshToken string    `json:"refresh_token"`
-		IsChirpyRed  bool      `json:"is_chirpy_red"`
+		UserResponse
+		Token        string `json:"token"`
+		RefreshToken string `json:"refresh_token"`
 	}
 
 	respondWithJson(w, http.StatusOK, response{
-		Id:           userData.ID,
-		CreatedAt:    userData.CreatedAt,
-		UpdatedAt:    userData.UpdatedAt,
-		Email:        userData.Email,
+		UserResponse: newUserResponse(userData),
 		Token:        token,
 		RefreshToken: refreshToken,
-		IsChirpyRed:  userData.IsChirpyRed,
 	})
 }
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/vemolista/chirpy/v2/internal/blob"
+	"github.com/vemolista/chirpy/v2/internal/database"
+	"github.com/vemolista/chirpy/v2/internal/media"
+)
+
+// multipartOverhead is how much larger than the file an upload's body may
+// be, for the multipart boundaries and headers.
+const multipartOverhead = 64 << 10
+
+// uploadMediaHandler takes a multipart/form-data body with a single "file"
+// part holding an image.
+func (cfg *apiConfig) uploadMediaHandler(w http.ResponseWriter, r *http.Request) {
+	userId, err := cfg.authenticate(r)
+	if err != nil {
+		respondWithError(w, r, err)
+		return
+	}
+
+	user, err := cfg.db.GetUser(r.Context(), userId)
+	if err != nil {
+		respondWithError(w, r, internalError("Error getting user", err))
+		return
+	}
+
+	limit := cfg.media.MaxBytes
+	if user.IsChirpyRed {
+		limit = cfg.media.RedMaxBytes
+	}
+
+	data, err := readUpload(w, r, limit)
+	if err != nil {
+		respondWithError(w, r, err)
+		return
+	}
+
+	img, err := media.Process(data)
+	if err != nil {
+		switch {
+		case errors.Is(err, media.ErrUnsupportedType):
+			respondWithError(w, r, &apiError{
+				Status: http.StatusUnsupportedMediaType,
+				Code:   "unsupported_media_type",
+				Detail: "Media must be a JPEG, PNG, GIF or WebP image",
+				Err:    err,
+			})
+		case errors.Is(err, media.ErrTooManyPixels):
+			respondWithError(w, r, validationError("image_too_large", fmt.Sprintf("Images must have at most %d pixels", media.MaxPixels)))
+		default:
+			respondWithError(w, r, validationError("invalid_image", "The file is not a valid image"))
+		}
+		return
+	}
+
+	id := uuid.New()
+	err = cfg.storeBlobs(r.Context(), map[string][]byte{
+		mediaKey(id):     img.Data,
+		thumbnailKey(id): img.Thumbnail,
+	})
+	if err != nil {
+		respondWithError(w, r, internalError("Error storing media", err))
+		return
+	}
+
+	created, err := cfg.db.CreateMedia(r.Context(), database.CreateMediaParams{
+		ID:          id,
+		UserID:      userId,
+		ContentType: img.ContentType,
+		Size:        int64(len(img.Data)),
+		Width:       int32(img.Width),
+		Height:      int32(img.Height),
+	})
+	if err != nil {
+		cfg.deleteBlobs(r.Context(), mediaKey(id), thumbnailKey(id))
+		respondWithError(w, r, internalError("Error creating media", err))
+		return
+	}
+
+	respondWithJson(w, http.StatusCreated, newMedia(created))
+}
+
+// readUpload returns the contents of the "file" part of a multipart body,
+// which may be at most limit bytes.
+func readUpload(w http.ResponseWriter, r *http.Request, limit int64) ([]byte, error) {
+	r.Body = http.MaxBytesReader(w, r.Body, limit+multipartOverhead)
+
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return nil, &apiError{
+			Status: http.StatusUnsupportedMediaType,
+			Code:   "unsupported_media_type",
+			Detail: "Request body must be multipart/form-data",
+			Err:    err,
+		}
+	}
+
+	tooLarge := &apiError{
+		Status: http.StatusRequestEntityTooLarge,
+		Code:   "media_too_large",
+		Detail: fmt.Sprintf("Media must be at most %d bytes", limit),
+	}
+
+	var data []byte
+	for {
+		part, err := reader.NextPart()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			var maxBytesErr *http.MaxBytesError
+			if errors.As(err, &maxBytesErr) {
+				return nil, tooLarge
+			}
+			return nil, validationError("malformed_multipart", "Request body is not valid multipart/form-data")
+		}
+
+		if part.FormName() != "file" || data != nil {
+			return nil, validationError("unknown_field", "Only a single file part is allowed",
+				fieldError{Field: part.FormName(), Code: "unknown_field", Message: "is not allowed"})
+		}
+
+		data, err = io.ReadAll(io.LimitReader(part, limit+1))
+		if err != nil {
+			var maxBytesErr *http.MaxBytesError
+			if errors.As(err, &maxBytesErr) {
+				return nil, tooLarge
+			}
+			return nil, validationError("malformed_multipart", "Request body is not valid multipart/form-data")
+		}
+
+		if int64(len(data)) > limit {
+			return nil, tooLarge
+		}
+	}
+
+	if data == nil {
+		return nil, validationError("validation_failed", "Request body failed validation",
+			fieldError{Field: "file", Code: "required", Message: "is required"})
+	}
+
+	return data, nil
+}
+
+// storeBlobs puts every blob or, if one fails, none of them.
+func (cfg *apiConfig) storeBlobs(ctx context.Context, blobs map[string][]byte) error {
+	var stored []string
+	for key, data := range blobs {
+		err := cfg.blobs.Put(ctx, key, bytes.NewReader(data))
+		if err != nil {
+			cfg.deleteBlobs(ctx, stored...)
+			return err
+		}
+		stored = append(stored, key)
+	}
+
+	return nil
+}
+
+func (cfg *apiConfig) deleteBlobs(ctx context.Context, keys ...string) {
+	for _, key := range keys {
+		err := cfg.blobs.Delete(ctx, key)
+		if err != nil {
+			log.Printf("request %s: error deleting blob %s: %v", requestID(ctx), key, err)
+		}
+	}
+}
+
+func (cfg *apiConfig) getMediaHandler(w http.ResponseWriter, r *http.Request) {
+	cfg.serveMedia(w, r, mediaKey)
+}
+
+func (cfg *apiConfig) getMediaThumbnailHandler(w http.ResponseWriter, r *http.Request) {
+	cfg.serveMedia(w, r, thumbnailKey)
+}
+
+// serveMedia serves a blob of a media to those who may see it, which
+// canSeeMedia decides. Like the /app/ file server it supports conditional
+// and range requests.
+func (cfg *apiConfig) serveMedia(w http.ResponseWriter, r *http.Request, key func(uuid.UUID) string) {
+	id, err := parseUUID("mediaId", r.PathValue("mediaId"))
+	if err != nil {
+		respondWithError(w, r, err)
+		return
+	}
+
+	notFound := notFoundError("media_not_found", fmt.Sprintf("No media with Id %s", id), nil)
+
+	mediaData, err := cfg.db.GetMedia(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			respondWithError(w, r, notFound)
+			return
+		}
+
+		respondWithError(w, r, internalError("Error getting media", err))
+		return
+	}
+
+	visible, public, err := cfg.canSeeMedia(r, mediaData)
+	if err != nil {
+		respondWithError(w, r, internalError("Error getting chirp of media", err))
+		return
+	}
+	if !visible {
+		respondWithError(w, r, notFound)
+		return
+	}
+
+	f, modTime, err := cfg.blobs.Open(r.Context(), key(id))
+	if err != nil {
+		if errors.Is(err, blob.ErrNotFound) {
+			respondWithError(w, r, notFoundError("media_not_found", fmt.Sprintf("No media with Id %s", id), err))
+			return
+		}
+
+		respondWithError(w, r, internalError("Error opening media", err))
+		return
+	}
+	defer f.Close()
+
+	w.Header().Set("Content-Type", mediaData.ContentType)
+	if public {
+		w.Header().Set("Cache-Control", mediaCacheControl)
+	} else {
+		w.Header().Set("Cache-Control", "private, no-store")
+	}
+	w.Header().Set("ETag", fmt.Sprintf(`"%s"`, key(id)))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	http.ServeContent(w, r, "", modTime, f)
+}
+
+// canSeeMedia reports whether the request may see a media, and whether
+// shared caches may keep it. Attached media are as visible as their chirp;
+// only published chirps that aren't deleted are public. Unattached uploads
+// are only visible to the user who uploaded them.
+func (cfg *apiConfig) canSeeMedia(r *http.Request, media database.Medium) (visible, public bool, err error) {
+	if !media.ChirpID.Valid {
+		userId, err := cfg.authenticate(r)
+		return err == nil && userId == media.UserID, false, nil
+	}
+
+	chirp, err := cfg.db.GetChirp(r.Context(), media.ChirpID.UUID)
+	if errors.Is(err, sql.ErrNoRows) {
+		chirp, err = cfg.deletedChirpForModerator(r, media.ChirpID.UUID)
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, false, nil
+	}
+	if err != nil {
+		return false, false, err
+	}
+
+	if !cfg.canSeeChirp(r, chirp) {
+		return false, false, nil
+	}
+
+	return true, chirp.Status == chirpStatusPublished && !chirp.DeletedAt.Valid, nil
+}
@@ -2,22 +2,46 @@ package main
 
 import (
 	"fmt"
+	"html"
 	"net/http"
+	"sort"
+	"strings"
 )
 
 func (cfg *apiConfig) metricsHandler(w http.ResponseWriter, r *http.Request) {
+	snapshot, err := cfg.metrics.Snapshot()
+	if err != nil {
+		respondWithError(w, r, internalError("Error reading metrics", err))
+		return
+	}
+
+	routes := make([]string, 0, len(snapshot.Requests))
+	for route := range snapshot.Requests {
+		routes = append(routes, route)
+	}
+	sort.Strings(routes)
+
+	var rows strings.Builder
+	for _, route := range routes {
+		fmt.Fprintf(&rows, "\n\t\t\t\t\t<tr><td>%s</td><td>%d</td></tr>", html.EscapeString(route), snapshot.Requests[route])
+	}
+
 	w.Header().Add("Content-Type", "text/html")
 	w.WriteHeader(http.StatusOK)
 
-	html := fmt.Sprintf(`
+	page := fmt.Sprintf(`
 		<html>
 			<body>
 				<h1>Welcome, Chirpy Admin</h1>
 				<p>Chirpy has been visited %d times!</p>
+				<p>Logins: %d succeeded, %d failed</p>
+				<table>
+					<tr><th>Route</th><th>Requests</th></tr>%s
+				</table>
 			</body>
-		</html>`, cfg.fileserverHits.Load())
+		</html>`, snapshot.FileserverHits, snapshot.Logins["success"], snapshot.Logins["failure"], rows.String())
 
-	_, err := w.Write([]byte(html))
+	_, err = w.Write([]byte(page))
 	if err != nil {
 		fmt.Printf("error writing request body: %v\n", err)
 	}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+
+	"github.com/google/uuid"
+	"github.com/vemolista/chirpy/v2/internal/database"
+)
+
+const (
+	defaultNotificationsLimit = 20
+	maxNotificationsLimit     = 100
+)
+
+func (cfg *apiConfig) listNotificationsHandler(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		Notifications []Notification `json:"notifications"`
+		UnreadCount   int64          `json:"unread_count"`
+		// NextBefore is the before parameter for the next page, if any.
+		NextBefore *uuid.UUID `json:"next_before,omitempty"`
+	}
+
+	userId, err := cfg.authenticate(r)
+	if err != nil {
+		respondWithError(w, r, err)
+		return
+	}
+
+	limit, err := parseLimit(r, defaultNotificationsLimit, maxNotificationsLimit)
+	if err != nil {
+		respondWithError(w, r, err)
+		return
+	}
+
+	var notifications []database.Notification
+	if value := r.URL.Query().Get("before"); value != "" {
+		beforeId, err := parseUUID("before", value)
+		if err != nil {
+			respondWithError(w, r, err)
+			return
+		}
+
+		before, err := cfg.db.GetNotification(r.Context(), beforeId)
+		if errors.Is(err, sql.ErrNoRows) || (err == nil && before.UserID != userId) {
+			respondWithError(w, r, validationError("invalid_query", "Unknown before parameter", fieldError{
+				Field:   "before",
+				Code:    "not_found",
+				Message: "must be the id of one of your notifications",
+			}))
+			return
+		}
+		if err != nil {
+			respondWithError(w, r, internalError("Error getting notification", err))
+			return
+		}
+
+		notifications, err = cfg.db.ListNotificationsBefore(r.Context(), database.ListNotificationsBeforeParams{
+			UserID:    userId,
+			Limit:     int32(limit),
+			CreatedAt: before.CreatedAt,
+			ID:        before.ID,
+		})
+		if err != nil {
+			respondWithError(w, r, internalError("Error getting notifications", err))
+			return
+		}
+	} else {
+		notifications, err = cfg.db.ListNotifications(r.Context(), database.ListNotificationsParams{
+			UserID: userId,
+			Limit:  int32(limit),
+		})
+		if err != nil {
+			respondWithError(w, r, internalError("Error getting notifications", err))
+			return
+		}
+	}
+
+	unread, err := cfg.db.CountUnreadNotifications(r.Context(), userId)
+	if err != nil {
+		respondWithError(w, r, internalError("Error counting unread notifications", err))
+		return
+	}
+
+	res := response{Notifications: []Notification{}, UnreadCount: unread}
+	for _, notification := range notifications {
+		res.Notifications = append(res.Notifications, newNotification(notification))
+	}
+
+	if len(notifications) == limit {
+		res.NextBefore = &notifications[len(notifications)-1].ID
+	}
+
+	respondWithJson(w, http.StatusOK, res)
+}
+
+func (cfg *apiConfig) readNotificationsHandler(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Ids []uuid.UUID `json:"ids"`
+		All bool        `json:"all"`
+	}
+
+	type response struct {
+		Marked      int64 `json:"marked"`
+		UnreadCount int64 `json:"unread_count"`
+	}
+
+	userId, err := cfg.authenticate(r)
+	if err != nil {
+		respondWithError(w, r, err)
+		return
+	}
+
+	params := parameters{}
+	err = decodeJSON(w, r, &params)
+	if err != nil {
+		respondWithError(w, r, err)
+		return
+	}
+
+	if params.All == (len(params.Ids) > 0) {
+		respondWithError(w, r, validationError("validation_failed", "Send either ids or all", fieldError{
+			Field:   "ids",
+			Code:    "required",
+			Message: "is required unless all is true",
+		}))
+		return
+	}
+
+	if len(params.Ids) > maxNotificationsLimit {
+		respondWithError(w, r, validationError("validation_failed", "Too many ids", fieldError{
+			Field:   "ids",
+			Code:    "max_items",
+			Message: fmt.Sprintf("must have at most %d items", maxNotificationsLimit),
+		}))
+		return
+	}
+
+	var marked int64
+	if params.All {
+		marked, err = cfg.db.MarkAllNotificationsRead(r.Context(), userId)
+		if err != nil {
+			respondWithError(w, r, internalError("Error marking notifications read", err))
+			return
+		}
+	}
+
+	for _, id := range params.Ids {
+		n, err := cfg.db.MarkNotificationRead(r.Context(), database.MarkNotificationReadParams{
+			ID:     id,
+			UserID: userId,
+		})
+		if err != nil {
+			respondWithError(w, r, internalError("Error marking notification read", err))
+			return
+		}
+		marked += n
+	}
+
+	unread, err := cfg.db.CountUnreadNotifications(r.Context(), userId)
+	if err != nil {
+		respondWithError(w, r, internalError("Error counting unread notifications", err))
+		return
+	}
+
+	respondWithJson(w, http.StatusOK, response{Marked: marked, UnreadCount: unread})
+}
+
+func (cfg *apiConfig) getNotificationPreferencesHandler(w http.ResponseWriter, r *http.Request) {
+	userId, err := cfg.authenticate(r)
+	if err != nil {
+		respondWithError(w, r, err)
+		return
+	}
+
+	preferences, err := cfg.notificationPreferences(r.Context(), userId)
+	if err != nil {
+		respondWithError(w, r, internalError("Error getting notification preferences", err))
+		return
+	}
+
+	respondWithJson(w, http.StatusOK, preferences)
+}
+
+// updateNotificationPreferencesHandler turns the notification types in the
+// body on or off, leaving the others as they are.
+func (cfg *apiConfig) updateNotificationPreferencesHandler(w http.ResponseWriter, r *http.Request) {
+	userId, err := cfg.authenticate(r)
+	if err != nil {
+		respondWithError(w, r, err)
+		return
+	}
+
+	params := map[string]bool{}
+	err = decodeJSON(w, r, &params)
+	if err != nil {
+		respondWithError(w, r, err)
+		return
+	}
+
+	var fields []fieldError
+	for kind := range params {
+		if !slices.Contains(notificationTypes, kind) {
+			fields = append(fields, fieldError{
+				Field:   kind,
+				Code:    "unknown_field",
+				Message: "is not a notification type",
+			})
+		}
+	}
+	if len(fields) > 0 {
+		respondWithError(w, r, validationError("validation_failed", "Unknown notification type", fields...))
+		return
+	}
+
+	for kind, enabled := range params {
+		err = cfg.db.SetNotificationPreference(r.Context(), database.SetNotificationPreferenceParams{
+			UserID:  userId,
+			Type:    kind,
+			Enabled: enabled,
+		})
+		if err != nil {
+			respondWithError(w, r, internalError("Error updating notification preferences", err))
+			return
+		}
+	}
+
+	preferences, err := cfg.notificationPreferences(r.Context(), userId)
+	if err != nil {
+		respondWithError(w, r, internalError("Error getting notification preferences", err))
+		return
+	}
+
+	respondWithJson(w, http.StatusOK, preferences)
+}
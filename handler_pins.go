@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/vemolista/chirpy/v2/internal/database"
+	"github.com/vemolista/chirpy/v2/internal/store"
+)
+
+// pinChirpHandler pins one of the user's published chirps to the top of
+// their listing, replacing any chirp pinned before.
+func (cfg *apiConfig) pinChirpHandler(w http.ResponseWriter, r *http.Request) {
+	userId, err := cfg.authenticate(r)
+	if err != nil {
+		respondWithError(w, r, err)
+		return
+	}
+
+	chirpId, err := parseUUID("chirpId", r.PathValue("chirpId"))
+	if err != nil {
+		respondWithError(w, r, err)
+		return
+	}
+
+	notFound := notFoundError("chirp_not_found", fmt.Sprintf("No chirp with Id %s", chirpId), nil)
+
+	chirp, err := cfg.db.GetChirp(r.Context(), chirpId)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && !cfg.canSeeChirp(r, chirp)) {
+		respondWithError(w, r, notFound)
+		return
+	}
+	if err != nil {
+		respondWithError(w, r, internalError("Error getting chirp from db", err))
+		return
+	}
+
+	if chirp.UserID != userId {
+		respondWithError(w, r, forbiddenError("not_chirp_author", "Cannot pin chirps of other users"))
+		return
+	}
+
+	if chirp.Status != chirpStatusPublished {
+		respondWithError(w, r, conflictError("chirp_not_published", "Only published chirps can be pinned", nil))
+		return
+	}
+
+	err = cfg.db.PinChirp(r.Context(), database.PinChirpParams{
+		UserID:  userId,
+		ChirpID: chirp.ID,
+	})
+	if store.IsForeignKeyViolation(err) {
+		// Purged since we read it.
+		respondWithError(w, r, notFound)
+		return
+	}
+	if err != nil {
+		respondWithError(w, r, internalError("Error pinning chirp", err))
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// unpinChirpHandler unpins the user's pinned chirp, if it is chirpId.
+func (cfg *apiConfig) unpinChirpHandler(w http.ResponseWriter, r *http.Request) {
+	userId, err := cfg.authenticate(r)
+	if err != nil {
+		respondWithError(w, r, err)
+		return
+	}
+
+	chirpId, err := parseUUID("chirpId", r.PathValue("chirpId"))
+	if err != nil {
+		respondWithError(w, r, err)
+		return
+	}
+
+	_, err = cfg.db.UnpinChirp(r.Context(), database.UnpinChirpParams{
+		UserID:  userId,
+		ChirpID: chirpId,
+	})
+	if err != nil {
+		respondWithError(w, r, internalError("Error unpinning chirp", err))
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// pinFirst moves the chirp authorId pinned, if it is in chirps, to the
+// front and marks it pinned.
+func (cfg *apiConfig) pinFirst(ctx context.Context, authorId uuid.UUID, chirps []Chirp) ([]Chirp, error) {
+	pinned, err := cfg.db.GetPinnedChirp(ctx, authorId)
+	if errors.Is(err, sql.ErrNoRows) {
+		return chirps, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for i, chirp := range chirps {
+		if chirp.Id == pinned.ID {
+			chirp.Pinned = true
+			copy(chirps[1:i+1], chirps[:i])
+			chirps[0] = chirp
+			break
+		}
+	}
+
+	return chirps, nil
+}
@@ -2,7 +2,6 @@ package main
 
 import (
 	"database/sql"
-	"encoding/json"
 	"errors"
 	"net/http"
 
@@ -16,30 +15,33 @@ func (cfg *apiConfig) polkaWebhookHandler(w http.ResponseWriter, r *http.Request
 	}
 
 	type parameters struct {
-		Event string `json:"event"`
+		Event string `json:"event" validate:"required"`
 		Data  data   `json:"data"`
 	}
 
 	apiKey, err := auth.GetAPIKey(r.Header)
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Error getting apiKey from header", err)
+		cfg.metrics.Webhook("polka", "", "unauthorized")
+		respondWithError(w, r, unauthorizedError("missing_api_key", "An ApiKey is required in the Authorization header", err))
 		return
 	}
 
 	if apiKey != cfg.polkaKey {
-		respondWithError(w, http.StatusUnauthorized, "Incorrect api key", err)
+		cfg.metrics.Webhook("polka", "", "unauthorized")
+		respondWithError(w, r, unauthorizedError("invalid_api_key", "Incorrect api key", nil))
 		return
 	}
 
 	var params parameters
-	decoder := json.NewDecoder(r.Body)
-	err = decoder.Decode(&params)
+	err = decodeWebhook(w, r, &params)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Error decoding JSON", err)
+		cfg.metrics.Webhook("polka", "", "bad_request")
+		respondWithError(w, r, err)
 		return
 	}
 
 	if params.Event != "user.upgraded" {
+		cfg.metrics.Webhook("polka", "other", "ignored")
 		w.WriteHeader(http.StatusNoContent)
 		return
 	}
@@ -47,13 +49,16 @@ func (cfg *apiConfig) polkaWebhookHandler(w http.ResponseWriter, r *http.Request
 	_, err = cfg.db.UpgradeToChirpyRed(r.Context(), params.Data.UserId)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			respondWithError(w, http.StatusNotFound, "User not found", err)
+			cfg.metrics.Webhook("polka", params.Event, "not_found")
+			respondWithError(w, r, notFoundError("user_not_found", "User not found", nil))
 			return
 		}
 
-		respondWithError(w, http.StatusInternalServerError, "Error upgrading user", err)
+		cfg.metrics.Webhook("polka", params.Event, "error")
+		respondWithError(w, r, internalError("Error upgrading user", err))
 		return
 	}
 
+	cfg.metrics.Webhook("polka", params.Event, "processed")
 	w.WriteHeader(http.StatusNoContent)
 }
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/vemolista/chirpy/v2/internal/database"
+	"github.com/vemolista/chirpy/v2/internal/store"
+)
+
+// ProfileResponse is a user's public profile. Like Author, it must never
+// include private fields such as the email.
+type ProfileResponse struct {
+	Id             uuid.UUID `json:"id"`
+	Username       *string   `json:"username"`
+	DisplayName    string    `json:"display_name"`
+	Bio            string    `json:"bio"`
+	AvatarUrl      string    `json:"avatar_url"`
+	CreatedAt      time.Time `json:"created_at"`
+	IsChirpyRed    bool      `json:"is_chirpy_red"`
+	ChirpCount     int64     `json:"chirp_count"`
+	FollowerCount  int64     `json:"follower_count"`
+	FollowingCount int64     `json:"following_count"`
+}
+
+func (cfg *apiConfig) getProfileHandler(w http.ResponseWriter, r *http.Request) {
+	username := r.PathValue("username")
+
+	user, err := cfg.db.GetUserByUsername(r.Context(), nullUsername(username))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			respondWithError(w, r, notFoundError("user_not_found", "No user with username "+username, nil))
+			return
+		}
+
+		respondWithError(w, r, internalError("Error getting user", err))
+		return
+	}
+
+	counts, err := cfg.db.GetUserCounts(r.Context(), user.ID)
+	if err != nil {
+		respondWithError(w, r, internalError("Error counting user's chirps and follows", err))
+		return
+	}
+
+	respondWithJson(w, http.StatusOK, ProfileResponse{
+		Id:             user.ID,
+		Username:       usernamePointer(user.Username),
+		DisplayName:    user.DisplayName,
+		Bio:            user.Bio,
+		AvatarUrl:      user.AvatarUrl,
+		CreatedAt:      user.CreatedAt,
+		IsChirpyRed:    user.IsChirpyRed,
+		ChirpCount:     counts.ChirpCount,
+		FollowerCount:  counts.FollowerCount,
+		FollowingCount: counts.FollowingCount,
+	})
+}
+
+// updateProfileHandler changes the fields present in the body and keeps the
+// others. An empty username removes it.
+func (cfg *apiConfig) updateProfileHandler(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Username    *string `json:"username" validate:"username"`
+		DisplayName *string `json:"display_name" validate:"max=50"`
+		Bio         *string `json:"bio" validate:"max=160"`
+		AvatarUrl   *string `json:"avatar_url" validate:"url,max=2048"`
+	}
+
+	userId, err := cfg.authenticate(r)
+	if err != nil {
+		respondWithError(w, r, err)
+		return
+	}
+
+	var params parameters
+	err = decodeJSON(w, r, &params)
+	if err != nil {
+		respondWithError(w, r, err)
+		return
+	}
+
+	user, err := cfg.db.GetUser(r.Context(), userId)
+	if err != nil {
+		respondWithError(w, r, internalError("Error getting user", err))
+		return
+	}
+
+	update := database.UpdateUserProfileParams{
+		ID:          user.ID,
+		Username:    user.Username,
+		DisplayName: user.DisplayName,
+		Bio:         user.Bio,
+		AvatarUrl:   user.AvatarUrl,
+	}
+	if params.Username != nil {
+		update.Username = nullUsername(*params.Username)
+	}
+	if params.DisplayName != nil {
+		update.DisplayName = *params.DisplayName
+	}
+	if params.Bio != nil {
+		update.Bio = *params.Bio
+	}
+	if params.AvatarUrl != nil {
+		update.AvatarUrl = *params.AvatarUrl
+	}
+
+	updated, err := cfg.db.UpdateUserProfile(r.Context(), update)
+	if err != nil {
+		if store.IsUniqueViolation(err) {
+			respondWithError(w, r, conflictError("username_taken", "A user with this username already exists", err))
+			return
+		}
+
+		respondWithError(w, r, internalError("Error updating profile", err))
+		return
+	}
+
+	if updated.Username != user.Username {
+		cfg.resolveMentions(r.Context(), updated)
+	}
+
+	respondWithJson(w, http.StatusOK, newUserResponse(updated))
+}
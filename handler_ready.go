@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+func (cfg *apiConfig) readyHandler(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		Status string `json:"status"`
+	}
+
+	if cfg.shuttingDown.Load() {
+		respondWithJson(w, http.StatusServiceUnavailable, response{Status: "shutting down"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 2*time.Second)
+	defer cancel()
+
+	// The probe is public, so why it failed, which may name hosts or
+	// credentials, is only logged.
+	err := cfg.checkReady(ctx)
+	if err != nil {
+		log.Printf("request %s: not ready: %v", requestID(r.Context()), err)
+		respondWithJson(w, http.StatusServiceUnavailable, response{Status: "not ready"})
+		return
+	}
+
+	respondWithJson(w, http.StatusOK, response{Status: "ready"})
+}
+
+func (cfg *apiConfig) checkReady(ctx context.Context) error {
+	if cfg.dbConn != nil {
+		err := cfg.dbConn.PingContext(ctx)
+		if err != nil {
+			return fmt.Errorf("database unreachable: %w", err)
+		}
+	}
+
+	if cfg.migrations != nil {
+		return checkSchema(ctx, cfg.migrations)
+	}
+
+	return nil
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/coder/websocket"
+	"github.com/google/uuid"
+	"github.com/vemolista/chirpy/v2/internal/auth"
+	"github.com/vemolista/chirpy/v2/internal/events"
+	"github.com/vemolista/chirpy/v2/internal/realtime"
+)
+
+const (
+	// realtimeIdleTimeout is how long a client may go without sending a
+	// frame; clients ping more often than this to stay connected.
+	realtimeIdleTimeout = time.Minute
+	// realtimeWriteTimeout disconnects clients that stop reading.
+	realtimeWriteTimeout = 10 * time.Second
+)
+
+// realtimeHandler upgrades the request to a WebSocket speaking the realtime
+// protocol. Clients authenticate with the same access token as the rest of
+// the API, either in the Authorization header or, since browsers can't set
+// it on a WebSocket, in an auth frame. They can then subscribe to:
+//
+//	user:<userId>   chirps created and deleted by a user
+//	chirp:<chirpId> changes to a chirp
+//	notifications   the caller's own notifications
+func (cfg *apiConfig) realtimeHandler(w http.ResponseWriter, r *http.Request) {
+	userId := uuid.Nil
+	if r.Header.Get("Authorization") != "" {
+		var err error
+		userId, err = cfg.authenticate(r)
+		if err != nil {
+			respondWithError(w, r, err)
+			return
+		}
+	}
+
+	conn, err := websocket.Accept(w, r, nil)
+	if err != nil {
+		// Accept has already responded.
+		return
+	}
+
+	// Who blocked the user and who they muted, loaded with the first event.
+	var relationships *relationshipFilter
+
+	// The connection outlives the request's trace and rate limit, but ends
+	// with the server.
+	err = realtime.Serve(context.WithoutCancel(r.Context()), conn, cfg.realtime, userId, realtime.Options{
+		Authenticate: func(ctx context.Context, token string) (uuid.UUID, error) {
+			userId, err := auth.ValidateJWTContext(ctx, token, cfg.secret)
+			if err != nil {
+				return uuid.Nil, err
+			}
+
+			_, err = cfg.activeUser(ctx, userId)
+			if err != nil {
+				return uuid.Nil, err
+			}
+
+			return userId, nil
+		},
+		Resolve: resolveRealtimeTopic,
+		Topics: func(userId uuid.UUID) []string {
+			return []string{relationshipsTopic(userId)}
+		},
+		Filter: func(ctx context.Context, userId uuid.UUID, msg realtime.Message) (bool, error) {
+			if relationships == nil {
+				relationships = cfg.newRelationshipFilter(userId)
+			}
+
+			if msg.Type == relationshipEventChanged {
+				relationships.changed()
+				return false, nil
+			}
+
+			// Muting only keeps chirps out of timelines; subscribing to a
+			// user asks for their chirps.
+			hidden, err := relationships.hidesChirps(ctx, msg.UserID, false)
+			return !hidden, err
+		},
+		IdleTimeout:  realtimeIdleTimeout,
+		WriteTimeout: realtimeWriteTimeout,
+	})
+	if err != nil {
+		log.Printf("request %s: realtime connection ended: %v", requestID(r.Context()), err)
+	}
+}
+
+// resolveRealtimeTopic checks a topic a user asked for and returns the hub
+// topic it is published on.
+func resolveRealtimeTopic(userId uuid.UUID, topic string) (string, error) {
+	if topic == "notifications" {
+		return notificationsTopic(userId), nil
+	}
+
+	kind, id, ok := strings.Cut(topic, ":")
+	if !ok || (kind != "user" && kind != "chirp") {
+		return "", errors.New("topic must be user:<userId>, chirp:<chirpId> or notifications")
+	}
+
+	parsed, err := uuid.Parse(id)
+	if err != nil {
+		return "", errors.New("topic id must be a UUID")
+	}
+
+	return kind + ":" + parsed.String(), nil
+}
+
+func userTopic(userId uuid.UUID) string {
+	return "user:" + userId.String()
+}
+
+func chirpTopic(chirpId uuid.UUID) string {
+	return "chirp:" + chirpId.String()
+}
+
+func notificationsTopic(userId uuid.UUID) string {
+	return "notifications:" + userId.String()
+}
+
+// relationshipsTopic carries the relationship events of a user to their
+// connections, which subscribe to it without asking.
+func relationshipsTopic(userId uuid.UUID) string {
+	return "relationships:" + userId.String()
+}
+
+// relayChirpEvents publishes the events of every replica, as received on
+// sub, to realtime topics until ctx is done or the event hub is closed.
+// Chirp events go to the topics of their author and chirp, notifications
+// and relationship events to their user's.
+func (cfg *apiConfig) relayChirpEvents(ctx context.Context, sub *events.Subscription) {
+	defer sub.Close()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event, ok := <-sub.C:
+			if !ok {
+				return
+			}
+
+			msg := realtime.Message{
+				Type:   event.Type,
+				ID:     strconv.FormatInt(event.ID, 10),
+				UserID: event.UserID,
+				Data:   json.RawMessage(event.Data),
+			}
+
+			if event.Type == notificationEventCreated {
+				msg.Topic = notificationsTopic(event.UserID)
+				cfg.realtime.Publish(msg)
+				continue
+			}
+
+			if event.Type == relationshipEventChanged {
+				msg.Topic = relationshipsTopic(event.UserID)
+				cfg.realtime.Publish(msg)
+				continue
+			}
+
+			// Both created and deleted events carry the chirp's id.
+			var chirp struct {
+				Id uuid.UUID `json:"id"`
+			}
+			err := json.Unmarshal([]byte(event.Data), &chirp)
+			if err != nil {
+				log.Printf("Error decoding chirp event %d: %v", event.ID, err)
+				continue
+			}
+
+			for _, topic := range []string{userTopic(event.UserID), chirpTopic(chirp.Id)} {
+				msg.Topic = topic
+				cfg.realtime.Publish(msg)
+			}
+		}
+	}
+}
@@ -12,34 +12,40 @@ import (
 func (cfg *apiConfig) refreshHandler(w http.ResponseWriter, r *http.Request) {
 	refreshToken, err := auth.GetBearerToken(r.Header)
 	if err != nil {
-		respondWithError(w, http.StatusBadRequest, "Failed to get refresh token from Authorization header", err)
+		respondWithError(w, r, &apiError{Status: http.StatusBadRequest, Code: "missing_token", Detail: "A refresh token is required in the Authorization header", Err: err})
 		return
 	}
 
 	tokenData, err := cfg.db.GetRefreshToken(r.Context(), refreshToken)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			respondWithError(w, http.StatusUnauthorized, "Refresh token does not exist", err)
+			respondWithError(w, r, unauthorizedError("invalid_refresh_token", "Refresh token does not exist", nil))
 			return
 		}
 
-		respondWithError(w, http.StatusInternalServerError, "Error getting refresh token from db", err)
+		respondWithError(w, r, internalError("Error getting refresh token from db", err))
 		return
 	}
 
 	if tokenData.RevokedAt.Valid {
-		respondWithError(w, http.StatusUnauthorized, "Refresh token is revoked", err)
+		respondWithError(w, r, unauthorizedError("refresh_token_revoked", "Refresh token is revoked", nil))
 		return
 	}
 
 	if tokenData.ExpiresAt.Before(time.Now()) {
-		respondWithError(w, http.StatusUnauthorized, "Refresh token is expired", err)
+		respondWithError(w, r, unauthorizedError("refresh_token_expired", "Refresh token is expired", nil))
 		return
 	}
 
-	newAccessToken, err := auth.MakeJWT(tokenData.UserID, cfg.secret, time.Hour)
+	_, err = cfg.activeUser(r.Context(), tokenData.UserID)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Error making making JWT", err)
+		respondWithError(w, r, err)
+		return
+	}
+
+	newAccessToken, err := auth.MakeJWTContext(r.Context(), tokenData.UserID, cfg.secret, time.Hour)
+	if err != nil {
+		respondWithError(w, r, internalError("Error making JWT", err))
 		return
 	}
 
@@ -55,29 +61,29 @@ func (cfg *apiConfig) refreshHandler(w http.ResponseWriter, r *http.Request) {
 func (cfg *apiConfig) revokeHandler(w http.ResponseWriter, r *http.Request) {
 	refreshToken, err := auth.GetBearerToken(r.Header)
 	if err != nil {
-		respondWithError(w, http.StatusBadRequest, "Failed to get bearer token from header", err)
+		respondWithError(w, r, &apiError{Status: http.StatusBadRequest, Code: "missing_token", Detail: "A refresh token is required in the Authorization header", Err: err})
 		return
 	}
 
 	tokenData, err := cfg.db.GetRefreshToken(r.Context(), refreshToken)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			respondWithError(w, http.StatusUnauthorized, "Refresh token does not exist", err)
+			respondWithError(w, r, unauthorizedError("invalid_refresh_token", "Refresh token does not exist", nil))
 			return
 		}
 
-		respondWithError(w, http.StatusInternalServerError, "Error getting refresh token from db", err)
+		respondWithError(w, r, internalError("Error getting refresh token from db", err))
 		return
 	}
 
 	if tokenData.ExpiresAt.Before(time.Now()) {
-		respondWithError(w, http.StatusUnauthorized, "Refresh token is expired", err)
+		respondWithError(w, r, unauthorizedError("refresh_token_expired", "Refresh token is expired", nil))
 		return
 	}
 
 	err = cfg.db.RevokeToken(r.Context(), refreshToken)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Error revoking token", err)
+		respondWithError(w, r, internalError("Error revoking token", err))
 		return
 	}
 
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/vemolista/chirpy/v2/internal/database"
+)
+
+// createReportHandler reports a chirp or an account to the moderators.
+func (cfg *apiConfig) createReportHandler(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		// Exactly one of ChirpId and UserId must be set.
+		ChirpId *uuid.UUID `json:"chirp_id"`
+		UserId  *uuid.UUID `json:"user_id"`
+		Reason  string     `json:"reason" validate:"required,oneof=spam harassment hate violence sexual_content misinformation other"`
+		Details string     `json:"details" validate:"max=500"`
+	}
+
+	userId, err := cfg.authenticate(r)
+	if err != nil {
+		respondWithError(w, r, err)
+		return
+	}
+
+	var params parameters
+	err = decodeJSON(w, r, &params)
+	if err != nil {
+		respondWithError(w, r, err)
+		return
+	}
+
+	if (params.ChirpId == nil) == (params.UserId == nil) {
+		respondWithError(w, r, validationError("validation_failed", "Request body failed validation", fieldError{
+			Field:   "chirp_id",
+			Code:    "required",
+			Message: "exactly one of chirp_id and user_id is required",
+		}))
+		return
+	}
+
+	report := database.CreateReportParams{
+		ReporterID: uuid.NullUUID{UUID: userId, Valid: true},
+		Reason:     params.Reason,
+		Details:    params.Details,
+	}
+
+	if params.ChirpId != nil {
+		chirp, err := cfg.db.GetChirp(r.Context(), *params.ChirpId)
+		if errors.Is(err, sql.ErrNoRows) || (err == nil && !cfg.canSeeChirp(r, chirp)) {
+			respondWithError(w, r, notFoundError("chirp_not_found", fmt.Sprintf("No chirp with Id %s", *params.ChirpId), nil))
+			return
+		}
+		if err != nil {
+			respondWithError(w, r, internalError("Error getting chirp", err))
+			return
+		}
+
+		report.UserID = chirp.UserID
+		report.ChirpID = uuid.NullUUID{UUID: chirp.ID, Valid: true}
+		report.ChirpBody = chirp.Body
+	} else {
+		user, err := cfg.db.GetUser(r.Context(), *params.UserId)
+		if errors.Is(err, sql.ErrNoRows) {
+			respondWithError(w, r, notFoundError("user_not_found", "User does not exist", nil))
+			return
+		}
+		if err != nil {
+			respondWithError(w, r, internalError("Error getting user", err))
+			return
+		}
+
+		report.UserID = user.ID
+	}
+
+	if report.UserID == userId {
+		respondWithError(w, r, validationError("cannot_report_self", "Users cannot report themselves"))
+		return
+	}
+
+	created, err := cfg.db.CreateReport(r.Context(), report)
+	if err != nil {
+		respondWithError(w, r, internalError("Error creating report", err))
+		return
+	}
+
+	respondWithJson(w, http.StatusCreated, newReport(created))
+}
+
+// listReportsHandler lists the open reports for moderators, oldest first.
+func (cfg *apiConfig) listReportsHandler(w http.ResponseWriter, r *http.Request) {
+	_, err := cfg.requireModerator(r)
+	if err != nil {
+		respondWithError(w, r, err)
+		return
+	}
+
+	limit, err := parseLimit(r, defaultModerationLimit, maxModerationLimit)
+	if err != nil {
+		respondWithError(w, r, err)
+		return
+	}
+
+	reports, err := cfg.db.ListOpenReports(r.Context(), int32(limit))
+	if err != nil {
+		respondWithError(w, r, internalError("Error getting reports", err))
+		return
+	}
+
+	response := make([]Report, len(reports))
+	for i, report := range reports {
+		response[i] = newReport(report)
+	}
+
+	respondWithJson(w, http.StatusOK, response)
+}
+
+// decideReportHandler resolves a report with a moderation action and
+// records the decision in the log.
+func (cfg *apiConfig) decideReportHandler(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Action string `json:"action" validate:"required,oneof=hide_chirp warn suspend dismiss"`
+		Note   string `json:"note" validate:"max=500"`
+	}
+
+	moderator, err := cfg.requireModerator(r)
+	if err != nil {
+		respondWithError(w, r, err)
+		return
+	}
+
+	reportId, err := parseUUID("reportId", r.PathValue("reportId"))
+	if err != nil {
+		respondWithError(w, r, err)
+		return
+	}
+
+	var params parameters
+	err = decodeJSON(w, r, &params)
+	if err != nil {
+		respondWithError(w, r, err)
+		return
+	}
+
+	report, err := cfg.db.GetReport(r.Context(), reportId)
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, r, notFoundError("report_not_found", fmt.Sprintf("No report with Id %s", reportId), nil))
+		return
+	}
+	if err != nil {
+		respondWithError(w, r, internalError("Error getting report", err))
+		return
+	}
+
+	if params.Action == moderationHideChirp && !report.ChirpID.Valid {
+		respondWithError(w, r, validationError("validation_failed", "Request body failed validation", fieldError{
+			Field:   "action",
+			Code:    "not_allowed",
+			Message: "can only hide a chirp on a report about a chirp",
+		}))
+		return
+	}
+
+	// Resolving, acting and recording the decision happen together, and
+	// resolving first means only one moderator acts on a report.
+	var action database.ModerationAction
+	var event database.ChirpEvent
+	err = cfg.db.InTx(r.Context(), func(ctx context.Context) error {
+		_, err := cfg.db.ResolveReport(ctx, reportId)
+		if errors.Is(err, sql.ErrNoRows) {
+			return conflictError("report_resolved", "Report is already resolved", nil)
+		}
+		if err != nil {
+			return internalError("Error resolving report", err)
+		}
+
+		switch params.Action {
+		case moderationHideChirp:
+			event, err = cfg.hideChirp(ctx, report.ChirpID.UUID)
+		case moderationSuspend:
+			_, err = cfg.db.SuspendUser(ctx, report.UserID)
+		}
+		if err != nil {
+			return internalError("Error applying moderation action", err)
+		}
+
+		action, err = cfg.db.CreateModerationAction(ctx, database.CreateModerationActionParams{
+			ModeratorID: moderator.ID,
+			UserID:      report.UserID,
+			ChirpID:     report.ChirpID,
+			ReportID:    uuid.NullUUID{UUID: report.ID, Valid: true},
+			Action:      params.Action,
+			Note:        params.Note,
+		})
+		if err != nil {
+			return internalError("Error recording moderation action", err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		respondWithError(w, r, err)
+		return
+	}
+
+	if event.ID != 0 {
+		cfg.deliverEvent(event)
+	}
+
+	if params.Action == moderationWarn {
+		// The warned user is the actor so that the moderator stays
+		// anonymous.
+		cfg.createNotification(r.Context(), report.UserID, report.UserID, notificationWarning, report.ChirpID)
+	}
+
+	respondWithJson(w, http.StatusOK, newModerationAction(action))
+}
+
+// hideChirp hides a chirp and, if it was visible, records the event that
+// tells streams it is gone. The event is zero otherwise.
+func (cfg *apiConfig) hideChirp(ctx context.Context, chirpId uuid.UUID) (database.ChirpEvent, error) {
+	visible, err := cfg.db.GetChirp(ctx, chirpId)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return database.ChirpEvent{}, fmt.Errorf("error getting chirp: %w", err)
+	}
+
+	_, err = cfg.db.HideChirp(ctx, chirpId)
+	if err != nil {
+		return database.ChirpEvent{}, fmt.Errorf("error hiding chirp: %w", err)
+	}
+
+	if visible.Status != chirpStatusPublished {
+		return database.ChirpEvent{}, nil
+	}
+
+	return cfg.recordEvent(ctx, chirpEventDeleted, visible.UserID, deletedChirp{
+		Id:     visible.ID,
+		UserId: visible.UserID,
+	})
+}
+
+// listModerationActionsHandler returns the decision log, newest first,
+// optionally for one user.
+func (cfg *apiConfig) listModerationActionsHandler(w http.ResponseWriter, r *http.Request) {
+	_, err := cfg.requireModerator(r)
+	if err != nil {
+		respondWithError(w, r, err)
+		return
+	}
+
+	var userId uuid.NullUUID
+	if value := r.URL.Query().Get("user_id"); value != "" {
+		userId.UUID, err = parseUUID("user_id", value)
+		if err != nil {
+			respondWithError(w, r, err)
+			return
+		}
+		userId.Valid = true
+	}
+
+	limit, err := parseLimit(r, defaultModerationLimit, maxModerationLimit)
+	if err != nil {
+		respondWithError(w, r, err)
+		return
+	}
+
+	actions, err := cfg.db.ListModerationActions(r.Context(), database.ListModerationActionsParams{
+		UserID:     userId,
+		LimitCount: int32(limit),
+	})
+	if err != nil {
+		respondWithError(w, r, internalError("Error getting moderation actions", err))
+		return
+	}
+
+	response := make([]ModerationAction, len(actions))
+	for i, action := range actions {
+		response[i] = newModerationAction(action)
+	}
+
+	respondWithJson(w, http.StatusOK, response)
+}
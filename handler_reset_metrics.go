@@ -2,20 +2,24 @@ package main
 
 import (
 	"net/http"
+
+	"github.com/vemolista/chirpy/v2/internal/config"
 )
 
 func (cfg *apiConfig) resetMetricsHandler(w http.ResponseWriter, r *http.Request) {
-	if cfg.platform != "dev" {
-		w.WriteHeader(http.StatusForbidden)
+	if cfg.platform != config.PlatformDev {
+		respondWithError(w, r, forbiddenError("dev_only", "Reset is only available in dev"))
 		return
 	}
 
 	err := cfg.db.DeleteUsers(r.Context())
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Something went wrong", err)
+		respondWithError(w, r, internalError("Error deleting users", err))
 		return
 	}
 
+	cfg.metrics.ResetFileserverHits()
+
 	type returnValue struct {
 		Body string `json:"body"`
 	}
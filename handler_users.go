@@ -1,12 +1,13 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
 	"net/http"
 	"time"
 
 	"github.com/vemolista/chirpy/v2/internal/auth"
 	"github.com/vemolista/chirpy/v2/internal/database"
+	"github.com/vemolista/chirpy/v2/internal/store"
 )
 
 type UserResponse struct {
@@ -15,75 +16,93 @@ type UserResponse struct {
 	CreatedAt   time.Time `json:"created_at"`
 	UpdatedAt   time.Time `json:"updated_at"`
 	IsChirpyRed bool      `json:"is_chirpy_red"`
+	Username    *string   `json:"username"`
+	DisplayName string    `json:"display_name"`
+	Bio         string    `json:"bio"`
+	AvatarUrl   string    `json:"avatar_url"`
+	Role        string    `json:"role"`
+}
+
+// newUserResponse is a user as they see themselves. Other users see an
+// Author or a ProfileResponse instead.
+func newUserResponse(user database.User) UserResponse {
+	return UserResponse{
+		Id:          user.ID.String(),
+		Email:       user.Email,
+		CreatedAt:   user.CreatedAt,
+		UpdatedAt:   user.UpdatedAt,
+		IsChirpyRed: user.IsChirpyRed,
+		Username:    usernamePointer(user.Username),
+		DisplayName: user.DisplayName,
+		Bio:         user.Bio,
+		AvatarUrl:   user.AvatarUrl,
+		Role:        user.Role,
+	}
 }
 
 func (cfg *apiConfig) createUserHandler(w http.ResponseWriter, r *http.Request) {
 	type parameters struct {
-		Email    string `json:"email"`
-		Password string `json:"password"`
+		Email    string `json:"email" validate:"required,email,max=254"`
+		Password string `json:"password" validate:"required"`
+		Username string `json:"username" validate:"username"`
 	}
 
-	decoder := json.NewDecoder(r.Body)
 	params := parameters{}
-	err := decoder.Decode(&params)
-
+	err := decodeJSON(w, r, &params)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Something went wrong", err)
+		respondWithError(w, r, err)
 		return
 	}
 
-	hashedPassword, err := auth.HashPassword(params.Password)
+	hashedPassword, err := auth.HashPasswordContext(r.Context(), params.Password)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Error hashing passowrd", err)
+		respondWithError(w, r, internalError("Error hashing password", err))
+		return
 	}
 
 	user, err := cfg.db.CreateUser(r.Context(), database.CreateUserParams{
 		Email:          params.Email,
 		HashedPassword: hashedPassword,
+		Username:       nullUsername(params.Username),
 	})
 
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Something went wrong", err)
+		if store.IsUniqueViolation(err) {
+			respondWithError(w, r, cfg.userConflict(r.Context(), params.Email, err))
+			return
+		}
+
+		respondWithError(w, r, internalError("Error creating user", err))
+		return
 	}
 
-	respondWithJson(w, http.StatusCreated, UserResponse{
-		Id:          user.ID.String(),
-		Email:       user.Email,
-		CreatedAt:   user.CreatedAt,
-		UpdatedAt:   user.UpdatedAt,
-		IsChirpyRed: user.IsChirpyRed,
-	})
+	cfg.resolveMentions(r.Context(), user)
+
+	respondWithJson(w, http.StatusCreated, newUserResponse(user))
 }
 
 func (cfg *apiConfig) updateUserHandler(w http.ResponseWriter, r *http.Request) {
 	type parameters struct {
-		Email    string `json:"email"`
-		Password string `json:"password"`
+		Email    string `json:"email" validate:"required,email,max=254"`
+		Password string `json:"password" validate:"required"`
 	}
 
 	var params parameters
-	decoder := json.NewDecoder(r.Body)
-	err := decoder.Decode(&params)
-	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Error decoding JSON", err)
-		return
-	}
-
-	accessToken, err := auth.GetBearerToken(r.Header)
+	err := decodeJSON(w, r, &params)
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Error getting bearer token from header", err)
+		respondWithError(w, r, err)
 		return
 	}
 
-	userId, err := auth.ValidateJWT(accessToken, cfg.secret)
+	userId, err := cfg.authenticate(r)
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Error validating JWT", err)
+		respondWithError(w, r, err)
 		return
 	}
 
-	hashedPassword, err := auth.HashPassword(params.Password)
+	hashedPassword, err := auth.HashPasswordContext(r.Context(), params.Password)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Error hashing password", err)
+		respondWithError(w, r, internalError("Error hashing password", err))
 		return
 	}
 
@@ -93,15 +112,25 @@ func (cfg *apiConfig) updateUserHandler(w http.ResponseWriter, r *http.Request)
 		HashedPassword: hashedPassword,
 	})
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Error updating user", err)
+		if store.IsUniqueViolation(err) {
+			respondWithError(w, r, conflictError("email_taken", "A user with this email already exists", err))
+			return
+		}
+
+		respondWithError(w, r, internalError("Error updating user", err))
 		return
 	}
 
-	respondWithJson(w, http.StatusOK, UserResponse{
-		Id:          userData.ID.String(),
-		Email:       userData.Email,
-		CreatedAt:   userData.CreatedAt,
-		UpdatedAt:   userData.UpdatedAt,
-		IsChirpyRed: userData.IsChirpyRed,
-	})
+	respondWithJson(w, http.StatusOK, newUserResponse(userData))
+}
+
+// userConflict tells which unique field a new user clashed on: signup takes
+// both an email and a username.
+func (cfg *apiConfig) userConflict(ctx context.Context, email string, err error) error {
+	_, lookupErr := cfg.db.GetUserByEmail(ctx, email)
+	if lookupErr == nil {
+		return conflictError("email_taken", "A user with this email already exists", err)
+	}
+
+	return conflictError("username_taken", "A user with this username already exists", err)
 }
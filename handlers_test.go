@@ -0,0 +1,2111 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"image"
+	"image/png"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/coder/websocket"
+	"github.com/coder/websocket/wsjson"
+	"github.com/google/uuid"
+	"github.com/vemolista/chirpy/v2/internal/auth"
+	"github.com/vemolista/chirpy/v2/internal/config"
+	"github.com/vemolista/chirpy/v2/internal/database"
+	"github.com/vemolista/chirpy/v2/internal/linkpreview"
+	"github.com/vemolista/chirpy/v2/internal/ratelimit"
+	"github.com/vemolista/chirpy/v2/internal/realtime"
+)
+
+type fixture struct {
+	*testServer
+	alice      loginResponse
+	bob        loginResponse
+	aliceChirp Chirp
+}
+
+func newFixture(t *testing.T) *fixture {
+	t.Helper()
+
+	s := newTestServer(t)
+	s.signup("alice@example.com", "alice-password")
+	s.signup("bob@example.com", "bob-password")
+
+	f := &fixture{
+		testServer: s,
+		alice:      s.login("alice@example.com", "alice-password"),
+		bob:        s.login("bob@example.com", "bob-password"),
+	}
+	f.aliceChirp = s.createChirp(f.alice.Token, "hello from alice")
+
+	return f
+}
+
+func TestHandlerStatuses(t *testing.T) {
+	f := newFixture(t)
+
+	expiredToken, err := auth.MakeJWT(uuid.MustParse(f.alice.Id), testSecret, -time.Minute)
+	if err != nil {
+		t.Fatalf("expected to make jwt: %v", err)
+	}
+
+	foreignToken, err := auth.MakeJWT(uuid.MustParse(f.alice.Id), "not the test secret", time.Minute)
+	if err != nil {
+		t.Fatalf("expected to make jwt: %v", err)
+	}
+
+	chirpPath := "/api/chirps/" + f.aliceChirp.Id.String()
+
+	cases := []struct {
+		name          string
+		method        string
+		path          string
+		authorization string
+		body          any
+		status        int
+	}{
+		{"health", "GET", "/api/healthz", "", nil, http.StatusOK},
+		{"ready", "GET", "/api/readyz", "", nil, http.StatusOK},
+
+		{"login with malformed JSON", "POST", "/api/login", "", "{", http.StatusBadRequest},
+		{"login with wrong password", "POST", "/api/login", "", map[string]string{"email": "alice@example.com", "password": "wrong"}, http.StatusUnauthorized},
+		{"login with unknown email", "POST", "/api/login", "", map[string]string{"email": "carol@example.com", "password": "x"}, http.StatusUnauthorized},
+
+		{"signup with taken email", "POST", "/api/users", "", map[string]string{"email": "alice@example.com", "password": "x"}, http.StatusConflict},
+		{"signup with invalid email", "POST", "/api/users", "", map[string]string{"email": "not-an-email", "password": "x"}, http.StatusBadRequest},
+		{"signup without password", "POST", "/api/users", "", map[string]string{"email": "carol@example.com"}, http.StatusBadRequest},
+		{"update user without token", "PUT", "/api/users", "", map[string]string{"email": "a@example.com", "password": "x"}, http.StatusUnauthorized},
+		{"update user with expired token", "PUT", "/api/users", bearer(expiredToken), map[string]string{"email": "a@example.com", "password": "x"}, http.StatusUnauthorized},
+
+		{"create chirp without token", "POST", "/api/chirps", "", map[string]string{"body": "hi"}, http.StatusUnauthorized},
+		{"create chirp with malformed header", "POST", "/api/chirps", "Token " + f.alice.Token, map[string]string{"body": "hi"}, http.StatusUnauthorized},
+		{"create chirp with expired token", "POST", "/api/chirps", bearer(expiredToken), map[string]string{"body": "hi"}, http.StatusUnauthorized},
+		{"create chirp with token signed elsewhere", "POST", "/api/chirps", bearer(foreignToken), map[string]string{"body": "hi"}, http.StatusUnauthorized},
+		{"create chirp with refresh token", "POST", "/api/chirps", bearer(f.alice.RefreshToken), map[string]string{"body": "hi"}, http.StatusUnauthorized},
+		{"create chirp with malformed JSON", "POST", "/api/chirps", bearer(f.alice.Token), "{", http.StatusBadRequest},
+		{"create chirp with unknown field", "POST", "/api/chirps", bearer(f.alice.Token), map[string]string{"body": "hi", "mood": "happy"}, http.StatusBadRequest},
+		{"create empty chirp", "POST", "/api/chirps", bearer(f.alice.Token), map[string]string{"body": ""}, http.StatusBadRequest},
+		{"create chirp that is too long", "POST", "/api/chirps", bearer(f.alice.Token), map[string]string{"body": strings.Repeat("a", 142)}, http.StatusBadRequest},
+
+		{"list chirps", "GET", "/api/chirps", "", nil, http.StatusOK},
+		{"list chirps with bad author", "GET", "/api/chirps?author_id=nope", "", nil, http.StatusBadRequest},
+		{"get chirp", "GET", chirpPath, "", nil, http.StatusOK},
+		{"get chirp with bad id", "GET", "/api/chirps/not-a-uuid", "", nil, http.StatusBadRequest},
+		{"get missing chirp", "GET", "/api/chirps/" + uuid.NewString(), "", nil, http.StatusNotFound},
+
+		{"edit chirp without token", "PUT", chirpPath, "", map[string]string{"body": "edited"}, http.StatusUnauthorized},
+		{"edit chirp of another user", "PUT", chirpPath, bearer(f.bob.Token), map[string]string{"body": "edited"}, http.StatusForbidden},
+		{"edit missing chirp", "PUT", "/api/chirps/" + uuid.NewString(), bearer(f.bob.Token), map[string]string{"body": "edited"}, http.StatusNotFound},
+		{"edit chirp to be too long", "PUT", chirpPath, bearer(f.alice.Token), map[string]string{"body": strings.Repeat("a", 142)}, http.StatusBadRequest},
+
+		{"delete chirp without token", "DELETE", chirpPath, "", nil, http.StatusUnauthorized},
+		{"delete chirp of another user", "DELETE", chirpPath, bearer(f.bob.Token), nil, http.StatusForbidden},
+		{"delete chirp with bad id", "DELETE", "/api/chirps/not-a-uuid", bearer(f.bob.Token), nil, http.StatusBadRequest},
+		{"delete missing chirp", "DELETE", "/api/chirps/" + uuid.NewString(), bearer(f.bob.Token), nil, http.StatusNotFound},
+
+		{"hashtag chirps", "GET", "/api/hashtags/Go/chirps", "", nil, http.StatusOK},
+		{"hashtag chirps with bad tag", "GET", "/api/hashtags/not-a-tag/chirps", "", nil, http.StatusBadRequest},
+
+		{"create chirp with unknown status", "POST", "/api/chirps", bearer(f.alice.Token), map[string]string{"body": "hi", "status": "pending"}, http.StatusBadRequest},
+		{"schedule chirp without publish_at", "POST", "/api/chirps", bearer(f.alice.Token), map[string]string{"body": "hi", "status": "scheduled"}, http.StatusBadRequest},
+		{"schedule chirp in the past", "POST", "/api/chirps", bearer(f.alice.Token), map[string]any{"body": "hi", "publish_at": time.Now().Add(-time.Minute)}, http.StatusBadRequest},
+		{"draft chirp with publish_at", "POST", "/api/chirps", bearer(f.alice.Token), map[string]any{"body": "hi", "status": "draft", "publish_at": time.Now().Add(time.Hour)}, http.StatusBadRequest},
+		{"list drafts without token", "GET", "/api/chirps/drafts", "", nil, http.StatusUnauthorized},
+		{"publish published chirp", "POST", chirpPath + "/publish", bearer(f.alice.Token), nil, http.StatusConflict},
+		{"publish chirp of another user", "POST", chirpPath + "/publish", bearer(f.bob.Token), nil, http.StatusForbidden},
+		{"publish missing chirp", "POST", "/api/chirps/" + uuid.NewString() + "/publish", bearer(f.alice.Token), nil, http.StatusNotFound},
+		{"restore chirp without token", "POST", chirpPath + "/restore", "", nil, http.StatusUnauthorized},
+		{"restore chirp that isn't deleted", "POST", chirpPath + "/restore", bearer(f.alice.Token), nil, http.StatusNotFound},
+		{"restore missing chirp", "POST", "/api/chirps/" + uuid.NewString() + "/restore", bearer(f.alice.Token), nil, http.StatusNotFound},
+
+		{"report without token", "POST", "/api/reports", "", map[string]string{"user_id": f.alice.Id, "reason": "spam"}, http.StatusUnauthorized},
+		{"report with unknown reason", "POST", "/api/reports", bearer(f.bob.Token), map[string]string{"user_id": f.alice.Id, "reason": "boring"}, http.StatusBadRequest},
+		{"report without target", "POST", "/api/reports", bearer(f.bob.Token), map[string]string{"reason": "spam"}, http.StatusBadRequest},
+		{"report missing user", "POST", "/api/reports", bearer(f.bob.Token), map[string]string{"user_id": uuid.NewString(), "reason": "spam"}, http.StatusNotFound},
+		{"report yourself", "POST", "/api/reports", bearer(f.alice.Token), map[string]string{"user_id": f.alice.Id, "reason": "spam"}, http.StatusBadRequest},
+		{"list reports without token", "GET", "/api/moderation/reports", "", nil, http.StatusUnauthorized},
+		{"list reports as a user", "GET", "/api/moderation/reports", bearer(f.alice.Token), nil, http.StatusForbidden},
+		{"decide report as a user", "POST", "/api/moderation/reports/" + uuid.NewString() + "/decision", bearer(f.alice.Token), map[string]string{"action": "dismiss"}, http.StatusForbidden},
+		{"list moderation actions as a user", "GET", "/api/moderation/actions", bearer(f.alice.Token), nil, http.StatusForbidden},
+
+		{"stream chirps with bad author", "GET", "/api/chirps/stream?author_id=nope", "", nil, http.StatusBadRequest},
+		{"stream followed chirps without token", "GET", "/api/chirps/stream?following=true", "", nil, http.StatusUnauthorized},
+		{"stream chirps with bad following", "GET", "/api/chirps/stream?following=maybe", "", nil, http.StatusBadRequest},
+
+		{"profile", "GET", "/api/users/nobody", "", nil, http.StatusNotFound},
+		{"update profile without token", "PATCH", "/api/users/me", "", map[string]string{"bio": "hi"}, http.StatusUnauthorized},
+		{"update profile with reserved username", "PATCH", "/api/users/me", bearer(f.alice.Token), map[string]string{"username": "admin"}, http.StatusBadRequest},
+		{"update profile with invalid username", "PATCH", "/api/users/me", bearer(f.alice.Token), map[string]string{"username": "no spaces"}, http.StatusBadRequest},
+		{"update profile with bad avatar", "PATCH", "/api/users/me", bearer(f.alice.Token), map[string]string{"avatar_url": "ftp://example.com/a.png"}, http.StatusBadRequest},
+		{"update profile with long bio", "PATCH", "/api/users/me", bearer(f.alice.Token), map[string]string{"bio": strings.Repeat("a", 161)}, http.StatusBadRequest},
+
+		{"upload media without token", "POST", "/api/media", "", "", http.StatusUnauthorized},
+		{"upload media as JSON", "POST", "/api/media", bearer(f.alice.Token), map[string]string{"file": "x"}, http.StatusUnsupportedMediaType},
+		{"get missing media", "GET", "/api/media/" + uuid.NewString(), "", nil, http.StatusNotFound},
+		{"get media with bad id", "GET", "/api/media/not-a-uuid/thumbnail", "", nil, http.StatusBadRequest},
+		{"create chirp with missing media", "POST", "/api/chirps", bearer(f.alice.Token), map[string]any{"body": "hi", "media_ids": []string{uuid.NewString()}}, http.StatusBadRequest},
+		{"create chirp with too many media", "POST", "/api/chirps", bearer(f.alice.Token), map[string]any{"body": "hi", "media_ids": []string{uuid.NewString(), uuid.NewString(), uuid.NewString(), uuid.NewString(), uuid.NewString()}}, http.StatusBadRequest},
+
+		{"follow user without token", "POST", "/api/users/" + f.bob.Id + "/follow", "", nil, http.StatusUnauthorized},
+		{"follow yourself", "POST", "/api/users/" + f.alice.Id + "/follow", bearer(f.alice.Token), nil, http.StatusBadRequest},
+		{"follow user with bad id", "POST", "/api/users/not-a-uuid/follow", bearer(f.alice.Token), nil, http.StatusBadRequest},
+		{"follow missing user", "POST", "/api/users/" + uuid.NewString() + "/follow", bearer(f.alice.Token), nil, http.StatusNotFound},
+		{"unfollow user not followed", "DELETE", "/api/users/" + f.bob.Id + "/follow", bearer(f.alice.Token), nil, http.StatusNoContent},
+		{"block user without token", "POST", "/api/users/" + f.bob.Id + "/block", "", nil, http.StatusUnauthorized},
+		{"block yourself", "POST", "/api/users/" + f.alice.Id + "/block", bearer(f.alice.Token), nil, http.StatusBadRequest},
+		{"block missing user", "POST", "/api/users/" + uuid.NewString() + "/block", bearer(f.alice.Token), nil, http.StatusNotFound},
+		{"unblock user not blocked", "DELETE", "/api/users/" + f.bob.Id + "/block", bearer(f.alice.Token), nil, http.StatusNoContent},
+		{"mute yourself", "POST", "/api/users/" + f.alice.Id + "/mute", bearer(f.alice.Token), nil, http.StatusBadRequest},
+		{"mute missing user", "POST", "/api/users/" + uuid.NewString() + "/mute", bearer(f.alice.Token), nil, http.StatusNotFound},
+		{"unmute user not muted", "DELETE", "/api/users/" + f.bob.Id + "/mute", bearer(f.alice.Token), nil, http.StatusNoContent},
+		{"bookmark without token", "POST", "/api/chirps/" + f.aliceChirp.Id.String() + "/bookmark", "", nil, http.StatusUnauthorized},
+		{"bookmark missing chirp", "POST", "/api/chirps/" + uuid.NewString() + "/bookmark", bearer(f.bob.Token), nil, http.StatusNotFound},
+		{"remove missing bookmark", "DELETE", "/api/chirps/" + f.aliceChirp.Id.String() + "/bookmark", bearer(f.bob.Token), nil, http.StatusNoContent},
+		{"bookmarks without token", "GET", "/api/bookmarks", "", nil, http.StatusUnauthorized},
+		{"bookmarks with bad limit", "GET", "/api/bookmarks?limit=0", bearer(f.bob.Token), nil, http.StatusBadRequest},
+		{"bookmarks before unknown chirp", "GET", "/api/bookmarks?before=" + uuid.NewString(), bearer(f.bob.Token), nil, http.StatusBadRequest},
+		{"pin another user's chirp", "POST", "/api/chirps/" + f.aliceChirp.Id.String() + "/pin", bearer(f.bob.Token), nil, http.StatusForbidden},
+		{"pin missing chirp", "POST", "/api/chirps/" + uuid.NewString() + "/pin", bearer(f.alice.Token), nil, http.StatusNotFound},
+		{"create poll with one option", "POST", "/api/chirps", bearer(f.alice.Token), map[string]any{"body": "hi", "poll": map[string]any{"options": []string{"a"}, "closes_at": time.Now().Add(time.Hour)}}, http.StatusBadRequest},
+		{"create poll with five options", "POST", "/api/chirps", bearer(f.alice.Token), map[string]any{"body": "hi", "poll": map[string]any{"options": []string{"a", "b", "c", "d", "e"}, "closes_at": time.Now().Add(time.Hour)}}, http.StatusBadRequest},
+		{"create poll with repeated options", "POST", "/api/chirps", bearer(f.alice.Token), map[string]any{"body": "hi", "poll": map[string]any{"options": []string{"yes", "Yes "}, "closes_at": time.Now().Add(time.Hour)}}, http.StatusBadRequest},
+		{"create poll without expiry", "POST", "/api/chirps", bearer(f.alice.Token), map[string]any{"body": "hi", "poll": map[string]any{"options": []string{"a", "b"}}}, http.StatusBadRequest},
+		{"create poll that closed", "POST", "/api/chirps", bearer(f.alice.Token), map[string]any{"body": "hi", "poll": map[string]any{"options": []string{"a", "b"}, "closes_at": time.Now().Add(-time.Minute)}}, http.StatusBadRequest},
+		{"create poll open too long", "POST", "/api/chirps", bearer(f.alice.Token), map[string]any{"body": "hi", "poll": map[string]any{"options": []string{"a", "b"}, "closes_at": time.Now().Add(8 * 24 * time.Hour)}}, http.StatusBadRequest},
+		{"create draft with poll", "POST", "/api/chirps", bearer(f.alice.Token), map[string]any{"body": "hi", "status": "draft", "poll": map[string]any{"options": []string{"a", "b"}, "closes_at": time.Now().Add(time.Hour)}}, http.StatusBadRequest},
+		{"vote without token", "POST", chirpPath + "/poll/votes", "", map[string]string{"option_id": uuid.NewString()}, http.StatusUnauthorized},
+		{"vote without option", "POST", chirpPath + "/poll/votes", bearer(f.bob.Token), map[string]string{}, http.StatusBadRequest},
+		{"vote on chirp without poll", "POST", chirpPath + "/poll/votes", bearer(f.bob.Token), map[string]string{"option_id": uuid.NewString()}, http.StatusNotFound},
+		{"vote on missing chirp", "POST", "/api/chirps/" + uuid.NewString() + "/poll/votes", bearer(f.bob.Token), map[string]string{"option_id": uuid.NewString()}, http.StatusNotFound},
+		{"unpin chirp not pinned", "DELETE", "/api/chirps/" + f.aliceChirp.Id.String() + "/pin", bearer(f.alice.Token), nil, http.StatusNoContent},
+		{"list chirps with invalid token", "GET", "/api/chirps", bearer("not a token"), nil, http.StatusOK},
+		{"list chirps with expired token", "GET", "/api/chirps", bearer(expiredToken), nil, http.StatusOK},
+		{"hashtag chirps with invalid token", "GET", "/api/hashtags/go/chirps", bearer("not a token"), nil, http.StatusOK},
+
+		{"notifications without token", "GET", "/api/notifications", "", nil, http.StatusUnauthorized},
+		{"notifications with bad limit", "GET", "/api/notifications?limit=0", bearer(f.alice.Token), nil, http.StatusBadRequest},
+		{"notifications before unknown id", "GET", "/api/notifications?before=" + uuid.NewString(), bearer(f.alice.Token), nil, http.StatusBadRequest},
+		{"read notifications without ids", "POST", "/api/notifications/read", bearer(f.alice.Token), map[string]any{}, http.StatusBadRequest},
+		{"read notifications with ids and all", "POST", "/api/notifications/read", bearer(f.alice.Token), map[string]any{"ids": []string{uuid.NewString()}, "all": true}, http.StatusBadRequest},
+		{"set unknown notification preference", "PUT", "/api/notifications/preferences", bearer(f.alice.Token), map[string]bool{"pokes": true}, http.StatusBadRequest},
+
+		{"refresh without token", "POST", "/api/refresh", "", nil, http.StatusBadRequest},
+		{"refresh with access token", "POST", "/api/refresh", bearer(f.alice.Token), nil, http.StatusUnauthorized},
+		{"revoke unknown token", "POST", "/api/revoke", bearer("unknown"), nil, http.StatusUnauthorized},
+
+		{"polka without key", "POST", "/api/polka/webhooks", "", map[string]any{"event": "user.upgraded"}, http.StatusUnauthorized},
+		{"polka with wrong key", "POST", "/api/polka/webhooks", "ApiKey wrong", map[string]any{"event": "user.upgraded"}, http.StatusUnauthorized},
+		{"polka with bearer token", "POST", "/api/polka/webhooks", bearer(testPolkaKey), map[string]any{"event": "user.upgraded"}, http.StatusUnauthorized},
+		{"polka with malformed JSON", "POST", "/api/polka/webhooks", "ApiKey " + testPolkaKey, "{", http.StatusBadRequest},
+		{"polka ignores other events", "POST", "/api/polka/webhooks", "ApiKey " + testPolkaKey, map[string]any{"event": "user.downgraded"}, http.StatusNoContent},
+		{"polka with unknown user", "POST", "/api/polka/webhooks", "ApiKey " + testPolkaKey, map[string]any{"event": "user.upgraded", "data": map[string]string{"user_id": uuid.NewString()}}, http.StatusNotFound},
+
+		{"admin metrics", "GET", "/admin/metrics", "", nil, http.StatusOK},
+		{"prometheus metrics", "GET", "/metrics", "", nil, http.StatusOK},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			res := f.do(c.method, c.path, c.authorization, c.body)
+			if res.status != c.status {
+				t.Errorf("expected %d, instead got %d: %s", c.status, res.status, res.body)
+			}
+		})
+	}
+}
+
+func TestReadyHidesErrors(t *testing.T) {
+	f := newFixture(t)
+
+	conn, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("expected to open sqlite: %v", err)
+	}
+	conn.Close()
+	f.cfg.dbConn = conn
+
+	res := f.do("GET", "/api/readyz", "", nil)
+	if res.status != http.StatusServiceUnavailable || strings.TrimSpace(string(res.body)) != `{"status":"not ready"}` {
+		t.Errorf("expected 503 without the database error, instead got %d: %s", res.status, res.body)
+	}
+}
+
+func TestProblemDetails(t *testing.T) {
+	f := newFixture(t)
+
+	cases := []struct {
+		name   string
+		method string
+		path   string
+		body   any
+		status int
+		code   string
+		field  string
+	}{
+		{"bad id", "GET", "/api/chirps/not-a-uuid", nil, http.StatusBadRequest, "invalid_id", "chirpId"},
+		{"missing chirp", "GET", "/api/chirps/" + uuid.NewString(), nil, http.StatusNotFound, "chirp_not_found", ""},
+		{"chirp too long", "POST", "/api/chirps", map[string]string{"body": strings.Repeat("a", 142)}, http.StatusBadRequest, "chirp_too_long", "body"},
+		{"malformed JSON", "POST", "/api/chirps", "{", http.StatusBadRequest, "malformed_json", ""},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			res := f.do(c.method, c.path, bearer(f.alice.Token), c.body)
+			if res.status != c.status {
+				t.Fatalf("expected %d, instead got %d: %s", c.status, res.status, res.body)
+			}
+
+			if contentType := res.header.Get("Content-Type"); contentType != "application/problem+json" {
+				t.Errorf("expected application/problem+json, instead got %q", contentType)
+			}
+
+			var p problem
+			res.decode(t, &p)
+
+			if p.Status != c.status || p.Code != c.code || p.Instance != c.path {
+				t.Errorf("expected status %d, code %q and instance %q, instead got %+v", c.status, c.code, c.path, p)
+			}
+
+			if p.RequestID == "" || p.RequestID != res.header.Get(requestIDHeader) {
+				t.Errorf("expected request_id to match the %s header, instead got %q", requestIDHeader, p.RequestID)
+			}
+
+			if c.field != "" && (len(p.Errors) != 1 || p.Errors[0].Field != c.field) {
+				t.Errorf("expected an error for field %q, instead got %+v", c.field, p.Errors)
+			}
+		})
+	}
+}
+
+func TestRequestID(t *testing.T) {
+	s := newTestServer(t)
+
+	req, err := http.NewRequest("GET", s.server.URL+"/api/healthz", nil)
+	if err != nil {
+		t.Fatalf("expected to build request: %v", err)
+	}
+	req.Header.Set(requestIDHeader, "client-id.1")
+
+	res, err := s.server.Client().Do(req)
+	if err != nil {
+		t.Fatalf("expected request to succeed: %v", err)
+	}
+	res.Body.Close()
+
+	if id := res.Header.Get(requestIDHeader); id != "client-id.1" {
+		t.Errorf("expected the client's request ID to be echoed, instead got %q", id)
+	}
+
+	req.Header.Set(requestIDHeader, "not a valid id")
+	res, err = s.server.Client().Do(req)
+	if err != nil {
+		t.Fatalf("expected request to succeed: %v", err)
+	}
+	res.Body.Close()
+
+	if id := res.Header.Get(requestIDHeader); uuid.Validate(id) != nil {
+		t.Errorf("expected an invalid request ID to be replaced, instead got %q", id)
+	}
+}
+
+func TestSignupAndLogin(t *testing.T) {
+	s := newTestServer(t)
+
+	user := s.signup("carol@example.com", "carol-password")
+	if user.Email != "carol@example.com" || user.IsChirpyRed {
+		t.Errorf("expected a new user without Chirpy Red, instead got %+v", user)
+	}
+
+	res := s.do("POST", "/api/users", "", map[string]string{"email": "dave@example.com", "password": "pw"})
+	if strings.Contains(string(res.body), "password") {
+		t.Errorf("expected signup response to omit the password, instead got %s", res.body)
+	}
+
+	login := s.login("carol@example.com", "carol-password")
+	if login.Id != user.Id || login.Token == "" || login.RefreshToken == "" {
+		t.Errorf("expected login to return tokens for the user, instead got %+v", login)
+	}
+
+	userId, err := auth.ValidateJWT(login.Token, testSecret)
+	if err != nil || userId.String() != user.Id {
+		t.Errorf("expected access token for %s, instead got %s, %v", user.Id, userId, err)
+	}
+}
+
+func TestUpdateUser(t *testing.T) {
+	f := newFixture(t)
+
+	res := f.do("PUT", "/api/users", bearer(f.alice.Token), map[string]string{
+		"email":    "alice@new.example.com",
+		"password": "new-password",
+	})
+	if res.status != http.StatusOK {
+		t.Fatalf("expected 200, instead got %d: %s", res.status, res.body)
+	}
+
+	var user UserResponse
+	res.decode(t, &user)
+	if user.Email != "alice@new.example.com" || user.Id != f.alice.Id {
+		t.Errorf("expected updated email for alice, instead got %+v", user)
+	}
+
+	f.login("alice@new.example.com", "new-password")
+
+	res = f.do("POST", "/api/login", "", map[string]string{"email": "alice@example.com", "password": "alice-password"})
+	if res.status != http.StatusUnauthorized {
+		t.Errorf("expected old credentials to fail, instead got %d", res.status)
+	}
+}
+
+func TestRefreshAndRevoke(t *testing.T) {
+	f := newFixture(t)
+
+	res := f.do("POST", "/api/refresh", bearer(f.alice.RefreshToken), nil)
+	if res.status != http.StatusOK {
+		t.Fatalf("expected 200, instead got %d: %s", res.status, res.body)
+	}
+
+	var refreshed struct {
+		Token string `json:"token"`
+	}
+	res.decode(t, &refreshed)
+
+	userId, err := auth.ValidateJWT(refreshed.Token, testSecret)
+	if err != nil || userId.String() != f.alice.Id {
+		t.Errorf("expected a new access token for alice, instead got %s, %v", userId, err)
+	}
+
+	res = f.do("POST", "/api/revoke", bearer(f.alice.RefreshToken), nil)
+	if res.status != http.StatusNoContent {
+		t.Fatalf("expected 204, instead got %d: %s", res.status, res.body)
+	}
+
+	res = f.do("POST", "/api/refresh", bearer(f.alice.RefreshToken), nil)
+	if res.status != http.StatusUnauthorized {
+		t.Errorf("expected revoked token to be rejected, instead got %d", res.status)
+	}
+
+	res = f.do("POST", "/api/refresh", bearer(f.bob.RefreshToken), nil)
+	if res.status != http.StatusOK {
+		t.Errorf("expected bob's token to be unaffected, instead got %d", res.status)
+	}
+}
+
+func TestChirpCRUD(t *testing.T) {
+	f := newFixture(t)
+
+	cleaned := f.createChirp(f.bob.Token, "what a Kerfuffle this sharbert is")
+	if cleaned.Body != "what a **** this **** is" {
+		t.Errorf("expected profanity to be cleaned, instead got %q", cleaned.Body)
+	}
+
+	if cleaned.UserId.String() != f.bob.Id {
+		t.Errorf("expected chirp to belong to bob, instead got %s", cleaned.UserId)
+	}
+
+	res := f.do("GET", "/api/chirps", "", nil)
+	var chirps []Chirp
+	res.decode(t, &chirps)
+	if len(chirps) != 2 || chirps[0].Id != f.aliceChirp.Id {
+		t.Fatalf("expected both chirps oldest first, instead got %+v", chirps)
+	}
+
+	res = f.do("GET", "/api/chirps?sort=desc", "", nil)
+	res.decode(t, &chirps)
+	if len(chirps) != 2 || chirps[0].Id != cleaned.Id {
+		t.Errorf("expected newest chirp first, instead got %+v", chirps)
+	}
+
+	res = f.do("GET", "/api/chirps?author_id="+f.alice.Id, "", nil)
+	res.decode(t, &chirps)
+	if len(chirps) != 1 || chirps[0].Id != f.aliceChirp.Id {
+		t.Errorf("expected only alice's chirp, instead got %+v", chirps)
+	}
+
+	res = f.do("GET", "/api/chirps/"+cleaned.Id.String(), "", nil)
+	var chirp Chirp
+	res.decode(t, &chirp)
+	if chirp.Id != cleaned.Id || chirp.Body != cleaned.Body {
+		t.Errorf("expected to get the created chirp, instead got %+v", chirp)
+	}
+
+	res = f.do("DELETE", "/api/chirps/"+cleaned.Id.String(), bearer(f.bob.Token), nil)
+	if res.status != http.StatusNoContent {
+		t.Fatalf("expected 204, instead got %d: %s", res.status, res.body)
+	}
+
+	res = f.do("GET", "/api/chirps/"+cleaned.Id.String(), "", nil)
+	if res.status != http.StatusNotFound {
+		t.Errorf("expected deleted chirp to be gone, instead got %d", res.status)
+	}
+}
+
+func TestChirpEntities(t *testing.T) {
+	f := newFixture(t)
+
+	chirp := f.createChirp(f.alice.Token, "hi @Bob, #GoLang news: https://go.dev/blog.")
+	mentions, hashtags, urls := chirp.Entities.Mentions, chirp.Entities.Hashtags, chirp.Entities.Urls
+	if len(mentions) != 1 || mentions[0].Handle != "bob" || mentions[0].Start != 3 || mentions[0].End != 7 {
+		t.Errorf("expected a mention of bob at 3-7, instead got %+v", mentions)
+	}
+	if len(hashtags) != 1 || hashtags[0].Tag != "golang" || chirp.Body[hashtags[0].Start:hashtags[0].End] != "#GoLang" {
+		t.Errorf("expected the #GoLang hashtag, instead got %+v", hashtags)
+	}
+	if len(urls) != 1 || urls[0].Url != "https://go.dev/blog" {
+		t.Errorf("expected the url without the full stop, instead got %+v", urls)
+	}
+
+	var got Chirp
+	f.do("GET", "/api/chirps/"+chirp.Id.String(), "", nil).decode(t, &got)
+	if len(got.Entities.Hashtags) != 1 || len(got.Entities.Mentions) != 1 || len(got.Entities.Urls) != 1 {
+		t.Errorf("expected the stored entities, instead got %+v", got.Entities)
+	}
+
+	f.createChirp(f.bob.Token, "more #golang")
+
+	var tagged []Chirp
+	f.do("GET", "/api/hashtags/%23GOLANG/chirps?sort=desc", "", nil).decode(t, &tagged)
+	if len(tagged) != 2 || tagged[0].UserId.String() != f.bob.Id || tagged[1].Id != chirp.Id {
+		t.Fatalf("expected both #golang chirps, newest first, instead got %+v", tagged)
+	}
+
+	res := f.send("PUT", "/api/chirps/"+chirp.Id.String(), http.Header{
+		"Authorization": {bearer(f.alice.Token)},
+		"If-Match":      {chirpETag(chirp)},
+	}, map[string]string{"body": "now about #rust"})
+	if res.status != http.StatusOK {
+		t.Fatalf("expected 200, instead got %d: %s", res.status, res.body)
+	}
+	res.decode(t, &got)
+	if len(got.Entities.Hashtags) != 1 || got.Entities.Hashtags[0].Tag != "rust" || len(got.Entities.Mentions) != 0 {
+		t.Errorf("expected the edit to replace the entities, instead got %+v", got.Entities)
+	}
+
+	f.do("GET", "/api/hashtags/golang/chirps", "", nil).decode(t, &tagged)
+	if len(tagged) != 1 || tagged[0].UserId.String() != f.bob.Id {
+		t.Errorf("expected only bob's chirp to be left under #golang, instead got %+v", tagged)
+	}
+}
+
+func TestProfiles(t *testing.T) {
+	f := newFixture(t)
+
+	var user UserResponse
+	res := f.do("PATCH", "/api/users/me", bearer(f.alice.Token), map[string]string{
+		"username":     "Alice",
+		"display_name": "Alice A.",
+		"avatar_url":   "https://example.com/alice.png",
+	})
+	res.decode(t, &user)
+	if res.status != http.StatusOK || user.Username == nil || *user.Username != "alice" || user.DisplayName != "Alice A." {
+		t.Fatalf("expected alice's profile with a lowercased username, instead got %d: %s", res.status, res.body)
+	}
+
+	f.do("PATCH", "/api/users/me", bearer(f.alice.Token), map[string]string{"bio": "hello"}).decode(t, &user)
+	if user.Bio != "hello" || user.DisplayName != "Alice A." || user.Username == nil {
+		t.Errorf("expected the other fields to be kept, instead got %+v", user)
+	}
+
+	res = f.do("PATCH", "/api/users/me", bearer(f.bob.Token), map[string]string{"username": "ALICE"})
+	if res.status != http.StatusConflict || !strings.Contains(string(res.body), "username_taken") {
+		t.Errorf("expected usernames to clash regardless of case, instead got %d: %s", res.status, res.body)
+	}
+
+	res = f.do("POST", "/api/users", "", map[string]string{"email": "other@example.com", "password": "x", "username": "alice"})
+	if res.status != http.StatusConflict || !strings.Contains(string(res.body), "username_taken") {
+		t.Errorf("expected signup with a taken username to conflict, instead got %d: %s", res.status, res.body)
+	}
+
+	// Mentions of a username nobody has yet resolve once someone takes it.
+	mention := f.createChirp(f.alice.Token, "waiting for @carol")
+	if mention.Entities.Mentions[0].UserId != nil {
+		t.Errorf("expected an unknown handle not to resolve, instead got %+v", mention.Entities.Mentions)
+	}
+	if mention.Author.Username == nil || *mention.Author.Username != "alice" || mention.Author.AvatarUrl != "https://example.com/alice.png" {
+		t.Errorf("expected alice as the author, instead got %+v", mention.Author)
+	}
+
+	// The author is part of every chirp, so a profile edit changes the
+	// ETags of their chirps and of lists with them.
+	etags := map[string]string{}
+	for _, path := range []string{"/api/chirps/" + mention.Id.String(), "/api/chirps"} {
+		etags[path] = f.do("GET", path, "", nil).header.Get("ETag")
+	}
+	f.do("PATCH", "/api/users/me", bearer(f.alice.Token), map[string]string{"display_name": "Alice B."})
+	for path, etag := range etags {
+		res = f.send("GET", path, http.Header{"If-None-Match": {etag}}, nil)
+		if res.status != http.StatusOK || !strings.Contains(string(res.body), "Alice B.") {
+			t.Errorf("expected %s to change with its author's profile, instead got %d: %s", path, res.status, res.body)
+		}
+	}
+
+	res = f.do("POST", "/api/users", "", map[string]string{"email": "carol@example.com", "password": "carol-password", "username": "Carol"})
+	if res.status != http.StatusCreated {
+		t.Fatalf("expected signup with a username to return 201, instead got %d: %s", res.status, res.body)
+	}
+	carol := f.login("carol@example.com", "carol-password")
+
+	var got Chirp
+	f.do("GET", "/api/chirps/"+mention.Id.String(), "", nil).decode(t, &got)
+	if len(got.Entities.Mentions) != 1 || got.Entities.Mentions[0].UserId == nil || got.Entities.Mentions[0].UserId.String() != carol.Id {
+		t.Errorf("expected the mention to resolve to carol, instead got %+v", got.Entities.Mentions)
+	}
+
+	var page notificationsResponse
+	f.do("GET", "/api/notifications", bearer(carol.Token), nil).decode(t, &page)
+	if len(page.Notifications) != 0 {
+		t.Errorf("expected no notification for a mention from before signup, instead got %+v", page)
+	}
+
+	mention = f.createChirp(f.alice.Token, "hi @carol and @CAROL")
+	f.do("GET", "/api/notifications", bearer(carol.Token), nil).decode(t, &page)
+	if len(page.Notifications) != 1 || page.Notifications[0].Type != "mention" || *page.Notifications[0].ChirpId != mention.Id {
+		t.Errorf("expected one mention notification, instead got %+v", page)
+	}
+
+	f.do("POST", "/api/users/"+f.alice.Id+"/follow", bearer(carol.Token), nil)
+
+	var profile ProfileResponse
+	res = f.do("GET", "/api/users/ALICE", "", nil)
+	res.decode(t, &profile)
+	if profile.Id.String() != f.alice.Id || profile.ChirpCount != 3 || profile.FollowerCount != 1 || profile.FollowingCount != 0 || profile.Bio != "hello" {
+		t.Errorf("expected alice's profile with 3 chirps and 1 follower, instead got %+v", profile)
+	}
+
+	var chirps []json.RawMessage
+	f.do("GET", "/api/chirps", "", nil).decode(t, &chirps)
+	for _, body := range append(chirps, res.body) {
+		if strings.Contains(string(body), "@example.com") {
+			t.Errorf("expected public responses not to expose emails, instead got %s", body)
+		}
+	}
+}
+
+// upload posts data as the file part of a multipart/form-data body.
+func (s *testServer) upload(token, filename string, data []byte) testResponse {
+	s.t.Helper()
+
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	part, err := writer.CreateFormFile("file", filename)
+	if err == nil {
+		_, err = part.Write(data)
+	}
+	if err == nil {
+		err = writer.Close()
+	}
+	if err != nil {
+		s.t.Fatalf("expected to build multipart body: %v", err)
+	}
+
+	return s.send("POST", "/api/media", http.Header{
+		"Authorization": {bearer(token)},
+		"Content-Type":  {writer.FormDataContentType()},
+	}, body.String())
+}
+
+func testPNG(t *testing.T, width, height int) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, width, height)))
+	if err != nil {
+		t.Fatalf("expected to encode png: %v", err)
+	}
+
+	return buf.Bytes()
+}
+
+func TestMedia(t *testing.T) {
+	f := newFixture(t)
+
+	var uploaded Media
+	res := f.upload(f.alice.Token, "cat.png", testPNG(t, 640, 480))
+	res.decode(t, &uploaded)
+	if res.status != http.StatusCreated || uploaded.Width != 640 || uploaded.Height != 480 || uploaded.ContentType != "image/png" {
+		t.Fatalf("expected a 640x480 png, instead got %d: %s", res.status, res.body)
+	}
+
+	res = f.do("GET", uploaded.Url, bearer(f.alice.Token), nil)
+	if res.status != http.StatusOK || res.header.Get("Content-Type") != "image/png" || res.header.Get("Cache-Control") != "private, no-store" || len(res.body) != int(uploaded.Size) {
+		t.Errorf("expected the uploader to get the unattached image, instead got %d %v", res.status, res.header)
+	}
+
+	if res := f.do("GET", uploaded.Url, "", nil); res.status != http.StatusNotFound {
+		t.Errorf("expected unattached media to be hidden from others, instead got %d", res.status)
+	}
+
+	res = f.do("GET", uploaded.ThumbnailUrl, bearer(f.alice.Token), nil)
+	thumb, err := png.DecodeConfig(bytes.NewReader(res.body))
+	if res.status != http.StatusOK || err != nil || thumb.Width != 320 || thumb.Height != 240 {
+		t.Errorf("expected a 320x240 thumbnail, instead got %d %+v %v", res.status, thumb, err)
+	}
+
+	res = f.do("POST", "/api/chirps", bearer(f.bob.Token), map[string]any{"body": "mine now", "media_ids": []uuid.UUID{uploaded.Id}})
+	if res.status != http.StatusBadRequest {
+		t.Errorf("expected 400 attaching another user's media, instead got %d: %s", res.status, res.body)
+	}
+
+	var chirp Chirp
+	res = f.do("POST", "/api/chirps", bearer(f.alice.Token), map[string]any{"body": "my cat", "media_ids": []uuid.UUID{uploaded.Id}})
+	res.decode(t, &chirp)
+	if res.status != http.StatusCreated || len(chirp.Media) != 1 || chirp.Media[0].Id != uploaded.Id {
+		t.Fatalf("expected the chirp to have the image, instead got %d: %s", res.status, res.body)
+	}
+
+	var got Chirp
+	f.do("GET", "/api/chirps/"+chirp.Id.String(), "", nil).decode(t, &got)
+	if len(got.Media) != 1 || got.Media[0].Url != uploaded.Url || len(f.aliceChirp.Media) != 0 {
+		t.Errorf("expected the stored chirp to have the image, instead got %+v", got.Media)
+	}
+
+	res = f.do("GET", uploaded.Url, "", nil)
+	if res.status != http.StatusOK || res.header.Get("Cache-Control") != mediaCacheControl {
+		t.Errorf("expected the image of a published chirp with cache headers, instead got %d %v", res.status, res.header)
+	}
+
+	res = f.send("GET", uploaded.Url, http.Header{"If-None-Match": {res.header.Get("ETag")}}, nil)
+	if res.status != http.StatusNotModified {
+		t.Errorf("expected 304 for a cached image, instead got %d", res.status)
+	}
+
+	f.do("POST", "/api/users/"+f.bob.Id+"/block", bearer(f.alice.Token), nil)
+	if res := f.do("GET", uploaded.Url, bearer(f.bob.Token), nil); res.status != http.StatusNotFound {
+		t.Errorf("expected the image to be hidden from a blocked user, instead got %d", res.status)
+	}
+	f.do("DELETE", "/api/users/"+f.bob.Id+"/block", bearer(f.alice.Token), nil)
+
+	var draftMedia Media
+	f.upload(f.alice.Token, "draft.png", testPNG(t, 10, 10)).decode(t, &draftMedia)
+	var draft Chirp
+	f.do("POST", "/api/chirps", bearer(f.alice.Token), map[string]any{"body": "not yet", "status": "draft", "media_ids": []uuid.UUID{draftMedia.Id}}).decode(t, &draft)
+	if res := f.do("GET", draftMedia.Url, bearer(f.bob.Token), nil); res.status != http.StatusNotFound {
+		t.Errorf("expected the image of a draft to be hidden from others, instead got %d", res.status)
+	}
+	if res := f.do("GET", draftMedia.Url, bearer(f.alice.Token), nil); res.status != http.StatusOK || res.header.Get("Cache-Control") != "private, no-store" {
+		t.Errorf("expected the author to get the image of a draft, privately, instead got %d %v", res.status, res.header)
+	}
+
+	f.do("DELETE", "/api/chirps/"+draft.Id.String(), bearer(f.alice.Token), nil)
+	if res := f.do("GET", draftMedia.Url, bearer(f.alice.Token), nil); res.status != http.StatusNotFound {
+		t.Errorf("expected the image of a deleted chirp to be gone, instead got %d", res.status)
+	}
+
+	res = f.do("POST", "/api/chirps", bearer(f.alice.Token), map[string]any{"body": "again", "media_ids": []uuid.UUID{uploaded.Id}})
+	if res.status != http.StatusConflict {
+		t.Errorf("expected 409 attaching media twice, instead got %d: %s", res.status, res.body)
+	}
+
+	res = f.upload(f.alice.Token, "notes.txt", []byte("just text"))
+	if res.status != http.StatusUnsupportedMediaType {
+		t.Errorf("expected 415 for a text file, instead got %d: %s", res.status, res.body)
+	}
+
+	f.cfg.media.MaxBytes = 100
+	res = f.upload(f.alice.Token, "cat.png", testPNG(t, 640, 480))
+	if res.status != http.StatusRequestEntityTooLarge {
+		t.Errorf("expected 413 over the free plan's limit, instead got %d: %s", res.status, res.body)
+	}
+
+	f.do("POST", "/api/polka/webhooks", "ApiKey "+testPolkaKey, map[string]any{"event": "user.upgraded", "data": map[string]string{"user_id": f.alice.Id}})
+	res = f.upload(f.alice.Token, "cat.png", testPNG(t, 640, 480))
+	res.decode(t, &uploaded)
+	if res.status != http.StatusCreated {
+		t.Errorf("expected Chirpy Red's larger limit to allow the upload, instead got %d: %s", res.status, res.body)
+	}
+
+	f.cfg.deleteUnattachedMedia(context.Background(), time.Now().UTC().Add(time.Minute))
+	if res := f.do("GET", uploaded.Url, "", nil); res.status != http.StatusNotFound {
+		t.Errorf("expected unattached media to be swept, instead got %d", res.status)
+	}
+	if res := f.do("GET", chirp.Media[0].Url, "", nil); res.status != http.StatusOK {
+		t.Errorf("expected attached media to be kept, instead got %d", res.status)
+	}
+}
+
+// enableLinkPreviews lets the server fetch previews from httptest servers,
+// which listen on loopback.
+func (s *testServer) enableLinkPreviews() {
+	s.cfg.linkPreviews = linkpreview.NewFetcher(linkpreview.Options{Timeout: 2 * time.Second, AllowPrivate: true})
+	s.cfg.linkPreviewQueue = make(chan string, linkPreviewQueueSize)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s.t.Cleanup(cancel)
+	go s.cfg.fetchLinkPreviews(ctx)
+}
+
+// waitForLinkPreview waits until the fetcher has stored a preview of url.
+func (s *testServer) waitForLinkPreview(url string) database.LinkPreview {
+	s.t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		preview, err := s.cfg.db.GetLinkPreview(context.Background(), url)
+		if err == nil {
+			return preview
+		}
+		if time.Now().After(deadline) {
+			s.t.Fatalf("expected a preview of %s to be fetched, instead got %v", url, err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestLinkPreviews(t *testing.T) {
+	f := newFixture(t)
+	f.enableLinkPreviews()
+
+	var fetches atomic.Int32
+	// The article is served once the chirp has been read without it.
+	release := make(chan struct{})
+	site := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		if r.URL.Path != "/article" {
+			http.NotFound(w, r)
+			return
+		}
+		<-release
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte(`<html><head>
+<meta property="og:title" content="An article">
+<meta property="og:description" content="About things">
+<meta property="og:image" content="/card.png">
+<meta property="og:site_name" content="Site">
+</head><body>Text</body></html>`))
+	}))
+	defer site.Close()
+
+	article := site.URL + "/article"
+	chirp := f.createChirp(f.alice.Token, "read "+article+" and "+article)
+	if len(chirp.LinkPreviews) != 0 {
+		t.Errorf("expected no preview before it is fetched, instead got %+v", chirp.LinkPreviews)
+	}
+
+	etag := f.do("GET", "/api/chirps/"+chirp.Id.String(), "", nil).header.Get("ETag")
+	close(release)
+	f.waitForLinkPreview(article)
+
+	// The preview changes the chirp without changing updated_at.
+	res := f.send("GET", "/api/chirps/"+chirp.Id.String(), http.Header{"If-None-Match": {etag}}, nil)
+	if res.status != http.StatusOK {
+		t.Errorf("expected 200 once the preview is fetched, instead got %d", res.status)
+	}
+
+	expected := LinkPreview{
+		Url:         article,
+		Title:       "An article",
+		Description: "About things",
+		ImageUrl:    site.URL + "/card.png",
+		SiteName:    "Site",
+	}
+
+	var got Chirp
+	f.do("GET", "/api/chirps/"+chirp.Id.String(), "", nil).decode(t, &got)
+	if len(got.LinkPreviews) != 1 || got.LinkPreviews[0] != expected {
+		t.Errorf("expected one preview %+v, instead got %+v", expected, got.LinkPreviews)
+	}
+
+	again := f.createChirp(f.bob.Token, "also "+article)
+	if len(again.LinkPreviews) != 1 || again.LinkPreviews[0] != expected {
+		t.Errorf("expected the stored preview on a new chirp, instead got %+v", again.LinkPreviews)
+	}
+
+	missing := site.URL + "/missing"
+	broken := f.createChirp(f.bob.Token, "see "+missing)
+	if preview := f.waitForLinkPreview(missing); preview.Status != linkPreviewFailed {
+		t.Errorf("expected a failed preview for a missing page, instead got %+v", preview)
+	}
+
+	f.do("GET", "/api/chirps/"+broken.Id.String(), "", nil).decode(t, &got)
+	if len(got.LinkPreviews) != 0 {
+		t.Errorf("expected no preview for a missing page, instead got %+v", got.LinkPreviews)
+	}
+
+	// Fetching a URL again uses the stored preview.
+	f.cfg.refreshLinkPreview(context.Background(), article)
+	if n := fetches.Load(); n != 2 {
+		t.Errorf("expected each page to be fetched once, instead got %d fetches", n)
+	}
+}
+
+func TestScheduledChirps(t *testing.T) {
+	f := newFixture(t)
+	ctx := context.Background()
+	bobId := uuid.MustParse(f.bob.Id)
+	f.do("PATCH", "/api/users/me", bearer(f.bob.Token), map[string]string{"username": "bob"})
+
+	var draft Chirp
+	res := f.do("POST", "/api/chirps", bearer(f.alice.Token), map[string]string{"body": "hi @bob, soon", "status": "draft"})
+	res.decode(t, &draft)
+	if res.status != http.StatusCreated || draft.Status != chirpStatusDraft || draft.PublishAt != nil {
+		t.Fatalf("expected a draft, instead got %d: %s", res.status, res.body)
+	}
+
+	publishAt := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+	var scheduled Chirp
+	res = f.do("POST", "/api/chirps", bearer(f.alice.Token), map[string]any{"body": "later", "publish_at": publishAt})
+	res.decode(t, &scheduled)
+	if res.status != http.StatusCreated || scheduled.Status != chirpStatusScheduled || scheduled.PublishAt == nil || !scheduled.PublishAt.Equal(publishAt) {
+		t.Fatalf("expected a chirp scheduled at %v, instead got %d: %s", publishAt, res.status, res.body)
+	}
+
+	var listed []Chirp
+	f.do("GET", "/api/chirps", "", nil).decode(t, &listed)
+	if len(listed) != 1 || listed[0].Id != f.aliceChirp.Id {
+		t.Errorf("expected only published chirps, instead got %+v", listed)
+	}
+
+	res = f.do("GET", "/api/chirps/"+draft.Id.String(), bearer(f.bob.Token), nil)
+	if res.status != http.StatusNotFound {
+		t.Errorf("expected 404 for another user's draft, instead got %d", res.status)
+	}
+
+	res = f.do("GET", "/api/chirps/"+draft.Id.String(), bearer(f.alice.Token), nil)
+	if res.status != http.StatusOK || res.header.Get("Cache-Control") != "private, no-store" {
+		t.Errorf("expected the author to get the draft privately, instead got %d %v", res.status, res.header)
+	}
+
+	res = f.do("PUT", "/api/chirps/"+draft.Id.String(), bearer(f.bob.Token), map[string]string{"body": "mine"})
+	if res.status != http.StatusNotFound {
+		t.Errorf("expected 404 editing another user's draft, instead got %d", res.status)
+	}
+
+	f.do("GET", "/api/chirps/drafts", bearer(f.alice.Token), nil).decode(t, &listed)
+	if len(listed) != 2 || listed[0].Id != draft.Id || listed[1].Id != scheduled.Id {
+		t.Errorf("expected alice's draft and scheduled chirp, instead got %+v", listed)
+	}
+
+	f.do("GET", "/api/chirps/drafts", bearer(f.bob.Token), nil).decode(t, &listed)
+	if len(listed) != 0 {
+		t.Errorf("expected bob to have no drafts, instead got %+v", listed)
+	}
+
+	unread, err := f.cfg.db.CountUnreadNotifications(ctx, bobId)
+	if err != nil || unread != 0 {
+		t.Errorf("expected no mention notification for a draft, instead got %d, %v", unread, err)
+	}
+
+	var published Chirp
+	res = f.send("POST", "/api/chirps/"+draft.Id.String()+"/publish", http.Header{
+		"Authorization": {bearer(f.alice.Token)},
+		"If-Match":      {chirpETag(draft)},
+	}, nil)
+	res.decode(t, &published)
+	if res.status != http.StatusOK || published.Status != chirpStatusPublished || !published.CreatedAt.After(draft.CreatedAt) {
+		t.Fatalf("expected the draft to be published now, instead got %d: %s", res.status, res.body)
+	}
+
+	unread, err = f.cfg.db.CountUnreadNotifications(ctx, bobId)
+	if err != nil || unread != 1 {
+		t.Errorf("expected a mention notification once published, instead got %d, %v", unread, err)
+	}
+
+	f.cfg.publishDueChirps(ctx, time.Now().UTC())
+	f.do("GET", "/api/chirps/drafts", bearer(f.alice.Token), nil).decode(t, &listed)
+	if len(listed) != 1 || listed[0].Id != scheduled.Id {
+		t.Errorf("expected the chirp not to be published before it is due, instead got %+v", listed)
+	}
+
+	f.cfg.publishDueChirps(ctx, publishAt)
+	f.do("GET", "/api/chirps", "", nil).decode(t, &listed)
+	if len(listed) != 3 || listed[2].Id != scheduled.Id || listed[2].Status != chirpStatusPublished {
+		t.Errorf("expected the due chirp to be published last, instead got %+v", listed)
+	}
+
+	f.do("GET", "/api/chirps/drafts", bearer(f.alice.Token), nil).decode(t, &listed)
+	if len(listed) != 0 {
+		t.Errorf("expected no drafts left, instead got %+v", listed)
+	}
+}
+
+func TestDeletedChirps(t *testing.T) {
+	f := newFixture(t)
+	ctx := context.Background()
+	chirpPath := "/api/chirps/" + f.aliceChirp.Id.String()
+
+	res := f.do("DELETE", chirpPath, bearer(f.alice.Token), nil)
+	if res.status != http.StatusNoContent {
+		t.Fatalf("expected 204, instead got %d: %s", res.status, res.body)
+	}
+
+	res = f.do("GET", chirpPath, bearer(f.alice.Token), nil)
+	if res.status != http.StatusNotFound {
+		t.Errorf("expected 404 for a deleted chirp, instead got %d", res.status)
+	}
+
+	var listed []Chirp
+	f.do("GET", "/api/chirps", "", nil).decode(t, &listed)
+	if len(listed) != 0 {
+		t.Errorf("expected deleted chirps not to be listed, instead got %+v", listed)
+	}
+
+	_, err := f.cfg.db.SetUserRole(ctx, database.SetUserRoleParams{ID: uuid.MustParse(f.bob.Id), Role: roleModerator})
+	if err != nil {
+		t.Fatalf("expected bob to become a moderator: %v", err)
+	}
+
+	var deleted Chirp
+	res = f.do("GET", chirpPath, bearer(f.bob.Token), nil)
+	res.decode(t, &deleted)
+	if res.status != http.StatusOK || deleted.DeletedAt == nil || res.header.Get("Cache-Control") != "private, no-store" {
+		t.Errorf("expected a moderator to get the deleted chirp privately, instead got %d %v: %s", res.status, res.header, res.body)
+	}
+
+	res = f.do("POST", chirpPath+"/restore", bearer(f.bob.Token), nil)
+	if res.status != http.StatusNotFound {
+		t.Errorf("expected 404 restoring another user's chirp, instead got %d", res.status)
+	}
+
+	var restored Chirp
+	res = f.do("POST", chirpPath+"/restore", bearer(f.alice.Token), nil)
+	res.decode(t, &restored)
+	if res.status != http.StatusOK || restored.Id != f.aliceChirp.Id || restored.DeletedAt != nil {
+		t.Fatalf("expected the chirp to be restored, instead got %d: %s", res.status, res.body)
+	}
+
+	f.do("GET", "/api/chirps", "", nil).decode(t, &listed)
+	if len(listed) != 1 || listed[0].Id != f.aliceChirp.Id {
+		t.Errorf("expected the restored chirp to be listed, instead got %+v", listed)
+	}
+
+	res = f.do("POST", chirpPath+"/restore", bearer(f.alice.Token), nil)
+	if res.status != http.StatusNotFound {
+		t.Errorf("expected 404 restoring a chirp that isn't deleted, instead got %d", res.status)
+	}
+
+	f.do("DELETE", chirpPath, bearer(f.alice.Token), nil)
+	f.cfg.chirps.RestoreWindow = 0
+	res = f.do("POST", chirpPath+"/restore", bearer(f.alice.Token), nil)
+	if res.status != http.StatusConflict {
+		t.Errorf("expected 409 restoring after the window, instead got %d: %s", res.status, res.body)
+	}
+}
+
+func TestModeration(t *testing.T) {
+	f := newFixture(t)
+	ctx := context.Background()
+	aliceId := uuid.MustParse(f.alice.Id)
+
+	filtered := f.createChirp(f.alice.Token, "what a Kerfuffle")
+	if filtered.Body != "what a ****" {
+		t.Fatalf("expected the chirp to be censored, instead got %q", filtered.Body)
+	}
+
+	var chirpReport Report
+	res := f.do("POST", "/api/reports", bearer(f.bob.Token), map[string]any{"chirp_id": f.aliceChirp.Id, "reason": "spam"})
+	res.decode(t, &chirpReport)
+	if res.status != http.StatusCreated || chirpReport.UserId != aliceId || chirpReport.ChirpBody != "hello from alice" {
+		t.Fatalf("expected the chirp to be reported, instead got %d: %s", res.status, res.body)
+	}
+
+	var userReport Report
+	res = f.do("POST", "/api/reports", bearer(f.bob.Token), map[string]any{"user_id": f.alice.Id, "reason": "harassment", "details": "rude"})
+	res.decode(t, &userReport)
+	if res.status != http.StatusCreated || userReport.ChirpId != nil {
+		t.Fatalf("expected the user to be reported, instead got %d: %s", res.status, res.body)
+	}
+
+	res = f.do("POST", "/api/reports", bearer(f.alice.Token), map[string]any{"chirp_id": f.aliceChirp.Id, "reason": "spam"})
+	if res.status != http.StatusBadRequest {
+		t.Errorf("expected 400 reporting your own chirp, instead got %d", res.status)
+	}
+
+	res = f.do("GET", "/api/moderation/reports", bearer(f.bob.Token), nil)
+	if res.status != http.StatusForbidden {
+		t.Errorf("expected 403 listing reports as a user, instead got %d", res.status)
+	}
+
+	_, err := f.cfg.db.SetUserRole(ctx, database.SetUserRoleParams{ID: uuid.MustParse(f.bob.Id), Role: roleModerator})
+	if err != nil {
+		t.Fatalf("expected bob to become a moderator: %v", err)
+	}
+
+	var reports []Report
+	f.do("GET", "/api/moderation/reports", bearer(f.bob.Token), nil).decode(t, &reports)
+	var filteredReport *Report
+	for i := range reports {
+		if reports[i].Reason == reportFilteredWords {
+			filteredReport = &reports[i]
+		}
+	}
+	if len(reports) != 3 || filteredReport == nil || filteredReport.ReporterId != nil || filteredReport.ChirpBody != "what a Kerfuffle" {
+		t.Fatalf("expected 3 open reports including the filtered chirp, instead got %+v", reports)
+	}
+
+	decide := func(report Report, action string) testResponse {
+		return f.do("POST", "/api/moderation/reports/"+report.Id.String()+"/decision", bearer(f.bob.Token), map[string]string{"action": action})
+	}
+
+	res = decide(userReport, moderationHideChirp)
+	if res.status != http.StatusBadRequest {
+		t.Errorf("expected 400 hiding a chirp on an account report, instead got %d", res.status)
+	}
+
+	res = decide(chirpReport, moderationHideChirp)
+	if res.status != http.StatusOK {
+		t.Fatalf("expected the chirp to be hidden, instead got %d: %s", res.status, res.body)
+	}
+
+	res = f.do("GET", "/api/chirps/"+f.aliceChirp.Id.String(), "", nil)
+	if res.status != http.StatusNotFound {
+		t.Errorf("expected 404 for a hidden chirp, instead got %d", res.status)
+	}
+
+	res = f.do("POST", "/api/chirps/"+f.aliceChirp.Id.String()+"/restore", bearer(f.alice.Token), nil)
+	if res.status != http.StatusForbidden {
+		t.Errorf("expected 403 restoring a hidden chirp, instead got %d", res.status)
+	}
+
+	res = decide(chirpReport, moderationDismiss)
+	if res.status != http.StatusConflict {
+		t.Errorf("expected 409 deciding a resolved report, instead got %d", res.status)
+	}
+
+	res = decide(userReport, moderationWarn)
+	if res.status != http.StatusOK {
+		t.Fatalf("expected alice to be warned, instead got %d: %s", res.status, res.body)
+	}
+
+	var notifications struct {
+		Notifications []Notification `json:"notifications"`
+	}
+	f.do("GET", "/api/notifications", bearer(f.alice.Token), nil).decode(t, &notifications)
+	if len(notifications.Notifications) != 1 || notifications.Notifications[0].Type != notificationWarning {
+		t.Errorf("expected a warning notification, instead got %+v", notifications.Notifications)
+	}
+
+	res = decide(*filteredReport, moderationSuspend)
+	if res.status != http.StatusOK {
+		t.Fatalf("expected alice to be suspended, instead got %d: %s", res.status, res.body)
+	}
+
+	res = f.do("GET", "/api/chirps/drafts", bearer(f.alice.Token), nil)
+	if res.status != http.StatusForbidden {
+		t.Errorf("expected 403 for a suspended user's token, instead got %d", res.status)
+	}
+
+	res = f.do("POST", "/api/login", "", map[string]string{"email": "alice@example.com", "password": "alice-password"})
+	if res.status != http.StatusForbidden {
+		t.Errorf("expected 403 logging in while suspended, instead got %d", res.status)
+	}
+
+	res = f.do("POST", "/api/refresh", bearer(f.alice.RefreshToken), nil)
+	if res.status != http.StatusForbidden {
+		t.Errorf("expected 403 refreshing while suspended, instead got %d", res.status)
+	}
+
+	var actions []ModerationAction
+	f.do("GET", "/api/moderation/actions?user_id="+f.alice.Id, bearer(f.bob.Token), nil).decode(t, &actions)
+	if len(actions) != 3 || actions[0].Action != moderationSuspend || actions[2].Action != moderationHideChirp {
+		t.Errorf("expected the decision log newest first, instead got %+v", actions)
+	}
+}
+
+func TestBlocksAndMutes(t *testing.T) {
+	f := newFixture(t)
+	aliceChirpPath := "/api/chirps/" + f.aliceChirp.Id.String()
+
+	res := f.do("PATCH", "/api/users/me", bearer(f.alice.Token), map[string]string{"username": "alice"})
+	if res.status != http.StatusOK {
+		t.Fatalf("expected alice to get a username, instead got %d: %s", res.status, res.body)
+	}
+
+	f.do("POST", "/api/users/"+f.alice.Id+"/follow", bearer(f.bob.Token), nil)
+
+	header := http.Header{}
+	header.Set("Authorization", bearer(f.bob.Token))
+	conn, _, err := f.dialRealtime(header)
+	if err != nil {
+		t.Fatalf("expected to connect: %v", err)
+	}
+	exchange(t, conn, nil)
+	exchange(t, conn, realtime.Frame{Type: "subscribe", Topic: "user:" + f.alice.Id})
+	exchange(t, conn, realtime.Frame{Type: "subscribe", Topic: "user:" + f.bob.Id})
+	stream := f.openStream("/api/chirps/stream", header)
+
+	res = f.do("POST", "/api/users/"+f.bob.Id+"/block", bearer(f.alice.Token), nil)
+	if res.status != http.StatusNoContent {
+		t.Fatalf("expected alice to block bob, instead got %d: %s", res.status, res.body)
+	}
+
+	res = f.do("POST", "/api/users/"+f.alice.Id+"/follow", bearer(f.bob.Token), nil)
+	if res.status != http.StatusForbidden {
+		t.Errorf("expected 403 following a user who blocked you, instead got %d", res.status)
+	}
+
+	var profile ProfileResponse
+	f.do("GET", "/api/users/alice", "", nil).decode(t, &profile)
+	if profile.FollowerCount != 0 {
+		t.Errorf("expected blocking to remove bob's follow, instead got %+v", profile)
+	}
+
+	res = f.do("GET", aliceChirpPath, bearer(f.bob.Token), nil)
+	if res.status != http.StatusNotFound {
+		t.Errorf("expected 404 for a chirp by a user who blocked you, instead got %d", res.status)
+	}
+
+	tagged := f.createChirp(f.alice.Token, "hi #go")
+	for _, path := range []string{"/api/chirps", "/api/chirps?author_id=" + f.alice.Id, "/api/hashtags/go/chirps"} {
+		var listed []Chirp
+		f.do("GET", path, bearer(f.bob.Token), nil).decode(t, &listed)
+		if len(listed) != 0 {
+			t.Errorf("expected %s to leave out alice's chirps for bob, instead got %+v", path, listed)
+		}
+
+		f.do("GET", path, "", nil).decode(t, &listed)
+		if len(listed) == 0 {
+			t.Errorf("expected %s to list alice's chirps for everyone else", path)
+		}
+	}
+
+	bobsChirp := f.createChirp(f.bob.Token, "hey @alice")
+	if len(bobsChirp.Entities.Mentions) != 1 || bobsChirp.Entities.Mentions[0].UserId != nil {
+		t.Errorf("expected the mention of a user who blocked the author to stay unresolved, instead got %+v", bobsChirp.Entities.Mentions)
+	}
+
+	event := exchange(t, conn, nil)
+	if event.Topic != "user:"+f.bob.Id || !strings.Contains(string(event.Data), bobsChirp.Id.String()) {
+		t.Errorf("expected alice's chirp %s to be kept from bob, instead got %+v", tagged.Id, event)
+	}
+
+	if streamed := nextEvent(t, stream); !strings.Contains(streamed.data, bobsChirp.Id.String()) {
+		t.Errorf("expected alice's chirp %s to be kept from bob's stream, instead got %+v", tagged.Id, streamed)
+	}
+
+	var notifications notificationsResponse
+	f.do("GET", "/api/notifications", bearer(f.alice.Token), nil).decode(t, &notifications)
+	for _, notification := range notifications.Notifications {
+		if notification.Type == notificationMention {
+			t.Errorf("expected no mention from a blocked user, instead got %+v", notification)
+		}
+	}
+
+	res = f.do("DELETE", "/api/users/"+f.bob.Id+"/block", bearer(f.alice.Token), nil)
+	if res.status != http.StatusNoContent {
+		t.Fatalf("expected alice to unblock bob, instead got %d: %s", res.status, res.body)
+	}
+
+	res = f.do("GET", aliceChirpPath, bearer(f.bob.Token), nil)
+	if res.status != http.StatusOK {
+		t.Errorf("expected bob to see alice's chirp after the unblock, instead got %d", res.status)
+	}
+
+	unblocked := f.createChirp(f.alice.Token, "hi again")
+	if event := exchange(t, conn, nil); !strings.Contains(string(event.Data), unblocked.Id.String()) {
+		t.Errorf("expected alice's chirps to reach bob again after the unblock, instead got %+v", event)
+	}
+	if streamed := nextEvent(t, stream); !strings.Contains(streamed.data, unblocked.Id.String()) {
+		t.Errorf("expected alice's chirps in bob's stream again after the unblock, instead got %+v", streamed)
+	}
+
+	res = f.do("POST", "/api/users/"+f.alice.Id+"/mute", bearer(f.bob.Token), nil)
+	if res.status != http.StatusNoContent {
+		t.Fatalf("expected bob to mute alice, instead got %d: %s", res.status, res.body)
+	}
+
+	var listed []Chirp
+	f.do("GET", "/api/chirps", bearer(f.bob.Token), nil).decode(t, &listed)
+	if len(listed) != 1 || listed[0].Id != bobsChirp.Id {
+		t.Errorf("expected bob's timeline without alice, instead got %+v", listed)
+	}
+
+	f.do("GET", "/api/chirps?author_id="+f.alice.Id, bearer(f.bob.Token), nil).decode(t, &listed)
+	if len(listed) != 3 {
+		t.Errorf("expected alice's chirps when bob asks for them, instead got %+v", listed)
+	}
+
+	f.do("GET", "/api/chirps", bearer(f.alice.Token), nil).decode(t, &listed)
+	if len(listed) != 4 {
+		t.Errorf("expected the mute not to affect alice, instead got %+v", listed)
+	}
+
+	// Muting keeps alice out of bob's timeline stream, but not from his
+	// subscription to her.
+	muted := f.createChirp(f.alice.Token, "muted")
+	if event := exchange(t, conn, nil); !strings.Contains(string(event.Data), muted.Id.String()) {
+		t.Errorf("expected bob's subscription to alice to ignore the mute, instead got %+v", event)
+	}
+	afterMute := f.createChirp(f.bob.Token, "after the mute")
+	if streamed := nextEvent(t, stream); !strings.Contains(streamed.data, afterMute.Id.String()) {
+		t.Errorf("expected alice's chirp %s to be kept from bob's stream, instead got %+v", muted.Id, streamed)
+	}
+
+	f.do("DELETE", "/api/users/"+f.alice.Id+"/mute", bearer(f.bob.Token), nil)
+	f.do("GET", "/api/chirps", bearer(f.bob.Token), nil).decode(t, &listed)
+	if len(listed) != 6 {
+		t.Errorf("expected alice back in bob's timeline after the unmute, instead got %+v", listed)
+	}
+}
+
+func TestBookmarksAndPins(t *testing.T) {
+	f := newFixture(t)
+
+	type bookmarksResponse struct {
+		Bookmarks  []Chirp    `json:"bookmarks"`
+		NextBefore *uuid.UUID `json:"next_before"`
+	}
+
+	second := f.createChirp(f.alice.Token, "second from alice")
+	third := f.createChirp(f.alice.Token, "third from alice")
+	for _, chirp := range []Chirp{third, f.aliceChirp, second} {
+		res := f.do("POST", "/api/chirps/"+chirp.Id.String()+"/bookmark", bearer(f.bob.Token), nil)
+		if res.status != http.StatusNoContent {
+			t.Fatalf("expected bob to bookmark %s, instead got %d: %s", chirp.Id, res.status, res.body)
+		}
+	}
+
+	var page bookmarksResponse
+	f.do("GET", "/api/bookmarks?limit=2", bearer(f.bob.Token), nil).decode(t, &page)
+	if len(page.Bookmarks) != 2 || page.Bookmarks[0].Id != second.Id || page.Bookmarks[1].Id != f.aliceChirp.Id || page.NextBefore == nil {
+		t.Fatalf("expected the newest two bookmarks and a cursor, instead got %+v", page)
+	}
+
+	var last bookmarksResponse
+	f.do("GET", "/api/bookmarks?limit=2&before="+page.NextBefore.String(), bearer(f.bob.Token), nil).decode(t, &last)
+	if len(last.Bookmarks) != 1 || last.Bookmarks[0].Id != third.Id || last.NextBefore != nil {
+		t.Errorf("expected the last bookmark, instead got %+v", last)
+	}
+
+	var alices bookmarksResponse
+	f.do("GET", "/api/bookmarks", bearer(f.alice.Token), nil).decode(t, &alices)
+	if len(alices.Bookmarks) != 0 {
+		t.Errorf("expected bookmarks to be private to bob, instead got %+v", alices)
+	}
+
+	res := f.do("POST", "/api/chirps/"+second.Id.String()+"/pin", bearer(f.alice.Token), nil)
+	if res.status != http.StatusNoContent {
+		t.Fatalf("expected alice to pin her chirp, instead got %d: %s", res.status, res.body)
+	}
+
+	for _, path := range []string{"/api/chirps?author_id=" + f.alice.Id, "/api/chirps?sort=desc&author_id=" + f.alice.Id} {
+		var listed []Chirp
+		f.do("GET", path, "", nil).decode(t, &listed)
+		if len(listed) != 3 || listed[0].Id != second.Id || !listed[0].Pinned || listed[1].Pinned {
+			t.Errorf("expected %s to list the pinned chirp first, instead got %+v", path, listed)
+		}
+	}
+
+	var timeline []Chirp
+	f.do("GET", "/api/chirps", "", nil).decode(t, &timeline)
+	if len(timeline) != 3 || timeline[0].Id != f.aliceChirp.Id || timeline[1].Pinned {
+		t.Errorf("expected the pin to only apply to author listings, instead got %+v", timeline)
+	}
+
+	f.do("POST", "/api/chirps/"+third.Id.String()+"/pin", bearer(f.alice.Token), nil)
+	var repinned []Chirp
+	f.do("GET", "/api/chirps?author_id="+f.alice.Id, "", nil).decode(t, &repinned)
+	if len(repinned) != 3 || repinned[0].Id != third.Id || !repinned[0].Pinned || repinned[1].Pinned || repinned[2].Pinned {
+		t.Errorf("expected pinning another chirp to replace the pin, instead got %+v", repinned)
+	}
+
+	res = f.do("DELETE", "/api/chirps/"+third.Id.String(), bearer(f.alice.Token), nil)
+	if res.status != http.StatusNoContent {
+		t.Fatalf("expected alice to delete her chirp, instead got %d: %s", res.status, res.body)
+	}
+
+	var unpinned []Chirp
+	f.do("GET", "/api/chirps?author_id="+f.alice.Id, "", nil).decode(t, &unpinned)
+	if len(unpinned) != 2 || unpinned[0].Id != f.aliceChirp.Id || unpinned[0].Pinned || unpinned[1].Pinned {
+		t.Errorf("expected a deleted chirp not to stay pinned, instead got %+v", unpinned)
+	}
+
+	var remaining bookmarksResponse
+	f.do("GET", "/api/bookmarks", bearer(f.bob.Token), nil).decode(t, &remaining)
+	if len(remaining.Bookmarks) != 2 {
+		t.Errorf("expected a deleted chirp to leave bookmarks, instead got %+v", remaining)
+	}
+
+	f.do("DELETE", "/api/chirps/"+second.Id.String()+"/bookmark", bearer(f.bob.Token), nil)
+	var removed bookmarksResponse
+	f.do("GET", "/api/bookmarks", bearer(f.bob.Token), nil).decode(t, &removed)
+	if len(removed.Bookmarks) != 1 || removed.Bookmarks[0].Id != f.aliceChirp.Id {
+		t.Errorf("expected the bookmark to be removed, instead got %+v", removed)
+	}
+}
+
+func TestPolls(t *testing.T) {
+	f := newFixture(t)
+	ctx := context.Background()
+
+	var created Chirp
+	res := f.do("POST", "/api/chirps", bearer(f.alice.Token), map[string]any{
+		"body": "tabs or spaces?",
+		"poll": map[string]any{"options": []string{" tabs ", "spaces", "both"}, "closes_at": time.Now().Add(time.Hour)},
+	})
+	res.decode(t, &created)
+	if res.status != http.StatusCreated || created.Poll == nil || len(created.Poll.Options) != 3 || created.Poll.Options[0].Text != "tabs" {
+		t.Fatalf("expected a chirp with a poll, instead got %d: %s", res.status, res.body)
+	}
+	if created.Poll.TotalVotes != nil || created.Poll.Options[0].Votes != nil || created.Poll.Closed {
+		t.Errorf("expected the results of an open poll to be hidden, instead got %+v", created.Poll)
+	}
+
+	chirpPath := "/api/chirps/" + created.Id.String()
+	tabs, spaces := created.Poll.Options[0].Id, created.Poll.Options[1].Id
+
+	res = f.do("POST", chirpPath+"/poll/votes", bearer(f.bob.Token), map[string]string{"option_id": uuid.NewString()})
+	if res.status != http.StatusBadRequest {
+		t.Errorf("expected 400 voting for an option not in the poll, instead got %d", res.status)
+	}
+
+	var poll Poll
+	res = f.do("POST", chirpPath+"/poll/votes", bearer(f.bob.Token), map[string]string{"option_id": spaces.String()})
+	res.decode(t, &poll)
+	if res.status != http.StatusOK || poll.VotedOptionId == nil || *poll.VotedOptionId != spaces {
+		t.Fatalf("expected bob to vote, instead got %d: %s", res.status, res.body)
+	}
+	if poll.TotalVotes == nil || *poll.TotalVotes != 1 || *poll.Options[0].Votes != 0 || *poll.Options[1].Votes != 1 {
+		t.Errorf("expected the results after voting, instead got %+v", poll)
+	}
+
+	res = f.do("POST", chirpPath+"/poll/votes", bearer(f.bob.Token), map[string]string{"option_id": tabs.String()})
+	if res.status != http.StatusConflict {
+		t.Errorf("expected 409 voting twice, instead got %d", res.status)
+	}
+
+	var bobs Chirp
+	res = f.do("GET", chirpPath, bearer(f.bob.Token), nil)
+	res.decode(t, &bobs)
+	if bobs.Poll == nil || bobs.Poll.TotalVotes == nil || *bobs.Poll.TotalVotes != 1 {
+		t.Errorf("expected bob to see the results, instead got %s", res.body)
+	}
+	if res.header.Get("Cache-Control") != "private, no-store" {
+		t.Errorf("expected a chirp with a poll not to be cached by shared caches, instead got %v", res.header)
+	}
+
+	var anonymous Chirp
+	f.do("GET", chirpPath, "", nil).decode(t, &anonymous)
+	if anonymous.Poll == nil || anonymous.Poll.TotalVotes != nil || anonymous.Poll.VotedOptionId != nil {
+		t.Errorf("expected the results to be hidden from anonymous viewers, instead got %+v", anonymous.Poll)
+	}
+
+	listed := f.do("GET", "/api/chirps", bearer(f.alice.Token), nil)
+	var before []Chirp
+	listed.decode(t, &before)
+	if len(before) != 2 || before[1].Poll == nil || before[1].Poll.TotalVotes != nil {
+		t.Errorf("expected the results to be hidden from alice before she votes, instead got %s", listed.body)
+	}
+	if listed.header.Get("Cache-Control") != privateChirpCacheControl {
+		t.Errorf("expected alice's list to be kept from shared caches, instead got %v", listed.header)
+	}
+
+	if res := f.do("GET", "/api/chirps", "", nil); res.header.Get("Cache-Control") != privateChirpCacheControl {
+		t.Errorf("expected a list with a poll to be kept from shared caches, instead got %v", res.header)
+	}
+
+	// Only one of alice's concurrent votes may count.
+	statuses := make(chan int, 5)
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			statuses <- f.do("POST", chirpPath+"/poll/votes", bearer(f.alice.Token), map[string]string{"option_id": tabs.String()}).status
+		}()
+	}
+	wg.Wait()
+	close(statuses)
+
+	counted := 0
+	for status := range statuses {
+		switch status {
+		case http.StatusOK:
+			counted++
+		case http.StatusConflict:
+		default:
+			t.Errorf("expected 200 or 409 for concurrent votes, instead got %d", status)
+		}
+	}
+	if counted != 1 {
+		t.Errorf("expected exactly one of the concurrent votes to count, instead got %d", counted)
+	}
+
+	header := http.Header{}
+	header.Set("Authorization", bearer(f.alice.Token))
+	header.Set("If-None-Match", listed.header.Get("ETag"))
+	res = f.send("GET", "/api/chirps", header, nil)
+	if res.status != http.StatusOK {
+		t.Errorf("expected voting to change the list's ETag, instead got %d", res.status)
+	}
+
+	var after []Chirp
+	res.decode(t, &after)
+	if after[1].Poll.TotalVotes == nil || *after[1].Poll.TotalVotes != 2 || *after[1].Poll.Options[0].Votes != 1 {
+		t.Errorf("expected both votes in the results, instead got %+v", after[1].Poll)
+	}
+
+	closed := f.createChirp(f.alice.Token, "closed poll")
+	_, err := f.cfg.db.CreatePoll(ctx, database.CreatePollParams{ChirpID: closed.Id, ClosesAt: time.Now().Add(-time.Minute)})
+	if err != nil {
+		t.Fatalf("expected to create a closed poll: %v", err)
+	}
+	option, err := f.cfg.db.CreatePollOption(ctx, database.CreatePollOptionParams{ChirpID: closed.Id, Text: "too late"})
+	if err != nil {
+		t.Fatalf("expected to create a poll option: %v", err)
+	}
+
+	res = f.do("POST", "/api/chirps/"+closed.Id.String()+"/poll/votes", bearer(f.bob.Token), map[string]string{"option_id": option.ID.String()})
+	if res.status != http.StatusConflict {
+		t.Errorf("expected 409 voting on a closed poll, instead got %d", res.status)
+	}
+
+	var closedChirp Chirp
+	f.do("GET", "/api/chirps/"+closed.Id.String(), "", nil).decode(t, &closedChirp)
+	if closedChirp.Poll == nil || !closedChirp.Poll.Closed || closedChirp.Poll.TotalVotes == nil || *closedChirp.Poll.TotalVotes != 0 {
+		t.Errorf("expected the results of a closed poll to be shown to everyone, instead got %+v", closedChirp.Poll)
+	}
+}
+
+func TestPolkaUpgrade(t *testing.T) {
+	f := newFixture(t)
+
+	res := f.do("POST", "/api/polka/webhooks", "ApiKey "+testPolkaKey, map[string]any{
+		"event": "user.upgraded",
+		"data":  map[string]string{"user_id": f.bob.Id},
+	})
+	if res.status != http.StatusNoContent {
+		t.Fatalf("expected 204, instead got %d: %s", res.status, res.body)
+	}
+
+	if bob := f.login("bob@example.com", "bob-password"); !bob.IsChirpyRed {
+		t.Errorf("expected bob to be upgraded")
+	}
+
+	if alice := f.login("alice@example.com", "alice-password"); alice.IsChirpyRed {
+		t.Errorf("expected alice not to be upgraded")
+	}
+}
+
+func TestAdminReset(t *testing.T) {
+	f := newFixture(t)
+
+	f.cfg.platform = config.PlatformProduction
+	res := f.do("POST", "/admin/reset", "", nil)
+	if res.status != http.StatusForbidden {
+		t.Errorf("expected reset to be forbidden outside dev, instead got %d", res.status)
+	}
+
+	f.cfg.platform = config.PlatformDev
+	res = f.do("POST", "/admin/reset", "", nil)
+	if res.status != http.StatusOK {
+		t.Fatalf("expected 200, instead got %d: %s", res.status, res.body)
+	}
+
+	res = f.do("GET", "/api/chirps", "", nil)
+	var chirps []Chirp
+	res.decode(t, &chirps)
+	if len(chirps) != 0 {
+		t.Errorf("expected reset to remove all chirps, instead got %d", len(chirps))
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	f := newFixture(t)
+
+	f.cfg.rateLimiter = ratelimit.NewMemory()
+	f.cfg.rateLimits = config.RateLimitConfig{
+		RedMultiplier: 2,
+		Auth:          ratelimit.Limit{Requests: 1, Per: time.Minute},
+		Write:         ratelimit.Limit{Requests: 2, Per: time.Minute},
+	}
+
+	res := f.do("POST", "/api/polka/webhooks", "ApiKey "+testPolkaKey, map[string]any{
+		"event": "user.upgraded",
+		"data":  map[string]string{"user_id": f.alice.Id},
+	})
+	if res.status != http.StatusNoContent {
+		t.Fatalf("expected unlimited webhooks to pass, instead got %d: %s", res.status, res.body)
+	}
+
+	for i := 0; i < 2; i++ {
+		f.createChirp(f.bob.Token, "within the limit")
+	}
+
+	res = f.do("POST", "/api/chirps", bearer(f.bob.Token), map[string]string{"body": "over the limit"})
+	if res.status != http.StatusTooManyRequests {
+		t.Fatalf("expected 429, instead got %d: %s", res.status, res.body)
+	}
+
+	var p problem
+	res.decode(t, &p)
+	if p.Code != "rate_limited" {
+		t.Errorf("expected rate_limited, instead got %q", p.Code)
+	}
+
+	if res.header.Get("Retry-After") != "30" || res.header.Get("RateLimit-Remaining") != "0" || res.header.Get("RateLimit-Limit") != "2" {
+		t.Errorf("expected rate limit headers, instead got %v", res.header)
+	}
+
+	for i := 0; i < 4; i++ {
+		f.createChirp(f.alice.Token, "chirpy red")
+	}
+
+	res = f.do("POST", "/api/chirps", bearer(f.alice.Token), map[string]string{"body": "over the red limit"})
+	if res.status != http.StatusTooManyRequests {
+		t.Errorf("expected a Chirpy Red user to get twice the limit, instead got %d", res.status)
+	}
+
+	f.login("bob@example.com", "bob-password")
+	res = f.do("POST", "/api/login", "", map[string]string{"email": "alice@example.com", "password": "alice-password"})
+	if res.status != http.StatusTooManyRequests {
+		t.Errorf("expected logins to be limited per client IP, instead got %d", res.status)
+	}
+}
+
+func TestIdempotency(t *testing.T) {
+	f := newFixture(t)
+
+	header := func(token, key string) http.Header {
+		h := http.Header{}
+		h.Set("Authorization", bearer(token))
+		h.Set("Idempotency-Key", key)
+		return h
+	}
+
+	body := map[string]string{"body": "only once"}
+	first := f.send("POST", "/api/chirps", header(f.alice.Token, "retry-me"), body)
+	if first.status != http.StatusCreated {
+		t.Fatalf("expected 201, instead got %d: %s", first.status, first.body)
+	}
+
+	retry := f.send("POST", "/api/chirps", header(f.alice.Token, "retry-me"), body)
+	if retry.status != http.StatusCreated || string(retry.body) != string(first.body) {
+		t.Errorf("expected the first response to be replayed, instead got %d: %s", retry.status, retry.body)
+	}
+
+	if retry.header.Get("Idempotent-Replayed") != "true" || retry.header.Get("Content-Type") != "application/json" {
+		t.Errorf("expected replay headers, instead got %v", retry.header)
+	}
+
+	if etag := first.header.Get("ETag"); etag == "" || retry.header.Get("ETag") != etag {
+		t.Errorf("expected the ETag %q to be replayed, instead got %q", etag, retry.header.Get("ETag"))
+	}
+
+	var chirps []Chirp
+	f.do("GET", "/api/chirps?author_id="+f.alice.Id, "", nil).decode(t, &chirps)
+	if len(chirps) != 2 {
+		t.Errorf("expected the retry not to create a chirp, instead got %d chirps", len(chirps))
+	}
+
+	res := f.send("POST", "/api/chirps", header(f.alice.Token, "retry-me"), map[string]string{"body": "something else"})
+	if res.status != http.StatusUnprocessableEntity {
+		t.Errorf("expected 422 reusing a key for another request, instead got %d: %s", res.status, res.body)
+	}
+
+	res = f.send("POST", "/api/chirps", header(f.bob.Token, "retry-me"), body)
+	if res.status != http.StatusCreated || res.header.Get("Idempotent-Replayed") != "" {
+		t.Errorf("expected keys to be scoped per user, instead got %d: %s", res.status, res.body)
+	}
+
+	hash := sha256.Sum256([]byte("POST /api/chirps\n" + `{"body":"in flight"}`))
+	_, err := f.cfg.db.CreateIdempotencyKey(context.Background(), database.CreateIdempotencyKeyParams{
+		Scope:       "user:" + f.alice.Id,
+		Key:         "in-flight",
+		RequestHash: hex.EncodeToString(hash[:]),
+		ExpiresAt:   time.Now().Add(time.Hour),
+	})
+	if err != nil {
+		t.Fatalf("expected to create idempotency key: %v", err)
+	}
+
+	res = f.send("POST", "/api/chirps", header(f.alice.Token, "in-flight"), map[string]string{"body": "in flight"})
+	if res.status != http.StatusConflict {
+		t.Errorf("expected 409 while the first request is in flight, instead got %d: %s", res.status, res.body)
+	}
+
+	signup := http.Header{}
+	signup.Set("Idempotency-Key", "signup")
+	credentials := map[string]string{"email": "carol@example.com", "password": "carol-password"}
+
+	var created, replayed UserResponse
+	f.send("POST", "/api/users", signup, credentials).decode(t, &created)
+	res = f.send("POST", "/api/users", signup, credentials)
+	res.decode(t, &replayed)
+	if res.status != http.StatusCreated || replayed.Id != created.Id {
+		t.Errorf("expected a retried signup to be replayed, instead got %d: %s", res.status, res.body)
+	}
+
+	f.cfg.trustedProxies = []netip.Prefix{netip.MustParsePrefix("127.0.0.0/8")}
+	signup.Set("X-Forwarded-For", "203.0.113.7")
+	res = f.send("POST", "/api/users", signup, map[string]string{"email": "dave@example.com", "password": "dave-password"})
+	if res.status != http.StatusCreated || res.header.Get("Idempotent-Replayed") != "" {
+		t.Errorf("expected anonymous keys to be scoped per client IP, instead got %d: %s", res.status, res.body)
+	}
+}
+
+func TestConditionalRequests(t *testing.T) {
+	f := newFixture(t)
+
+	chirpPath := "/api/chirps/" + f.aliceChirp.Id.String()
+	conditional := func(name, value string) http.Header {
+		h := http.Header{}
+		h.Set(name, value)
+		return h
+	}
+
+	res := f.do("GET", chirpPath, "", nil)
+	etag := res.header.Get("ETag")
+	if !strings.HasPrefix(etag, `W/"`) || res.header.Get("Last-Modified") != "" || res.header.Get("Cache-Control") != chirpCacheControl {
+		t.Fatalf("expected a weak ETag and caching headers, instead got %v", res.header)
+	}
+
+	for _, inm := range []string{etag, strings.TrimPrefix(etag, "W/"), `"other", ` + etag, "*"} {
+		res = f.send("GET", chirpPath, conditional("If-None-Match", inm), nil)
+		if res.status != http.StatusNotModified || len(res.body) != 0 || res.header.Get("ETag") != etag {
+			t.Errorf("expected 304 for If-None-Match %s, instead got %d: %s", inm, res.status, res.body)
+		}
+	}
+
+	res = f.send("GET", chirpPath, conditional("If-None-Match", `"other"`), nil)
+	if res.status != http.StatusOK {
+		t.Errorf("expected 200 for a stale ETag, instead got %d", res.status)
+	}
+
+	res = f.send("GET", chirpPath, conditional("If-Modified-Since", time.Now().UTC().Format(http.TimeFormat)), nil)
+	if res.status != http.StatusOK {
+		t.Errorf("expected If-Modified-Since to be ignored without Last-Modified, instead got %d", res.status)
+	}
+
+	f.createChirp(f.bob.Token, "a second chirp")
+	list := f.do("GET", "/api/chirps", "", nil)
+	listETag := list.header.Get("ETag")
+	if list.header.Get("Cache-Control") != chirpCacheControl {
+		t.Errorf("expected an anonymous list to be public, instead got %v", list.header)
+	}
+
+	for _, path := range []string{chirpPath, "/api/chirps", "/api/hashtags/go/chirps"} {
+		if res := f.do("GET", path, bearer(f.bob.Token), nil); res.header.Get("Cache-Control") != privateChirpCacheControl {
+			t.Errorf("expected %s to be private when signed in, instead got %v", path, res.header)
+		}
+	}
+	res = f.send("GET", "/api/chirps", conditional("If-None-Match", listETag), nil)
+	if res.status != http.StatusNotModified {
+		t.Errorf("expected 304 for an unchanged list, instead got %d", res.status)
+	}
+
+	if f.do("GET", "/api/chirps?sort=desc", "", nil).header.Get("ETag") == listETag {
+		t.Errorf("expected a different ETag for a different order")
+	}
+
+	edit := func(ifMatch, body string) testResponse {
+		h := conditional("Authorization", bearer(f.alice.Token))
+		if ifMatch != "" {
+			h.Set("If-Match", ifMatch)
+		}
+		return f.send("PUT", chirpPath, h, map[string]string{"body": body})
+	}
+
+	res = edit(etag, "edited once")
+	if res.status != http.StatusOK {
+		t.Fatalf("expected 200 editing the current version, instead got %d: %s", res.status, res.body)
+	}
+
+	var edited Chirp
+	res.decode(t, &edited)
+	newETag := res.header.Get("ETag")
+	if edited.Body != "edited once" || newETag == etag || !edited.UpdatedAt.After(f.aliceChirp.UpdatedAt) {
+		t.Errorf("expected the chirp to be edited with a new ETag, instead got %+v, %s", edited, newETag)
+	}
+
+	res = edit(etag, "clobbered")
+	if res.status != http.StatusPreconditionFailed {
+		t.Errorf("expected 412 editing a stale version, instead got %d: %s", res.status, res.body)
+	}
+
+	res = f.send("GET", "/api/chirps", conditional("If-None-Match", listETag), nil)
+	if res.status != http.StatusOK {
+		t.Errorf("expected an edit to change the list, instead got %d", res.status)
+	}
+
+	deleteHeader := conditional("Authorization", bearer(f.alice.Token))
+	deleteHeader.Set("If-Match", etag)
+	res = f.send("DELETE", chirpPath, deleteHeader, nil)
+	if res.status != http.StatusPreconditionFailed {
+		t.Errorf("expected 412 deleting a stale version, instead got %d", res.status)
+	}
+
+	deleteHeader.Set("If-Match", newETag)
+	res = f.send("DELETE", chirpPath, deleteHeader, nil)
+	if res.status != http.StatusNoContent {
+		t.Errorf("expected 204 deleting the current version, instead got %d: %s", res.status, res.body)
+	}
+}
+
+func TestEditChirpWithoutIfMatch(t *testing.T) {
+	f := newFixture(t)
+
+	res := f.do("PUT", "/api/chirps/"+f.aliceChirp.Id.String(), bearer(f.alice.Token), map[string]string{"body": "what a sharbert"})
+	if res.status != http.StatusOK {
+		t.Fatalf("expected 200, instead got %d: %s", res.status, res.body)
+	}
+
+	var chirp Chirp
+	res.decode(t, &chirp)
+	if chirp.Body != "what a ****" || chirp.Id != f.aliceChirp.Id {
+		t.Errorf("expected the edit to be cleaned, instead got %+v", chirp)
+	}
+}
+
+type streamEvent struct {
+	id    string
+	event string
+	data  string
+}
+
+// openStream opens an event stream and returns its events as they arrive.
+func (s *testServer) openStream(path string, header http.Header) <-chan streamEvent {
+	s.t.Helper()
+
+	req, err := http.NewRequest("GET", s.server.URL+path, nil)
+	if err != nil {
+		s.t.Fatalf("expected to build request: %v", err)
+	}
+	req.Header = header
+
+	res, err := s.server.Client().Do(req)
+	if err != nil {
+		s.t.Fatalf("expected to open stream: %v", err)
+	}
+	s.t.Cleanup(func() { res.Body.Close() })
+
+	if res.StatusCode != http.StatusOK || res.Header.Get("Content-Type") != "text/event-stream" {
+		s.t.Fatalf("expected an event stream, instead got %d %s", res.StatusCode, res.Header.Get("Content-Type"))
+	}
+
+	events := make(chan streamEvent)
+	go func() {
+		defer close(events)
+
+		var event streamEvent
+		scanner := bufio.NewScanner(res.Body)
+		for scanner.Scan() {
+			field, value, _ := strings.Cut(scanner.Text(), ": ")
+			switch field {
+			case "id":
+				event.id = value
+			case "event":
+				event.event = value
+			case "data":
+				event.data = value
+			case "":
+				if event.event != "" {
+					events <- event
+				}
+				event = streamEvent{}
+			}
+		}
+	}()
+
+	return events
+}
+
+func nextEvent(t *testing.T, events <-chan streamEvent) streamEvent {
+	t.Helper()
+
+	select {
+	case event, ok := <-events:
+		if !ok {
+			t.Fatalf("expected another event, instead the stream ended")
+		}
+		return event
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected another event within 5s")
+	}
+
+	return streamEvent{}
+}
+
+func TestChirpStream(t *testing.T) {
+	f := newFixture(t)
+
+	bobsChirps := f.openStream("/api/chirps/stream?author_id="+f.bob.Id, http.Header{})
+
+	bobsChirp := f.createChirp(f.bob.Token, "hello from bob")
+	f.createChirp(f.alice.Token, "alice again")
+	res := f.do("DELETE", "/api/chirps/"+bobsChirp.Id.String(), bearer(f.bob.Token), nil)
+	if res.status != http.StatusNoContent {
+		t.Fatalf("expected 204, instead got %d: %s", res.status, res.body)
+	}
+
+	created := nextEvent(t, bobsChirps)
+	var chirp Chirp
+	testResponse{body: []byte(created.data)}.decode(t, &chirp)
+	if created.event != "chirp.created" || chirp.Id != bobsChirp.Id || chirp.Body != "hello from bob" {
+		t.Errorf("expected bob's chirp to be created, instead got %+v", created)
+	}
+
+	deleted := nextEvent(t, bobsChirps)
+	if deleted.event != "chirp.deleted" || !strings.Contains(deleted.data, bobsChirp.Id.String()) {
+		t.Errorf("expected bob's chirp to be deleted, instead got %+v", deleted)
+	}
+
+	// Resuming replays everything after the last event seen.
+	header := http.Header{}
+	header.Set("Last-Event-ID", created.id)
+	resumed := f.openStream("/api/chirps/stream", header)
+
+	for _, expected := range []string{"chirp.created", "chirp.deleted"} {
+		event := nextEvent(t, resumed)
+		if event.event != expected {
+			t.Errorf("expected a replayed %s event, instead got %+v", expected, event)
+		}
+	}
+
+	f.createChirp(f.alice.Token, "live after replay")
+	live := nextEvent(t, resumed)
+	if live.event != "chirp.created" || !strings.Contains(live.data, "live after replay") {
+		t.Errorf("expected the live event after the replay, instead got %+v", live)
+	}
+
+	header.Set("Last-Event-ID", "not a number")
+	res = f.send("GET", "/api/chirps/stream", header, nil)
+	if res.status != http.StatusBadRequest {
+		t.Errorf("expected 400 for a malformed Last-Event-ID, instead got %d", res.status)
+	}
+}
+
+func TestFollowingStream(t *testing.T) {
+	f := newFixture(t)
+
+	res := f.do("POST", "/api/users/"+f.bob.Id+"/follow", bearer(f.alice.Token), nil)
+	if res.status != http.StatusNoContent {
+		t.Fatalf("expected 204, instead got %d: %s", res.status, res.body)
+	}
+
+	header := http.Header{}
+	header.Set("Authorization", bearer(f.alice.Token))
+	following := f.openStream("/api/chirps/stream?following=true", header)
+
+	f.createChirp(f.alice.Token, "not followed")
+	f.createChirp(f.bob.Token, "followed")
+
+	event := nextEvent(t, following)
+	if !strings.Contains(event.data, `"body":"followed"`) {
+		t.Errorf("expected only followed users' chirps, instead got %+v", event)
+	}
+}
+
+// dialRealtime opens a realtime connection with the given request headers.
+func (s *testServer) dialRealtime(header http.Header) (*websocket.Conn, *http.Response, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	conn, res, err := websocket.Dial(ctx, "ws"+strings.TrimPrefix(s.server.URL, "http")+"/api/realtime", &websocket.DialOptions{
+		HTTPHeader: header,
+	})
+	if conn != nil {
+		s.t.Cleanup(func() { conn.CloseNow() })
+	}
+
+	return conn, res, err
+}
+
+// exchange sends frame, unless it is nil, and returns the next frame the
+// server sends.
+func exchange(t *testing.T, conn *websocket.Conn, frame any) realtime.Frame {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if frame != nil {
+		err := wsjson.Write(ctx, conn, frame)
+		if err != nil {
+			t.Fatalf("expected to send frame: %v", err)
+		}
+	}
+
+	var reply realtime.Frame
+	err := wsjson.Read(ctx, conn, &reply)
+	if err != nil {
+		t.Fatalf("expected a frame, instead got %v", err)
+	}
+
+	return reply
+}
+
+func TestRealtime(t *testing.T) {
+	f := newFixture(t)
+
+	conn, _, err := f.dialRealtime(nil)
+	if err != nil {
+		t.Fatalf("expected to connect: %v", err)
+	}
+
+	cases := []struct {
+		name     string
+		send     any
+		expected realtime.Frame
+	}{
+		{"auth", realtime.Frame{Type: "auth", Token: f.alice.Token}, realtime.Frame{Type: "ready"}},
+		{"ping", realtime.Frame{Type: "ping"}, realtime.Frame{Type: "pong"}},
+		{"subscribe to user", realtime.Frame{Type: "subscribe", Topic: "user:" + f.bob.Id}, realtime.Frame{Type: "subscribed", Topic: "user:" + f.bob.Id}},
+		{"subscribe to notifications", realtime.Frame{Type: "subscribe", Topic: "notifications"}, realtime.Frame{Type: "subscribed", Topic: "notifications"}},
+		{"subscribe to unknown topic", realtime.Frame{Type: "subscribe", Topic: "everything"}, realtime.Frame{Type: "error", Topic: "everything", Code: "invalid_topic"}},
+		{"subscribe with bad id", realtime.Frame{Type: "subscribe", Topic: "chirp:nope"}, realtime.Frame{Type: "error", Topic: "chirp:nope", Code: "invalid_topic"}},
+		{"unknown frame", realtime.Frame{Type: "dance"}, realtime.Frame{Type: "error", Code: "unknown_frame_type"}},
+		{"malformed frame", json.RawMessage(`"not a frame"`), realtime.Frame{Type: "error", Code: "malformed_frame"}},
+	}
+
+	for _, c := range cases {
+		reply := exchange(t, conn, c.send)
+		if reply.Type != c.expected.Type || reply.Topic != c.expected.Topic || reply.Code != c.expected.Code {
+			t.Errorf("%s: expected %+v, instead got %+v", c.name, c.expected, reply)
+		}
+	}
+
+	f.createChirp(f.alice.Token, "not subscribed")
+	chirp := f.createChirp(f.bob.Token, "hello subscribers")
+
+	event := exchange(t, conn, nil)
+	if event.Type != "event" || event.Topic != "user:"+f.bob.Id || event.Event != "chirp.created" || !strings.Contains(string(event.Data), chirp.Id.String()) {
+		t.Errorf("expected bob's chirp on his topic, instead got %+v", event)
+	}
+
+	reply := exchange(t, conn, realtime.Frame{Type: "unsubscribe", Topic: "user:" + f.bob.Id})
+	if reply.Type != "unsubscribed" {
+		t.Errorf("expected to unsubscribe, instead got %+v", reply)
+	}
+}
+
+func TestRealtimeAuthentication(t *testing.T) {
+	f := newFixture(t)
+
+	header := http.Header{}
+	header.Set("Authorization", bearer(f.alice.Token))
+	conn, _, err := f.dialRealtime(header)
+	if err != nil {
+		t.Fatalf("expected to connect with an access token: %v", err)
+	}
+
+	if reply := exchange(t, conn, nil); reply.Type != "ready" {
+		t.Errorf("expected ready without an auth frame, instead got %+v", reply)
+	}
+
+	header.Set("Authorization", bearer("not a token"))
+	_, res, err := f.dialRealtime(header)
+	if err == nil || res == nil || res.StatusCode != http.StatusUnauthorized {
+		t.Errorf("expected 401 for an invalid token, instead got %v", err)
+	}
+
+	for _, frame := range []realtime.Frame{{Type: "auth", Token: "not a token"}, {Type: "ping"}} {
+		conn, _, err = f.dialRealtime(nil)
+		if err != nil {
+			t.Fatalf("expected to connect: %v", err)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		wsjson.Write(ctx, conn, frame)
+		_, _, err = conn.Read(ctx)
+		cancel()
+
+		if websocket.CloseStatus(err) != websocket.StatusPolicyViolation {
+			t.Errorf("expected %s to be refused, instead got %v", frame.Type, err)
+		}
+	}
+}
+
+type notificationsResponse struct {
+	Notifications []Notification `json:"notifications"`
+	UnreadCount   int64          `json:"unread_count"`
+	NextBefore    string         `json:"next_before"`
+}
+
+func TestNotifications(t *testing.T) {
+	f := newFixture(t)
+	f.signup("carol@example.com", "carol-password")
+	carol := f.login("carol@example.com", "carol-password")
+
+	conn, _, err := f.dialRealtime(nil)
+	if err != nil {
+		t.Fatalf("expected to connect: %v", err)
+	}
+	exchange(t, conn, realtime.Frame{Type: "auth", Token: f.alice.Token})
+	exchange(t, conn, realtime.Frame{Type: "subscribe", Topic: "notifications"})
+
+	chirps := f.openStream("/api/chirps/stream", http.Header{})
+
+	follow := "/api/users/" + f.alice.Id + "/follow"
+	for _, token := range []string{f.bob.Token, f.bob.Token, carol.Token} {
+		res := f.do("POST", follow, bearer(token), nil)
+		if res.status != http.StatusNoContent {
+			t.Fatalf("expected 204, instead got %d: %s", res.status, res.body)
+		}
+	}
+
+	pushed := exchange(t, conn, nil)
+	if pushed.Event != "notification.created" || pushed.Topic != "notifications" || !strings.Contains(string(pushed.Data), f.bob.Id) {
+		t.Errorf("expected bob's follow to be pushed, instead got %+v", pushed)
+	}
+
+	// Notifications are private, so the public stream skips them.
+	f.createChirp(f.bob.Token, "after the follows")
+	if event := nextEvent(t, chirps); event.event != "chirp.created" {
+		t.Errorf("expected only chirp events on the stream, instead got %+v", event)
+	}
+
+	var page notificationsResponse
+	f.do("GET", "/api/notifications?limit=1", bearer(f.alice.Token), nil).decode(t, &page)
+	if len(page.Notifications) != 1 || page.Notifications[0].ActorId.String() != carol.Id || page.UnreadCount != 2 || page.NextBefore == "" {
+		t.Fatalf("expected carol's follow first of 2 unread, instead got %+v", page)
+	}
+
+	var next notificationsResponse
+	f.do("GET", "/api/notifications?limit=1&before="+page.NextBefore, bearer(f.alice.Token), nil).decode(t, &next)
+	if len(next.Notifications) != 1 || next.Notifications[0].ActorId.String() != f.bob.Id || next.Notifications[0].Type != "follow" {
+		t.Errorf("expected bob's follow on the second page, instead got %+v", next)
+	}
+
+	res := f.do("GET", "/api/notifications?before="+page.NextBefore, bearer(f.bob.Token), nil)
+	if res.status != http.StatusBadRequest {
+		t.Errorf("expected 400 paging through another user's notifications, instead got %d", res.status)
+	}
+
+	var read struct {
+		Marked      int64 `json:"marked"`
+		UnreadCount int64 `json:"unread_count"`
+	}
+	f.do("POST", "/api/notifications/read", bearer(f.bob.Token), map[string]any{"ids": []uuid.UUID{page.Notifications[0].Id}}).decode(t, &read)
+	if read.Marked != 0 {
+		t.Errorf("expected bob not to mark alice's notification read, instead got %+v", read)
+	}
+
+	f.do("POST", "/api/notifications/read", bearer(f.alice.Token), map[string]any{"ids": []uuid.UUID{page.Notifications[0].Id}}).decode(t, &read)
+	if read.Marked != 1 || read.UnreadCount != 1 {
+		t.Errorf("expected one marked and one left unread, instead got %+v", read)
+	}
+
+	f.do("POST", "/api/notifications/read", bearer(f.alice.Token), map[string]any{"all": true}).decode(t, &read)
+	if read.Marked != 1 || read.UnreadCount != 0 {
+		t.Errorf("expected the rest to be marked, instead got %+v", read)
+	}
+
+	var preferences map[string]bool
+	f.do("PUT", "/api/notifications/preferences", bearer(f.alice.Token), map[string]bool{"follow": false}).decode(t, &preferences)
+	if preferences["follow"] {
+		t.Errorf("expected follow notifications to be off, instead got %v", preferences)
+	}
+
+	f.do("DELETE", follow, bearer(carol.Token), nil)
+	f.do("POST", follow, bearer(carol.Token), nil)
+
+	f.do("GET", "/api/notifications", bearer(f.alice.Token), nil).decode(t, &page)
+	if len(page.Notifications) != 2 || page.UnreadCount != 0 {
+		t.Errorf("expected no notification once turned off, instead got %+v", page)
+	}
+}
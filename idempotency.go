@@ -0,0 +1,263 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/vemolista/chirpy/v2/internal/database"
+	"github.com/vemolista/chirpy/v2/internal/ratelimit"
+	"github.com/vemolista/chirpy/v2/internal/store"
+)
+
+const (
+	idempotencyKeyHeader = "Idempotency-Key"
+	// idempotencyKeyTTL is how long a response is kept for retries.
+	idempotencyKeyTTL    = 24 * time.Hour
+	maxIdempotencyKeyLen = 255
+	// maxClaimAttempts bounds the retries when the key changes under us.
+	maxClaimAttempts = 3
+)
+
+// idempotent lets clients retry next safely by sending an Idempotency-Key
+// header. The first response to a key, with the headers next set, is stored
+// per user, or per client IP for anonymous callers, and replayed to any
+// retry with the same key. A retry with a different request is refused with
+// 422 and one that arrives while the first is still being handled with 409.
+// Server errors are not stored, so the request can be retried for real.
+func (cfg *apiConfig) idempotent(next http.HandlerFunc) http.HandlerFunc {
+	return cfg.idempotentUpTo(maxBodyBytes, next)
+}
+
+// idempotentUpTo is idempotent for routes whose bodies may be larger than
+// maxBodyBytes, such as uploads. It only bounds what is read to hash the
+// request: next still enforces its own limit.
+func (cfg *apiConfig) idempotentUpTo(maxBytes int64, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(idempotencyKeyHeader)
+		if key == "" {
+			next(w, r)
+			return
+		}
+
+		if len(key) > maxIdempotencyKeyLen {
+			respondWithError(w, r, validationError("invalid_idempotency_key", "Idempotency-Key is too long", fieldError{
+				Field:   idempotencyKeyHeader,
+				Code:    "max_length",
+				Message: "must be at most 255 characters",
+			}))
+			return
+		}
+
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBytes))
+		if err != nil {
+			respondWithError(w, r, decodeError(err))
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		scope := "ip:" + ratelimit.ClientIP(r, cfg.trustedProxies)
+		if userId, err := cfg.authenticate(r); err == nil {
+			scope = "user:" + userId.String()
+		}
+
+		hash := sha256.Sum256([]byte(r.Method + " " + r.URL.Path + "\n" + string(body)))
+		requestHash := hex.EncodeToString(hash[:])
+
+		created, err := cfg.claimIdempotencyKey(r.Context(), scope, key, requestHash)
+		if err != nil {
+			respondWithError(w, r, err)
+			return
+		}
+
+		if !created.ResponseStatus.Valid {
+			cfg.handleIdempotently(w, r, next, created)
+			return
+		}
+
+		if created.ResponseHeaders.Valid {
+			var header http.Header
+			err := json.Unmarshal([]byte(created.ResponseHeaders.String), &header)
+			if err != nil {
+				respondWithError(w, r, internalError("Error decoding idempotent response headers", err))
+				return
+			}
+			for name, values := range header {
+				w.Header()[name] = values
+			}
+		}
+		w.Header().Set("Idempotent-Replayed", "true")
+		w.WriteHeader(int(created.ResponseStatus.Int32))
+		w.Write(created.ResponseBody)
+	}
+}
+
+// claimIdempotencyKey creates the key, or returns the completed key a
+// previous request left behind.
+func (cfg *apiConfig) claimIdempotencyKey(ctx context.Context, scope, key, requestHash string) (database.IdempotencyKey, error) {
+	id := database.GetIdempotencyKeyParams{Scope: scope, Key: key}
+
+	for attempt := 0; attempt < maxClaimAttempts; attempt++ {
+		created, err := cfg.db.CreateIdempotencyKey(ctx, database.CreateIdempotencyKeyParams{
+			Scope:       scope,
+			Key:         key,
+			RequestHash: requestHash,
+			ExpiresAt:   time.Now().UTC().Add(idempotencyKeyTTL),
+		})
+		if err == nil {
+			return created, nil
+		}
+
+		if !store.IsUniqueViolation(err) {
+			return database.IdempotencyKey{}, internalError("Error creating idempotency key", err)
+		}
+
+		existing, err := cfg.db.GetIdempotencyKey(ctx, id)
+		if errors.Is(err, sql.ErrNoRows) {
+			// The first request failed and released the key; try again.
+			continue
+		}
+		if err != nil {
+			return database.IdempotencyKey{}, internalError("Error getting idempotency key", err)
+		}
+
+		if existing.ExpiresAt.Before(time.Now()) {
+			err = cfg.db.DeleteIdempotencyKey(ctx, database.DeleteIdempotencyKeyParams(id))
+			if err != nil {
+				return database.IdempotencyKey{}, internalError("Error deleting idempotency key", err)
+			}
+			continue
+		}
+
+		if existing.RequestHash != requestHash {
+			return database.IdempotencyKey{}, &apiError{
+				Status: http.StatusUnprocessableEntity,
+				Code:   "idempotency_key_reused",
+				Detail: "Idempotency-Key was already used for a different request",
+			}
+		}
+
+		if !existing.ResponseStatus.Valid {
+			return database.IdempotencyKey{}, conflictError("idempotency_key_in_progress", "A request with this Idempotency-Key is still being handled", nil)
+		}
+
+		return existing, nil
+	}
+
+	return database.IdempotencyKey{}, conflictError("idempotency_key_in_progress", "A request with this Idempotency-Key is still being handled", nil)
+}
+
+// handleIdempotently serves the first request for key and stores its
+// response.
+func (cfg *apiConfig) handleIdempotently(w http.ResponseWriter, r *http.Request, next http.HandlerFunc, key database.IdempotencyKey) {
+	id := database.DeleteIdempotencyKeyParams{Scope: key.Scope, Key: key.Key}
+	rec := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+	// Headers set before next, such as the request ID and rate limits,
+	// belong to this request rather than to the response being stored.
+	before := w.Header().Clone()
+
+	// The response is stored even if the client has gone away, which is
+	// exactly when it will retry.
+	ctx := context.WithoutCancel(r.Context())
+
+	// Release the key unless a response was stored, including when next
+	// panics, so that it isn't stuck in progress until it expires.
+	completed := false
+	defer func() {
+		if completed {
+			return
+		}
+
+		err := cfg.db.DeleteIdempotencyKey(ctx, id)
+		if err != nil {
+			log.Printf("request %s: error releasing idempotency key: %v", requestID(ctx), err)
+		}
+	}()
+
+	next(rec, r)
+
+	if rec.status >= http.StatusInternalServerError {
+		return
+	}
+
+	header := http.Header{}
+	for name, values := range rec.Header() {
+		if !slices.Equal(before[name], values) {
+			header[name] = values
+		}
+	}
+	headerJson, err := json.Marshal(header)
+	if err != nil {
+		log.Printf("request %s: error encoding idempotent response headers: %v", requestID(ctx), err)
+		return
+	}
+
+	err = cfg.db.CompleteIdempotencyKey(ctx, database.CompleteIdempotencyKeyParams{
+		Scope:           key.Scope,
+		Key:             key.Key,
+		ResponseStatus:  sql.NullInt32{Int32: int32(rec.status), Valid: true},
+		ResponseBody:    rec.body.Bytes(),
+		ResponseHeaders: sql.NullString{String: string(headerJson), Valid: true},
+	})
+	if err != nil {
+		log.Printf("request %s: error storing idempotent response: %v", requestID(ctx), err)
+		return
+	}
+
+	completed = true
+}
+
+// sweepIdempotencyKeys deletes expired idempotency keys every interval
+// until ctx is done.
+func (cfg *apiConfig) sweepIdempotencyKeys(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		err := cfg.db.DeleteExpiredIdempotencyKeys(ctx, time.Now().UTC())
+		if err != nil {
+			log.Printf("Error deleting expired idempotency keys: %v", err)
+		}
+	}
+}
+
+// responseRecorder passes a response through while keeping a copy.
+type responseRecorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+	body        bytes.Buffer
+}
+
+func (r *responseRecorder) WriteHeader(status int) {
+	if !r.wroteHeader {
+		r.status = status
+		r.wroteHeader = true
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	r.wroteHeader = true
+	r.body.Write(b)
+	return r.ResponseWriter.Write(b)
+}
+
+func (r *responseRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
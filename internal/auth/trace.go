@@ -0,0 +1,63 @@
+package auth
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("github.com/vemolista/chirpy/v2/internal/auth")
+
+// The Context variants below wrap their counterparts in a span so that the
+// cost of bcrypt and JWT handling shows up in request traces.
+
+func HashPasswordContext(ctx context.Context, password string) (string, error) {
+	_, span := tracer.Start(ctx, "auth.HashPassword")
+	defer span.End()
+
+	hashed, err := HashPassword(password)
+	endSpan(span, err)
+
+	return hashed, err
+}
+
+func CheckPasswordHashContext(ctx context.Context, password, hash string) error {
+	_, span := tracer.Start(ctx, "auth.CheckPasswordHash")
+	defer span.End()
+
+	err := CheckPasswordHash(password, hash)
+	endSpan(span, err)
+
+	return err
+}
+
+func MakeJWTContext(ctx context.Context, userId uuid.UUID, tokenSecret string, expiresIn time.Duration) (string, error) {
+	_, span := tracer.Start(ctx, "auth.MakeJWT")
+	defer span.End()
+
+	token, err := MakeJWT(userId, tokenSecret, expiresIn)
+	endSpan(span, err)
+
+	return token, err
+}
+
+func ValidateJWTContext(ctx context.Context, tokenString, tokenSecret string) (uuid.UUID, error) {
+	_, span := tracer.Start(ctx, "auth.ValidateJWT")
+	defer span.End()
+
+	userId, err := ValidateJWT(tokenString, tokenSecret)
+	endSpan(span, err)
+
+	return userId, err
+}
+
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+}
@@ -0,0 +1,125 @@
+// Package blob stores opaque files, such as uploaded media, by key. The
+// filesystem store in this package is meant for single-instance
+// deployments; replicas need a shared implementation such as an
+// S3-compatible one.
+package blob
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// ErrNotFound is returned for keys that have no blob.
+var ErrNotFound = errors.New("blob not found")
+
+// Store is the interface blob stores implement. Keys are slash-separated
+// relative paths without "." or ".." elements.
+type Store interface {
+	// Put stores the contents of r under key, replacing any blob already
+	// there. Readers never see a partly written blob.
+	Put(ctx context.Context, key string, r io.Reader) error
+	// Open returns the blob under key and when it was stored.
+	Open(ctx context.Context, key string) (io.ReadSeekCloser, time.Time, error)
+	// Delete removes the blob under key. Deleting a missing blob is not an
+	// error.
+	Delete(ctx context.Context, key string) error
+}
+
+// FS is a Store keeping each blob in a file under a directory.
+type FS struct {
+	dir string
+}
+
+var _ Store = (*FS)(nil)
+
+func NewFS(dir string) *FS {
+	return &FS{dir: dir}
+}
+
+func (fs *FS) path(key string) (string, error) {
+	if !filepath.IsLocal(filepath.FromSlash(key)) {
+		return "", fmt.Errorf("invalid blob key %q", key)
+	}
+
+	return filepath.Join(fs.dir, filepath.FromSlash(key)), nil
+}
+
+func (fs *FS) Put(ctx context.Context, key string, r io.Reader) error {
+	path, err := fs.path(key)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(path), 0o755)
+	if err != nil {
+		return fmt.Errorf("error creating blob directory: %w", err)
+	}
+
+	// Write to a temporary file in the same directory and rename it into
+	// place, which is atomic.
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return fmt.Errorf("error creating blob file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = io.Copy(tmp, r)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("error writing blob %s: %w", key, err)
+	}
+
+	err = os.Rename(tmp.Name(), path)
+	if err != nil {
+		return fmt.Errorf("error storing blob %s: %w", key, err)
+	}
+
+	return nil
+}
+
+func (fs *FS) Open(ctx context.Context, key string) (io.ReadSeekCloser, time.Time, error) {
+	path, err := fs.path(key)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, time.Time{}, ErrNotFound
+	}
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("error opening blob %s: %w", key, err)
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, time.Time{}, fmt.Errorf("error opening blob %s: %w", key, err)
+	}
+
+	return f, info.ModTime(), nil
+}
+
+func (fs *FS) Delete(ctx context.Context, key string) error {
+	path, err := fs.path(key)
+	if err != nil {
+		return err
+	}
+
+	err = os.Remove(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("error deleting blob %s: %w", key, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,61 @@
+package blob
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFS(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	fs := NewFS(dir)
+
+	for _, contents := range []string{"first", "second"} {
+		err := fs.Put(ctx, "media/a", strings.NewReader(contents))
+		if err != nil {
+			t.Fatalf("expected to put blob: %v", err)
+		}
+	}
+
+	f, _, err := fs.Open(ctx, "media/a")
+	if err != nil {
+		t.Fatalf("expected to open blob: %v", err)
+	}
+	data, err := io.ReadAll(f)
+	f.Close()
+	if err != nil || string(data) != "second" {
+		t.Errorf("expected the blob to be replaced, instead got %q, %v", data, err)
+	}
+
+	entries, err := os.ReadDir(filepath.Join(dir, "media"))
+	if err != nil || len(entries) != 1 {
+		t.Errorf("expected no temporary files to be left behind, instead got %v, %v", entries, err)
+	}
+
+	err = fs.Delete(ctx, "media/a")
+	if err != nil {
+		t.Fatalf("expected to delete blob: %v", err)
+	}
+
+	_, _, err = fs.Open(ctx, "media/a")
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected a deleted blob not to be found, instead got %v", err)
+	}
+
+	err = fs.Delete(ctx, "media/a")
+	if err != nil {
+		t.Errorf("expected deleting a missing blob to succeed, instead got %v", err)
+	}
+
+	for _, key := range []string{"../escape", "/etc/passwd", ""} {
+		err = fs.Put(ctx, key, strings.NewReader("x"))
+		if err == nil {
+			t.Errorf("expected key %q to be rejected", key)
+		}
+	}
+}
@@ -0,0 +1,519 @@
+package config
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/vemolista/chirpy/v2/internal/ratelimit"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	PlatformDev        = "dev"
+	PlatformProduction = "production"
+
+	StoragePostgres = "postgres"
+	StorageSQLite   = "sqlite"
+	StorageMemory   = "memory"
+
+	redacted = "[redacted]"
+)
+
+type Config struct {
+	Port string `yaml:"port" toml:"port"`
+	// Storage selects the backend: postgres, sqlite or memory. DBURL is
+	// the Postgres connection string or the SQLite file path.
+	Storage   string          `yaml:"storage" toml:"storage"`
+	DBURL     string          `yaml:"db_url" toml:"db_url"`
+	Platform  string          `yaml:"platform" toml:"platform"`
+	Secret    string          `yaml:"secret" toml:"secret"`
+	PolkaKey  string          `yaml:"polka_key" toml:"polka_key"`
+	Server    ServerConfig    `yaml:"server" toml:"server"`
+	Tracing   TracingConfig   `yaml:"tracing" toml:"tracing"`
+	RateLimit RateLimitConfig `yaml:"rate_limit" toml:"rate_limit"`
+	Media     MediaConfig     `yaml:"media" toml:"media"`
+	Chirps    ChirpsConfig    `yaml:"chirps" toml:"chirps"`
+	// AutoMigrate applies pending migrations on startup instead of refusing
+	// to serve with an outdated schema.
+	AutoMigrate bool `yaml:"auto_migrate" toml:"auto_migrate"`
+
+	// GeneratedSecret is set when no SECRET was given in dev and a random
+	// one was generated, so tokens will not survive a restart.
+	GeneratedSecret bool `yaml:"-" toml:"-"`
+}
+
+type ServerConfig struct {
+	ReadHeaderTimeout time.Duration `yaml:"read_header_timeout" toml:"read_header_timeout"`
+	ReadTimeout       time.Duration `yaml:"read_timeout" toml:"read_timeout"`
+	WriteTimeout      time.Duration `yaml:"write_timeout" toml:"write_timeout"`
+	IdleTimeout       time.Duration `yaml:"idle_timeout" toml:"idle_timeout"`
+	// DrainPeriod is how long /api/readyz reports not ready before the
+	// server stops accepting connections on shutdown.
+	DrainPeriod     time.Duration `yaml:"drain_period" toml:"drain_period"`
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout" toml:"shutdown_timeout"`
+}
+
+type TracingConfig struct {
+	Exporter string `yaml:"exporter" toml:"exporter"`
+	Endpoint string `yaml:"endpoint" toml:"endpoint"`
+	File     string `yaml:"file" toml:"file"`
+}
+
+// RateLimitConfig sets a token-bucket limit per route group. Requests with
+// a valid access token are limited per user, everything else per client IP.
+type RateLimitConfig struct {
+	Enabled bool `yaml:"enabled" toml:"enabled"`
+	// Store is memory, which limits each instance separately, or postgres,
+	// which shares the limits between instances.
+	Store string `yaml:"store" toml:"store"`
+	// TrustedProxies lists the addresses or CIDR prefixes of the proxies
+	// whose X-Forwarded-For header is believed.
+	TrustedProxies []string `yaml:"trusted_proxies" toml:"trusted_proxies"`
+	// RedMultiplier scales every limit for Chirpy Red users.
+	RedMultiplier float64         `yaml:"red_multiplier" toml:"red_multiplier"`
+	Auth          ratelimit.Limit `yaml:"auth" toml:"auth"`
+	Write         ratelimit.Limit `yaml:"write" toml:"write"`
+	Read          ratelimit.Limit `yaml:"read" toml:"read"`
+	Webhook       ratelimit.Limit `yaml:"webhook" toml:"webhook"`
+}
+
+// MediaConfig sets where uploaded media are kept and how large they may be.
+type MediaConfig struct {
+	// Dir is the directory of the filesystem blob store.
+	Dir string `yaml:"dir" toml:"dir"`
+	// MaxBytes limits the size of an upload, and RedMaxBytes that of an
+	// upload by a Chirpy Red user.
+	MaxBytes    int64 `yaml:"max_bytes" toml:"max_bytes"`
+	RedMaxBytes int64 `yaml:"red_max_bytes" toml:"red_max_bytes"`
+}
+
+// ChirpsConfig sets how long deleted chirps are kept.
+type ChirpsConfig struct {
+	// RestoreWindow is how long after deleting a chirp its author may
+	// restore it. DeletedRetention is how long deleted chirps are kept,
+	// for moderators, before they are purged.
+	RestoreWindow    time.Duration `yaml:"restore_window" toml:"restore_window"`
+	DeletedRetention time.Duration `yaml:"deleted_retention" toml:"deleted_retention"`
+}
+
+func Default() Config {
+	return Config{
+		Port:     "8080",
+		Storage:  StoragePostgres,
+		Platform: PlatformProduction,
+		Server: ServerConfig{
+			ReadHeaderTimeout: 5 * time.Second,
+			ReadTimeout:       15 * time.Second,
+			WriteTimeout:      30 * time.Second,
+			IdleTimeout:       2 * time.Minute,
+			DrainPeriod:       5 * time.Second,
+			ShutdownTimeout:   20 * time.Second,
+		},
+		Tracing: TracingConfig{
+			Exporter: "none",
+		},
+		RateLimit: RateLimitConfig{
+			Enabled:       true,
+			Store:         StorageMemory,
+			RedMultiplier: 5,
+			Auth:          ratelimit.Limit{Requests: 10, Per: time.Minute},
+			Write:         ratelimit.Limit{Requests: 30, Per: time.Minute},
+			Read:          ratelimit.Limit{Requests: 300, Per: time.Minute},
+			Webhook:       ratelimit.Limit{Requests: 120, Per: time.Minute},
+		},
+		Media: MediaConfig{
+			Dir:         "media",
+			MaxBytes:    5 << 20,
+			RedMaxBytes: 15 << 20,
+		},
+		Chirps: ChirpsConfig{
+			RestoreWindow:    7 * 24 * time.Hour,
+			DeletedRetention: 30 * 24 * time.Hour,
+		},
+	}
+}
+
+// Load builds the configuration from, in increasing order of precedence,
+// the defaults, an optional YAML or TOML file, the environment and the
+// command line flags in args. The file is named by -config or CHIRPY_CONFIG.
+func Load(args []string, getenv func(string) string) (Config, error) {
+	path := getenv("CHIRPY_CONFIG")
+
+	// The first pass only finds -config and rejects malformed flags; the
+	// second pass below applies the flags on top of the file and environment.
+	scratch := Default()
+	err := newFlagSet(&scratch, &path).Parse(args)
+	if err != nil {
+		return Config{}, err
+	}
+
+	cfg := Default()
+	if path != "" {
+		err = loadFile(path, &cfg)
+		if err != nil {
+			return Config{}, err
+		}
+	}
+
+	err = cfg.applyEnv(getenv)
+	if err != nil {
+		return Config{}, err
+	}
+
+	err = newFlagSet(&cfg, &path).Parse(args)
+	if err != nil {
+		return Config{}, err
+	}
+
+	if cfg.Platform == PlatformDev && cfg.Secret == "" {
+		cfg.Secret, err = randomSecret()
+		if err != nil {
+			return Config{}, err
+		}
+		cfg.GeneratedSecret = true
+	}
+
+	err = cfg.Validate()
+	if err != nil {
+		return Config{}, err
+	}
+
+	return cfg, nil
+}
+
+func newFlagSet(cfg *Config, path *string) *flag.FlagSet {
+	fs := flag.NewFlagSet("chirpy", flag.ContinueOnError)
+
+	fs.StringVar(path, "config", *path, "path to a YAML or TOML config file")
+	fs.StringVar(&cfg.Port, "port", cfg.Port, "port to listen on")
+	fs.StringVar(&cfg.Storage, "storage", cfg.Storage, "postgres, sqlite or memory")
+	fs.StringVar(&cfg.DBURL, "db-url", cfg.DBURL, "Postgres connection string or SQLite file path")
+	fs.StringVar(&cfg.Platform, "platform", cfg.Platform, "dev or production")
+	fs.BoolVar(&cfg.AutoMigrate, "auto-migrate", cfg.AutoMigrate, "apply pending database migrations on startup")
+	fs.StringVar(&cfg.Tracing.Exporter, "tracing-exporter", cfg.Tracing.Exporter, "none, otlp, stdout or file")
+	fs.StringVar(&cfg.Tracing.Endpoint, "tracing-endpoint", cfg.Tracing.Endpoint, "OTLP/HTTP endpoint URL")
+	fs.StringVar(&cfg.Tracing.File, "tracing-file", cfg.Tracing.File, "file the file exporter writes spans to")
+	fs.BoolVar(&cfg.RateLimit.Enabled, "rate-limit", cfg.RateLimit.Enabled, "enable rate limiting")
+	fs.StringVar(&cfg.RateLimit.Store, "rate-limit-store", cfg.RateLimit.Store, "memory or postgres")
+	fs.TextVar(&cfg.RateLimit.Auth, "rate-limit-auth", cfg.RateLimit.Auth, "limit for signup, login and token routes, e.g. 10/1m")
+	fs.TextVar(&cfg.RateLimit.Write, "rate-limit-write", cfg.RateLimit.Write, "limit for routes that change chirps or users")
+	fs.TextVar(&cfg.RateLimit.Read, "rate-limit-read", cfg.RateLimit.Read, "limit for routes that read chirps")
+	fs.TextVar(&cfg.RateLimit.Webhook, "rate-limit-webhook", cfg.RateLimit.Webhook, "limit for incoming webhooks")
+	fs.StringVar(&cfg.Media.Dir, "media-dir", cfg.Media.Dir, "directory uploaded media are stored in")
+	fs.Int64Var(&cfg.Media.MaxBytes, "media-max-bytes", cfg.Media.MaxBytes, "largest media upload")
+	fs.Int64Var(&cfg.Media.RedMaxBytes, "media-red-max-bytes", cfg.Media.RedMaxBytes, "largest media upload by Chirpy Red users")
+	fs.DurationVar(&cfg.Chirps.RestoreWindow, "chirp-restore-window", cfg.Chirps.RestoreWindow, "how long a deleted chirp can be restored")
+	fs.DurationVar(&cfg.Chirps.DeletedRetention, "chirp-deleted-retention", cfg.Chirps.DeletedRetention, "how long deleted chirps are kept before being purged")
+	fs.DurationVar(&cfg.Server.ReadHeaderTimeout, "read-header-timeout", cfg.Server.ReadHeaderTimeout, "")
+	fs.DurationVar(&cfg.Server.ReadTimeout, "read-timeout", cfg.Server.ReadTimeout, "")
+	fs.DurationVar(&cfg.Server.WriteTimeout, "write-timeout", cfg.Server.WriteTimeout, "")
+	fs.DurationVar(&cfg.Server.IdleTimeout, "idle-timeout", cfg.Server.IdleTimeout, "")
+	fs.DurationVar(&cfg.Server.DrainPeriod, "drain-period", cfg.Server.DrainPeriod, "how long to report not ready before shutting down")
+	fs.DurationVar(&cfg.Server.ShutdownTimeout, "shutdown-timeout", cfg.Server.ShutdownTimeout, "how long to wait for in-flight requests on shutdown")
+
+	return fs
+}
+
+func loadFile(path string, cfg *Config) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("error reading config file: %w", err)
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, cfg)
+	case ".toml":
+		err = toml.Unmarshal(data, cfg)
+	default:
+		return fmt.Errorf("unsupported config file extension %q", filepath.Ext(path))
+	}
+
+	if err != nil {
+		return fmt.Errorf("error parsing config file %s: %w", path, err)
+	}
+
+	return nil
+}
+
+func (c *Config) applyEnv(getenv func(string) string) error {
+	stringVars := map[string]*string{
+		"PORT":             &c.Port,
+		"STORAGE":          &c.Storage,
+		"DB_URL":           &c.DBURL,
+		"PLATFORM":         &c.Platform,
+		"SECRET":           &c.Secret,
+		"POLKA_KEY":        &c.PolkaKey,
+		"TRACING_EXPORTER": &c.Tracing.Exporter,
+		"TRACING_ENDPOINT": &c.Tracing.Endpoint,
+		"TRACING_FILE":     &c.Tracing.File,
+		"RATE_LIMIT_STORE": &c.RateLimit.Store,
+		"MEDIA_DIR":        &c.Media.Dir,
+	}
+	for name, field := range stringVars {
+		if value := getenv(name); value != "" {
+			*field = value
+		}
+	}
+
+	boolVars := map[string]*bool{
+		"AUTO_MIGRATE":       &c.AutoMigrate,
+		"RATE_LIMIT_ENABLED": &c.RateLimit.Enabled,
+	}
+	for name, field := range boolVars {
+		value := getenv(name)
+		if value == "" {
+			continue
+		}
+
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("invalid %s: %w", name, err)
+		}
+		*field = b
+	}
+
+	if value := getenv("RATE_LIMIT_TRUSTED_PROXIES"); value != "" {
+		c.RateLimit.TrustedProxies = strings.Split(value, ",")
+	}
+
+	if value := getenv("RATE_LIMIT_RED_MULTIPLIER"); value != "" {
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("invalid RATE_LIMIT_RED_MULTIPLIER: %w", err)
+		}
+		c.RateLimit.RedMultiplier = f
+	}
+
+	byteVars := map[string]*int64{
+		"MEDIA_MAX_BYTES":     &c.Media.MaxBytes,
+		"MEDIA_RED_MAX_BYTES": &c.Media.RedMaxBytes,
+	}
+	for name, field := range byteVars {
+		value := getenv(name)
+		if value == "" {
+			continue
+		}
+
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid %s: %w", name, err)
+		}
+		*field = n
+	}
+
+	limitVars := map[string]*ratelimit.Limit{
+		"RATE_LIMIT_AUTH":    &c.RateLimit.Auth,
+		"RATE_LIMIT_WRITE":   &c.RateLimit.Write,
+		"RATE_LIMIT_READ":    &c.RateLimit.Read,
+		"RATE_LIMIT_WEBHOOK": &c.RateLimit.Webhook,
+	}
+	for name, field := range limitVars {
+		value := getenv(name)
+		if value == "" {
+			continue
+		}
+
+		err := field.UnmarshalText([]byte(value))
+		if err != nil {
+			return fmt.Errorf("invalid %s: %w", name, err)
+		}
+	}
+
+	durationVars := map[string]*time.Duration{
+		"READ_HEADER_TIMEOUT":     &c.Server.ReadHeaderTimeout,
+		"READ_TIMEOUT":            &c.Server.ReadTimeout,
+		"WRITE_TIMEOUT":           &c.Server.WriteTimeout,
+		"IDLE_TIMEOUT":            &c.Server.IdleTimeout,
+		"DRAIN_PERIOD":            &c.Server.DrainPeriod,
+		"SHUTDOWN_TIMEOUT":        &c.Server.ShutdownTimeout,
+		"CHIRP_RESTORE_WINDOW":    &c.Chirps.RestoreWindow,
+		"CHIRP_DELETED_RETENTION": &c.Chirps.DeletedRetention,
+	}
+	for name, field := range durationVars {
+		value := getenv(name)
+		if value == "" {
+			continue
+		}
+
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("invalid %s: %w", name, err)
+		}
+		*field = d
+	}
+
+	return nil
+}
+
+// Validate reports every problem with the configuration at once. A missing
+// SECRET is always fatal, so tokens are never signed with an empty key, and
+// POLKA_KEY is required in production.
+func (c Config) Validate() error {
+	var errs []error
+
+	port, err := strconv.Atoi(c.Port)
+	if err != nil || port < 1 || port > 65535 {
+		errs = append(errs, fmt.Errorf("port must be a number between 1 and 65535, got %q", c.Port))
+	}
+
+	switch c.Storage {
+	case StoragePostgres, StorageSQLite:
+		if c.DBURL == "" {
+			errs = append(errs, fmt.Errorf("DB_URL is required for %s storage", c.Storage))
+		}
+	case StorageMemory:
+		if c.Platform == PlatformProduction {
+			errs = append(errs, errors.New("memory storage is not allowed in production"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("storage must be %q, %q or %q, got %q", StoragePostgres, StorageSQLite, StorageMemory, c.Storage))
+	}
+
+	if c.Secret == "" {
+		errs = append(errs, errors.New("SECRET is required"))
+	}
+
+	switch c.Platform {
+	case PlatformDev:
+	case PlatformProduction:
+		if c.PolkaKey == "" {
+			errs = append(errs, errors.New("POLKA_KEY is required in production"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("platform must be %q or %q, got %q", PlatformDev, PlatformProduction, c.Platform))
+	}
+
+	switch c.Tracing.Exporter {
+	case "none", "otlp", "stdout":
+	case "file":
+		if c.Tracing.File == "" {
+			errs = append(errs, errors.New("tracing file is required for the file exporter"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("unknown tracing exporter %q", c.Tracing.Exporter))
+	}
+
+	errs = append(errs, c.RateLimit.validate(c.Storage)...)
+
+	if c.Media.Dir == "" {
+		errs = append(errs, errors.New("media dir is required"))
+	}
+	if c.Media.MaxBytes <= 0 {
+		errs = append(errs, errors.New("media max bytes must be positive"))
+	}
+	if c.Media.RedMaxBytes < c.Media.MaxBytes {
+		errs = append(errs, errors.New("media red max bytes must be at least media max bytes"))
+	}
+
+	if c.Chirps.RestoreWindow < 0 {
+		errs = append(errs, errors.New("chirp restore window must not be negative"))
+	}
+	if c.Chirps.DeletedRetention < c.Chirps.RestoreWindow {
+		errs = append(errs, errors.New("chirp deleted retention must be at least the restore window"))
+	}
+
+	for name, d := range map[string]time.Duration{
+		"read header timeout": c.Server.ReadHeaderTimeout,
+		"read timeout":        c.Server.ReadTimeout,
+		"write timeout":       c.Server.WriteTimeout,
+		"idle timeout":        c.Server.IdleTimeout,
+		"shutdown timeout":    c.Server.ShutdownTimeout,
+	} {
+		if d <= 0 {
+			errs = append(errs, fmt.Errorf("%s must be positive", name))
+		}
+	}
+
+	if c.Server.DrainPeriod < 0 {
+		errs = append(errs, errors.New("drain period must not be negative"))
+	}
+
+	return errors.Join(errs...)
+}
+
+func (r RateLimitConfig) validate(storage string) []error {
+	var errs []error
+
+	switch r.Store {
+	case StorageMemory:
+	case StoragePostgres:
+		if storage != StoragePostgres {
+			errs = append(errs, fmt.Errorf("the postgres rate limit store requires %s storage", StoragePostgres))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("rate limit store must be %q or %q, got %q", StorageMemory, StoragePostgres, r.Store))
+	}
+
+	_, err := ratelimit.ParseTrustedProxies(r.TrustedProxies)
+	if err != nil {
+		errs = append(errs, err)
+	}
+
+	if r.RedMultiplier < 1 {
+		errs = append(errs, errors.New("rate limit red multiplier must be at least 1"))
+	}
+
+	return errs
+}
+
+func (c Config) Addr() string {
+	return ":" + c.Port
+}
+
+// Redacted renders the configuration as YAML with secrets and the database
+// password masked, for logging at startup.
+func (c Config) Redacted() string {
+	if c.Secret != "" {
+		c.Secret = redacted
+	}
+
+	if c.PolkaKey != "" {
+		c.PolkaKey = redacted
+	}
+
+	c.DBURL = redactURL(c.DBURL)
+
+	data, err := yaml.Marshal(c)
+	if err != nil {
+		return fmt.Sprintf("error marshalling config: %v", err)
+	}
+
+	return string(data)
+}
+
+// redactURL masks the password of a URL-style connection string. Anything
+// that does not parse as a URL, such as a key=value DSN, is masked entirely.
+func redactURL(raw string) string {
+	if raw == "" {
+		return ""
+	}
+
+	u, err := url.Parse(raw)
+	if err != nil || u.Scheme == "" {
+		return redacted
+	}
+
+	return u.Redacted()
+}
+
+func randomSecret() (string, error) {
+	bytes := make([]byte, 32)
+
+	_, err := rand.Read(bytes)
+	if err != nil {
+		return "", fmt.Errorf("error generating dev secret: %w", err)
+	}
+
+	return hex.EncodeToString(bytes), nil
+}
@@ -0,0 +1,189 @@
+// Package entities finds the @mentions, #hashtags and URLs in a chirp.
+package entities
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Entity types.
+const (
+	Mention = "mention"
+	Hashtag = "hashtag"
+	URL     = "url"
+)
+
+const (
+	// MaxHandleLen is the longest handle a mention can have.
+	MaxHandleLen = 15
+	// MaxHashtagLen is the longest hashtag, in characters.
+	MaxHashtagLen = 100
+)
+
+// Entity is a span of a chirp's body. Start and End are byte offsets, so
+// body[Start:End] is the entity as written, including its @ or #.
+type Entity struct {
+	Type  string
+	Start int
+	End   int
+	// Text is the handle or tag without its prefix, lower cased, or the
+	// URL as written.
+	Text string
+}
+
+// Extract returns the entities in text in order. Mentions and hashtags must
+// start a word, so email addresses and URL fragments aren't mistaken for
+// them.
+func Extract(text string) []Entity {
+	var entities []Entity
+
+	for i := 0; i < len(text); {
+		if !startsWord(text, i) {
+			_, size := utf8.DecodeRuneInString(text[i:])
+			i += size
+			continue
+		}
+
+		var entity Entity
+		var ok bool
+		switch {
+		case hasURLScheme(text[i:]):
+			entity, ok = scanURL(text, i)
+		case text[i] == '@':
+			entity, ok = scanMention(text, i)
+		case text[i] == '#':
+			entity, ok = scanHashtag(text, i)
+		}
+
+		if ok {
+			entities = append(entities, entity)
+			i = entity.End
+			continue
+		}
+
+		_, size := utf8.DecodeRuneInString(text[i:])
+		i += size
+	}
+
+	return entities
+}
+
+// NormalizeHashtag returns tag in the form Extract stores it, without a
+// leading #, and whether it is a valid hashtag at all.
+func NormalizeHashtag(tag string) (string, bool) {
+	tag = strings.TrimPrefix(tag, "#")
+
+	entity, ok := scanHashtag("#"+tag, 0)
+	if !ok || entity.End != len(tag)+1 {
+		return "", false
+	}
+
+	return entity.Text, true
+}
+
+func startsWord(text string, i int) bool {
+	if i == 0 {
+		return true
+	}
+
+	prev, _ := utf8.DecodeLastRuneInString(text[:i])
+	return !isWordRune(prev)
+}
+
+func isWordRune(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.Is(unicode.Mn, r)
+}
+
+func isHandleByte(b byte) bool {
+	return b == '_' || ('a' <= b && b <= 'z') || ('A' <= b && b <= 'Z') || ('0' <= b && b <= '9')
+}
+
+func scanMention(text string, start int) (Entity, bool) {
+	end := start + 1
+	for end < len(text) && isHandleByte(text[end]) {
+		end++
+	}
+
+	handle := text[start+1 : end]
+	if handle == "" || len(handle) > MaxHandleLen {
+		return Entity{}, false
+	}
+
+	// A handle can't run on into other word characters, as in @bob's
+	// sibling @bobé.
+	if next, _ := utf8.DecodeRuneInString(text[end:]); end < len(text) && isWordRune(next) {
+		return Entity{}, false
+	}
+
+	return Entity{Type: Mention, Start: start, End: end, Text: strings.ToLower(handle)}, true
+}
+
+func scanHashtag(text string, start int) (Entity, bool) {
+	end := start + 1
+	length := 0
+	hasLetter := false
+
+	for end < len(text) {
+		r, size := utf8.DecodeRuneInString(text[end:])
+		if !isWordRune(r) {
+			break
+		}
+
+		if !unicode.IsDigit(r) {
+			hasLetter = true
+		}
+		length++
+		end += size
+	}
+
+	// All-digit tags like #1 are usually numbering, not topics.
+	if !hasLetter || length > MaxHashtagLen {
+		return Entity{}, false
+	}
+
+	return Entity{Type: Hashtag, Start: start, End: end, Text: strings.ToLower(text[start+1 : end])}, true
+}
+
+func hasURLScheme(text string) bool {
+	for _, scheme := range []string{"http://", "https://"} {
+		if len(text) >= len(scheme) && strings.EqualFold(text[:len(scheme)], scheme) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// scanURL takes everything up to the next space as the URL, less trailing
+// punctuation that more likely ends the sentence, keeping a closing
+// parenthesis that has an opening one in the URL.
+func scanURL(text string, start int) (Entity, bool) {
+	end := start
+	for end < len(text) {
+		r, size := utf8.DecodeRuneInString(text[end:])
+		if unicode.IsSpace(r) || r == '<' || r == '>' || r == '"' {
+			break
+		}
+		end += size
+	}
+
+	for end > start {
+		last := text[end-1]
+		if last == ')' && strings.Count(text[start:end], "(") >= strings.Count(text[start:end], ")") {
+			break
+		}
+		if !strings.ContainsRune(".,;:!?'\")]}", rune(last)) {
+			break
+		}
+		end--
+	}
+
+	url := text[start:end]
+	scheme := strings.Index(url, "://") + len("://")
+	if len(url) == scheme {
+		return Entity{}, false
+	}
+
+	return Entity{Type: URL, Start: start, End: end, Text: url}, true
+}
@@ -0,0 +1,62 @@
+package entities
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestExtract(t *testing.T) {
+	cases := []struct {
+		name     string
+		text     string
+		expected []Entity
+	}{
+		{"plain text", "just a chirp", nil},
+		{"mention", "hi @Bob!", []Entity{{Mention, 3, 7, "bob"}}},
+		{"mention at start", "@alice_1 hello", []Entity{{Mention, 0, 8, "alice_1"}}},
+		{"email is not a mention", "mail bob@example.com", nil},
+		{"handle too long", "@abcdefghijklmnop", nil},
+		{"handle running into a letter", "@bobé", nil},
+		{"hashtag", "love #Go and #golang_2025.", []Entity{{Hashtag, 5, 8, "go"}, {Hashtag, 13, 25, "golang_2025"}}},
+		{"unicode hashtag", "#café time", []Entity{{Hashtag, 0, 6, "café"}}},
+		{"numeric hashtag", "we're #1", nil},
+		{"hash inside a word", "C# and a#b", nil},
+		{"url", "see https://example.com/a?b=c.", []Entity{{URL, 4, 29, "https://example.com/a?b=c"}}},
+		{"url in parentheses", "(http://example.com)", []Entity{{URL, 1, 19, "http://example.com"}}},
+		{"url with parentheses", "https://en.wikipedia.org/wiki/Go_(game)", []Entity{{URL, 0, 39, "https://en.wikipedia.org/wiki/Go_(game)"}}},
+		{"url fragment is not a hashtag", "https://example.com/#top @bob", []Entity{{URL, 0, 24, "https://example.com/#top"}, {Mention, 25, 29, "bob"}}},
+		{"bare scheme", "https:// nothing", nil},
+		{"everything", "@bob #go https://go.dev", []Entity{{Mention, 0, 4, "bob"}, {Hashtag, 5, 8, "go"}, {URL, 9, 23, "https://go.dev"}}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			entities := Extract(c.text)
+			if !reflect.DeepEqual(entities, c.expected) {
+				t.Errorf("expected %+v, instead got %+v", c.expected, entities)
+			}
+		})
+	}
+}
+
+func TestNormalizeHashtag(t *testing.T) {
+	cases := []struct {
+		input    string
+		expected string
+		ok       bool
+	}{
+		{"Go", "go", true},
+		{"#Go", "go", true},
+		{"café", "café", true},
+		{"123", "", false},
+		{"two words", "", false},
+		{"", "", false},
+	}
+
+	for _, c := range cases {
+		tag, ok := NormalizeHashtag(c.input)
+		if tag != c.expected || ok != c.ok {
+			t.Errorf("expected %q to normalize to %q (ok %v), instead got %q, %v", c.input, c.expected, c.ok, tag, ok)
+		}
+	}
+}
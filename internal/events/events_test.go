@@ -0,0 +1,133 @@
+package events
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/pressly/goose/v3"
+	"github.com/vemolista/chirpy/v2/internal/database"
+)
+
+func TestHub(t *testing.T) {
+	hub := NewHub()
+	first := hub.Subscribe()
+	second := hub.Subscribe()
+
+	hub.Publish(database.ChirpEvent{ID: 1})
+
+	for _, sub := range []*Subscription{first, second} {
+		event := <-sub.C
+		if event.ID != 1 {
+			t.Errorf("expected event 1, instead got %d", event.ID)
+		}
+	}
+
+	second.Close()
+	second.Close()
+	hub.Publish(database.ChirpEvent{ID: 2})
+
+	if event := <-first.C; event.ID != 2 {
+		t.Errorf("expected event 2, instead got %d", event.ID)
+	}
+
+	if _, ok := <-second.C; ok {
+		t.Errorf("expected a closed subscription to receive nothing")
+	}
+
+	hub.Close()
+	if _, ok := <-first.C; ok {
+		t.Errorf("expected closing the hub to end subscriptions")
+	}
+
+	if _, ok := <-hub.Subscribe().C; ok {
+		t.Errorf("expected subscribing to a closed hub to end at once")
+	}
+}
+
+func TestHubDropsSlowSubscribers(t *testing.T) {
+	hub := NewHub()
+	slow := hub.Subscribe()
+
+	for i := range subscriptionBuffer + 1 {
+		hub.Publish(database.ChirpEvent{ID: int64(i + 1)})
+	}
+
+	received := 0
+	for range slow.C {
+		received++
+	}
+
+	if received != subscriptionBuffer {
+		t.Errorf("expected %d buffered events before the drop, instead got %d", subscriptionBuffer, received)
+	}
+}
+
+func TestListen(t *testing.T) {
+	dbUrl := os.Getenv("CHIRPY_TEST_DB_URL")
+	if dbUrl == "" {
+		t.Skip("CHIRPY_TEST_DB_URL not set")
+	}
+
+	db, err := sql.Open("postgres", dbUrl)
+	if err != nil {
+		t.Fatalf("expected to open postgres: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	provider, err := goose.NewProvider(goose.DialectPostgres, db, os.DirFS("../../sql/migrations"))
+	if err != nil {
+		t.Fatalf("expected migration provider: %v", err)
+	}
+
+	_, err = provider.Up(context.Background())
+	if err != nil {
+		t.Fatalf("expected migrations to apply: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	queries := database.New(db)
+	hub := NewHub()
+	sub := hub.Subscribe()
+	go Listen(ctx, dbUrl, queries, hub)
+
+	user, err := queries.CreateUser(ctx, database.CreateUserParams{
+		Email:          "listen-" + time.Now().Format("150405.000000") + "@example.com",
+		HashedPassword: "hash",
+	})
+	if err != nil {
+		t.Fatalf("expected to create user: %v", err)
+	}
+
+	// The listener may not be listening yet, so keep sending events until
+	// one arrives.
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	timeout := time.After(10 * time.Second)
+
+	for {
+		created, err := queries.CreateChirpEvent(ctx, database.CreateChirpEventParams{
+			Type:   "chirp.created",
+			UserID: user.ID,
+			Data:   `{}`,
+		})
+		if err != nil {
+			t.Fatalf("expected to create chirp event: %v", err)
+		}
+
+		select {
+		case event := <-sub.C:
+			if event.UserID != user.ID || event.ID > created.ID {
+				t.Errorf("expected an event of the test user, instead got %+v", event)
+			}
+			return
+		case <-ticker.C:
+		case <-timeout:
+			t.Fatalf("expected a notified event within 10s")
+		}
+	}
+}
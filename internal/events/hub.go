@@ -0,0 +1,96 @@
+// Package events fans chirp events out to the clients streaming them.
+package events
+
+import (
+	"sync"
+
+	"github.com/vemolista/chirpy/v2/internal/database"
+)
+
+// subscriptionBuffer is how many events a subscriber may fall behind by
+// before it is dropped.
+const subscriptionBuffer = 64
+
+// Hub delivers every published event to all current subscribers. Publish
+// never blocks: a subscriber that can't keep up is dropped, and is expected
+// to reconnect and resume from the last event it saw.
+type Hub struct {
+	mu          sync.Mutex
+	subscribers map[*Subscription]struct{}
+	closed      bool
+}
+
+// Subscription receives events on C until it is closed, dropped for being
+// too slow, or the hub is closed, at which point C is closed.
+type Subscription struct {
+	C <-chan database.ChirpEvent
+
+	c   chan database.ChirpEvent
+	hub *Hub
+}
+
+func NewHub() *Hub {
+	return &Hub{subscribers: map[*Subscription]struct{}{}}
+}
+
+// Subscribe returns a subscription to events published from now on.
+func (h *Hub) Subscribe() *Subscription {
+	c := make(chan database.ChirpEvent, subscriptionBuffer)
+	sub := &Subscription{C: c, c: c, hub: h}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.closed {
+		close(c)
+		return sub
+	}
+
+	h.subscribers[sub] = struct{}{}
+
+	return sub
+}
+
+// Close stops the subscription. It is safe to call more than once.
+func (s *Subscription) Close() {
+	s.hub.mu.Lock()
+	defer s.hub.mu.Unlock()
+
+	s.hub.remove(s)
+}
+
+// Publish delivers event to every subscriber.
+func (h *Hub) Publish(event database.ChirpEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for sub := range h.subscribers {
+		select {
+		case sub.c <- event:
+		default:
+			h.remove(sub)
+		}
+	}
+}
+
+// Close ends every subscription, and any made later, so that streams
+// finish when the server shuts down.
+func (h *Hub) Close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.closed = true
+	for sub := range h.subscribers {
+		h.remove(sub)
+	}
+}
+
+// remove must be called with h.mu held.
+func (h *Hub) remove(sub *Subscription) {
+	if _, ok := h.subscribers[sub]; !ok {
+		return
+	}
+
+	delete(h.subscribers, sub)
+	close(sub.c)
+}
@@ -0,0 +1,96 @@
+package events
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/vemolista/chirpy/v2/internal/database"
+	"github.com/vemolista/chirpy/v2/internal/store"
+)
+
+// Channel is the Postgres notification channel the chirp_events trigger
+// sends each new event's ID on.
+const Channel = "chirp_events"
+
+// catchUpPage is how many events are read at a time after a reconnect.
+const catchUpPage = 100
+
+// Listen publishes events to hub as Postgres notifies this replica of them,
+// until ctx is done. The notification only carries the event's ID; the
+// event itself is read from db. Notifications sent while the connection was
+// down are lost, so after reconnecting Listen reads every event after the
+// last one it published.
+func Listen(ctx context.Context, dbURL string, db store.ChirpEventStore, hub *Hub) error {
+	listener := pq.NewListener(dbURL, time.Second, time.Minute, func(event pq.ListenerEventType, err error) {
+		if err != nil {
+			log.Printf("Chirp event listener: %v", err)
+		}
+	})
+	defer listener.Close()
+
+	err := listener.Listen(Channel)
+	if err != nil {
+		return fmt.Errorf("error listening on %s: %w", Channel, err)
+	}
+
+	var lastID int64
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case n := <-listener.Notify:
+			// A nil notification means the connection was re-established.
+			if n == nil {
+				lastID = catchUp(ctx, db, hub, lastID)
+				continue
+			}
+
+			id, err := strconv.ParseInt(n.Extra, 10, 64)
+			if err != nil {
+				log.Printf("Chirp event listener: invalid event id %q", n.Extra)
+				continue
+			}
+
+			event, err := db.GetChirpEvent(ctx, id)
+			if err != nil {
+				log.Printf("Chirp event listener: error getting event %d: %v", id, err)
+				continue
+			}
+
+			hub.Publish(event)
+			lastID = max(lastID, event.ID)
+		}
+	}
+}
+
+// catchUp publishes the events after lastID and returns the new last ID.
+// Before anything was published there is nothing to catch up on.
+func catchUp(ctx context.Context, db store.ChirpEventStore, hub *Hub, lastID int64) int64 {
+	if lastID == 0 {
+		return lastID
+	}
+
+	for {
+		events, err := db.ListChirpEventsAfter(ctx, database.ListChirpEventsAfterParams{
+			ID:    lastID,
+			Limit: catchUpPage,
+		})
+		if err != nil {
+			log.Printf("Chirp event listener: error catching up after event %d: %v", lastID, err)
+			return lastID
+		}
+
+		for _, event := range events {
+			hub.Publish(event)
+			lastID = event.ID
+		}
+
+		if len(events) < catchUpPage {
+			return lastID
+		}
+	}
+}
@@ -0,0 +1,312 @@
+// Package linkpreview fetches the OpenGraph and Twitter card metadata that
+// clients show as a card for a link.
+package linkpreview
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+	"unicode/utf8"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+	"golang.org/x/net/html/charset"
+)
+
+const (
+	// DefaultTimeout bounds a whole fetch, redirects included.
+	DefaultTimeout = 5 * time.Second
+	// DefaultMaxBytes is how much of a page is read. The metadata is in the
+	// head, so a truncated page is still parsed.
+	DefaultMaxBytes = 512 << 10
+
+	maxRedirects      = 5
+	maxHeaderBytes    = 64 << 10
+	maxTitleBytes     = 300
+	maxDescBytes      = 1000
+	maxSiteNameBytes  = 100
+	maxImageURLLength = 2048
+)
+
+var (
+	ErrUnsupportedURL   = errors.New("unsupported URL")
+	ErrForbiddenAddress = errors.New("address is not public")
+	ErrNotHTML          = errors.New("not an HTML page")
+	ErrNoMetadata       = errors.New("page has no preview metadata")
+)
+
+// Preview is the card for a page. Any field may be empty, but not all.
+type Preview struct {
+	Title       string
+	Description string
+	ImageURL    string
+	SiteName    string
+}
+
+type Options struct {
+	// Timeout defaults to DefaultTimeout and MaxBytes to DefaultMaxBytes.
+	Timeout   time.Duration
+	MaxBytes  int64
+	UserAgent string
+	// AllowPrivate lets the fetcher connect to loopback and private
+	// addresses. It is for tests against httptest servers only.
+	AllowPrivate bool
+}
+
+// Fetcher fetches previews. It only connects to public addresses: the
+// check is made on the address actually dialed, after DNS resolution and
+// on every redirect, so a hostname can't smuggle in an internal one.
+type Fetcher struct {
+	client    *http.Client
+	userAgent string
+	maxBytes  int64
+}
+
+func NewFetcher(opts Options) *Fetcher {
+	if opts.Timeout <= 0 {
+		opts.Timeout = DefaultTimeout
+	}
+	if opts.MaxBytes <= 0 {
+		opts.MaxBytes = DefaultMaxBytes
+	}
+
+	dialer := &net.Dialer{Timeout: opts.Timeout}
+	if !opts.AllowPrivate {
+		dialer.Control = checkAddress
+	}
+
+	return &Fetcher{
+		client: &http.Client{
+			Timeout: opts.Timeout,
+			// No Proxy: a proxy would dial on our behalf, unchecked.
+			Transport: &http.Transport{
+				DialContext:            dialer.DialContext,
+				TLSHandshakeTimeout:    opts.Timeout,
+				ResponseHeaderTimeout:  opts.Timeout,
+				MaxResponseHeaderBytes: maxHeaderBytes,
+				ForceAttemptHTTP2:      true,
+			},
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				if len(via) >= maxRedirects {
+					return fmt.Errorf("stopped after %d redirects", maxRedirects)
+				}
+				return checkURL(req.URL)
+			},
+		},
+		userAgent: opts.UserAgent,
+		maxBytes:  opts.MaxBytes,
+	}
+}
+
+// Fetch gets the page at rawURL and returns its preview.
+func (f *Fetcher) Fetch(ctx context.Context, rawURL string) (Preview, error) {
+	target, err := url.Parse(rawURL)
+	if err != nil {
+		return Preview{}, fmt.Errorf("%w: %v", ErrUnsupportedURL, err)
+	}
+
+	err = checkURL(target)
+	if err != nil {
+		return Preview{}, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target.String(), nil)
+	if err != nil {
+		return Preview{}, fmt.Errorf("error creating request: %w", err)
+	}
+	req.Header.Set("Accept", "text/html,application/xhtml+xml")
+	if f.userAgent != "" {
+		req.Header.Set("User-Agent", f.userAgent)
+	}
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return Preview{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return Preview{}, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	contentType := resp.Header.Get("Content-Type")
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	if mediaType != "text/html" && mediaType != "application/xhtml+xml" {
+		return Preview{}, fmt.Errorf("%w: %q", ErrNotHTML, contentType)
+	}
+
+	var body io.Reader = io.LimitReader(resp.Body, f.maxBytes)
+	decoded, err := charset.NewReader(body, contentType)
+	if err == nil {
+		body = decoded
+	}
+
+	preview := parse(body, resp.Request.URL)
+	if preview == (Preview{}) {
+		return Preview{}, ErrNoMetadata
+	}
+
+	return preview, nil
+}
+
+func checkURL(u *url.URL) error {
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" {
+		return fmt.Errorf("%w: %s", ErrUnsupportedURL, u.Redacted())
+	}
+
+	return nil
+}
+
+// checkAddress is a net.Dialer Control function that refuses to connect to
+// addresses that aren't public.
+func checkAddress(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrForbiddenAddress, address)
+	}
+
+	addr, err := netip.ParseAddr(host)
+	if err != nil || !isPublic(addr) {
+		return fmt.Errorf("%w: %s", ErrForbiddenAddress, host)
+	}
+
+	return nil
+}
+
+// reserved are the ranges that aren't public but that netip.Addr has no
+// predicate for. NAT64 and 6to4 addresses embed IPv4 ones, which could be
+// private.
+var reserved = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"),
+	netip.MustParsePrefix("192.0.0.0/24"),
+	netip.MustParsePrefix("198.18.0.0/15"),
+	netip.MustParsePrefix("240.0.0.0/4"),
+	netip.MustParsePrefix("64:ff9b::/96"),
+	netip.MustParsePrefix("64:ff9b:1::/48"),
+	netip.MustParsePrefix("2002::/16"),
+}
+
+func isPublic(addr netip.Addr) bool {
+	addr = addr.Unmap().WithZone("")
+	if !addr.IsValid() || addr.IsUnspecified() || addr.IsLoopback() || addr.IsPrivate() ||
+		addr.IsLinkLocalUnicast() || addr.IsMulticast() {
+		return false
+	}
+
+	for _, prefix := range reserved {
+		if prefix.Contains(addr) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// parse reads the metadata in the head of a page at base. OpenGraph tags
+// win over Twitter card tags, which win over the plain title and
+// description.
+func parse(r io.Reader, base *url.URL) Preview {
+	meta := map[string]string{}
+	var title strings.Builder
+	inTitle, sawTitle := false, false
+
+	z := html.NewTokenizer(r)
+tokens:
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			// The end of the page, or of what was read of it.
+			break tokens
+		case html.StartTagToken, html.SelfClosingTagToken:
+			name, hasAttr := z.TagName()
+			switch atom.Lookup(name) {
+			case atom.Meta:
+				var key, content string
+				for hasAttr {
+					var attr, value []byte
+					attr, value, hasAttr = z.TagAttr()
+					switch string(attr) {
+					case "property", "name":
+						key = strings.ToLower(strings.TrimSpace(string(value)))
+					case "content":
+						content = string(value)
+					}
+				}
+				if _, ok := meta[key]; !ok && key != "" && strings.TrimSpace(content) != "" {
+					meta[key] = content
+				}
+			case atom.Title:
+				inTitle = !sawTitle
+				sawTitle = true
+			case atom.Body:
+				break tokens
+			}
+		case html.TextToken:
+			if inTitle {
+				title.Write(z.Text())
+			}
+		case html.EndTagToken:
+			name, _ := z.TagName()
+			switch atom.Lookup(name) {
+			case atom.Title:
+				inTitle = false
+			case atom.Head:
+				break tokens
+			}
+		}
+	}
+
+	return Preview{
+		Title:       clean(cmp.Or(meta["og:title"], meta["twitter:title"], title.String()), maxTitleBytes),
+		Description: clean(cmp.Or(meta["og:description"], meta["twitter:description"], meta["description"]), maxDescBytes),
+		ImageURL:    imageURL(base, cmp.Or(meta["og:image:secure_url"], meta["og:image"], meta["og:image:url"], meta["twitter:image"], meta["twitter:image:src"])),
+		SiteName:    clean(meta["og:site_name"], maxSiteNameBytes),
+	}
+}
+
+// clean collapses whitespace and truncates s to at most max bytes without
+// splitting a rune.
+func clean(s string, max int) string {
+	s = strings.Join(strings.Fields(s), " ")
+	if len(s) <= max {
+		return s
+	}
+
+	for max > 0 && !utf8.RuneStart(s[max]) {
+		max--
+	}
+
+	return strings.TrimSpace(s[:max])
+}
+
+// imageURL resolves an image reference against the page it is on, and
+// drops anything that isn't a reasonable http or https URL.
+func imageURL(base *url.URL, ref string) string {
+	ref = strings.TrimSpace(ref)
+	if ref == "" {
+		return ""
+	}
+
+	resolved, err := base.Parse(ref)
+	if err != nil || checkURL(resolved) != nil {
+		return ""
+	}
+
+	s := resolved.String()
+	if len(s) > maxImageURLLength {
+		return ""
+	}
+
+	return s
+}
@@ -0,0 +1,243 @@
+package linkpreview
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestFetcher(opts Options) *Fetcher {
+	opts.AllowPrivate = true
+	return NewFetcher(opts)
+}
+
+// serve responds to every request with contentType and body.
+func serve(t *testing.T, contentType, body string) *httptest.Server {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", contentType)
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+func TestFetch(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		expected    Preview
+	}{
+		{
+			name:        "opengraph",
+			contentType: "text/html; charset=utf-8",
+			body: `<!doctype html><html><head>
+<title>Page title</title>
+<meta property="og:title" content="OG &amp; title">
+<meta property="og:description" content="  An   OG
+  description ">
+<meta property="og:image" content="https://cdn.example.com/card.png">
+<meta property="og:site_name" content="Example">
+<meta name="twitter:title" content="Twitter title">
+</head><body></body></html>`,
+			expected: Preview{
+				Title:       "OG & title",
+				Description: "An OG description",
+				ImageURL:    "https://cdn.example.com/card.png",
+				SiteName:    "Example",
+			},
+		},
+		{
+			name:        "twitter card",
+			contentType: "text/html",
+			body: `<head><title>Page title</title>
+<meta name="twitter:title" content="Twitter title">
+<meta name="twitter:description" content="Twitter description">
+<meta name="twitter:image" content="/images/card.png">`,
+			expected: Preview{
+				Title:       "Twitter title",
+				Description: "Twitter description",
+				ImageURL:    "/images/card.png",
+			},
+		},
+		{
+			name:        "plain title and description",
+			contentType: "text/html",
+			body:        `<head><TITLE> Plain &lt;title&gt; </TITLE><meta name="Description" content="Plain description"></head>`,
+			expected: Preview{
+				Title:       "Plain <title>",
+				Description: "Plain description",
+			},
+		},
+		{
+			name:        "metadata in the body is ignored",
+			contentType: "text/html",
+			body:        `<head><title>Head</title></head><body><meta property="og:title" content="Body"></body>`,
+			expected:    Preview{Title: "Head"},
+		},
+		{
+			name:        "image that isn't http is dropped",
+			contentType: "text/html",
+			body:        `<meta property="og:title" content="Title"><meta property="og:image" content="javascript:alert(1)">`,
+			expected:    Preview{Title: "Title"},
+		},
+		{
+			name:        "latin-1",
+			contentType: "text/html; charset=iso-8859-1",
+			body:        "<title>Caf\xe9</title>",
+			expected:    Preview{Title: "Café"},
+		},
+		{
+			name:        "long title is truncated",
+			contentType: "text/html",
+			body:        "<title>" + strings.Repeat("é", 200) + "</title>",
+			expected:    Preview{Title: strings.Repeat("é", maxTitleBytes/2)},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := serve(t, test.contentType, test.body)
+			if strings.HasPrefix(test.expected.ImageURL, "/") {
+				test.expected.ImageURL = server.URL + test.expected.ImageURL
+			}
+
+			preview, err := newTestFetcher(Options{}).Fetch(context.Background(), server.URL+"/page")
+			if err != nil {
+				t.Fatalf("expected to fetch preview, instead got %v", err)
+			}
+
+			if preview != test.expected {
+				t.Errorf("expected preview %+v, instead got %+v", test.expected, preview)
+			}
+		})
+	}
+}
+
+func TestFetchErrors(t *testing.T) {
+	page := `<head><title>Title</title></head>`
+
+	t.Run("private address", func(t *testing.T) {
+		server := serve(t, "text/html", page)
+
+		_, err := NewFetcher(Options{}).Fetch(context.Background(), server.URL)
+		if !errors.Is(err, ErrForbiddenAddress) {
+			t.Errorf("expected %v, instead got %v", ErrForbiddenAddress, err)
+		}
+	})
+
+	t.Run("unsupported scheme", func(t *testing.T) {
+		_, err := newTestFetcher(Options{}).Fetch(context.Background(), "file:///etc/passwd")
+		if !errors.Is(err, ErrUnsupportedURL) {
+			t.Errorf("expected %v, instead got %v", ErrUnsupportedURL, err)
+		}
+	})
+
+	t.Run("redirect to unsupported scheme", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.Redirect(w, r, "gopher://example.com/", http.StatusFound)
+		}))
+		defer server.Close()
+
+		_, err := newTestFetcher(Options{}).Fetch(context.Background(), server.URL)
+		if !errors.Is(err, ErrUnsupportedURL) {
+			t.Errorf("expected %v, instead got %v", ErrUnsupportedURL, err)
+		}
+	})
+
+	t.Run("not html", func(t *testing.T) {
+		server := serve(t, "application/json", `{"title": "Title"}`)
+
+		_, err := newTestFetcher(Options{}).Fetch(context.Background(), server.URL)
+		if !errors.Is(err, ErrNotHTML) {
+			t.Errorf("expected %v, instead got %v", ErrNotHTML, err)
+		}
+	})
+
+	t.Run("no metadata", func(t *testing.T) {
+		server := serve(t, "text/html", `<p>Just text</p>`)
+
+		_, err := newTestFetcher(Options{}).Fetch(context.Background(), server.URL)
+		if !errors.Is(err, ErrNoMetadata) {
+			t.Errorf("expected %v, instead got %v", ErrNoMetadata, err)
+		}
+	})
+
+	t.Run("metadata past the size cap", func(t *testing.T) {
+		server := serve(t, "text/html", "<head><!--"+strings.Repeat("x", 1024)+"--><title>Title</title></head>")
+
+		_, err := newTestFetcher(Options{MaxBytes: 512}).Fetch(context.Background(), server.URL)
+		if !errors.Is(err, ErrNoMetadata) {
+			t.Errorf("expected %v, instead got %v", ErrNoMetadata, err)
+		}
+	})
+
+	t.Run("error status", func(t *testing.T) {
+		server := httptest.NewServer(http.NotFoundHandler())
+		defer server.Close()
+
+		_, err := newTestFetcher(Options{}).Fetch(context.Background(), server.URL)
+		if err == nil {
+			t.Errorf("expected an error, instead got none")
+		}
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		release := make(chan struct{})
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			<-release
+		}))
+		defer server.Close()
+		defer close(release)
+
+		start := time.Now()
+		_, err := newTestFetcher(Options{Timeout: 50 * time.Millisecond}).Fetch(context.Background(), server.URL)
+		if err == nil {
+			t.Errorf("expected an error, instead got none")
+		}
+		if elapsed := time.Since(start); elapsed > 2*time.Second {
+			t.Errorf("expected the fetch to time out, instead it took %v", elapsed)
+		}
+	})
+}
+
+func TestIsPublic(t *testing.T) {
+	tests := []struct {
+		addr     string
+		expected bool
+	}{
+		{"93.184.215.14", true},
+		{"2606:2800:21f:cb07:6820:80da:af6b:8b2c", true},
+		{"127.0.0.1", false},
+		{"::1", false},
+		{"10.1.2.3", false},
+		{"172.16.0.1", false},
+		{"192.168.1.1", false},
+		{"169.254.169.254", false},
+		{"100.64.0.1", false},
+		{"0.0.0.0", false},
+		{"::", false},
+		{"fd00::1", false},
+		{"fe80::1%eth0", false},
+		{"::ffff:127.0.0.1", false},
+		{"::ffff:10.0.0.1", false},
+		{"64:ff9b::a00:1", false},
+		{"224.0.0.1", false},
+		{"255.255.255.255", false},
+	}
+
+	for _, test := range tests {
+		actual := isPublic(netip.MustParseAddr(test.addr))
+		if actual != test.expected {
+			t.Errorf("expected isPublic(%s) to be %v, instead got %v", test.addr, test.expected, actual)
+		}
+	}
+}
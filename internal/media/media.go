@@ -0,0 +1,161 @@
+// Package media validates uploaded images and prepares them for serving.
+package media
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"net/http"
+
+	xdraw "golang.org/x/image/draw"
+	"golang.org/x/image/webp"
+)
+
+const (
+	// MaxPixels bounds the decoded size of an image, as a small file can
+	// decode to a huge one.
+	MaxPixels = 40_000_000
+	// ThumbnailSize is the largest width and height of a thumbnail.
+	ThumbnailSize = 320
+
+	jpegQuality = 90
+)
+
+var (
+	ErrUnsupportedType = errors.New("unsupported media type")
+	ErrTooManyPixels   = errors.New("image has too many pixels")
+)
+
+// Image is an upload ready to store. Data and Thumbnail are encoded as
+// ContentType.
+type Image struct {
+	ContentType string
+	Data        []byte
+	Width       int
+	Height      int
+	Thumbnail   []byte
+}
+
+// Process decodes an upload and re-encodes it, which strips EXIF and other
+// metadata. The type is sniffed from the contents rather than trusted from
+// the client. JPEGs stay JPEGs and are turned upright using their EXIF
+// orientation first; PNG, GIF and WebP become PNG. Animated GIFs keep only
+// their first frame.
+func Process(data []byte) (Image, error) {
+	contentType := http.DetectContentType(data)
+
+	var decode func([]byte) (image.Image, error)
+	switch contentType {
+	case "image/jpeg":
+		decode = decodeWith(jpeg.DecodeConfig, jpeg.Decode)
+	case "image/png":
+		decode = decodeWith(png.DecodeConfig, png.Decode)
+	case "image/gif":
+		decode = decodeWith(gif.DecodeConfig, gif.Decode)
+	case "image/webp":
+		decode = decodeWith(webp.DecodeConfig, webp.Decode)
+	default:
+		return Image{}, fmt.Errorf("%w: %s", ErrUnsupportedType, contentType)
+	}
+
+	img, err := decode(data)
+	if err != nil {
+		return Image{}, err
+	}
+
+	encode := encodePNG
+	if contentType == "image/jpeg" {
+		img = orient(img, jpegOrientation(data))
+		encode = encodeJPEG
+	} else {
+		contentType = "image/png"
+	}
+
+	processed := Image{
+		ContentType: contentType,
+		Width:       img.Bounds().Dx(),
+		Height:      img.Bounds().Dy(),
+	}
+
+	processed.Data, err = encode(img)
+	if err != nil {
+		return Image{}, err
+	}
+
+	processed.Thumbnail, err = encode(thumbnail(img))
+	if err != nil {
+		return Image{}, err
+	}
+
+	return processed, nil
+}
+
+// decodeWith checks an image's dimensions before decoding all of it.
+func decodeWith(
+	decodeConfig func(r io.Reader) (image.Config, error),
+	decode func(r io.Reader) (image.Image, error),
+) func([]byte) (image.Image, error) {
+	return func(data []byte) (image.Image, error) {
+		config, err := decodeConfig(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("error decoding image: %w", err)
+		}
+
+		if config.Width <= 0 || config.Height <= 0 || config.Width*config.Height > MaxPixels {
+			return nil, ErrTooManyPixels
+		}
+
+		img, err := decode(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("error decoding image: %w", err)
+		}
+
+		return img, nil
+	}
+}
+
+func encodeJPEG(img image.Image) ([]byte, error) {
+	var buf bytes.Buffer
+	err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: jpegQuality})
+	if err != nil {
+		return nil, fmt.Errorf("error encoding jpeg: %w", err)
+	}
+
+	return buf.Bytes(), nil
+}
+
+func encodePNG(img image.Image) ([]byte, error) {
+	var buf bytes.Buffer
+	err := png.Encode(&buf, img)
+	if err != nil {
+		return nil, fmt.Errorf("error encoding png: %w", err)
+	}
+
+	return buf.Bytes(), nil
+}
+
+// thumbnail scales img to fit in ThumbnailSize, keeping its aspect ratio.
+// Images that already fit are not scaled up.
+func thumbnail(img image.Image) image.Image {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width <= ThumbnailSize && height <= ThumbnailSize {
+		return img
+	}
+
+	if width >= height {
+		width, height = ThumbnailSize, max(1, height*ThumbnailSize/width)
+	} else {
+		width, height = max(1, width*ThumbnailSize/height), ThumbnailSize
+	}
+
+	dst := image.NewNRGBA(image.Rect(0, 0, width, height))
+	xdraw.CatmullRom.Scale(dst, dst.Bounds(), img, bounds, xdraw.Src, nil)
+
+	return dst
+}
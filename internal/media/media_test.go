@@ -0,0 +1,144 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+func testImage(width, height int) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := range height {
+		for x := range width {
+			img.Set(x, y, color.NRGBA{R: uint8(x), G: uint8(y), B: 128, A: 255})
+		}
+	}
+
+	return img
+}
+
+// exifJPEG encodes img as a JPEG with an EXIF orientation tag.
+func exifJPEG(t *testing.T, img image.Image, orientation uint16) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	err := jpeg.Encode(&buf, img, nil)
+	if err != nil {
+		t.Fatalf("expected to encode jpeg: %v", err)
+	}
+
+	tiff := []byte("MM\x00\x2a\x00\x00\x00\x08")
+	tiff = binary.BigEndian.AppendUint16(tiff, 1)
+	tiff = binary.BigEndian.AppendUint16(tiff, 0x0112)
+	tiff = binary.BigEndian.AppendUint16(tiff, 3)
+	tiff = binary.BigEndian.AppendUint32(tiff, 1)
+	tiff = binary.BigEndian.AppendUint16(tiff, orientation)
+	tiff = append(tiff, 0, 0, 0, 0, 0, 0)
+
+	app1 := append([]byte("Exif\x00\x00"), tiff...)
+	segment := []byte{0xFF, 0xE1}
+	segment = binary.BigEndian.AppendUint16(segment, uint16(len(app1)+2))
+	segment = append(segment, app1...)
+
+	data := buf.Bytes()
+	return append(append(append([]byte{}, data[:2]...), segment...), data[2:]...)
+}
+
+func TestProcessJPEG(t *testing.T) {
+	data := exifJPEG(t, testImage(40, 20), 6)
+	if jpegOrientation(data) != 6 {
+		t.Fatalf("expected orientation 6, instead got %d", jpegOrientation(data))
+	}
+
+	img, err := Process(data)
+	if err != nil {
+		t.Fatalf("expected to process jpeg: %v", err)
+	}
+
+	if img.ContentType != "image/jpeg" || img.Width != 20 || img.Height != 40 {
+		t.Errorf("expected an upright 20x40 jpeg, instead got %s %dx%d", img.ContentType, img.Width, img.Height)
+	}
+
+	if bytes.Contains(img.Data, []byte("Exif")) {
+		t.Errorf("expected the EXIF data to be stripped")
+	}
+}
+
+func TestProcessPNG(t *testing.T) {
+	var buf bytes.Buffer
+	err := png.Encode(&buf, testImage(1000, 500))
+	if err != nil {
+		t.Fatalf("expected to encode png: %v", err)
+	}
+
+	img, err := Process(buf.Bytes())
+	if err != nil {
+		t.Fatalf("expected to process png: %v", err)
+	}
+
+	thumb, err := png.Decode(bytes.NewReader(img.Thumbnail))
+	if err != nil {
+		t.Fatalf("expected a png thumbnail: %v", err)
+	}
+
+	if img.ContentType != "image/png" || img.Width != 1000 || thumb.Bounds().Dx() != ThumbnailSize || thumb.Bounds().Dy() != ThumbnailSize/2 {
+		t.Errorf("expected a 1000x500 png with a 320x160 thumbnail, instead got %s %dx%d and %v", img.ContentType, img.Width, img.Height, thumb.Bounds())
+	}
+}
+
+func TestProcessRejects(t *testing.T) {
+	_, err := Process([]byte("<html>not an image</html>"))
+	if !errors.Is(err, ErrUnsupportedType) {
+		t.Errorf("expected html to be unsupported, instead got %v", err)
+	}
+
+	// A PNG header claiming 100000x100000 pixels, with no pixel data.
+	var buf bytes.Buffer
+	png.Encode(&buf, testImage(1, 1))
+	data := buf.Bytes()
+	binary.BigEndian.PutUint32(data[16:], 100_000)
+	binary.BigEndian.PutUint32(data[20:], 100_000)
+	binary.BigEndian.PutUint32(data[29:], crc32.ChecksumIEEE(data[12:29]))
+
+	_, err = Process(data)
+	if !errors.Is(err, ErrTooManyPixels) {
+		t.Errorf("expected a decompression bomb to be rejected, instead got %v", err)
+	}
+
+	_, err = Process(data[:40])
+	if err == nil {
+		t.Errorf("expected a truncated png to be rejected")
+	}
+}
+
+func TestOrient(t *testing.T) {
+	src := testImage(3, 2)
+	corner := src.NRGBAAt(0, 0)
+
+	cases := []struct {
+		orientation int
+		x, y        int
+	}{
+		{1, 0, 0},
+		{2, 2, 0},
+		{3, 2, 1},
+		{4, 0, 1},
+		{5, 0, 0},
+		{6, 1, 0},
+		{7, 1, 2},
+		{8, 0, 2},
+	}
+
+	for _, c := range cases {
+		dst := orient(src, c.orientation).(*image.NRGBA)
+		if got := dst.NRGBAAt(c.x, c.y); got != corner {
+			t.Errorf("expected orientation %d to move the top-left pixel to %d,%d, instead found %v there", c.orientation, c.x, c.y, got)
+		}
+	}
+}
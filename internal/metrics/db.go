@@ -0,0 +1,67 @@
+package metrics
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/vemolista/chirpy/v2/internal/database"
+)
+
+type instrumentedDB struct {
+	db      database.DBTX
+	metrics *Metrics
+}
+
+// InstrumentDB wraps db so that every sqlc query is timed under its query
+// name. Pass the result to database.New.
+func (m *Metrics) InstrumentDB(db database.DBTX) database.DBTX {
+	return &instrumentedDB{db: db, metrics: m}
+}
+
+func (i *instrumentedDB) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	start := time.Now()
+	result, err := i.db.ExecContext(ctx, query, args...)
+	i.metrics.observeQuery(QueryName(query), time.Since(start), err)
+
+	return result, err
+}
+
+func (i *instrumentedDB) PrepareContext(ctx context.Context, query string) (*sql.Stmt, error) {
+	return i.db.PrepareContext(ctx, query)
+}
+
+func (i *instrumentedDB) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	start := time.Now()
+	rows, err := i.db.QueryContext(ctx, query, args...)
+	i.metrics.observeQuery(QueryName(query), time.Since(start), err)
+
+	return rows, err
+}
+
+func (i *instrumentedDB) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	start := time.Now()
+	row := i.db.QueryRowContext(ctx, query, args...)
+	i.metrics.observeQuery(QueryName(query), time.Since(start), row.Err())
+
+	return row
+}
+
+// QueryName extracts the name from the "-- name: CreateChirp :one" header
+// sqlc puts at the top of every generated query.
+func QueryName(query string) string {
+	const prefix = "-- name: "
+
+	line, _, _ := strings.Cut(query, "\n")
+	if !strings.HasPrefix(line, prefix) {
+		return "unknown"
+	}
+
+	name, _, _ := strings.Cut(strings.TrimPrefix(line, prefix), " ")
+	if name == "" {
+		return "unknown"
+	}
+
+	return name
+}
@@ -0,0 +1,239 @@
+package metrics
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	dto "github.com/prometheus/client_model/go"
+)
+
+const namespace = "chirpy"
+
+// Metrics owns the Prometheus registry and every collector the server
+// reports. Both /metrics and the HTML admin page read from Registry.
+type Metrics struct {
+	Registry *prometheus.Registry
+
+	requests       *prometheus.CounterVec
+	duration       *prometheus.HistogramVec
+	inFlight       prometheus.Gauge
+	dbQueries      *prometheus.HistogramVec
+	logins         *prometheus.CounterVec
+	webhooks       *prometheus.CounterVec
+	rateLimited    *prometheus.CounterVec
+	fileserverHits *prometheus.CounterVec
+}
+
+func New() *Metrics {
+	m := &Metrics{
+		Registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "http_requests_total",
+			Help:      "HTTP requests by route, method and status code.",
+		}, []string{"route", "method", "code"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "http_request_duration_seconds",
+			Help:      "HTTP request latency by route and method.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"route", "method"}),
+		inFlight: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "http_requests_in_flight",
+			Help:      "HTTP requests currently being served.",
+		}),
+		dbQueries: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "db_query_duration_seconds",
+			Help:      "Database query latency by sqlc query name and outcome.",
+			Buckets:   []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1},
+		}, []string{"query", "outcome"}),
+		logins: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "logins_total",
+			Help:      "Login attempts by result.",
+		}, []string{"result"}),
+		webhooks: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "webhooks_total",
+			Help:      "Incoming webhooks by source, event and outcome.",
+		}, []string{"source", "event", "outcome"}),
+		rateLimited: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "rate_limited_total",
+			Help:      "Requests refused by the rate limiter by route group.",
+		}, []string{"group"}),
+		fileserverHits: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "fileserver_hits_total",
+			Help:      "Requests served by the /app/ file server since the last reset.",
+		}, nil),
+	}
+
+	m.Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.requests,
+		m.duration,
+		m.inFlight,
+		m.dbQueries,
+		m.logins,
+		m.webhooks,
+		m.rateLimited,
+		m.fileserverHits,
+	)
+
+	return m
+}
+
+// RegisterDB exports connection pool statistics for db.
+func (m *Metrics) RegisterDB(db *sql.DB, name string) {
+	m.Registry.MustRegister(collectors.NewDBStatsCollector(db, name))
+}
+
+// Handler serves the registry in the Prometheus text exposition format.
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.Registry, promhttp.HandlerOpts{Registry: m.Registry})
+}
+
+// Middleware records request counts, latency and in-flight requests. It must
+// wrap the ServeMux so that the matched pattern is known once the request
+// has been served; unmatched requests share a single label to keep the
+// cardinality bounded.
+func (m *Metrics) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		m.inFlight.Inc()
+		defer m.inFlight.Dec()
+
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+
+		route := r.Pattern
+		if route == "" {
+			route = "unmatched"
+		}
+
+		m.requests.WithLabelValues(route, r.Method, strconv.Itoa(rec.status)).Inc()
+		m.duration.WithLabelValues(route, r.Method).Observe(time.Since(start).Seconds())
+	})
+}
+
+// FileserverMiddleware counts hits on the static file server.
+func (m *Metrics) FileserverMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		m.fileserverHits.WithLabelValues().Inc()
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (m *Metrics) ResetFileserverHits() {
+	m.fileserverHits.Reset()
+}
+
+func (m *Metrics) LoginSucceeded() {
+	m.logins.WithLabelValues("success").Inc()
+}
+
+func (m *Metrics) LoginFailed() {
+	m.logins.WithLabelValues("failure").Inc()
+}
+
+func (m *Metrics) RateLimited(group string) {
+	m.rateLimited.WithLabelValues(group).Inc()
+}
+
+func (m *Metrics) Webhook(source, event, outcome string) {
+	m.webhooks.WithLabelValues(source, event, outcome).Inc()
+}
+
+func (m *Metrics) observeQuery(query string, d time.Duration, err error) {
+	outcome := "ok"
+	if err != nil {
+		outcome = "error"
+	}
+
+	m.dbQueries.WithLabelValues(query, outcome).Observe(d.Seconds())
+}
+
+// Snapshot is a point-in-time summary of the registry for the admin page.
+type Snapshot struct {
+	FileserverHits int
+	Requests       map[string]int
+	Logins         map[string]int
+}
+
+func (m *Metrics) Snapshot() (Snapshot, error) {
+	families, err := m.Registry.Gather()
+	if err != nil {
+		return Snapshot{}, fmt.Errorf("error gathering metrics: %w", err)
+	}
+
+	snapshot := Snapshot{
+		Requests: map[string]int{},
+		Logins:   map[string]int{},
+	}
+
+	for _, family := range families {
+		switch family.GetName() {
+		case namespace + "_fileserver_hits_total":
+			for _, metric := range family.GetMetric() {
+				snapshot.FileserverHits += int(metric.GetCounter().GetValue())
+			}
+		case namespace + "_http_requests_total":
+			for _, metric := range family.GetMetric() {
+				snapshot.Requests[label(metric, "route")] += int(metric.GetCounter().GetValue())
+			}
+		case namespace + "_logins_total":
+			for _, metric := range family.GetMetric() {
+				snapshot.Logins[label(metric, "result")] += int(metric.GetCounter().GetValue())
+			}
+		}
+	}
+
+	return snapshot, nil
+}
+
+func label(metric *dto.Metric, name string) string {
+	for _, pair := range metric.GetLabel() {
+		if pair.GetName() == name {
+			return pair.GetValue()
+		}
+	}
+
+	return ""
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func (r *statusRecorder) WriteHeader(code int) {
+	if !r.wroteHeader {
+		r.status = code
+		r.wroteHeader = true
+	}
+
+	r.ResponseWriter.WriteHeader(code)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	r.wroteHeader = true
+
+	return r.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
@@ -0,0 +1,70 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestQueryName(t *testing.T) {
+	cases := map[string]string{
+		"-- name: CreateChirp :one\ninsert into chirps": "CreateChirp",
+		"-- name: DeleteUsers :exec\ndelete from users": "DeleteUsers",
+		"select 1": "unknown",
+		"":         "unknown",
+	}
+
+	for query, expected := range cases {
+		if got := QueryName(query); got != expected {
+			t.Errorf("expected %q for %q, instead got %q", expected, query, got)
+		}
+	}
+}
+
+func TestMiddlewareUsesRoutePattern(t *testing.T) {
+	m := New()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/chirps/{chirpId}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	})
+	handler := m.Middleware(mux)
+
+	for _, path := range []string{"/api/chirps/1", "/api/chirps/2", "/nope"} {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+	}
+
+	snapshot, err := m.Snapshot()
+	if err != nil {
+		t.Fatalf("expected snapshot to succeed: %v", err)
+	}
+
+	if got := snapshot.Requests["GET /api/chirps/{chirpId}"]; got != 2 {
+		t.Errorf("expected 2 requests for the chirp route, instead got %d", got)
+	}
+
+	if got := snapshot.Requests["unmatched"]; got != 1 {
+		t.Errorf("expected 1 unmatched request, instead got %d", got)
+	}
+}
+
+func TestResetFileserverHits(t *testing.T) {
+	m := New()
+	handler := m.FileserverMiddleware(http.NotFoundHandler())
+
+	for range 3 {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/app/", nil))
+	}
+
+	snapshot, _ := m.Snapshot()
+	if snapshot.FileserverHits != 3 {
+		t.Errorf("expected 3 hits, instead got %d", snapshot.FileserverHits)
+	}
+
+	m.ResetFileserverHits()
+
+	snapshot, _ = m.Snapshot()
+	if snapshot.FileserverHits != 0 {
+		t.Errorf("expected hits to be reset, instead got %d", snapshot.FileserverHits)
+	}
+}
@@ -0,0 +1,101 @@
+package ratelimit
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/vemolista/chirpy/v2/internal/database"
+)
+
+// Postgres keeps the buckets in the rate_limit_buckets table, so that every
+// instance of the server shares them.
+type Postgres struct {
+	db *sql.DB
+
+	mu        sync.Mutex
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+var _ Store = (*Postgres)(nil)
+
+func NewPostgres(db *sql.DB) *Postgres {
+	return &Postgres{
+		db: db,
+		now: func() time.Time {
+			return time.Now().UTC()
+		},
+	}
+}
+
+func (p *Postgres) Take(ctx context.Context, key string, limit Limit) (Result, error) {
+	now := p.now()
+	p.sweep(ctx, now)
+
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return Result{}, fmt.Errorf("error starting rate limit transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	queries := database.New(tx)
+
+	// Concurrent requests for the same key queue on the row lock, so each
+	// one sees the bucket as the previous one left it.
+	err = queries.CreateRateLimitBucket(ctx, database.CreateRateLimitBucketParams{
+		Key:       key,
+		Tokens:    limit.capacity(),
+		UpdatedAt: now,
+	})
+	if err != nil {
+		return Result{}, fmt.Errorf("error creating rate limit bucket: %w", err)
+	}
+
+	row, err := queries.GetRateLimitBucketForUpdate(ctx, key)
+	if err != nil {
+		return Result{}, fmt.Errorf("error getting rate limit bucket: %w", err)
+	}
+
+	b := bucket{tokens: row.Tokens, updated: row.UpdatedAt}
+	result := b.take(limit, now)
+
+	err = queries.UpdateRateLimitBucket(ctx, database.UpdateRateLimitBucketParams{
+		Key:       key,
+		Tokens:    b.tokens,
+		UpdatedAt: b.updated,
+		FullAt:    now.Add(result.Reset),
+	})
+	if err != nil {
+		return Result{}, fmt.Errorf("error updating rate limit bucket: %w", err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return Result{}, fmt.Errorf("error committing rate limit transaction: %w", err)
+	}
+
+	return result, nil
+}
+
+// sweep deletes full buckets at most once per sweepInterval per process.
+func (p *Postgres) sweep(ctx context.Context, now time.Time) {
+	p.mu.Lock()
+	due := now.Sub(p.lastSweep) >= sweepInterval
+	if due {
+		p.lastSweep = now
+	}
+	p.mu.Unlock()
+
+	if !due {
+		return
+	}
+
+	err := database.New(p.db).DeleteFullRateLimitBuckets(ctx, now)
+	if err != nil {
+		log.Printf("Error deleting full rate limit buckets: %v", err)
+	}
+}
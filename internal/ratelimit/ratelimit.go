@@ -0,0 +1,165 @@
+// Package ratelimit implements token-bucket rate limiting with pluggable
+// storage for the buckets.
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Limit allows Requests requests per Per, in bursts of up to Requests. The
+// zero Limit does not limit anything.
+type Limit struct {
+	Requests int
+	Per      time.Duration
+}
+
+// ParseLimit parses a limit written as "<requests>/<duration>", such as
+// "10/1m". "0" and "off" disable the limit.
+func ParseLimit(s string) (Limit, error) {
+	if s == "0" || s == "off" {
+		return Limit{}, nil
+	}
+
+	requests, per, ok := strings.Cut(s, "/")
+	if !ok {
+		return Limit{}, fmt.Errorf("invalid rate limit %q: expected <requests>/<duration>", s)
+	}
+
+	n, err := strconv.Atoi(requests)
+	if err != nil || n < 0 {
+		return Limit{}, fmt.Errorf("invalid rate limit %q: requests must be a non-negative number", s)
+	}
+
+	d, err := time.ParseDuration(per)
+	if err != nil || d <= 0 {
+		return Limit{}, fmt.Errorf("invalid rate limit %q: duration must be positive", s)
+	}
+
+	return Limit{Requests: n, Per: d}, nil
+}
+
+func (l Limit) String() string {
+	if l.Unlimited() {
+		return "off"
+	}
+
+	return fmt.Sprintf("%d/%s", l.Requests, l.Per)
+}
+
+func (l Limit) MarshalText() ([]byte, error) {
+	return []byte(l.String()), nil
+}
+
+func (l *Limit) UnmarshalText(text []byte) error {
+	parsed, err := ParseLimit(string(text))
+	if err != nil {
+		return err
+	}
+
+	*l = parsed
+	return nil
+}
+
+func (l Limit) Unlimited() bool {
+	return l.Requests == 0
+}
+
+// Scale multiplies the number of requests allowed per period by factor.
+func (l Limit) Scale(factor float64) Limit {
+	if l.Unlimited() {
+		return l
+	}
+
+	l.Requests = int(math.Round(float64(l.Requests) * factor))
+	return l
+}
+
+func (l Limit) capacity() float64 {
+	return float64(l.Requests)
+}
+
+// rate is the number of tokens added to the bucket per second.
+func (l Limit) rate() float64 {
+	return float64(l.Requests) / l.Per.Seconds()
+}
+
+// Result is the outcome of taking a token from a bucket.
+type Result struct {
+	Allowed   bool
+	Limit     Limit
+	Remaining int
+	// Reset is how long until the bucket is full again.
+	Reset time.Duration
+	// RetryAfter is how long until the next request would be allowed. It
+	// is zero when the request was allowed.
+	RetryAfter time.Duration
+}
+
+// SetHeaders sets the RateLimit-* headers from the IETF RateLimit header
+// fields draft, and Retry-After when the request was refused.
+func (r Result) SetHeaders(h http.Header) {
+	h.Set("RateLimit-Limit", strconv.Itoa(r.Limit.Requests))
+	h.Set("RateLimit-Remaining", strconv.Itoa(r.Remaining))
+	h.Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(r.Reset)))
+	h.Set("RateLimit-Policy", fmt.Sprintf("%d;w=%d", r.Limit.Requests, ceilSeconds(r.Limit.Per)))
+
+	if !r.Allowed {
+		h.Set("Retry-After", strconv.Itoa(ceilSeconds(r.RetryAfter)))
+	}
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
+
+// Store keeps the token buckets. Take refills the bucket named key for the
+// time elapsed since it was last used and takes a token from it if there is
+// one. A bucket that has never been used starts full.
+type Store interface {
+	Take(ctx context.Context, key string, limit Limit) (Result, error)
+}
+
+// bucket is the state every Store keeps per key.
+type bucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// take refills b up to now and takes a token from it if there is one.
+func (b *bucket) take(limit Limit, now time.Time) Result {
+	elapsed := now.Sub(b.updated).Seconds()
+	if elapsed < 0 {
+		elapsed = 0
+	}
+
+	b.tokens = math.Min(limit.capacity(), b.tokens+elapsed*limit.rate())
+	b.updated = now
+
+	result := Result{Limit: limit}
+	if b.tokens >= 1 {
+		b.tokens--
+		result.Allowed = true
+	} else {
+		result.RetryAfter = seconds((1 - b.tokens) / limit.rate())
+	}
+
+	result.Remaining = int(b.tokens)
+	result.Reset = b.fullIn(limit)
+
+	return result
+}
+
+// fullIn is how long until b is refilled to the limit's capacity.
+func (b *bucket) fullIn(limit Limit) time.Duration {
+	return seconds((limit.capacity() - b.tokens) / limit.rate())
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
@@ -0,0 +1,221 @@
+package ratelimit
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	_ "github.com/lib/pq"
+	"github.com/pressly/goose/v3"
+)
+
+func TestParseLimit(t *testing.T) {
+	cases := []struct {
+		input string
+		limit Limit
+		ok    bool
+	}{
+		{"10/1m", Limit{Requests: 10, Per: time.Minute}, true},
+		{"1/500ms", Limit{Requests: 1, Per: 500 * time.Millisecond}, true},
+		{"off", Limit{}, true},
+		{"0", Limit{}, true},
+		{"10", Limit{}, false},
+		{"-1/1m", Limit{}, false},
+		{"10/0s", Limit{}, false},
+		{"ten/1m", Limit{}, false},
+	}
+
+	for _, c := range cases {
+		limit, err := ParseLimit(c.input)
+		if (err == nil) != c.ok || limit != c.limit {
+			t.Errorf("expected %q to parse as %v (ok %v), instead got %v, %v", c.input, c.limit, c.ok, limit, err)
+		}
+	}
+
+	text, _ := Limit{Requests: 10, Per: time.Minute}.MarshalText()
+	if string(text) != "10/1m0s" {
+		t.Errorf("expected limit to marshal as 10/1m0s, instead got %s", text)
+	}
+}
+
+func TestScale(t *testing.T) {
+	limit := Limit{Requests: 10, Per: time.Minute}.Scale(2.5)
+	if limit.Requests != 25 || limit.Per != time.Minute {
+		t.Errorf("expected 25/1m, instead got %v", limit)
+	}
+
+	if !(Limit{}).Scale(5).Unlimited() {
+		t.Errorf("expected scaling an unlimited limit to stay unlimited")
+	}
+}
+
+func TestMemoryStore(t *testing.T) {
+	testStore(t, func(t *testing.T, now func() time.Time) Store {
+		m := NewMemory()
+		m.now = now
+		return m
+	})
+}
+
+func TestPostgresStore(t *testing.T) {
+	dbUrl := os.Getenv("CHIRPY_TEST_DB_URL")
+	if dbUrl == "" {
+		t.Skip("CHIRPY_TEST_DB_URL not set")
+	}
+
+	db, err := sql.Open("postgres", dbUrl)
+	if err != nil {
+		t.Fatalf("expected to open postgres: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	provider, err := goose.NewProvider(goose.DialectPostgres, db, os.DirFS("../../sql/migrations"))
+	if err != nil {
+		t.Fatalf("expected migration provider: %v", err)
+	}
+
+	_, err = provider.Up(context.Background())
+	if err != nil {
+		t.Fatalf("expected migrations to apply: %v", err)
+	}
+
+	testStore(t, func(t *testing.T, now func() time.Time) Store {
+		p := NewPostgres(db)
+		p.now = now
+		return p
+	})
+}
+
+func testStore(t *testing.T, newStore func(t *testing.T, now func() time.Time) Store) {
+	ctx := context.Background()
+	limit := Limit{Requests: 3, Per: 3 * time.Second}
+
+	clock := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	s := newStore(t, func() time.Time { return clock })
+
+	// Keys are unique per run so a shared Postgres database starts clean.
+	key := "test:" + uuid.NewString()
+
+	for i := 2; i >= 0; i-- {
+		result, err := s.Take(ctx, key, limit)
+		if err != nil || !result.Allowed || result.Remaining != i {
+			t.Fatalf("expected request to be allowed with %d remaining, instead got %+v, %v", i, result, err)
+		}
+	}
+
+	result, err := s.Take(ctx, key, limit)
+	if err != nil || result.Allowed {
+		t.Fatalf("expected an empty bucket to refuse, instead got %+v, %v", result, err)
+	}
+
+	if result.RetryAfter != time.Second || result.Reset != 3*time.Second {
+		t.Errorf("expected retry after 1s and reset after 3s, instead got %v and %v", result.RetryAfter, result.Reset)
+	}
+
+	other, err := s.Take(ctx, key+":other", limit)
+	if err != nil || !other.Allowed {
+		t.Errorf("expected another key to have its own bucket, instead got %+v, %v", other, err)
+	}
+
+	clock = clock.Add(time.Second)
+	result, err = s.Take(ctx, key, limit)
+	if err != nil || !result.Allowed || result.Remaining != 0 {
+		t.Errorf("expected one token to be refilled after 1s, instead got %+v, %v", result, err)
+	}
+
+	clock = clock.Add(time.Hour)
+	result, err = s.Take(ctx, key, limit)
+	if err != nil || !result.Allowed || result.Remaining != 2 {
+		t.Errorf("expected the bucket to refill only up to its capacity, instead got %+v, %v", result, err)
+	}
+}
+
+func TestMemorySweep(t *testing.T) {
+	clock := time.Now()
+	m := NewMemory()
+	m.now = func() time.Time { return clock }
+
+	m.Take(context.Background(), "a", Limit{Requests: 1, Per: time.Second})
+	clock = clock.Add(2 * sweepInterval)
+	m.Take(context.Background(), "b", Limit{Requests: 1, Per: time.Second})
+
+	if _, ok := m.buckets["a"]; ok {
+		t.Errorf("expected the refilled bucket to be swept")
+	}
+
+	if _, ok := m.buckets["b"]; !ok {
+		t.Errorf("expected the bucket in use to be kept")
+	}
+}
+
+func TestSetHeaders(t *testing.T) {
+	h := http.Header{}
+	Result{
+		Allowed:    false,
+		Limit:      Limit{Requests: 10, Per: time.Minute},
+		Remaining:  0,
+		Reset:      5500 * time.Millisecond,
+		RetryAfter: 200 * time.Millisecond,
+	}.SetHeaders(h)
+
+	expected := map[string]string{
+		"RateLimit-Limit":     "10",
+		"RateLimit-Remaining": "0",
+		"RateLimit-Reset":     "6",
+		"RateLimit-Policy":    "10;w=60",
+		"Retry-After":         "1",
+	}
+	for name, value := range expected {
+		if h.Get(name) != value {
+			t.Errorf("expected %s: %s, instead got %q", name, value, h.Get(name))
+		}
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	trusted, err := ParseTrustedProxies([]string{"10.0.0.0/8", "192.168.1.1"})
+	if err != nil {
+		t.Fatalf("expected trusted proxies to parse: %v", err)
+	}
+
+	cases := []struct {
+		name         string
+		remoteAddr   string
+		forwardedFor string
+		expectedIP   string
+	}{
+		{"direct", "203.0.113.7:1234", "", "203.0.113.7"},
+		{"untrusted peer cannot spoof", "203.0.113.7:1234", "198.51.100.1", "203.0.113.7"},
+		{"trusted proxy", "10.1.2.3:1234", "198.51.100.1", "198.51.100.1"},
+		{"chain of trusted proxies", "10.1.2.3:1234", "198.51.100.1, 192.168.1.1, 10.0.0.5", "198.51.100.1"},
+		{"spoofed hop left of the client", "10.1.2.3:1234", "1.1.1.1, 198.51.100.1", "198.51.100.1"},
+		{"garbage hop", "10.1.2.3:1234", "nonsense", "10.1.2.3"},
+		{"trusted proxy without header", "192.168.1.1:1234", "", "192.168.1.1"},
+		{"ipv6", "[2001:db8::1]:1234", "", "2001:db8::1"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.RemoteAddr = c.remoteAddr
+			if c.forwardedFor != "" {
+				r.Header.Set("X-Forwarded-For", c.forwardedFor)
+			}
+
+			ip := ClientIP(r, trusted)
+			if ip != c.expectedIP {
+				t.Errorf("expected %s, instead got %s", c.expectedIP, ip)
+			}
+		})
+	}
+
+	_, err = ParseTrustedProxies([]string{"not-an-ip"})
+	if err == nil {
+		t.Errorf("expected an invalid proxy to fail to parse")
+	}
+}
@@ -0,0 +1,262 @@
+package realtime
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/coder/websocket"
+	"github.com/coder/websocket/wsjson"
+	"github.com/google/uuid"
+)
+
+// Frame is a JSON message on the WebSocket, in either direction.
+//
+// Clients send:
+//
+//	{"type": "auth", "token": "<access token>"}
+//	{"type": "subscribe", "topic": "<topic>"}
+//	{"type": "unsubscribe", "topic": "<topic>"}
+//	{"type": "ping"}
+//
+// and the server answers with ready, subscribed, unsubscribed, pong, event
+// and error frames.
+type Frame struct {
+	Type   string          `json:"type"`
+	Token  string          `json:"token,omitempty"`
+	Topic  string          `json:"topic,omitempty"`
+	Event  string          `json:"event,omitempty"`
+	ID     string          `json:"id,omitempty"`
+	Data   json.RawMessage `json:"data,omitempty"`
+	Code   string          `json:"code,omitempty"`
+	Detail string          `json:"detail,omitempty"`
+}
+
+// Options configures Serve.
+type Options struct {
+	// Authenticate returns the user an access token from an auth frame
+	// belongs to.
+	Authenticate func(ctx context.Context, token string) (uuid.UUID, error)
+	// Resolve returns the hub topic for a topic a user asked to subscribe
+	// to, or an error to report to the client.
+	Resolve func(userID uuid.UUID, topic string) (string, error)
+	// Topics returns hub topics a user's connection is subscribed to
+	// without the client asking, for messages Filter acts on. Messages on
+	// them go to Filter but are never sent. It may be nil.
+	Topics func(userID uuid.UUID) []string
+	// Filter reports whether to send a message to a user subscribed to its
+	// topic. If it is nil, every message is sent. It is called from one
+	// goroutine per connection. An error closes the connection, so that
+	// the client reconnects rather than silently missing the message.
+	Filter func(ctx context.Context, userID uuid.UUID, msg Message) (bool, error)
+	// IdleTimeout is how long a client may go without sending a frame.
+	// Clients send pings to stay connected.
+	IdleTimeout time.Duration
+	// WriteTimeout bounds each frame the server sends.
+	WriteTimeout time.Duration
+}
+
+// maxFrameBytes caps the size of a frame a client may send.
+const maxFrameBytes = 4096
+
+// Serve runs the protocol on conn until either side closes it, then closes
+// it. If userID is uuid.Nil, the client must authenticate with an auth frame
+// first. The returned error says why the connection ended; it is nil when
+// the client went away.
+func Serve(ctx context.Context, conn *websocket.Conn, hub *Hub, userID uuid.UUID, opts Options) error {
+	defer conn.CloseNow()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	conn.SetReadLimit(maxFrameBytes)
+
+	frames := make(chan incoming)
+	readErr := make(chan error, 1)
+	go func() {
+		for {
+			_, data, err := conn.Read(ctx)
+			if err != nil {
+				readErr <- err
+				return
+			}
+
+			var in incoming
+			in.err = json.Unmarshal(data, &in.frame)
+
+			select {
+			case frames <- in:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	s := &session{conn: conn, opts: opts, names: map[string]string{}}
+
+	idle := time.NewTimer(opts.IdleTimeout)
+	defer idle.Stop()
+
+	if userID == uuid.Nil {
+		select {
+		case in := <-frames:
+			if in.err != nil || in.frame.Type != "auth" {
+				return s.close(websocket.StatusPolicyViolation, "authentication required")
+			}
+
+			userID, err := opts.Authenticate(ctx, in.frame.Token)
+			if err != nil {
+				return s.close(websocket.StatusPolicyViolation, "invalid token")
+			}
+
+			return s.serve(ctx, hub, userID, frames, readErr, idle)
+		case err := <-readErr:
+			return closeError(err)
+		case <-idle.C:
+			return s.close(websocket.StatusPolicyViolation, "authentication timed out")
+		}
+	}
+
+	return s.serve(ctx, hub, userID, frames, readErr, idle)
+}
+
+// incoming is a frame read from the client, or why it couldn't be decoded.
+type incoming struct {
+	frame Frame
+	err   error
+}
+
+type session struct {
+	conn *websocket.Conn
+	opts Options
+	// names maps hub topics back to the topics the client asked for.
+	names map[string]string
+}
+
+func (s *session) serve(ctx context.Context, hub *Hub, userID uuid.UUID, frames <-chan incoming, readErr <-chan error, idle *time.Timer) error {
+	client := hub.Connect(userID)
+	defer client.Close()
+
+	if s.opts.Topics != nil {
+		for _, topic := range s.opts.Topics(userID) {
+			client.Subscribe(topic)
+		}
+	}
+
+	err := s.write(ctx, Frame{Type: "ready"})
+	if err != nil {
+		return err
+	}
+
+	for {
+		select {
+		case in := <-frames:
+			idle.Reset(s.opts.IdleTimeout)
+
+			if in.err != nil {
+				err := s.write(ctx, Frame{Type: "error", Code: "malformed_frame", Detail: in.err.Error()})
+				if err != nil {
+					return err
+				}
+				continue
+			}
+
+			err := s.handle(ctx, client, in.frame)
+			if err != nil {
+				return err
+			}
+		case msg, ok := <-client.Messages():
+			if !ok {
+				if errors.Is(client.Err(), ErrSlowConsumer) {
+					return s.close(websocket.StatusTryAgainLater, "too slow")
+				}
+				return s.close(websocket.StatusGoingAway, "server shutting down")
+			}
+
+			if s.opts.Filter != nil {
+				send, err := s.opts.Filter(ctx, client.UserID, msg)
+				if err != nil {
+					s.close(websocket.StatusInternalError, "internal error")
+					return fmt.Errorf("error filtering %s message: %w", msg.Type, err)
+				}
+				if !send {
+					continue
+				}
+			}
+
+			name, ok := s.names[msg.Topic]
+			if !ok {
+				continue
+			}
+
+			err := s.write(ctx, Frame{
+				Type:  "event",
+				Topic: name,
+				Event: msg.Type,
+				ID:    msg.ID,
+				Data:  msg.Data,
+			})
+			if err != nil {
+				return err
+			}
+		case err := <-readErr:
+			return closeError(err)
+		case <-idle.C:
+			return s.close(websocket.StatusPolicyViolation, "heartbeat timed out")
+		}
+	}
+}
+
+func (s *session) handle(ctx context.Context, client *Client, frame Frame) error {
+	switch frame.Type {
+	case "ping":
+		return s.write(ctx, Frame{Type: "pong"})
+	case "subscribe", "unsubscribe":
+		topic, err := s.opts.Resolve(client.UserID, frame.Topic)
+		if err != nil {
+			return s.write(ctx, Frame{Type: "error", Topic: frame.Topic, Code: "invalid_topic", Detail: err.Error()})
+		}
+
+		if frame.Type == "subscribe" {
+			s.names[topic] = frame.Topic
+			client.Subscribe(topic)
+			return s.write(ctx, Frame{Type: "subscribed", Topic: frame.Topic})
+		}
+
+		client.Unsubscribe(topic)
+		delete(s.names, topic)
+		return s.write(ctx, Frame{Type: "unsubscribed", Topic: frame.Topic})
+	default:
+		return s.write(ctx, Frame{Type: "error", Code: "unknown_frame_type", Detail: fmt.Sprintf("Unknown frame type %q", frame.Type)})
+	}
+}
+
+func (s *session) write(ctx context.Context, frame Frame) error {
+	ctx, cancel := context.WithTimeout(ctx, s.opts.WriteTimeout)
+	defer cancel()
+
+	err := wsjson.Write(ctx, s.conn, frame)
+	if err != nil {
+		return fmt.Errorf("error writing %s frame: %w", frame.Type, err)
+	}
+
+	return nil
+}
+
+// close closes the connection and returns the reason as an error.
+func (s *session) close(code websocket.StatusCode, reason string) error {
+	s.conn.Close(code, reason)
+	return errors.New(reason)
+}
+
+// closeError returns nil when the client closed the connection normally.
+func closeError(err error) error {
+	switch websocket.CloseStatus(err) {
+	case websocket.StatusNormalClosure, websocket.StatusGoingAway:
+		return nil
+	}
+
+	return err
+}
@@ -0,0 +1,191 @@
+// Package realtime routes events to clients subscribed to topics over a
+// single bidirectional connection. The Hub and Client work in process; Serve
+// speaks the protocol over a WebSocket.
+package realtime
+
+import (
+	"encoding/json"
+	"errors"
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+// clientBuffer is how many messages a client may fall behind by before it
+// is disconnected.
+const clientBuffer = 64
+
+var (
+	// ErrSlowConsumer ends a client that didn't keep up with its messages.
+	ErrSlowConsumer = errors.New("client fell too far behind")
+	// ErrHubClosed ends every client when the hub is closed.
+	ErrHubClosed = errors.New("hub closed")
+)
+
+// Message is an event published to a topic.
+type Message struct {
+	Topic string
+	// Type is the kind of event, such as chirp.created.
+	Type string
+	// ID identifies the event within its type, for clients that need to
+	// tell duplicates apart. It may be empty.
+	ID string
+	// UserID is the user the event is about, for Options.Filter. It may
+	// be uuid.Nil.
+	UserID uuid.UUID
+	Data   json.RawMessage
+}
+
+// Hub delivers each published message to the clients subscribed to its
+// topic. Publish never blocks: a client that can't keep up is disconnected
+// with ErrSlowConsumer.
+type Hub struct {
+	mu      sync.Mutex
+	topics  map[string]map[*Client]struct{}
+	clients map[*Client]struct{}
+	closed  bool
+}
+
+func NewHub() *Hub {
+	return &Hub{
+		topics:  map[string]map[*Client]struct{}{},
+		clients: map[*Client]struct{}{},
+	}
+}
+
+// Client is one connection to the hub, made on behalf of an authenticated
+// user.
+type Client struct {
+	UserID uuid.UUID
+
+	hub      *Hub
+	messages chan Message
+	topics   map[string]struct{}
+	// err is why the client ended, set under hub.mu before messages is
+	// closed.
+	err error
+}
+
+// Connect adds a client for userID with no subscriptions.
+func (h *Hub) Connect(userID uuid.UUID) *Client {
+	client := &Client{
+		UserID:   userID,
+		hub:      h,
+		messages: make(chan Message, clientBuffer),
+		topics:   map[string]struct{}{},
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.closed {
+		client.err = ErrHubClosed
+		close(client.messages)
+		return client
+	}
+
+	h.clients[client] = struct{}{}
+
+	return client
+}
+
+// Messages returns the messages published to the client's topics. It is
+// closed when the client ends; Err then says why.
+func (c *Client) Messages() <-chan Message {
+	return c.messages
+}
+
+// Err returns why the client ended, or nil while it is connected or after
+// it was closed normally.
+func (c *Client) Err() error {
+	c.hub.mu.Lock()
+	defer c.hub.mu.Unlock()
+
+	return c.err
+}
+
+// Subscribe adds topic to the client's subscriptions.
+func (c *Client) Subscribe(topic string) {
+	c.hub.mu.Lock()
+	defer c.hub.mu.Unlock()
+
+	if _, ok := c.hub.clients[c]; !ok {
+		return
+	}
+
+	c.topics[topic] = struct{}{}
+
+	subscribers, ok := c.hub.topics[topic]
+	if !ok {
+		subscribers = map[*Client]struct{}{}
+		c.hub.topics[topic] = subscribers
+	}
+	subscribers[c] = struct{}{}
+}
+
+// Unsubscribe removes topic from the client's subscriptions.
+func (c *Client) Unsubscribe(topic string) {
+	c.hub.mu.Lock()
+	defer c.hub.mu.Unlock()
+
+	delete(c.topics, topic)
+	c.hub.unsubscribe(c, topic)
+}
+
+// Close disconnects the client. It is safe to call more than once.
+func (c *Client) Close() {
+	c.hub.mu.Lock()
+	defer c.hub.mu.Unlock()
+
+	c.hub.disconnect(c, nil)
+}
+
+// Publish delivers msg to every client subscribed to its topic.
+func (h *Hub) Publish(msg Message) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for client := range h.topics[msg.Topic] {
+		select {
+		case client.messages <- msg:
+		default:
+			h.disconnect(client, ErrSlowConsumer)
+		}
+	}
+}
+
+// Close disconnects every client, and any that connect later, with
+// ErrHubClosed.
+func (h *Hub) Close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.closed = true
+	for client := range h.clients {
+		h.disconnect(client, ErrHubClosed)
+	}
+}
+
+// disconnect must be called with h.mu held.
+func (h *Hub) disconnect(client *Client, err error) {
+	if _, ok := h.clients[client]; !ok {
+		return
+	}
+
+	for topic := range client.topics {
+		h.unsubscribe(client, topic)
+	}
+
+	delete(h.clients, client)
+	client.err = err
+	close(client.messages)
+}
+
+// unsubscribe must be called with h.mu held.
+func (h *Hub) unsubscribe(client *Client, topic string) {
+	subscribers := h.topics[topic]
+	delete(subscribers, client)
+	if len(subscribers) == 0 {
+		delete(h.topics, topic)
+	}
+}
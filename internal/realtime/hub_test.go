@@ -0,0 +1,97 @@
+package realtime
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestHubRoutesByTopic(t *testing.T) {
+	hub := NewHub()
+	alice := hub.Connect(uuid.New())
+	bob := hub.Connect(uuid.New())
+
+	alice.Subscribe("user:1")
+	bob.Subscribe("user:1")
+	bob.Subscribe("chirp:2")
+
+	hub.Publish(Message{Topic: "user:1", Type: "chirp.created", ID: "1"})
+	hub.Publish(Message{Topic: "chirp:2", Type: "chirp.deleted", ID: "2"})
+	hub.Publish(Message{Topic: "nobody", Type: "chirp.created", ID: "3"})
+
+	if msg := <-alice.Messages(); msg.ID != "1" {
+		t.Errorf("expected alice to get message 1, instead got %+v", msg)
+	}
+
+	for _, expected := range []string{"1", "2"} {
+		if msg := <-bob.Messages(); msg.ID != expected {
+			t.Errorf("expected bob to get message %s, instead got %+v", expected, msg)
+		}
+	}
+
+	if len(alice.Messages()) != 0 || len(bob.Messages()) != 0 {
+		t.Errorf("expected no other messages to be delivered")
+	}
+
+	bob.Unsubscribe("user:1")
+	hub.Publish(Message{Topic: "user:1", ID: "4"})
+	if len(bob.Messages()) != 0 {
+		t.Errorf("expected no messages after unsubscribing")
+	}
+
+	if msg := <-alice.Messages(); msg.ID != "4" {
+		t.Errorf("expected alice to still get message 4, instead got %+v", msg)
+	}
+
+	alice.Close()
+	alice.Close()
+	if _, ok := <-alice.Messages(); ok || alice.Err() != nil {
+		t.Errorf("expected a closed client to end without an error, instead got %v", alice.Err())
+	}
+
+	if len(hub.topics) != 1 {
+		t.Errorf("expected only bob's topic to be left, instead got %v", hub.topics)
+	}
+}
+
+func TestHubDisconnectsSlowConsumers(t *testing.T) {
+	hub := NewHub()
+	slow := hub.Connect(uuid.New())
+	slow.Subscribe("busy")
+
+	for range clientBuffer + 1 {
+		hub.Publish(Message{Topic: "busy"})
+	}
+
+	received := 0
+	for range slow.Messages() {
+		received++
+	}
+
+	if received != clientBuffer || !errors.Is(slow.Err(), ErrSlowConsumer) {
+		t.Errorf("expected %d messages and ErrSlowConsumer, instead got %d and %v", clientBuffer, received, slow.Err())
+	}
+
+	// A disconnected client can't subscribe again.
+	slow.Subscribe("busy")
+	hub.Publish(Message{Topic: "busy"})
+	if len(hub.topics) != 0 {
+		t.Errorf("expected no topics left, instead got %v", hub.topics)
+	}
+}
+
+func TestHubClose(t *testing.T) {
+	hub := NewHub()
+	client := hub.Connect(uuid.New())
+
+	hub.Close()
+	if _, ok := <-client.Messages(); ok || !errors.Is(client.Err(), ErrHubClosed) {
+		t.Errorf("expected closing the hub to end clients, instead got %v", client.Err())
+	}
+
+	late := hub.Connect(uuid.New())
+	if _, ok := <-late.Messages(); ok || !errors.Is(late.Err(), ErrHubClosed) {
+		t.Errorf("expected connecting to a closed hub to end at once, instead got %v", late.Err())
+	}
+}
@@ -0,0 +1,1891 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"maps"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/vemolista/chirpy/v2/internal/database"
+)
+
+// Memory is an in-process Store for tests and local development. Rows are
+// copied in and out so callers never share memory with the store.
+type Memory struct {
+	mu sync.RWMutex
+	// txMu runs transactions one at a time.
+	txMu sync.Mutex
+	memoryData
+	// now is overridable so tests can control timestamps.
+	now func() time.Time
+}
+
+// memoryData is the contents of a Memory, which InTx saves to roll back to.
+type memoryData struct {
+	users         map[uuid.UUID]database.User
+	chirps        map[uuid.UUID]database.Chirp
+	refreshTokens map[string]database.RefreshToken
+	idempotency   map[idempotencyKey]database.IdempotencyKey
+	follows       map[database.FollowUserParams]time.Time
+	blocks        map[database.BlockUserParams]time.Time
+	mutes         map[database.MuteUserParams]time.Time
+	bookmarks     map[database.BookmarkChirpParams]time.Time
+	// pinned maps users to the chirp they pinned.
+	pinned        map[uuid.UUID]uuid.UUID
+	polls         map[uuid.UUID]database.Poll
+	pollOptions   map[uuid.UUID]database.PollOption
+	pollVotes     map[pollVoteKey]database.PollVote
+	chirpEvents   []database.ChirpEvent
+	notifications map[uuid.UUID]database.Notification
+	entities      map[uuid.UUID][]database.ChirpEntity
+	preferences   map[preferenceKey]bool
+	media         map[uuid.UUID]database.Medium
+	linkPreviews  map[string]database.LinkPreview
+	reports       map[uuid.UUID]database.Report
+	// moderationActions is in insertion order.
+	moderationActions []database.ModerationAction
+	// lastChirpEventID stands in for the Postgres sequence.
+	lastChirpEventID int64
+}
+
+var _ Store = (*Memory)(nil)
+
+func NewMemory() *Memory {
+	return &Memory{
+		memoryData: memoryData{
+			users:         map[uuid.UUID]database.User{},
+			chirps:        map[uuid.UUID]database.Chirp{},
+			refreshTokens: map[string]database.RefreshToken{},
+			idempotency:   map[idempotencyKey]database.IdempotencyKey{},
+			follows:       map[database.FollowUserParams]time.Time{},
+			blocks:        map[database.BlockUserParams]time.Time{},
+			mutes:         map[database.MuteUserParams]time.Time{},
+			bookmarks:     map[database.BookmarkChirpParams]time.Time{},
+			pinned:        map[uuid.UUID]uuid.UUID{},
+			polls:         map[uuid.UUID]database.Poll{},
+			pollOptions:   map[uuid.UUID]database.PollOption{},
+			pollVotes:     map[pollVoteKey]database.PollVote{},
+			notifications: map[uuid.UUID]database.Notification{},
+			entities:      map[uuid.UUID][]database.ChirpEntity{},
+			preferences:   map[preferenceKey]bool{},
+			media:         map[uuid.UUID]database.Medium{},
+			linkPreviews:  map[string]database.LinkPreview{},
+			reports:       map[uuid.UUID]database.Report{},
+		},
+		now: func() time.Time {
+			return time.Now().UTC()
+		},
+	}
+}
+
+// InTx rolls back by restoring the data saved when fn started. Only one
+// transaction runs at a time, but they aren't isolated: other callers see
+// their writes before they commit, and lose their own writes made meanwhile
+// if fn fails. That is enough for tests and local development.
+func (m *Memory) InTx(ctx context.Context, fn func(ctx context.Context) error) error {
+	if ctx.Value(txKey{}) == m {
+		return fn(ctx)
+	}
+
+	m.txMu.Lock()
+	defer m.txMu.Unlock()
+
+	m.mu.RLock()
+	saved := m.memoryData.clone()
+	m.mu.RUnlock()
+
+	err := fn(context.WithValue(ctx, txKey{}, m))
+	if err != nil {
+		m.mu.Lock()
+		m.memoryData = saved
+		m.mu.Unlock()
+	}
+
+	return err
+}
+
+// clone copies d deeply enough that writes to d don't change the copy.
+func (d memoryData) clone() memoryData {
+	d.users = maps.Clone(d.users)
+	d.chirps = maps.Clone(d.chirps)
+	d.refreshTokens = maps.Clone(d.refreshTokens)
+	d.idempotency = maps.Clone(d.idempotency)
+	d.follows = maps.Clone(d.follows)
+	d.blocks = maps.Clone(d.blocks)
+	d.mutes = maps.Clone(d.mutes)
+	d.bookmarks = maps.Clone(d.bookmarks)
+	d.pinned = maps.Clone(d.pinned)
+	d.polls = maps.Clone(d.polls)
+	d.pollOptions = maps.Clone(d.pollOptions)
+	d.pollVotes = maps.Clone(d.pollVotes)
+	d.chirpEvents = slices.Clone(d.chirpEvents)
+	d.notifications = maps.Clone(d.notifications)
+	d.preferences = maps.Clone(d.preferences)
+	d.media = maps.Clone(d.media)
+	d.linkPreviews = maps.Clone(d.linkPreviews)
+	d.reports = maps.Clone(d.reports)
+	d.moderationActions = slices.Clone(d.moderationActions)
+
+	// ResolveMentions updates entities in place.
+	entities := make(map[uuid.UUID][]database.ChirpEntity, len(d.entities))
+	for id, rows := range d.entities {
+		entities[id] = slices.Clone(rows)
+	}
+	d.entities = entities
+
+	return d
+}
+
+func (m *Memory) CreateUser(ctx context.Context, arg database.CreateUserParams) (database.User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.userByEmail(arg.Email); ok {
+		return database.User{}, ErrUniqueViolation
+	}
+
+	if _, ok := m.userByUsername(arg.Username); ok {
+		return database.User{}, ErrUniqueViolation
+	}
+
+	now := m.now()
+	user := database.User{
+		ID:             uuid.New(),
+		CreatedAt:      now,
+		UpdatedAt:      now,
+		Email:          arg.Email,
+		HashedPassword: arg.HashedPassword,
+		Username:       arg.Username,
+		Role:           "user",
+	}
+	m.users[user.ID] = user
+
+	return user, nil
+}
+
+func (m *Memory) GetUser(ctx context.Context, id uuid.UUID) (database.User, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	user, ok := m.users[id]
+	if !ok {
+		return database.User{}, sql.ErrNoRows
+	}
+
+	return user, nil
+}
+
+func (m *Memory) GetUserByEmail(ctx context.Context, email string) (database.User, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	user, ok := m.userByEmail(email)
+	if !ok {
+		return database.User{}, sql.ErrNoRows
+	}
+
+	return user, nil
+}
+
+func (m *Memory) GetUserByUsername(ctx context.Context, username sql.NullString) (database.User, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	user, ok := m.userByUsername(username)
+	if !ok {
+		return database.User{}, sql.ErrNoRows
+	}
+
+	return user, nil
+}
+
+func (m *Memory) ListUsersByIDs(ctx context.Context, ids []uuid.UUID) ([]database.User, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var users []database.User
+	for _, id := range ids {
+		if user, ok := m.users[id]; ok {
+			users = append(users, user)
+		}
+	}
+
+	return users, nil
+}
+
+func (m *Memory) UpdateUser(ctx context.Context, arg database.UpdateUserParams) (database.User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	user, ok := m.users[arg.ID]
+	if !ok {
+		return database.User{}, sql.ErrNoRows
+	}
+
+	if existing, ok := m.userByEmail(arg.Email); ok && existing.ID != arg.ID {
+		return database.User{}, ErrUniqueViolation
+	}
+
+	user.Email = arg.Email
+	user.HashedPassword = arg.HashedPassword
+	user.UpdatedAt = m.now()
+	m.users[user.ID] = user
+
+	return user, nil
+}
+
+func (m *Memory) UpdateUserProfile(ctx context.Context, arg database.UpdateUserProfileParams) (database.User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	user, ok := m.users[arg.ID]
+	if !ok {
+		return database.User{}, sql.ErrNoRows
+	}
+
+	if existing, ok := m.userByUsername(arg.Username); ok && existing.ID != arg.ID {
+		return database.User{}, ErrUniqueViolation
+	}
+
+	user.Username = arg.Username
+	user.DisplayName = arg.DisplayName
+	user.Bio = arg.Bio
+	user.AvatarUrl = arg.AvatarUrl
+	user.UpdatedAt = m.now()
+	m.users[user.ID] = user
+
+	return user, nil
+}
+
+func (m *Memory) GetUserCounts(ctx context.Context, userID uuid.UUID) (database.GetUserCountsRow, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var counts database.GetUserCountsRow
+	for _, chirp := range m.chirps {
+		if chirp.UserID == userID && chirp.Status == "published" && !chirp.DeletedAt.Valid {
+			counts.ChirpCount++
+		}
+	}
+
+	for follow := range m.follows {
+		if follow.FolloweeID == userID {
+			counts.FollowerCount++
+		}
+		if follow.FollowerID == userID {
+			counts.FollowingCount++
+		}
+	}
+
+	return counts, nil
+}
+
+func (m *Memory) DeleteUsers(ctx context.Context) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// Everything that references users cascades with them.
+	m.users = map[uuid.UUID]database.User{}
+	m.chirps = map[uuid.UUID]database.Chirp{}
+	m.refreshTokens = map[string]database.RefreshToken{}
+	m.follows = map[database.FollowUserParams]time.Time{}
+	m.blocks = map[database.BlockUserParams]time.Time{}
+	m.mutes = map[database.MuteUserParams]time.Time{}
+	m.bookmarks = map[database.BookmarkChirpParams]time.Time{}
+	m.pinned = map[uuid.UUID]uuid.UUID{}
+	m.polls = map[uuid.UUID]database.Poll{}
+	m.pollOptions = map[uuid.UUID]database.PollOption{}
+	m.pollVotes = map[pollVoteKey]database.PollVote{}
+	m.chirpEvents = nil
+	m.notifications = map[uuid.UUID]database.Notification{}
+	m.entities = map[uuid.UUID][]database.ChirpEntity{}
+	m.preferences = map[preferenceKey]bool{}
+	m.media = map[uuid.UUID]database.Medium{}
+	m.reports = map[uuid.UUID]database.Report{}
+	m.moderationActions = nil
+
+	return nil
+}
+
+func (m *Memory) UpgradeToChirpyRed(ctx context.Context, id uuid.UUID) (database.User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	user, ok := m.users[id]
+	if !ok {
+		return database.User{}, sql.ErrNoRows
+	}
+
+	user.IsChirpyRed = true
+	m.users[id] = user
+
+	return user, nil
+}
+
+func (m *Memory) SetUserRole(ctx context.Context, arg database.SetUserRoleParams) (database.User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	user, ok := m.users[arg.ID]
+	if !ok {
+		return database.User{}, sql.ErrNoRows
+	}
+
+	user.Role = arg.Role
+	user.UpdatedAt = m.now()
+	m.users[user.ID] = user
+
+	return user, nil
+}
+
+func (m *Memory) SuspendUser(ctx context.Context, id uuid.UUID) (database.User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	user, ok := m.users[id]
+	if !ok {
+		return database.User{}, sql.ErrNoRows
+	}
+
+	if !user.SuspendedAt.Valid {
+		user.SuspendedAt = sql.NullTime{Time: m.now(), Valid: true}
+		m.users[id] = user
+	}
+
+	return user, nil
+}
+
+func (m *Memory) userByEmail(email string) (database.User, bool) {
+	for _, user := range m.users {
+		if user.Email == email {
+			return user, true
+		}
+	}
+
+	return database.User{}, false
+}
+
+// userByUsername never matches a null username, as null is not equal to
+// anything in SQL.
+func (m *Memory) userByUsername(username sql.NullString) (database.User, bool) {
+	if !username.Valid {
+		return database.User{}, false
+	}
+
+	for _, user := range m.users {
+		if user.Username == username {
+			return user, true
+		}
+	}
+
+	return database.User{}, false
+}
+
+func (m *Memory) CreateChirp(ctx context.Context, arg database.CreateChirpParams) (database.Chirp, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.users[arg.UserID]; !ok {
+		return database.Chirp{}, ErrForeignKeyViolation
+	}
+
+	now := m.now()
+	chirp := database.Chirp{
+		ID:        uuid.New(),
+		CreatedAt: now,
+		UpdatedAt: now,
+		UserID:    arg.UserID,
+		Body:      arg.Body,
+		Status:    arg.Status,
+		PublishAt: arg.PublishAt,
+	}
+	m.chirps[chirp.ID] = chirp
+
+	return chirp, nil
+}
+
+func (m *Memory) ListChirps(ctx context.Context, viewerID uuid.UUID) ([]database.Chirp, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.sortedChirps(func(c database.Chirp) bool {
+		return c.Status == "published" && !c.DeletedAt.Valid && !m.filtered(viewerID, c.UserID)
+	}), nil
+}
+
+func (m *Memory) ListChirpsForAuthor(ctx context.Context, arg database.ListChirpsForAuthorParams) ([]database.Chirp, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if m.blocked(arg.UserID, arg.ViewerID) {
+		return nil, nil
+	}
+
+	return m.sortedChirps(func(c database.Chirp) bool {
+		return c.UserID == arg.UserID && c.Status == "published" && !c.DeletedAt.Valid
+	}), nil
+}
+
+func (m *Memory) ListUnpublishedChirps(ctx context.Context, userID uuid.UUID) ([]database.Chirp, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.sortedChirps(func(c database.Chirp) bool {
+		return c.UserID == userID && c.Status != "published" && !c.DeletedAt.Valid
+	}), nil
+}
+
+func (m *Memory) GetChirp(ctx context.Context, id uuid.UUID) (database.Chirp, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	chirp, ok := m.chirps[id]
+	if !ok || chirp.DeletedAt.Valid {
+		return database.Chirp{}, sql.ErrNoRows
+	}
+
+	return chirp, nil
+}
+
+func (m *Memory) GetDeletedChirp(ctx context.Context, id uuid.UUID) (database.Chirp, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	chirp, ok := m.chirps[id]
+	if !ok || !chirp.DeletedAt.Valid {
+		return database.Chirp{}, sql.ErrNoRows
+	}
+
+	return chirp, nil
+}
+
+func (m *Memory) DeleteChirp(ctx context.Context, id uuid.UUID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.deleteChirp(id)
+
+	return nil
+}
+
+func (m *Memory) UpdateChirp(ctx context.Context, arg database.UpdateChirpParams) (database.Chirp, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	chirp, ok := m.chirps[arg.ID]
+	if !ok || !chirp.UpdatedAt.Equal(arg.UpdatedAt) || chirp.DeletedAt.Valid {
+		return database.Chirp{}, sql.ErrNoRows
+	}
+
+	chirp.Body = arg.Body
+	chirp.UpdatedAt = m.now()
+	m.chirps[chirp.ID] = chirp
+
+	return chirp, nil
+}
+
+func (m *Memory) PublishChirp(ctx context.Context, arg database.PublishChirpParams) (database.Chirp, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	chirp, ok := m.chirps[arg.ID]
+	if !ok || !chirp.UpdatedAt.Equal(arg.UpdatedAt) || chirp.Status == "published" || chirp.DeletedAt.Valid {
+		return database.Chirp{}, sql.ErrNoRows
+	}
+
+	return m.publish(chirp), nil
+}
+
+func (m *Memory) PublishDueChirps(ctx context.Context, arg database.PublishDueChirpsParams) ([]database.Chirp, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var due []database.Chirp
+	for _, chirp := range m.chirps {
+		if chirp.Status == "scheduled" && !chirp.PublishAt.Time.After(arg.Now) && !chirp.DeletedAt.Valid {
+			due = append(due, chirp)
+		}
+	}
+
+	sort.Slice(due, func(i, j int) bool {
+		return due[i].PublishAt.Time.Before(due[j].PublishAt.Time)
+	})
+
+	published := []database.Chirp{}
+	for _, chirp := range due[:min(len(due), int(arg.Limit))] {
+		published = append(published, m.publish(chirp))
+	}
+
+	return published, nil
+}
+
+// publish must be called with m.mu held.
+func (m *Memory) publish(chirp database.Chirp) database.Chirp {
+	now := m.now()
+	chirp.Status = "published"
+	chirp.CreatedAt = now
+	chirp.UpdatedAt = now
+	m.chirps[chirp.ID] = chirp
+
+	return chirp
+}
+
+func (m *Memory) SoftDeleteChirp(ctx context.Context, arg database.SoftDeleteChirpParams) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	chirp, ok := m.chirps[arg.ID]
+	if !ok || !chirp.UpdatedAt.Equal(arg.UpdatedAt) || chirp.DeletedAt.Valid {
+		return 0, nil
+	}
+
+	chirp.DeletedAt = sql.NullTime{Time: m.now(), Valid: true}
+	m.chirps[chirp.ID] = chirp
+
+	return 1, nil
+}
+
+func (m *Memory) RestoreChirp(ctx context.Context, arg database.RestoreChirpParams) (database.Chirp, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	chirp, ok := m.chirps[arg.ID]
+	if !ok || !chirp.DeletedAt.Valid || !chirp.DeletedAt.Time.After(arg.DeletedAfter) || chirp.Hidden {
+		return database.Chirp{}, sql.ErrNoRows
+	}
+
+	chirp.DeletedAt = sql.NullTime{}
+	m.chirps[chirp.ID] = chirp
+
+	return chirp, nil
+}
+
+func (m *Memory) HideChirp(ctx context.Context, id uuid.UUID) (database.Chirp, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	chirp, ok := m.chirps[id]
+	if !ok {
+		return database.Chirp{}, sql.ErrNoRows
+	}
+
+	chirp.Hidden = true
+	if !chirp.DeletedAt.Valid {
+		chirp.DeletedAt = sql.NullTime{Time: m.now(), Valid: true}
+	}
+	m.chirps[id] = chirp
+
+	return chirp, nil
+}
+
+func (m *Memory) PurgeDeletedChirps(ctx context.Context, before time.Time) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var purged int64
+	for id, chirp := range m.chirps {
+		if chirp.DeletedAt.Valid && chirp.DeletedAt.Time.Before(before) {
+			m.deleteChirp(id)
+			purged++
+		}
+	}
+
+	return purged, nil
+}
+
+// deleteChirp deletes a chirp and, as Postgres would, the rows that
+// reference it. It must be called with m.mu held.
+func (m *Memory) deleteChirp(id uuid.UUID) {
+	delete(m.chirps, id)
+	delete(m.entities, id)
+
+	for notificationID, notification := range m.notifications {
+		if notification.ChirpID.Valid && notification.ChirpID.UUID == id {
+			delete(m.notifications, notificationID)
+		}
+	}
+
+	for mediaID, media := range m.media {
+		if media.ChirpID.Valid && media.ChirpID.UUID == id {
+			media.ChirpID = uuid.NullUUID{}
+			m.media[mediaID] = media
+		}
+	}
+
+	// Reports outlive the chirp, with the body they quote.
+	for reportID, report := range m.reports {
+		if report.ChirpID.Valid && report.ChirpID.UUID == id {
+			report.ChirpID = uuid.NullUUID{}
+			m.reports[reportID] = report
+		}
+	}
+
+	for bookmark := range m.bookmarks {
+		if bookmark.ChirpID == id {
+			delete(m.bookmarks, bookmark)
+		}
+	}
+
+	for userID, chirpID := range m.pinned {
+		if chirpID == id {
+			delete(m.pinned, userID)
+		}
+	}
+
+	delete(m.polls, id)
+	for optionID, option := range m.pollOptions {
+		if option.ChirpID == id {
+			delete(m.pollOptions, optionID)
+		}
+	}
+	for key := range m.pollVotes {
+		if key.chirpID == id {
+			delete(m.pollVotes, key)
+		}
+	}
+}
+
+// sortedChirps returns the chirps matching keep ordered by creation time,
+// with the ID as a tie breaker so the order is stable.
+func (m *Memory) sortedChirps(keep func(database.Chirp) bool) []database.Chirp {
+	chirps := []database.Chirp{}
+	for _, chirp := range m.chirps {
+		if keep(chirp) {
+			chirps = append(chirps, chirp)
+		}
+	}
+
+	sort.Slice(chirps, func(i, j int) bool {
+		if chirps[i].CreatedAt.Equal(chirps[j].CreatedAt) {
+			return chirps[i].ID.String() < chirps[j].ID.String()
+		}
+
+		return chirps[i].CreatedAt.Before(chirps[j].CreatedAt)
+	})
+
+	return chirps
+}
+
+func (m *Memory) CreateRefreshToken(ctx context.Context, arg database.CreateRefreshTokenParams) (database.RefreshToken, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.refreshTokens[arg.Token]; ok {
+		return database.RefreshToken{}, ErrUniqueViolation
+	}
+
+	if _, ok := m.users[arg.UserID]; !ok {
+		return database.RefreshToken{}, ErrForeignKeyViolation
+	}
+
+	now := m.now()
+	token := database.RefreshToken{
+		Token:     arg.Token,
+		CreatedAt: now,
+		UpdatedAt: now,
+		UserID:    arg.UserID,
+		ExpiresAt: arg.ExpiresAt,
+	}
+	m.refreshTokens[token.Token] = token
+
+	return token, nil
+}
+
+func (m *Memory) GetRefreshToken(ctx context.Context, token string) (database.RefreshToken, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	refreshToken, ok := m.refreshTokens[token]
+	if !ok {
+		return database.RefreshToken{}, sql.ErrNoRows
+	}
+
+	return refreshToken, nil
+}
+
+func (m *Memory) RevokeToken(ctx context.Context, token string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	refreshToken, ok := m.refreshTokens[token]
+	if !ok {
+		return nil
+	}
+
+	now := m.now()
+	refreshToken.UpdatedAt = now
+	refreshToken.RevokedAt = sql.NullTime{Time: now, Valid: true}
+	m.refreshTokens[token] = refreshToken
+
+	return nil
+}
+
+type idempotencyKey struct {
+	scope string
+	key   string
+}
+
+func (m *Memory) CreateIdempotencyKey(ctx context.Context, arg database.CreateIdempotencyKeyParams) (database.IdempotencyKey, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	id := idempotencyKey{scope: arg.Scope, key: arg.Key}
+	if _, ok := m.idempotency[id]; ok {
+		return database.IdempotencyKey{}, ErrUniqueViolation
+	}
+
+	key := database.IdempotencyKey{
+		Scope:       arg.Scope,
+		Key:         arg.Key,
+		RequestHash: arg.RequestHash,
+		CreatedAt:   m.now(),
+		ExpiresAt:   arg.ExpiresAt,
+	}
+	m.idempotency[id] = key
+
+	return key, nil
+}
+
+func (m *Memory) GetIdempotencyKey(ctx context.Context, arg database.GetIdempotencyKeyParams) (database.IdempotencyKey, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	key, ok := m.idempotency[idempotencyKey{scope: arg.Scope, key: arg.Key}]
+	if !ok {
+		return database.IdempotencyKey{}, sql.ErrNoRows
+	}
+
+	key.ResponseBody = bytes.Clone(key.ResponseBody)
+
+	return key, nil
+}
+
+func (m *Memory) CompleteIdempotencyKey(ctx context.Context, arg database.CompleteIdempotencyKeyParams) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	id := idempotencyKey{scope: arg.Scope, key: arg.Key}
+	key, ok := m.idempotency[id]
+	if !ok {
+		return nil
+	}
+
+	key.ResponseStatus = arg.ResponseStatus
+	key.ResponseBody = bytes.Clone(arg.ResponseBody)
+	key.ResponseHeaders = arg.ResponseHeaders
+	m.idempotency[id] = key
+
+	return nil
+}
+
+func (m *Memory) DeleteIdempotencyKey(ctx context.Context, arg database.DeleteIdempotencyKeyParams) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.idempotency, idempotencyKey{scope: arg.Scope, key: arg.Key})
+
+	return nil
+}
+
+func (m *Memory) DeleteExpiredIdempotencyKeys(ctx context.Context, expiresAt time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for id, key := range m.idempotency {
+		if key.ExpiresAt.Before(expiresAt) {
+			delete(m.idempotency, id)
+		}
+	}
+
+	return nil
+}
+
+func (m *Memory) FollowUser(ctx context.Context, arg database.FollowUserParams) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	_, followerExists := m.users[arg.FollowerID]
+	_, followeeExists := m.users[arg.FolloweeID]
+	if !followerExists || !followeeExists {
+		return 0, ErrForeignKeyViolation
+	}
+
+	if _, ok := m.follows[arg]; ok {
+		return 0, nil
+	}
+
+	m.follows[arg] = m.now()
+
+	return 1, nil
+}
+
+func (m *Memory) UnfollowUser(ctx context.Context, arg database.UnfollowUserParams) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.follows, database.FollowUserParams(arg))
+
+	return nil
+}
+
+func (m *Memory) ListFollowees(ctx context.Context, followerID uuid.UUID) ([]uuid.UUID, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var follows []database.FollowUserParams
+	for follow := range m.follows {
+		if follow.FollowerID == followerID {
+			follows = append(follows, follow)
+		}
+	}
+
+	sort.Slice(follows, func(i, j int) bool {
+		return m.follows[follows[i]].Before(m.follows[follows[j]])
+	})
+
+	var followees []uuid.UUID
+	for _, follow := range follows {
+		followees = append(followees, follow.FolloweeID)
+	}
+
+	return followees, nil
+}
+
+func (m *Memory) BlockUser(ctx context.Context, arg database.BlockUserParams) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	_, blockerExists := m.users[arg.BlockerID]
+	_, blockedExists := m.users[arg.BlockedID]
+	if !blockerExists || !blockedExists {
+		return 0, ErrForeignKeyViolation
+	}
+
+	if _, ok := m.blocks[arg]; ok {
+		return 0, nil
+	}
+
+	m.blocks[arg] = m.now()
+
+	return 1, nil
+}
+
+func (m *Memory) UnblockUser(ctx context.Context, arg database.UnblockUserParams) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.blocks, database.BlockUserParams(arg))
+
+	return nil
+}
+
+func (m *Memory) MuteUser(ctx context.Context, arg database.MuteUserParams) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	_, muterExists := m.users[arg.MuterID]
+	_, mutedExists := m.users[arg.MutedID]
+	if !muterExists || !mutedExists {
+		return 0, ErrForeignKeyViolation
+	}
+
+	if _, ok := m.mutes[arg]; ok {
+		return 0, nil
+	}
+
+	m.mutes[arg] = m.now()
+
+	return 1, nil
+}
+
+func (m *Memory) UnmuteUser(ctx context.Context, arg database.UnmuteUserParams) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.mutes, database.MuteUserParams(arg))
+
+	return nil
+}
+
+func (m *Memory) GetRelationship(ctx context.Context, arg database.GetRelationshipParams) (database.GetRelationshipRow, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	_, muting := m.mutes[database.MuteUserParams{MuterID: arg.UserID, MutedID: arg.OtherID}]
+
+	return database.GetRelationshipRow{
+		BlockedBy: m.blocked(arg.OtherID, arg.UserID),
+		Muting:    muting,
+	}, nil
+}
+
+func (m *Memory) ListBlockers(ctx context.Context, blockedID uuid.UUID) ([]uuid.UUID, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var blockers []uuid.UUID
+	for block := range m.blocks {
+		if block.BlockedID == blockedID {
+			blockers = append(blockers, block.BlockerID)
+		}
+	}
+
+	return blockers, nil
+}
+
+func (m *Memory) ListMutedUsers(ctx context.Context, muterID uuid.UUID) ([]uuid.UUID, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var muted []uuid.UUID
+	for mute := range m.mutes {
+		if mute.MuterID == muterID {
+			muted = append(muted, mute.MutedID)
+		}
+	}
+
+	return muted, nil
+}
+
+// blocked reports whether blocker blocked blocked. It must be called with
+// m.mu held.
+func (m *Memory) blocked(blocker, blocked uuid.UUID) bool {
+	_, ok := m.blocks[database.BlockUserParams{BlockerID: blocker, BlockedID: blocked}]
+	return ok
+}
+
+// filtered reports whether the chirps of author are left out of viewer's
+// timeline, because author blocked viewer or viewer muted author. It must
+// be called with m.mu held.
+func (m *Memory) filtered(viewer, author uuid.UUID) bool {
+	_, muted := m.mutes[database.MuteUserParams{MuterID: viewer, MutedID: author}]
+	return muted || m.blocked(author, viewer)
+}
+
+func (m *Memory) CreateChirpEvent(ctx context.Context, arg database.CreateChirpEventParams) (database.ChirpEvent, error) {
+	// Like the Postgres lock, waiting for the running transaction keeps
+	// IDs in commit order.
+	if ctx.Value(txKey{}) != m {
+		m.txMu.Lock()
+		defer m.txMu.Unlock()
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.users[arg.UserID]; !ok {
+		return database.ChirpEvent{}, ErrForeignKeyViolation
+	}
+
+	// IDs keep increasing even after DeleteUsers empties the log.
+	m.lastChirpEventID++
+	event := database.ChirpEvent{
+		ID:        m.lastChirpEventID,
+		Type:      arg.Type,
+		UserID:    arg.UserID,
+		Data:      arg.Data,
+		CreatedAt: m.now(),
+	}
+	m.chirpEvents = append(m.chirpEvents, event)
+
+	return event, nil
+}
+
+func (m *Memory) GetChirpEvent(ctx context.Context, id int64) (database.ChirpEvent, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, event := range m.chirpEvents {
+		if event.ID == id {
+			return event, nil
+		}
+	}
+
+	return database.ChirpEvent{}, sql.ErrNoRows
+}
+
+func (m *Memory) ListChirpEventsAfter(ctx context.Context, arg database.ListChirpEventsAfterParams) ([]database.ChirpEvent, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var events []database.ChirpEvent
+	for _, event := range m.chirpEvents {
+		if event.ID > arg.ID && len(events) < int(arg.Limit) {
+			events = append(events, event)
+		}
+	}
+
+	return events, nil
+}
+
+func (m *Memory) DeleteChirpEventsBefore(ctx context.Context, createdAt time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	kept := m.chirpEvents[:0]
+	for _, event := range m.chirpEvents {
+		if !event.CreatedAt.Before(createdAt) {
+			kept = append(kept, event)
+		}
+	}
+	m.chirpEvents = kept
+
+	return nil
+}
+
+type preferenceKey struct {
+	userID uuid.UUID
+	kind   string
+}
+
+func (m *Memory) CreateNotification(ctx context.Context, arg database.CreateNotificationParams) (database.Notification, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	_, userExists := m.users[arg.UserID]
+	_, actorExists := m.users[arg.ActorID]
+	if !userExists || !actorExists {
+		return database.Notification{}, ErrForeignKeyViolation
+	}
+
+	if arg.ChirpID.Valid {
+		if _, ok := m.chirps[arg.ChirpID.UUID]; !ok {
+			return database.Notification{}, ErrForeignKeyViolation
+		}
+	}
+
+	notification := database.Notification{
+		ID:        uuid.New(),
+		UserID:    arg.UserID,
+		ActorID:   arg.ActorID,
+		Type:      arg.Type,
+		ChirpID:   arg.ChirpID,
+		CreatedAt: m.now(),
+	}
+	m.notifications[notification.ID] = notification
+
+	return notification, nil
+}
+
+func (m *Memory) GetNotification(ctx context.Context, id uuid.UUID) (database.Notification, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	notification, ok := m.notifications[id]
+	if !ok {
+		return database.Notification{}, sql.ErrNoRows
+	}
+
+	return notification, nil
+}
+
+func (m *Memory) ListNotifications(ctx context.Context, arg database.ListNotificationsParams) ([]database.Notification, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.sortedNotifications(arg.UserID, arg.Limit, func(database.Notification) bool { return true }), nil
+}
+
+func (m *Memory) ListNotificationsBefore(ctx context.Context, arg database.ListNotificationsBeforeParams) ([]database.Notification, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.sortedNotifications(arg.UserID, arg.Limit, func(n database.Notification) bool {
+		if n.CreatedAt.Equal(arg.CreatedAt) {
+			return bytes.Compare(n.ID[:], arg.ID[:]) < 0
+		}
+
+		return n.CreatedAt.Before(arg.CreatedAt)
+	}), nil
+}
+
+// sortedNotifications returns up to limit of a user's notifications that
+// match keep, newest first with the ID as a tie breaker.
+func (m *Memory) sortedNotifications(userID uuid.UUID, limit int32, keep func(database.Notification) bool) []database.Notification {
+	var notifications []database.Notification
+	for _, notification := range m.notifications {
+		if notification.UserID == userID && keep(notification) {
+			notifications = append(notifications, notification)
+		}
+	}
+
+	sort.Slice(notifications, func(i, j int) bool {
+		a, b := notifications[i], notifications[j]
+		if !a.CreatedAt.Equal(b.CreatedAt) {
+			return a.CreatedAt.After(b.CreatedAt)
+		}
+
+		return bytes.Compare(a.ID[:], b.ID[:]) > 0
+	})
+
+	if len(notifications) > int(limit) {
+		notifications = notifications[:limit]
+	}
+
+	return notifications
+}
+
+func (m *Memory) CountUnreadNotifications(ctx context.Context, userID uuid.UUID) (int64, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var count int64
+	for _, notification := range m.notifications {
+		if notification.UserID == userID && !notification.ReadAt.Valid {
+			count++
+		}
+	}
+
+	return count, nil
+}
+
+func (m *Memory) MarkNotificationRead(ctx context.Context, arg database.MarkNotificationReadParams) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	notification, ok := m.notifications[arg.ID]
+	if !ok || notification.UserID != arg.UserID || notification.ReadAt.Valid {
+		return 0, nil
+	}
+
+	notification.ReadAt = sql.NullTime{Time: m.now(), Valid: true}
+	m.notifications[arg.ID] = notification
+
+	return 1, nil
+}
+
+func (m *Memory) MarkAllNotificationsRead(ctx context.Context, userID uuid.UUID) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var marked int64
+	for id, notification := range m.notifications {
+		if notification.UserID == userID && !notification.ReadAt.Valid {
+			notification.ReadAt = sql.NullTime{Time: m.now(), Valid: true}
+			m.notifications[id] = notification
+			marked++
+		}
+	}
+
+	return marked, nil
+}
+
+func (m *Memory) ListNotificationPreferences(ctx context.Context, userID uuid.UUID) ([]database.NotificationPreference, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var preferences []database.NotificationPreference
+	for key, enabled := range m.preferences {
+		if key.userID == userID {
+			preferences = append(preferences, database.NotificationPreference{
+				UserID:  key.userID,
+				Type:    key.kind,
+				Enabled: enabled,
+			})
+		}
+	}
+
+	sort.Slice(preferences, func(i, j int) bool {
+		return preferences[i].Type < preferences[j].Type
+	})
+
+	return preferences, nil
+}
+
+func (m *Memory) SetNotificationPreference(ctx context.Context, arg database.SetNotificationPreferenceParams) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.users[arg.UserID]; !ok {
+		return ErrForeignKeyViolation
+	}
+
+	m.preferences[preferenceKey{userID: arg.UserID, kind: arg.Type}] = arg.Enabled
+
+	return nil
+}
+
+func (m *Memory) CreateChirpEntity(ctx context.Context, arg database.CreateChirpEntityParams) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.chirps[arg.ChirpID]; !ok {
+		return ErrForeignKeyViolation
+	}
+
+	if arg.UserID.Valid {
+		if _, ok := m.users[arg.UserID.UUID]; !ok {
+			return ErrForeignKeyViolation
+		}
+	}
+
+	for _, entity := range m.entities[arg.ChirpID] {
+		if entity.StartOffset == arg.StartOffset {
+			return ErrUniqueViolation
+		}
+	}
+
+	m.entities[arg.ChirpID] = append(m.entities[arg.ChirpID], database.ChirpEntity(arg))
+
+	return nil
+}
+
+func (m *Memory) ListChirpEntities(ctx context.Context, chirpIds []uuid.UUID) ([]database.ChirpEntity, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var entities []database.ChirpEntity
+	for _, id := range chirpIds {
+		entities = append(entities, m.entities[id]...)
+	}
+
+	sort.SliceStable(entities, func(i, j int) bool {
+		a, b := entities[i], entities[j]
+		if a.ChirpID != b.ChirpID {
+			return bytes.Compare(a.ChirpID[:], b.ChirpID[:]) < 0
+		}
+
+		return a.StartOffset < b.StartOffset
+	})
+
+	return entities, nil
+}
+
+func (m *Memory) DeleteChirpEntities(ctx context.Context, chirpID uuid.UUID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.entities, chirpID)
+
+	return nil
+}
+
+func (m *Memory) ResolveMentions(ctx context.Context, arg database.ResolveMentionsParams) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if arg.UserID.Valid {
+		if _, ok := m.users[arg.UserID.UUID]; !ok {
+			return 0, ErrForeignKeyViolation
+		}
+	}
+
+	var resolved int64
+	for chirpID, entities := range m.entities {
+		if _, blocked := m.blocks[database.BlockUserParams{BlockerID: arg.UserID.UUID, BlockedID: m.chirps[chirpID].UserID}]; blocked {
+			continue
+		}
+
+		for i, entity := range entities {
+			if entity.Type == "mention" && entity.Text == arg.Text && !entity.UserID.Valid {
+				entities[i].UserID = arg.UserID
+				resolved++
+			}
+		}
+	}
+
+	return resolved, nil
+}
+
+func (m *Memory) ListChirpsForHashtag(ctx context.Context, arg database.ListChirpsForHashtagParams) ([]database.Chirp, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.sortedChirps(func(chirp database.Chirp) bool {
+		if chirp.Status != "published" || chirp.DeletedAt.Valid || m.filtered(arg.ViewerID, chirp.UserID) {
+			return false
+		}
+
+		for _, entity := range m.entities[chirp.ID] {
+			if entity.Type == "hashtag" && entity.Text == arg.Text {
+				return true
+			}
+		}
+
+		return false
+	}), nil
+}
+
+func (m *Memory) CreateMedia(ctx context.Context, arg database.CreateMediaParams) (database.Medium, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.users[arg.UserID]; !ok {
+		return database.Medium{}, ErrForeignKeyViolation
+	}
+
+	if _, ok := m.media[arg.ID]; ok {
+		return database.Medium{}, ErrUniqueViolation
+	}
+
+	media := database.Medium{
+		ID:          arg.ID,
+		UserID:      arg.UserID,
+		ContentType: arg.ContentType,
+		Size:        arg.Size,
+		Width:       arg.Width,
+		Height:      arg.Height,
+		CreatedAt:   m.now(),
+	}
+	m.media[media.ID] = media
+
+	return media, nil
+}
+
+func (m *Memory) GetMedia(ctx context.Context, id uuid.UUID) (database.Medium, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	media, ok := m.media[id]
+	if !ok {
+		return database.Medium{}, sql.ErrNoRows
+	}
+
+	return media, nil
+}
+
+func (m *Memory) AttachMedia(ctx context.Context, arg database.AttachMediaParams) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	media, ok := m.media[arg.ID]
+	if !ok || media.UserID != arg.UserID || media.ChirpID.Valid {
+		return 0, nil
+	}
+
+	if arg.ChirpID.Valid {
+		if _, ok := m.chirps[arg.ChirpID.UUID]; !ok {
+			return 0, ErrForeignKeyViolation
+		}
+	}
+
+	media.ChirpID = arg.ChirpID
+	media.Position = arg.Position
+	m.media[media.ID] = media
+
+	return 1, nil
+}
+
+func (m *Memory) ListMediaForChirps(ctx context.Context, chirpIds []uuid.UUID) ([]database.Medium, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	wanted := map[uuid.UUID]bool{}
+	for _, id := range chirpIds {
+		wanted[id] = true
+	}
+
+	var media []database.Medium
+	for _, item := range m.media {
+		if item.ChirpID.Valid && wanted[item.ChirpID.UUID] {
+			media = append(media, item)
+		}
+	}
+
+	sort.Slice(media, func(i, j int) bool {
+		a, b := media[i], media[j]
+		if a.ChirpID.UUID != b.ChirpID.UUID {
+			return bytes.Compare(a.ChirpID.UUID[:], b.ChirpID.UUID[:]) < 0
+		}
+
+		return a.Position < b.Position
+	})
+
+	return media, nil
+}
+
+func (m *Memory) ListUnattachedMediaBefore(ctx context.Context, createdAt time.Time) ([]database.Medium, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var media []database.Medium
+	for _, item := range m.media {
+		if !item.ChirpID.Valid && item.CreatedAt.Before(createdAt) {
+			media = append(media, item)
+		}
+	}
+
+	sort.Slice(media, func(i, j int) bool {
+		return media[i].CreatedAt.Before(media[j].CreatedAt)
+	})
+
+	return media, nil
+}
+
+func (m *Memory) DeleteUnattachedMedia(ctx context.Context, id uuid.UUID) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	media, ok := m.media[id]
+	if !ok || media.ChirpID.Valid {
+		return 0, nil
+	}
+
+	delete(m.media, id)
+
+	return 1, nil
+}
+
+func (m *Memory) UpsertLinkPreview(ctx context.Context, arg database.UpsertLinkPreviewParams) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.linkPreviews[arg.Url] = database.LinkPreview{
+		Url:         arg.Url,
+		Status:      arg.Status,
+		Title:       arg.Title,
+		Description: arg.Description,
+		ImageUrl:    arg.ImageUrl,
+		SiteName:    arg.SiteName,
+		FetchedAt:   m.now(),
+	}
+
+	return nil
+}
+
+func (m *Memory) GetLinkPreview(ctx context.Context, url string) (database.LinkPreview, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	preview, ok := m.linkPreviews[url]
+	if !ok {
+		return database.LinkPreview{}, sql.ErrNoRows
+	}
+
+	return preview, nil
+}
+
+func (m *Memory) ListLinkPreviews(ctx context.Context, urls []string) ([]database.LinkPreview, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	wanted := map[string]bool{}
+	for _, url := range urls {
+		wanted[url] = true
+	}
+
+	var previews []database.LinkPreview
+	for url, preview := range m.linkPreviews {
+		if wanted[url] {
+			previews = append(previews, preview)
+		}
+	}
+
+	return previews, nil
+}
+
+func (m *Memory) CreateReport(ctx context.Context, arg database.CreateReportParams) (database.Report, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.users[arg.UserID]; !ok {
+		return database.Report{}, ErrForeignKeyViolation
+	}
+	if _, ok := m.users[arg.ReporterID.UUID]; arg.ReporterID.Valid && !ok {
+		return database.Report{}, ErrForeignKeyViolation
+	}
+	if _, ok := m.chirps[arg.ChirpID.UUID]; arg.ChirpID.Valid && !ok {
+		return database.Report{}, ErrForeignKeyViolation
+	}
+
+	report := database.Report{
+		ID:         uuid.New(),
+		ReporterID: arg.ReporterID,
+		UserID:     arg.UserID,
+		ChirpID:    arg.ChirpID,
+		ChirpBody:  arg.ChirpBody,
+		Reason:     arg.Reason,
+		Details:    arg.Details,
+		CreatedAt:  m.now(),
+	}
+	m.reports[report.ID] = report
+
+	return report, nil
+}
+
+func (m *Memory) GetReport(ctx context.Context, id uuid.UUID) (database.Report, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	report, ok := m.reports[id]
+	if !ok {
+		return database.Report{}, sql.ErrNoRows
+	}
+
+	return report, nil
+}
+
+func (m *Memory) ListOpenReports(ctx context.Context, limit int32) ([]database.Report, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	reports := []database.Report{}
+	for _, report := range m.reports {
+		if !report.ResolvedAt.Valid {
+			reports = append(reports, report)
+		}
+	}
+
+	sort.Slice(reports, func(i, j int) bool {
+		if reports[i].CreatedAt.Equal(reports[j].CreatedAt) {
+			return reports[i].ID.String() < reports[j].ID.String()
+		}
+
+		return reports[i].CreatedAt.Before(reports[j].CreatedAt)
+	})
+
+	return reports[:min(len(reports), int(limit))], nil
+}
+
+func (m *Memory) ResolveReport(ctx context.Context, id uuid.UUID) (database.Report, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	report, ok := m.reports[id]
+	if !ok || report.ResolvedAt.Valid {
+		return database.Report{}, sql.ErrNoRows
+	}
+
+	report.ResolvedAt = sql.NullTime{Time: m.now(), Valid: true}
+	m.reports[id] = report
+
+	return report, nil
+}
+
+func (m *Memory) CreateModerationAction(ctx context.Context, arg database.CreateModerationActionParams) (database.ModerationAction, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.reports[arg.ReportID.UUID]; arg.ReportID.Valid && !ok {
+		return database.ModerationAction{}, ErrForeignKeyViolation
+	}
+
+	action := database.ModerationAction{
+		ID:          uuid.New(),
+		ModeratorID: arg.ModeratorID,
+		UserID:      arg.UserID,
+		ChirpID:     arg.ChirpID,
+		ReportID:    arg.ReportID,
+		Action:      arg.Action,
+		Note:        arg.Note,
+		CreatedAt:   m.now(),
+	}
+	m.moderationActions = append(m.moderationActions, action)
+
+	return action, nil
+}
+
+func (m *Memory) ListModerationActions(ctx context.Context, arg database.ListModerationActionsParams) ([]database.ModerationAction, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	actions := []database.ModerationAction{}
+	for i := len(m.moderationActions) - 1; i >= 0 && len(actions) < int(arg.LimitCount); i-- {
+		action := m.moderationActions[i]
+		if !arg.UserID.Valid || action.UserID == arg.UserID.UUID {
+			actions = append(actions, action)
+		}
+	}
+
+	return actions, nil
+}
+
+func (m *Memory) BookmarkChirp(ctx context.Context, arg database.BookmarkChirpParams) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	_, userExists := m.users[arg.UserID]
+	_, chirpExists := m.chirps[arg.ChirpID]
+	if !userExists || !chirpExists {
+		return 0, ErrForeignKeyViolation
+	}
+
+	if _, ok := m.bookmarks[arg]; ok {
+		return 0, nil
+	}
+
+	m.bookmarks[arg] = m.now()
+
+	return 1, nil
+}
+
+func (m *Memory) UnbookmarkChirp(ctx context.Context, arg database.UnbookmarkChirpParams) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.bookmarks, database.BookmarkChirpParams(arg))
+
+	return nil
+}
+
+func (m *Memory) GetBookmark(ctx context.Context, arg database.GetBookmarkParams) (database.Bookmark, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	createdAt, ok := m.bookmarks[database.BookmarkChirpParams(arg)]
+	if !ok {
+		return database.Bookmark{}, sql.ErrNoRows
+	}
+
+	return database.Bookmark{UserID: arg.UserID, ChirpID: arg.ChirpID, CreatedAt: createdAt}, nil
+}
+
+func (m *Memory) ListBookmarkedChirps(ctx context.Context, arg database.ListBookmarkedChirpsParams) ([]database.Chirp, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.bookmarkedChirps(arg.UserID, arg.Limit, func(database.Bookmark) bool { return true }), nil
+}
+
+func (m *Memory) ListBookmarkedChirpsBefore(ctx context.Context, arg database.ListBookmarkedChirpsBeforeParams) ([]database.Chirp, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.bookmarkedChirps(arg.UserID, arg.Limit, func(b database.Bookmark) bool {
+		if b.CreatedAt.Equal(arg.CreatedAt) {
+			return bytes.Compare(b.ChirpID[:], arg.ChirpID[:]) < 0
+		}
+
+		return b.CreatedAt.Before(arg.CreatedAt)
+	}), nil
+}
+
+// bookmarkedChirps returns up to limit of the visible chirps a user
+// bookmarked, for bookmarks that match keep, newest bookmark first with the
+// chirp ID as a tie breaker.
+func (m *Memory) bookmarkedChirps(userID uuid.UUID, limit int32, keep func(database.Bookmark) bool) []database.Chirp {
+	var bookmarks []database.Bookmark
+	for key, createdAt := range m.bookmarks {
+		bookmark := database.Bookmark{UserID: key.UserID, ChirpID: key.ChirpID, CreatedAt: createdAt}
+		if bookmark.UserID != userID || !keep(bookmark) {
+			continue
+		}
+
+		chirp := m.chirps[bookmark.ChirpID]
+		if chirp.Status != "published" || chirp.DeletedAt.Valid || m.blocked(chirp.UserID, userID) {
+			continue
+		}
+
+		bookmarks = append(bookmarks, bookmark)
+	}
+
+	sort.Slice(bookmarks, func(i, j int) bool {
+		a, b := bookmarks[i], bookmarks[j]
+		if !a.CreatedAt.Equal(b.CreatedAt) {
+			return a.CreatedAt.After(b.CreatedAt)
+		}
+
+		return bytes.Compare(a.ChirpID[:], b.ChirpID[:]) > 0
+	})
+
+	if len(bookmarks) > int(limit) {
+		bookmarks = bookmarks[:limit]
+	}
+
+	chirps := []database.Chirp{}
+	for _, bookmark := range bookmarks {
+		chirps = append(chirps, m.chirps[bookmark.ChirpID])
+	}
+
+	return chirps
+}
+
+func (m *Memory) PinChirp(ctx context.Context, arg database.PinChirpParams) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	_, userExists := m.users[arg.UserID]
+	_, chirpExists := m.chirps[arg.ChirpID]
+	if !userExists || !chirpExists {
+		return ErrForeignKeyViolation
+	}
+
+	m.pinned[arg.UserID] = arg.ChirpID
+
+	return nil
+}
+
+func (m *Memory) UnpinChirp(ctx context.Context, arg database.UnpinChirpParams) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	chirpID, ok := m.pinned[arg.UserID]
+	if !ok || chirpID != arg.ChirpID {
+		return 0, nil
+	}
+
+	delete(m.pinned, arg.UserID)
+
+	return 1, nil
+}
+
+func (m *Memory) GetPinnedChirp(ctx context.Context, userID uuid.UUID) (database.Chirp, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	chirpID, ok := m.pinned[userID]
+	if !ok {
+		return database.Chirp{}, sql.ErrNoRows
+	}
+
+	chirp := m.chirps[chirpID]
+	if chirp.DeletedAt.Valid {
+		return database.Chirp{}, sql.ErrNoRows
+	}
+
+	return chirp, nil
+}
+
+type pollVoteKey struct {
+	chirpID uuid.UUID
+	userID  uuid.UUID
+}
+
+func (m *Memory) CreatePoll(ctx context.Context, arg database.CreatePollParams) (database.Poll, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.chirps[arg.ChirpID]; !ok {
+		return database.Poll{}, ErrForeignKeyViolation
+	}
+	if _, ok := m.polls[arg.ChirpID]; ok {
+		return database.Poll{}, ErrUniqueViolation
+	}
+
+	poll := database.Poll{
+		ChirpID:   arg.ChirpID,
+		ClosesAt:  arg.ClosesAt,
+		CreatedAt: m.now(),
+	}
+	m.polls[poll.ChirpID] = poll
+
+	return poll, nil
+}
+
+func (m *Memory) CreatePollOption(ctx context.Context, arg database.CreatePollOptionParams) (database.PollOption, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.polls[arg.ChirpID]; !ok {
+		return database.PollOption{}, ErrForeignKeyViolation
+	}
+	for _, option := range m.pollOptions {
+		if option.ChirpID == arg.ChirpID && option.Position == arg.Position {
+			return database.PollOption{}, ErrUniqueViolation
+		}
+	}
+
+	option := database.PollOption{
+		ID:       uuid.New(),
+		ChirpID:  arg.ChirpID,
+		Position: arg.Position,
+		Text:     arg.Text,
+	}
+	m.pollOptions[option.ID] = option
+
+	return option, nil
+}
+
+func (m *Memory) ListPollsForChirps(ctx context.Context, chirpIds []uuid.UUID) ([]database.Poll, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var polls []database.Poll
+	for _, id := range chirpIds {
+		if poll, ok := m.polls[id]; ok {
+			polls = append(polls, poll)
+		}
+	}
+
+	return polls, nil
+}
+
+func (m *Memory) ListPollOptionsForChirps(ctx context.Context, chirpIds []uuid.UUID) ([]database.ListPollOptionsForChirpsRow, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	wanted := map[uuid.UUID]bool{}
+	for _, id := range chirpIds {
+		wanted[id] = true
+	}
+
+	votes := map[uuid.UUID]int64{}
+	for _, vote := range m.pollVotes {
+		votes[vote.OptionID]++
+	}
+
+	var options []database.ListPollOptionsForChirpsRow
+	for _, option := range m.pollOptions {
+		if wanted[option.ChirpID] {
+			options = append(options, database.ListPollOptionsForChirpsRow{
+				ID:       option.ID,
+				ChirpID:  option.ChirpID,
+				Position: option.Position,
+				Text:     option.Text,
+				Votes:    votes[option.ID],
+			})
+		}
+	}
+
+	sort.Slice(options, func(i, j int) bool {
+		a, b := options[i], options[j]
+		if a.ChirpID != b.ChirpID {
+			return bytes.Compare(a.ChirpID[:], b.ChirpID[:]) < 0
+		}
+
+		return a.Position < b.Position
+	})
+
+	return options, nil
+}
+
+func (m *Memory) ListPollVotesForUser(ctx context.Context, arg database.ListPollVotesForUserParams) ([]database.PollVote, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var votes []database.PollVote
+	for _, id := range arg.ChirpIds {
+		if vote, ok := m.pollVotes[pollVoteKey{chirpID: id, userID: arg.UserID}]; ok {
+			votes = append(votes, vote)
+		}
+	}
+
+	return votes, nil
+}
+
+func (m *Memory) VotePoll(ctx context.Context, arg database.VotePollParams) (database.PollVote, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+
+	poll, ok := m.polls[arg.ChirpID]
+	if !ok || !poll.ClosesAt.After(now) {
+		return database.PollVote{}, sql.ErrNoRows
+	}
+
+	key := pollVoteKey{chirpID: arg.ChirpID, userID: arg.UserID}
+	if _, ok := m.pollVotes[key]; ok {
+		return database.PollVote{}, ErrUniqueViolation
+	}
+
+	option, ok := m.pollOptions[arg.OptionID]
+	if _, userExists := m.users[arg.UserID]; !userExists || !ok || option.ChirpID != arg.ChirpID {
+		return database.PollVote{}, ErrForeignKeyViolation
+	}
+
+	vote := database.PollVote{
+		ChirpID:   arg.ChirpID,
+		UserID:    arg.UserID,
+		OptionID:  arg.OptionID,
+		CreatedAt: now,
+	}
+	m.pollVotes[key] = vote
+
+	return vote, nil
+}
@@ -0,0 +1,1529 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/vemolista/chirpy/v2/internal/database"
+)
+
+// SQLite is a Store backed by a SQLite database, for local development
+// without a Postgres server. Its schema mirrors sql/migrations but is
+// created in one step by OpenSQLite instead of through goose.
+type SQLite struct {
+	db *DB
+}
+
+var _ Store = (*SQLite)(nil)
+
+const sqliteSchema = `
+create table if not exists users (
+    id text primary key,
+    created_at timestamp not null,
+    updated_at timestamp not null,
+    email text unique not null,
+    hashed_password text not null default 'unset',
+    is_chirpy_red boolean not null default false,
+    username text unique,
+    display_name text not null default '',
+    bio text not null default '',
+    avatar_url text not null default '',
+    role text not null default 'user',
+    suspended_at timestamp
+);
+
+create table if not exists chirps (
+    id text primary key,
+    created_at timestamp not null,
+    updated_at timestamp not null,
+    user_id text not null references users(id) on delete cascade,
+    body text not null,
+    status text not null default 'published',
+    publish_at timestamp,
+    deleted_at timestamp,
+    hidden boolean not null default false
+);
+
+create index if not exists chirps_scheduled on chirps (publish_at) where status = 'scheduled';
+create index if not exists chirps_deleted on chirps (deleted_at) where deleted_at is not null;
+
+create table if not exists refresh_tokens (
+    token text primary key,
+    created_at timestamp not null,
+    updated_at timestamp not null,
+    user_id text not null references users(id) on delete cascade,
+    expires_at timestamp not null,
+    revoked_at timestamp
+);
+
+create table if not exists follows (
+    follower_id text not null references users(id) on delete cascade,
+    followee_id text not null references users(id) on delete cascade,
+    created_at timestamp not null,
+    primary key (follower_id, followee_id)
+);
+
+create table if not exists blocks (
+    blocker_id text not null references users(id) on delete cascade,
+    blocked_id text not null references users(id) on delete cascade,
+    created_at timestamp not null,
+    primary key (blocker_id, blocked_id)
+);
+
+create index if not exists blocks_blocked on blocks (blocked_id, blocker_id);
+
+create table if not exists bookmarks (
+    user_id text not null references users(id) on delete cascade,
+    chirp_id text not null references chirps(id) on delete cascade,
+    created_at timestamp not null,
+    primary key (user_id, chirp_id)
+);
+
+create table if not exists pinned_chirps (
+    user_id text primary key references users(id) on delete cascade,
+    chirp_id text not null references chirps(id) on delete cascade,
+    created_at timestamp not null
+);
+
+create table if not exists polls (
+    chirp_id text primary key references chirps(id) on delete cascade,
+    closes_at timestamp not null,
+    created_at timestamp not null
+);
+
+create table if not exists poll_options (
+    id text primary key,
+    chirp_id text not null references polls(chirp_id) on delete cascade,
+    position integer not null,
+    text text not null,
+    unique (chirp_id, position),
+    unique (chirp_id, id)
+);
+
+create table if not exists poll_votes (
+    chirp_id text not null references polls(chirp_id) on delete cascade,
+    user_id text not null references users(id) on delete cascade,
+    option_id text not null,
+    created_at timestamp not null,
+    primary key (chirp_id, user_id),
+    foreign key (chirp_id, option_id) references poll_options(chirp_id, id) on delete cascade
+);
+
+create index if not exists poll_votes_option on poll_votes (option_id);
+
+create table if not exists mutes (
+    muter_id text not null references users(id) on delete cascade,
+    muted_id text not null references users(id) on delete cascade,
+    created_at timestamp not null,
+    primary key (muter_id, muted_id)
+);
+
+create table if not exists chirp_events (
+    id integer primary key autoincrement,
+    type text not null,
+    user_id text not null references users(id) on delete cascade,
+    data text not null,
+    created_at timestamp not null
+);
+
+create table if not exists notifications (
+    id text primary key,
+    user_id text not null references users(id) on delete cascade,
+    actor_id text not null references users(id) on delete cascade,
+    type text not null,
+    chirp_id text references chirps(id) on delete cascade,
+    created_at timestamp not null,
+    read_at timestamp
+);
+
+create index if not exists notifications_inbox on notifications (user_id, created_at desc, id desc);
+
+create table if not exists notification_preferences (
+    user_id text not null references users(id) on delete cascade,
+    type text not null,
+    enabled boolean not null,
+    primary key (user_id, type)
+);
+
+create table if not exists chirp_entities (
+    chirp_id text not null references chirps(id) on delete cascade,
+    type text not null,
+    start_offset integer not null,
+    end_offset integer not null,
+    text text not null,
+    user_id text references users(id) on delete set null,
+    primary key (chirp_id, start_offset)
+);
+
+create index if not exists chirp_entities_text on chirp_entities (type, text);
+
+create table if not exists media (
+    id text primary key,
+    user_id text not null references users(id) on delete cascade,
+    chirp_id text references chirps(id) on delete set null,
+    position integer not null default 0,
+    content_type text not null,
+    size integer not null,
+    width integer not null,
+    height integer not null,
+    created_at timestamp not null
+);
+
+create index if not exists media_chirp on media (chirp_id, position);
+
+create table if not exists link_previews (
+    url text primary key,
+    status text not null,
+    title text not null default '',
+    description text not null default '',
+    image_url text not null default '',
+    site_name text not null default '',
+    fetched_at timestamp not null
+);
+
+create table if not exists reports (
+    id text primary key,
+    reporter_id text references users(id) on delete cascade,
+    user_id text not null references users(id) on delete cascade,
+    chirp_id text references chirps(id) on delete set null,
+    chirp_body text not null default '',
+    reason text not null,
+    details text not null default '',
+    created_at timestamp not null,
+    resolved_at timestamp
+);
+
+create index if not exists reports_open on reports (created_at) where resolved_at is null;
+
+create table if not exists moderation_actions (
+    id text primary key,
+    moderator_id text not null,
+    user_id text not null,
+    chirp_id text,
+    report_id text references reports(id) on delete set null,
+    action text not null,
+    note text not null default '',
+    created_at timestamp not null
+);
+
+create index if not exists moderation_actions_user on moderation_actions (user_id, created_at);
+
+create table if not exists idempotency_keys (
+    scope text not null,
+    key text not null,
+    request_hash text not null,
+    created_at timestamp not null,
+    expires_at timestamp not null,
+    response_status integer,
+    response_body blob,
+    response_headers text,
+    primary key (scope, key)
+);
+`
+
+// OpenSQLite opens the database at path, which may be ":memory:", and
+// creates the schema if it does not exist yet.
+func OpenSQLite(ctx context.Context, path string) (*sql.DB, error) {
+	db, err := sql.Open("sqlite3", fmt.Sprintf("file:%s?_foreign_keys=on&_busy_timeout=5000", path))
+	if err != nil {
+		return nil, fmt.Errorf("error opening sqlite database: %w", err)
+	}
+
+	// SQLite allows a single writer; sharing one connection also keeps
+	// ":memory:" databases from being created per connection.
+	db.SetMaxOpenConns(1)
+
+	_, err = db.ExecContext(ctx, sqliteSchema)
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("error creating sqlite schema: %w", err)
+	}
+
+	return db, nil
+}
+
+func NewSQLite(db *DB) *SQLite {
+	return &SQLite{db: db}
+}
+
+func (s *SQLite) InTx(ctx context.Context, fn func(ctx context.Context) error) error {
+	return s.db.InTx(ctx, fn)
+}
+
+func now() time.Time {
+	return time.Now().UTC()
+}
+
+const userColumns = "id, created_at, updated_at, email, hashed_password, is_chirpy_red, username, display_name, bio, avatar_url, role, suspended_at"
+
+func scanUser(row interface{ Scan(...any) error }) (database.User, error) {
+	var i database.User
+	err := row.Scan(&i.ID, &i.CreatedAt, &i.UpdatedAt, &i.Email, &i.HashedPassword, &i.IsChirpyRed,
+		&i.Username, &i.DisplayName, &i.Bio, &i.AvatarUrl, &i.Role, &i.SuspendedAt)
+	return i, err
+}
+
+func (s *SQLite) CreateUser(ctx context.Context, arg database.CreateUserParams) (database.User, error) {
+	now := now()
+	row := s.db.QueryRowContext(ctx, `-- name: CreateUser :one
+insert into users (id, created_at, updated_at, email, hashed_password, username)
+values (?, ?, ?, ?, ?, ?)
+returning `+userColumns, uuid.New(), now, now, arg.Email, arg.HashedPassword, arg.Username)
+
+	return scanUser(row)
+}
+
+func (s *SQLite) GetUser(ctx context.Context, id uuid.UUID) (database.User, error) {
+	row := s.db.QueryRowContext(ctx, `-- name: GetUser :one
+select `+userColumns+` from users where id = ?`, id)
+
+	return scanUser(row)
+}
+
+func (s *SQLite) GetUserByEmail(ctx context.Context, email string) (database.User, error) {
+	row := s.db.QueryRowContext(ctx, `-- name: GetUserByEmail :one
+select `+userColumns+` from users where email = ?`, email)
+
+	return scanUser(row)
+}
+
+func (s *SQLite) GetUserByUsername(ctx context.Context, username sql.NullString) (database.User, error) {
+	row := s.db.QueryRowContext(ctx, `-- name: GetUserByUsername :one
+select `+userColumns+` from users where username = ?`, username)
+
+	return scanUser(row)
+}
+
+func (s *SQLite) ListUsersByIDs(ctx context.Context, ids []uuid.UUID) ([]database.User, error) {
+	var users []database.User
+
+	for len(ids) > 0 {
+		batch := ids[:min(len(ids), sqliteMaxParams)]
+		ids = ids[len(batch):]
+
+		args := make([]any, len(batch))
+		for i, id := range batch {
+			args[i] = id
+		}
+
+		rows, err := s.db.QueryContext(ctx, `-- name: ListUsersByIDs :many
+select `+userColumns+` from users
+where id in (?`+strings.Repeat(", ?", len(batch)-1)+`)`, args...)
+		if err != nil {
+			return nil, err
+		}
+
+		for rows.Next() {
+			user, err := scanUser(rows)
+			if err != nil {
+				rows.Close()
+				return nil, err
+			}
+			users = append(users, user)
+		}
+
+		err = rows.Close()
+		if err == nil {
+			err = rows.Err()
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return users, nil
+}
+
+func (s *SQLite) UpdateUser(ctx context.Context, arg database.UpdateUserParams) (database.User, error) {
+	row := s.db.QueryRowContext(ctx, `-- name: UpdateUser :one
+update users set email = ?, hashed_password = ?, updated_at = ?
+where id = ?
+returning `+userColumns, arg.Email, arg.HashedPassword, now(), arg.ID)
+
+	return scanUser(row)
+}
+
+func (s *SQLite) UpdateUserProfile(ctx context.Context, arg database.UpdateUserProfileParams) (database.User, error) {
+	row := s.db.QueryRowContext(ctx, `-- name: UpdateUserProfile :one
+update users set username = ?, display_name = ?, bio = ?, avatar_url = ?, updated_at = ?
+where id = ?
+returning `+userColumns, arg.Username, arg.DisplayName, arg.Bio, arg.AvatarUrl, now(), arg.ID)
+
+	return scanUser(row)
+}
+
+func (s *SQLite) GetUserCounts(ctx context.Context, userID uuid.UUID) (database.GetUserCountsRow, error) {
+	row := s.db.QueryRowContext(ctx, `-- name: GetUserCounts :one
+select
+    (select count(*) from chirps where user_id = ?1 and status = 'published' and deleted_at is null) as chirp_count,
+    (select count(*) from follows where followee_id = ?1) as follower_count,
+    (select count(*) from follows where follower_id = ?1) as following_count`, userID)
+
+	var i database.GetUserCountsRow
+	err := row.Scan(&i.ChirpCount, &i.FollowerCount, &i.FollowingCount)
+	return i, err
+}
+
+func (s *SQLite) DeleteUsers(ctx context.Context) error {
+	_, err := s.db.ExecContext(ctx, `-- name: DeleteUsers :exec
+delete from moderation_actions;
+delete from users`)
+
+	return err
+}
+
+func (s *SQLite) UpgradeToChirpyRed(ctx context.Context, id uuid.UUID) (database.User, error) {
+	row := s.db.QueryRowContext(ctx, `-- name: UpgradeToChirpyRed :one
+update users set is_chirpy_red = true
+where id = ?
+returning `+userColumns, id)
+
+	return scanUser(row)
+}
+
+func (s *SQLite) SetUserRole(ctx context.Context, arg database.SetUserRoleParams) (database.User, error) {
+	row := s.db.QueryRowContext(ctx, `-- name: SetUserRole :one
+update users set role = ?, updated_at = ?
+where id = ?
+returning `+userColumns, arg.Role, now(), arg.ID)
+
+	return scanUser(row)
+}
+
+func (s *SQLite) SuspendUser(ctx context.Context, id uuid.UUID) (database.User, error) {
+	row := s.db.QueryRowContext(ctx, `-- name: SuspendUser :one
+update users set suspended_at = coalesce(suspended_at, ?)
+where id = ?
+returning `+userColumns, now(), id)
+
+	return scanUser(row)
+}
+
+const chirpColumns = "id, created_at, updated_at, user_id, body, status, publish_at, deleted_at, hidden"
+
+// qualifiedChirpColumns are chirpColumns prefixed with the table name, for
+// queries that join chirps with tables sharing column names.
+var qualifiedChirpColumns = "chirps." + strings.ReplaceAll(chirpColumns, ", ", ", chirps.")
+
+func scanChirp(row interface{ Scan(...any) error }) (database.Chirp, error) {
+	var i database.Chirp
+	err := row.Scan(&i.ID, &i.CreatedAt, &i.UpdatedAt, &i.UserID, &i.Body, &i.Status, &i.PublishAt, &i.DeletedAt, &i.Hidden)
+	return i, err
+}
+
+func (s *SQLite) queryChirps(ctx context.Context, query string, args ...any) ([]database.Chirp, error) {
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	chirps := []database.Chirp{}
+	for rows.Next() {
+		chirp, err := scanChirp(rows)
+		if err != nil {
+			return nil, err
+		}
+		chirps = append(chirps, chirp)
+	}
+
+	return chirps, rows.Err()
+}
+
+func (s *SQLite) CreateChirp(ctx context.Context, arg database.CreateChirpParams) (database.Chirp, error) {
+	now := now()
+	publishAt := arg.PublishAt
+	publishAt.Time = publishAt.Time.UTC()
+	row := s.db.QueryRowContext(ctx, `-- name: CreateChirp :one
+insert into chirps (id, created_at, updated_at, body, user_id, status, publish_at)
+values (?, ?, ?, ?, ?, ?, ?)
+returning `+chirpColumns, uuid.New(), now, now, arg.Body, arg.UserID, arg.Status, publishAt)
+
+	return scanChirp(row)
+}
+
+func (s *SQLite) ListChirps(ctx context.Context, viewerID uuid.UUID) ([]database.Chirp, error) {
+	return s.queryChirps(ctx, `-- name: ListChirps :many
+select `+chirpColumns+` from chirps
+where status = 'published' and deleted_at is null
+and not exists (select 1 from blocks where blocker_id = chirps.user_id and blocked_id = ?1)
+and not exists (select 1 from mutes where muter_id = ?1 and muted_id = chirps.user_id)
+order by created_at asc, id asc`, viewerID)
+}
+
+func (s *SQLite) ListChirpsForAuthor(ctx context.Context, arg database.ListChirpsForAuthorParams) ([]database.Chirp, error) {
+	return s.queryChirps(ctx, `-- name: ListChirpsForAuthor :many
+select `+chirpColumns+` from chirps
+where user_id = ?1 and status = 'published' and deleted_at is null
+and not exists (select 1 from blocks where blocker_id = ?1 and blocked_id = ?2)
+order by created_at asc, id asc`, arg.UserID, arg.ViewerID)
+}
+
+func (s *SQLite) ListUnpublishedChirps(ctx context.Context, userID uuid.UUID) ([]database.Chirp, error) {
+	return s.queryChirps(ctx, `-- name: ListUnpublishedChirps :many
+select `+chirpColumns+` from chirps
+where user_id = ? and status <> 'published' and deleted_at is null
+order by created_at asc, id asc`, userID)
+}
+
+func (s *SQLite) GetChirp(ctx context.Context, id uuid.UUID) (database.Chirp, error) {
+	row := s.db.QueryRowContext(ctx, `-- name: GetChirp :one
+select `+chirpColumns+` from chirps where id = ? and deleted_at is null`, id)
+
+	return scanChirp(row)
+}
+
+func (s *SQLite) GetDeletedChirp(ctx context.Context, id uuid.UUID) (database.Chirp, error) {
+	row := s.db.QueryRowContext(ctx, `-- name: GetDeletedChirp :one
+select `+chirpColumns+` from chirps where id = ? and deleted_at is not null`, id)
+
+	return scanChirp(row)
+}
+
+func (s *SQLite) DeleteChirp(ctx context.Context, id uuid.UUID) error {
+	_, err := s.db.ExecContext(ctx, `-- name: DeleteChirp :exec
+delete from chirps where id = ?`, id)
+
+	return err
+}
+
+func (s *SQLite) UpdateChirp(ctx context.Context, arg database.UpdateChirpParams) (database.Chirp, error) {
+	row := s.db.QueryRowContext(ctx, `-- name: UpdateChirp :one
+update chirps set body = ?, updated_at = ?
+where id = ? and updated_at = ? and deleted_at is null
+returning `+chirpColumns, arg.Body, now(), arg.ID, arg.UpdatedAt.UTC())
+
+	return scanChirp(row)
+}
+
+func (s *SQLite) PublishChirp(ctx context.Context, arg database.PublishChirpParams) (database.Chirp, error) {
+	now := now()
+	row := s.db.QueryRowContext(ctx, `-- name: PublishChirp :one
+update chirps set status = 'published', created_at = ?, updated_at = ?
+where id = ? and updated_at = ? and status <> 'published' and deleted_at is null
+returning `+chirpColumns, now, now, arg.ID, arg.UpdatedAt.UTC())
+
+	return scanChirp(row)
+}
+
+// PublishDueChirps has no skip locked to share the work with: SQLite has a
+// single writer, so the update alone publishes each chirp once.
+func (s *SQLite) PublishDueChirps(ctx context.Context, arg database.PublishDueChirpsParams) ([]database.Chirp, error) {
+	now := now()
+	return s.queryChirps(ctx, `-- name: PublishDueChirps :many
+update chirps set status = 'published', created_at = ?, updated_at = ?
+where id in (
+    select id from chirps
+    where status = 'scheduled' and publish_at <= ? and deleted_at is null
+    order by publish_at
+    limit ?
+)
+returning `+chirpColumns, now, now, arg.Now.UTC(), arg.Limit)
+}
+
+func (s *SQLite) SoftDeleteChirp(ctx context.Context, arg database.SoftDeleteChirpParams) (int64, error) {
+	result, err := s.db.ExecContext(ctx, `-- name: SoftDeleteChirp :execrows
+update chirps set deleted_at = ?
+where id = ? and updated_at = ? and deleted_at is null`, now(), arg.ID, arg.UpdatedAt.UTC())
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+func (s *SQLite) RestoreChirp(ctx context.Context, arg database.RestoreChirpParams) (database.Chirp, error) {
+	row := s.db.QueryRowContext(ctx, `-- name: RestoreChirp :one
+update chirps set deleted_at = null
+where id = ? and deleted_at > ? and not hidden
+returning `+chirpColumns, arg.ID, arg.DeletedAfter.UTC())
+
+	return scanChirp(row)
+}
+
+func (s *SQLite) HideChirp(ctx context.Context, id uuid.UUID) (database.Chirp, error) {
+	row := s.db.QueryRowContext(ctx, `-- name: HideChirp :one
+update chirps set hidden = true, deleted_at = coalesce(deleted_at, ?)
+where id = ?
+returning `+chirpColumns, now(), id)
+
+	return scanChirp(row)
+}
+
+func (s *SQLite) PurgeDeletedChirps(ctx context.Context, before time.Time) (int64, error) {
+	result, err := s.db.ExecContext(ctx, `-- name: PurgeDeletedChirps :execrows
+delete from chirps where deleted_at < ?`, before.UTC())
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+const refreshTokenColumns = "token, created_at, updated_at, user_id, expires_at, revoked_at"
+
+func scanRefreshToken(row interface{ Scan(...any) error }) (database.RefreshToken, error) {
+	var i database.RefreshToken
+	err := row.Scan(&i.Token, &i.CreatedAt, &i.UpdatedAt, &i.UserID, &i.ExpiresAt, &i.RevokedAt)
+	return i, err
+}
+
+func (s *SQLite) CreateRefreshToken(ctx context.Context, arg database.CreateRefreshTokenParams) (database.RefreshToken, error) {
+	now := now()
+	row := s.db.QueryRowContext(ctx, `-- name: CreateRefreshToken :one
+insert into refresh_tokens (token, created_at, updated_at, user_id, expires_at)
+values (?, ?, ?, ?, ?)
+returning `+refreshTokenColumns, arg.Token, now, now, arg.UserID, arg.ExpiresAt.UTC())
+
+	return scanRefreshToken(row)
+}
+
+func (s *SQLite) GetRefreshToken(ctx context.Context, token string) (database.RefreshToken, error) {
+	row := s.db.QueryRowContext(ctx, `-- name: GetRefreshToken :one
+select `+refreshTokenColumns+` from refresh_tokens where token = ?`, token)
+
+	return scanRefreshToken(row)
+}
+
+func (s *SQLite) RevokeToken(ctx context.Context, token string) error {
+	now := now()
+	_, err := s.db.ExecContext(ctx, `-- name: RevokeToken :exec
+update refresh_tokens set updated_at = ?, revoked_at = ? where token = ?`, now, now, token)
+
+	return err
+}
+
+const idempotencyKeyColumns = "scope, key, request_hash, created_at, expires_at, response_status, response_body, response_headers"
+
+func scanIdempotencyKey(row interface{ Scan(...any) error }) (database.IdempotencyKey, error) {
+	var i database.IdempotencyKey
+	err := row.Scan(&i.Scope, &i.Key, &i.RequestHash, &i.CreatedAt, &i.ExpiresAt, &i.ResponseStatus, &i.ResponseBody, &i.ResponseHeaders)
+	return i, err
+}
+
+func (s *SQLite) CreateIdempotencyKey(ctx context.Context, arg database.CreateIdempotencyKeyParams) (database.IdempotencyKey, error) {
+	row := s.db.QueryRowContext(ctx, `-- name: CreateIdempotencyKey :one
+insert into idempotency_keys (scope, key, request_hash, created_at, expires_at)
+values (?, ?, ?, ?, ?)
+returning `+idempotencyKeyColumns, arg.Scope, arg.Key, arg.RequestHash, now(), arg.ExpiresAt.UTC())
+
+	return scanIdempotencyKey(row)
+}
+
+func (s *SQLite) GetIdempotencyKey(ctx context.Context, arg database.GetIdempotencyKeyParams) (database.IdempotencyKey, error) {
+	row := s.db.QueryRowContext(ctx, `-- name: GetIdempotencyKey :one
+select `+idempotencyKeyColumns+` from idempotency_keys where scope = ? and key = ?`, arg.Scope, arg.Key)
+
+	return scanIdempotencyKey(row)
+}
+
+func (s *SQLite) CompleteIdempotencyKey(ctx context.Context, arg database.CompleteIdempotencyKeyParams) error {
+	_, err := s.db.ExecContext(ctx, `-- name: CompleteIdempotencyKey :exec
+update idempotency_keys set response_status = ?, response_body = ?, response_headers = ?
+where scope = ? and key = ?`, arg.ResponseStatus, arg.ResponseBody, arg.ResponseHeaders, arg.Scope, arg.Key)
+
+	return err
+}
+
+func (s *SQLite) DeleteIdempotencyKey(ctx context.Context, arg database.DeleteIdempotencyKeyParams) error {
+	_, err := s.db.ExecContext(ctx, `-- name: DeleteIdempotencyKey :exec
+delete from idempotency_keys where scope = ? and key = ?`, arg.Scope, arg.Key)
+
+	return err
+}
+
+func (s *SQLite) DeleteExpiredIdempotencyKeys(ctx context.Context, expiresAt time.Time) error {
+	_, err := s.db.ExecContext(ctx, `-- name: DeleteExpiredIdempotencyKeys :exec
+delete from idempotency_keys where expires_at < ?`, expiresAt.UTC())
+
+	return err
+}
+
+func (s *SQLite) FollowUser(ctx context.Context, arg database.FollowUserParams) (int64, error) {
+	result, err := s.db.ExecContext(ctx, `-- name: FollowUser :execrows
+insert into follows (follower_id, followee_id, created_at)
+values (?, ?, ?)
+on conflict (follower_id, followee_id) do nothing`, arg.FollowerID, arg.FolloweeID, now())
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+func (s *SQLite) UnfollowUser(ctx context.Context, arg database.UnfollowUserParams) error {
+	_, err := s.db.ExecContext(ctx, `-- name: UnfollowUser :exec
+delete from follows where follower_id = ? and followee_id = ?`, arg.FollowerID, arg.FolloweeID)
+
+	return err
+}
+
+func (s *SQLite) ListFollowees(ctx context.Context, followerID uuid.UUID) ([]uuid.UUID, error) {
+	rows, err := s.db.QueryContext(ctx, `-- name: ListFollowees :many
+select followee_id from follows where follower_id = ? order by created_at asc`, followerID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var followees []uuid.UUID
+	for rows.Next() {
+		var followee uuid.UUID
+		err := rows.Scan(&followee)
+		if err != nil {
+			return nil, err
+		}
+		followees = append(followees, followee)
+	}
+
+	return followees, rows.Err()
+}
+
+func (s *SQLite) BlockUser(ctx context.Context, arg database.BlockUserParams) (int64, error) {
+	result, err := s.db.ExecContext(ctx, `-- name: BlockUser :execrows
+insert into blocks (blocker_id, blocked_id, created_at)
+values (?, ?, ?)
+on conflict (blocker_id, blocked_id) do nothing`, arg.BlockerID, arg.BlockedID, now())
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+func (s *SQLite) UnblockUser(ctx context.Context, arg database.UnblockUserParams) error {
+	_, err := s.db.ExecContext(ctx, `-- name: UnblockUser :exec
+delete from blocks where blocker_id = ? and blocked_id = ?`, arg.BlockerID, arg.BlockedID)
+
+	return err
+}
+
+func (s *SQLite) MuteUser(ctx context.Context, arg database.MuteUserParams) (int64, error) {
+	result, err := s.db.ExecContext(ctx, `-- name: MuteUser :execrows
+insert into mutes (muter_id, muted_id, created_at)
+values (?, ?, ?)
+on conflict (muter_id, muted_id) do nothing`, arg.MuterID, arg.MutedID, now())
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+func (s *SQLite) UnmuteUser(ctx context.Context, arg database.UnmuteUserParams) error {
+	_, err := s.db.ExecContext(ctx, `-- name: UnmuteUser :exec
+delete from mutes where muter_id = ? and muted_id = ?`, arg.MuterID, arg.MutedID)
+
+	return err
+}
+
+func (s *SQLite) GetRelationship(ctx context.Context, arg database.GetRelationshipParams) (database.GetRelationshipRow, error) {
+	row := s.db.QueryRowContext(ctx, `-- name: GetRelationship :one
+select
+    exists (select 1 from blocks where blocker_id = ?1 and blocked_id = ?2),
+    exists (select 1 from mutes where muter_id = ?2 and muted_id = ?1)`, arg.OtherID, arg.UserID)
+
+	var i database.GetRelationshipRow
+	err := row.Scan(&i.BlockedBy, &i.Muting)
+	return i, err
+}
+
+func (s *SQLite) ListBlockers(ctx context.Context, blockedID uuid.UUID) ([]uuid.UUID, error) {
+	rows, err := s.db.QueryContext(ctx, `-- name: ListBlockers :many
+select blocker_id from blocks where blocked_id = ?`, blockedID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var blockers []uuid.UUID
+	for rows.Next() {
+		var blocker uuid.UUID
+		err := rows.Scan(&blocker)
+		if err != nil {
+			return nil, err
+		}
+		blockers = append(blockers, blocker)
+	}
+
+	return blockers, rows.Err()
+}
+
+func (s *SQLite) ListMutedUsers(ctx context.Context, muterID uuid.UUID) ([]uuid.UUID, error) {
+	rows, err := s.db.QueryContext(ctx, `-- name: ListMutedUsers :many
+select muted_id from mutes where muter_id = ?`, muterID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var mutedUsers []uuid.UUID
+	for rows.Next() {
+		var muted uuid.UUID
+		err := rows.Scan(&muted)
+		if err != nil {
+			return nil, err
+		}
+		mutedUsers = append(mutedUsers, muted)
+	}
+
+	return mutedUsers, rows.Err()
+}
+
+const chirpEventColumns = "id, type, user_id, data, created_at"
+
+func scanChirpEvent(row interface{ Scan(...any) error }) (database.ChirpEvent, error) {
+	var i database.ChirpEvent
+	err := row.Scan(&i.ID, &i.Type, &i.UserID, &i.Data, &i.CreatedAt)
+	return i, err
+}
+
+// CreateChirpEvent numbers events in commit order without the Postgres
+// lock: with one connection, transactions already run one at a time.
+func (s *SQLite) CreateChirpEvent(ctx context.Context, arg database.CreateChirpEventParams) (database.ChirpEvent, error) {
+	row := s.db.QueryRowContext(ctx, `-- name: CreateChirpEvent :one
+insert into chirp_events (type, user_id, data, created_at)
+values (?, ?, ?, ?)
+returning `+chirpEventColumns, arg.Type, arg.UserID, arg.Data, now())
+
+	return scanChirpEvent(row)
+}
+
+func (s *SQLite) GetChirpEvent(ctx context.Context, id int64) (database.ChirpEvent, error) {
+	row := s.db.QueryRowContext(ctx, `-- name: GetChirpEvent :one
+select `+chirpEventColumns+` from chirp_events where id = ?`, id)
+
+	return scanChirpEvent(row)
+}
+
+func (s *SQLite) ListChirpEventsAfter(ctx context.Context, arg database.ListChirpEventsAfterParams) ([]database.ChirpEvent, error) {
+	rows, err := s.db.QueryContext(ctx, `-- name: ListChirpEventsAfter :many
+select `+chirpEventColumns+` from chirp_events where id > ? order by id asc limit ?`, arg.ID, arg.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []database.ChirpEvent
+	for rows.Next() {
+		event, err := scanChirpEvent(rows)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+
+	return events, rows.Err()
+}
+
+func (s *SQLite) DeleteChirpEventsBefore(ctx context.Context, createdAt time.Time) error {
+	_, err := s.db.ExecContext(ctx, `-- name: DeleteChirpEventsBefore :exec
+delete from chirp_events where created_at < ?`, createdAt.UTC())
+
+	return err
+}
+
+const notificationColumns = "id, user_id, actor_id, type, chirp_id, created_at, read_at"
+
+func scanNotification(row interface{ Scan(...any) error }) (database.Notification, error) {
+	var i database.Notification
+	err := row.Scan(&i.ID, &i.UserID, &i.ActorID, &i.Type, &i.ChirpID, &i.CreatedAt, &i.ReadAt)
+	return i, err
+}
+
+func (s *SQLite) queryNotifications(ctx context.Context, query string, args ...any) ([]database.Notification, error) {
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var notifications []database.Notification
+	for rows.Next() {
+		notification, err := scanNotification(rows)
+		if err != nil {
+			return nil, err
+		}
+		notifications = append(notifications, notification)
+	}
+
+	return notifications, rows.Err()
+}
+
+func (s *SQLite) CreateNotification(ctx context.Context, arg database.CreateNotificationParams) (database.Notification, error) {
+	row := s.db.QueryRowContext(ctx, `-- name: CreateNotification :one
+insert into notifications (id, user_id, actor_id, type, chirp_id, created_at)
+values (?, ?, ?, ?, ?, ?)
+returning `+notificationColumns, uuid.New(), arg.UserID, arg.ActorID, arg.Type, arg.ChirpID, now())
+
+	return scanNotification(row)
+}
+
+func (s *SQLite) GetNotification(ctx context.Context, id uuid.UUID) (database.Notification, error) {
+	row := s.db.QueryRowContext(ctx, `-- name: GetNotification :one
+select `+notificationColumns+` from notifications where id = ?`, id)
+
+	return scanNotification(row)
+}
+
+func (s *SQLite) ListNotifications(ctx context.Context, arg database.ListNotificationsParams) ([]database.Notification, error) {
+	return s.queryNotifications(ctx, `-- name: ListNotifications :many
+select `+notificationColumns+` from notifications
+where user_id = ?
+order by created_at desc, id desc
+limit ?`, arg.UserID, arg.Limit)
+}
+
+func (s *SQLite) ListNotificationsBefore(ctx context.Context, arg database.ListNotificationsBeforeParams) ([]database.Notification, error) {
+	return s.queryNotifications(ctx, `-- name: ListNotificationsBefore :many
+select `+notificationColumns+` from notifications
+where user_id = ? and (created_at, id) < (?, ?)
+order by created_at desc, id desc
+limit ?`, arg.UserID, arg.CreatedAt.UTC(), arg.ID, arg.Limit)
+}
+
+func (s *SQLite) CountUnreadNotifications(ctx context.Context, userID uuid.UUID) (int64, error) {
+	row := s.db.QueryRowContext(ctx, `-- name: CountUnreadNotifications :one
+select count(*) from notifications where user_id = ? and read_at is null`, userID)
+
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
+func (s *SQLite) MarkNotificationRead(ctx context.Context, arg database.MarkNotificationReadParams) (int64, error) {
+	result, err := s.db.ExecContext(ctx, `-- name: MarkNotificationRead :execrows
+update notifications set read_at = ?
+where id = ? and user_id = ? and read_at is null`, now(), arg.ID, arg.UserID)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+func (s *SQLite) MarkAllNotificationsRead(ctx context.Context, userID uuid.UUID) (int64, error) {
+	result, err := s.db.ExecContext(ctx, `-- name: MarkAllNotificationsRead :execrows
+update notifications set read_at = ?
+where user_id = ? and read_at is null`, now(), userID)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+func (s *SQLite) ListNotificationPreferences(ctx context.Context, userID uuid.UUID) ([]database.NotificationPreference, error) {
+	rows, err := s.db.QueryContext(ctx, `-- name: ListNotificationPreferences :many
+select user_id, type, enabled from notification_preferences
+where user_id = ?
+order by type asc`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var preferences []database.NotificationPreference
+	for rows.Next() {
+		var i database.NotificationPreference
+		err := rows.Scan(&i.UserID, &i.Type, &i.Enabled)
+		if err != nil {
+			return nil, err
+		}
+		preferences = append(preferences, i)
+	}
+
+	return preferences, rows.Err()
+}
+
+func (s *SQLite) SetNotificationPreference(ctx context.Context, arg database.SetNotificationPreferenceParams) error {
+	_, err := s.db.ExecContext(ctx, `-- name: SetNotificationPreference :exec
+insert into notification_preferences (user_id, type, enabled)
+values (?, ?, ?)
+on conflict (user_id, type) do update set enabled = excluded.enabled`, arg.UserID, arg.Type, arg.Enabled)
+
+	return err
+}
+
+func (s *SQLite) CreateChirpEntity(ctx context.Context, arg database.CreateChirpEntityParams) error {
+	_, err := s.db.ExecContext(ctx, `-- name: CreateChirpEntity :exec
+insert into chirp_entities (chirp_id, type, start_offset, end_offset, text, user_id)
+values (?, ?, ?, ?, ?, ?)`, arg.ChirpID, arg.Type, arg.StartOffset, arg.EndOffset, arg.Text, arg.UserID)
+
+	return err
+}
+
+// sqliteMaxParams keeps "in" lists well under SQLite's limit on bound
+// parameters.
+const sqliteMaxParams = 500
+
+func (s *SQLite) ListChirpEntities(ctx context.Context, chirpIds []uuid.UUID) ([]database.ChirpEntity, error) {
+	var entities []database.ChirpEntity
+
+	// SQLite has no arrays, so the ids are bound one by one, in batches.
+	for len(chirpIds) > 0 {
+		batch := chirpIds[:min(len(chirpIds), sqliteMaxParams)]
+		chirpIds = chirpIds[len(batch):]
+
+		args := make([]any, len(batch))
+		for i, id := range batch {
+			args[i] = id
+		}
+
+		rows, err := s.db.QueryContext(ctx, `-- name: ListChirpEntities :many
+select chirp_id, type, start_offset, end_offset, text, user_id from chirp_entities
+where chirp_id in (?`+strings.Repeat(", ?", len(batch)-1)+`)
+order by chirp_id, start_offset`, args...)
+		if err != nil {
+			return nil, err
+		}
+
+		for rows.Next() {
+			var i database.ChirpEntity
+			err := rows.Scan(&i.ChirpID, &i.Type, &i.StartOffset, &i.EndOffset, &i.Text, &i.UserID)
+			if err != nil {
+				rows.Close()
+				return nil, err
+			}
+			entities = append(entities, i)
+		}
+
+		err = rows.Close()
+		if err == nil {
+			err = rows.Err()
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return entities, nil
+}
+
+func (s *SQLite) DeleteChirpEntities(ctx context.Context, chirpID uuid.UUID) error {
+	_, err := s.db.ExecContext(ctx, `-- name: DeleteChirpEntities :exec
+delete from chirp_entities where chirp_id = ?`, chirpID)
+
+	return err
+}
+
+func (s *SQLite) ResolveMentions(ctx context.Context, arg database.ResolveMentionsParams) (int64, error) {
+	result, err := s.db.ExecContext(ctx, `-- name: ResolveMentions :execrows
+update chirp_entities set user_id = ?1
+where type = 'mention' and text = ?2 and user_id is null
+    and not exists (
+        select 1 from chirps
+        join blocks on blocks.blocked_id = chirps.user_id
+        where chirps.id = chirp_entities.chirp_id and blocks.blocker_id = ?1
+    )`, arg.UserID, arg.Text)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+func (s *SQLite) ListChirpsForHashtag(ctx context.Context, arg database.ListChirpsForHashtagParams) ([]database.Chirp, error) {
+	return s.queryChirps(ctx, `-- name: ListChirpsForHashtag :many
+select `+chirpColumns+` from chirps
+where status = 'published' and deleted_at is null and id in (
+    select chirp_id from chirp_entities
+    where type = 'hashtag' and text = ?1
+)
+and not exists (select 1 from blocks where blocker_id = chirps.user_id and blocked_id = ?2)
+and not exists (select 1 from mutes where muter_id = ?2 and muted_id = chirps.user_id)
+order by created_at asc, id asc`, arg.Text, arg.ViewerID)
+}
+
+const mediaColumns = "id, user_id, chirp_id, position, content_type, size, width, height, created_at"
+
+func scanMedia(row interface{ Scan(...any) error }) (database.Medium, error) {
+	var i database.Medium
+	err := row.Scan(&i.ID, &i.UserID, &i.ChirpID, &i.Position, &i.ContentType, &i.Size, &i.Width, &i.Height, &i.CreatedAt)
+	return i, err
+}
+
+func (s *SQLite) queryMedia(ctx context.Context, query string, args ...any) ([]database.Medium, error) {
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var media []database.Medium
+	for rows.Next() {
+		item, err := scanMedia(rows)
+		if err != nil {
+			return nil, err
+		}
+		media = append(media, item)
+	}
+
+	return media, rows.Err()
+}
+
+func (s *SQLite) CreateMedia(ctx context.Context, arg database.CreateMediaParams) (database.Medium, error) {
+	row := s.db.QueryRowContext(ctx, `-- name: CreateMedia :one
+insert into media (id, user_id, content_type, size, width, height, created_at)
+values (?, ?, ?, ?, ?, ?, ?)
+returning `+mediaColumns, arg.ID, arg.UserID, arg.ContentType, arg.Size, arg.Width, arg.Height, now())
+
+	return scanMedia(row)
+}
+
+func (s *SQLite) GetMedia(ctx context.Context, id uuid.UUID) (database.Medium, error) {
+	row := s.db.QueryRowContext(ctx, `-- name: GetMedia :one
+select `+mediaColumns+` from media where id = ?`, id)
+
+	return scanMedia(row)
+}
+
+func (s *SQLite) AttachMedia(ctx context.Context, arg database.AttachMediaParams) (int64, error) {
+	result, err := s.db.ExecContext(ctx, `-- name: AttachMedia :execrows
+update media set chirp_id = ?, position = ?
+where id = ? and user_id = ? and chirp_id is null`, arg.ChirpID, arg.Position, arg.ID, arg.UserID)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+func (s *SQLite) ListMediaForChirps(ctx context.Context, chirpIds []uuid.UUID) ([]database.Medium, error) {
+	var media []database.Medium
+
+	for len(chirpIds) > 0 {
+		batch := chirpIds[:min(len(chirpIds), sqliteMaxParams)]
+		chirpIds = chirpIds[len(batch):]
+
+		args := make([]any, len(batch))
+		for i, id := range batch {
+			args[i] = id
+		}
+
+		items, err := s.queryMedia(ctx, `-- name: ListMediaForChirps :many
+select `+mediaColumns+` from media
+where chirp_id in (?`+strings.Repeat(", ?", len(batch)-1)+`)
+order by chirp_id, position`, args...)
+		if err != nil {
+			return nil, err
+		}
+		media = append(media, items...)
+	}
+
+	return media, nil
+}
+
+func (s *SQLite) ListUnattachedMediaBefore(ctx context.Context, createdAt time.Time) ([]database.Medium, error) {
+	return s.queryMedia(ctx, `-- name: ListUnattachedMediaBefore :many
+select `+mediaColumns+` from media
+where chirp_id is null and created_at < ?
+order by created_at`, createdAt)
+}
+
+func (s *SQLite) DeleteUnattachedMedia(ctx context.Context, id uuid.UUID) (int64, error) {
+	result, err := s.db.ExecContext(ctx, `-- name: DeleteUnattachedMedia :execrows
+delete from media where id = ? and chirp_id is null`, id)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+const linkPreviewColumns = "url, status, title, description, image_url, site_name, fetched_at"
+
+func scanLinkPreview(row interface{ Scan(...any) error }) (database.LinkPreview, error) {
+	var i database.LinkPreview
+	err := row.Scan(&i.Url, &i.Status, &i.Title, &i.Description, &i.ImageUrl, &i.SiteName, &i.FetchedAt)
+	return i, err
+}
+
+func (s *SQLite) UpsertLinkPreview(ctx context.Context, arg database.UpsertLinkPreviewParams) error {
+	_, err := s.db.ExecContext(ctx, `-- name: UpsertLinkPreview :exec
+insert into link_previews (url, status, title, description, image_url, site_name, fetched_at)
+values (?, ?, ?, ?, ?, ?, ?)
+on conflict (url) do update set
+    status = excluded.status,
+    title = excluded.title,
+    description = excluded.description,
+    image_url = excluded.image_url,
+    site_name = excluded.site_name,
+    fetched_at = excluded.fetched_at`, arg.Url, arg.Status, arg.Title, arg.Description, arg.ImageUrl, arg.SiteName, now())
+
+	return err
+}
+
+func (s *SQLite) GetLinkPreview(ctx context.Context, url string) (database.LinkPreview, error) {
+	row := s.db.QueryRowContext(ctx, `-- name: GetLinkPreview :one
+select `+linkPreviewColumns+` from link_previews where url = ?`, url)
+
+	return scanLinkPreview(row)
+}
+
+func (s *SQLite) ListLinkPreviews(ctx context.Context, urls []string) ([]database.LinkPreview, error) {
+	var previews []database.LinkPreview
+
+	for len(urls) > 0 {
+		batch := urls[:min(len(urls), sqliteMaxParams)]
+		urls = urls[len(batch):]
+
+		args := make([]any, len(batch))
+		for i, url := range batch {
+			args[i] = url
+		}
+
+		rows, err := s.db.QueryContext(ctx, `-- name: ListLinkPreviews :many
+select `+linkPreviewColumns+` from link_previews
+where url in (?`+strings.Repeat(", ?", len(batch)-1)+`)`, args...)
+		if err != nil {
+			return nil, err
+		}
+
+		for rows.Next() {
+			preview, err := scanLinkPreview(rows)
+			if err != nil {
+				rows.Close()
+				return nil, err
+			}
+			previews = append(previews, preview)
+		}
+
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return previews, nil
+}
+
+const reportColumns = "id, reporter_id, user_id, chirp_id, chirp_body, reason, details, created_at, resolved_at"
+
+func scanReport(row interface{ Scan(...any) error }) (database.Report, error) {
+	var i database.Report
+	err := row.Scan(&i.ID, &i.ReporterID, &i.UserID, &i.ChirpID, &i.ChirpBody, &i.Reason, &i.Details, &i.CreatedAt, &i.ResolvedAt)
+	return i, err
+}
+
+func (s *SQLite) CreateReport(ctx context.Context, arg database.CreateReportParams) (database.Report, error) {
+	row := s.db.QueryRowContext(ctx, `-- name: CreateReport :one
+insert into reports (id, reporter_id, user_id, chirp_id, chirp_body, reason, details, created_at)
+values (?, ?, ?, ?, ?, ?, ?, ?)
+returning `+reportColumns, uuid.New(), arg.ReporterID, arg.UserID, arg.ChirpID, arg.ChirpBody, arg.Reason, arg.Details, now())
+
+	return scanReport(row)
+}
+
+func (s *SQLite) GetReport(ctx context.Context, id uuid.UUID) (database.Report, error) {
+	row := s.db.QueryRowContext(ctx, `-- name: GetReport :one
+select `+reportColumns+` from reports where id = ?`, id)
+
+	return scanReport(row)
+}
+
+func (s *SQLite) ListOpenReports(ctx context.Context, limit int32) ([]database.Report, error) {
+	rows, err := s.db.QueryContext(ctx, `-- name: ListOpenReports :many
+select `+reportColumns+` from reports
+where resolved_at is null
+order by created_at asc, id asc
+limit ?`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	reports := []database.Report{}
+	for rows.Next() {
+		report, err := scanReport(rows)
+		if err != nil {
+			return nil, err
+		}
+		reports = append(reports, report)
+	}
+
+	return reports, rows.Err()
+}
+
+func (s *SQLite) ResolveReport(ctx context.Context, id uuid.UUID) (database.Report, error) {
+	row := s.db.QueryRowContext(ctx, `-- name: ResolveReport :one
+update reports set resolved_at = ?
+where id = ? and resolved_at is null
+returning `+reportColumns, now(), id)
+
+	return scanReport(row)
+}
+
+const moderationActionColumns = "id, moderator_id, user_id, chirp_id, report_id, action, note, created_at"
+
+func scanModerationAction(row interface{ Scan(...any) error }) (database.ModerationAction, error) {
+	var i database.ModerationAction
+	err := row.Scan(&i.ID, &i.ModeratorID, &i.UserID, &i.ChirpID, &i.ReportID, &i.Action, &i.Note, &i.CreatedAt)
+	return i, err
+}
+
+func (s *SQLite) CreateModerationAction(ctx context.Context, arg database.CreateModerationActionParams) (database.ModerationAction, error) {
+	row := s.db.QueryRowContext(ctx, `-- name: CreateModerationAction :one
+insert into moderation_actions (id, moderator_id, user_id, chirp_id, report_id, action, note, created_at)
+values (?, ?, ?, ?, ?, ?, ?, ?)
+returning `+moderationActionColumns, uuid.New(), arg.ModeratorID, arg.UserID, arg.ChirpID, arg.ReportID, arg.Action, arg.Note, now())
+
+	return scanModerationAction(row)
+}
+
+func (s *SQLite) ListModerationActions(ctx context.Context, arg database.ListModerationActionsParams) ([]database.ModerationAction, error) {
+	rows, err := s.db.QueryContext(ctx, `-- name: ListModerationActions :many
+select `+moderationActionColumns+` from moderation_actions
+where ?1 is null or user_id = ?1
+order by created_at desc, id desc
+limit ?2`, arg.UserID, arg.LimitCount)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	actions := []database.ModerationAction{}
+	for rows.Next() {
+		action, err := scanModerationAction(rows)
+		if err != nil {
+			return nil, err
+		}
+		actions = append(actions, action)
+	}
+
+	return actions, rows.Err()
+}
+
+func (s *SQLite) BookmarkChirp(ctx context.Context, arg database.BookmarkChirpParams) (int64, error) {
+	result, err := s.db.ExecContext(ctx, `-- name: BookmarkChirp :execrows
+insert into bookmarks (user_id, chirp_id, created_at)
+values (?, ?, ?)
+on conflict (user_id, chirp_id) do nothing`, arg.UserID, arg.ChirpID, now())
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+func (s *SQLite) UnbookmarkChirp(ctx context.Context, arg database.UnbookmarkChirpParams) error {
+	_, err := s.db.ExecContext(ctx, `-- name: UnbookmarkChirp :exec
+delete from bookmarks where user_id = ? and chirp_id = ?`, arg.UserID, arg.ChirpID)
+
+	return err
+}
+
+func (s *SQLite) GetBookmark(ctx context.Context, arg database.GetBookmarkParams) (database.Bookmark, error) {
+	row := s.db.QueryRowContext(ctx, `-- name: GetBookmark :one
+select user_id, chirp_id, created_at from bookmarks where user_id = ? and chirp_id = ?`, arg.UserID, arg.ChirpID)
+
+	var i database.Bookmark
+	err := row.Scan(&i.UserID, &i.ChirpID, &i.CreatedAt)
+	return i, err
+}
+
+func (s *SQLite) ListBookmarkedChirps(ctx context.Context, arg database.ListBookmarkedChirpsParams) ([]database.Chirp, error) {
+	return s.queryChirps(ctx, `-- name: ListBookmarkedChirps :many
+select `+qualifiedChirpColumns+` from bookmarks
+join chirps on chirps.id = bookmarks.chirp_id
+where bookmarks.user_id = ?1
+and chirps.status = 'published' and chirps.deleted_at is null
+and not exists (select 1 from blocks where blocker_id = chirps.user_id and blocked_id = ?1)
+order by bookmarks.created_at desc, bookmarks.chirp_id desc
+limit ?2`, arg.UserID, arg.Limit)
+}
+
+func (s *SQLite) ListBookmarkedChirpsBefore(ctx context.Context, arg database.ListBookmarkedChirpsBeforeParams) ([]database.Chirp, error) {
+	return s.queryChirps(ctx, `-- name: ListBookmarkedChirpsBefore :many
+select `+qualifiedChirpColumns+` from bookmarks
+join chirps on chirps.id = bookmarks.chirp_id
+where bookmarks.user_id = ?1
+and (bookmarks.created_at, bookmarks.chirp_id) < (?3, ?4)
+and chirps.status = 'published' and chirps.deleted_at is null
+and not exists (select 1 from blocks where blocker_id = chirps.user_id and blocked_id = ?1)
+order by bookmarks.created_at desc, bookmarks.chirp_id desc
+limit ?2`, arg.UserID, arg.Limit, arg.CreatedAt.UTC(), arg.ChirpID)
+}
+
+func (s *SQLite) PinChirp(ctx context.Context, arg database.PinChirpParams) error {
+	_, err := s.db.ExecContext(ctx, `-- name: PinChirp :exec
+insert into pinned_chirps (user_id, chirp_id, created_at)
+values (?, ?, ?)
+on conflict (user_id) do update
+set chirp_id = excluded.chirp_id, created_at = excluded.created_at`, arg.UserID, arg.ChirpID, now())
+
+	return err
+}
+
+func (s *SQLite) UnpinChirp(ctx context.Context, arg database.UnpinChirpParams) (int64, error) {
+	result, err := s.db.ExecContext(ctx, `-- name: UnpinChirp :execrows
+delete from pinned_chirps where user_id = ? and chirp_id = ?`, arg.UserID, arg.ChirpID)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+func (s *SQLite) GetPinnedChirp(ctx context.Context, userID uuid.UUID) (database.Chirp, error) {
+	row := s.db.QueryRowContext(ctx, `-- name: GetPinnedChirp :one
+select `+qualifiedChirpColumns+` from pinned_chirps
+join chirps on chirps.id = pinned_chirps.chirp_id
+where pinned_chirps.user_id = ? and chirps.deleted_at is null`, userID)
+
+	return scanChirp(row)
+}
+
+func (s *SQLite) CreatePoll(ctx context.Context, arg database.CreatePollParams) (database.Poll, error) {
+	row := s.db.QueryRowContext(ctx, `-- name: CreatePoll :one
+insert into polls (chirp_id, closes_at, created_at)
+values (?, ?, ?)
+returning chirp_id, closes_at, created_at`, arg.ChirpID, arg.ClosesAt.UTC(), now())
+
+	var i database.Poll
+	err := row.Scan(&i.ChirpID, &i.ClosesAt, &i.CreatedAt)
+	return i, err
+}
+
+func (s *SQLite) CreatePollOption(ctx context.Context, arg database.CreatePollOptionParams) (database.PollOption, error) {
+	row := s.db.QueryRowContext(ctx, `-- name: CreatePollOption :one
+insert into poll_options (id, chirp_id, position, text)
+values (?, ?, ?, ?)
+returning id, chirp_id, position, text`, uuid.New(), arg.ChirpID, arg.Position, arg.Text)
+
+	var i database.PollOption
+	err := row.Scan(&i.ID, &i.ChirpID, &i.Position, &i.Text)
+	return i, err
+}
+
+func (s *SQLite) ListPollsForChirps(ctx context.Context, chirpIds []uuid.UUID) ([]database.Poll, error) {
+	var polls []database.Poll
+
+	for len(chirpIds) > 0 {
+		batch := chirpIds[:min(len(chirpIds), sqliteMaxParams)]
+		chirpIds = chirpIds[len(batch):]
+
+		args := make([]any, len(batch))
+		for i, id := range batch {
+			args[i] = id
+		}
+
+		rows, err := s.db.QueryContext(ctx, `-- name: ListPollsForChirps :many
+select chirp_id, closes_at, created_at from polls
+where chirp_id in (?`+strings.Repeat(", ?", len(batch)-1)+`)`, args...)
+		if err != nil {
+			return nil, err
+		}
+
+		for rows.Next() {
+			var i database.Poll
+			if err := rows.Scan(&i.ChirpID, &i.ClosesAt, &i.CreatedAt); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			polls = append(polls, i)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	return polls, nil
+}
+
+func (s *SQLite) ListPollOptionsForChirps(ctx context.Context, chirpIds []uuid.UUID) ([]database.ListPollOptionsForChirpsRow, error) {
+	var options []database.ListPollOptionsForChirpsRow
+
+	for len(chirpIds) > 0 {
+		batch := chirpIds[:min(len(chirpIds), sqliteMaxParams)]
+		chirpIds = chirpIds[len(batch):]
+
+		args := make([]any, len(batch))
+		for i, id := range batch {
+			args[i] = id
+		}
+
+		rows, err := s.db.QueryContext(ctx, `-- name: ListPollOptionsForChirps :many
+select poll_options.id, poll_options.chirp_id, poll_options.position, poll_options.text, count(poll_votes.user_id)
+from poll_options
+left join poll_votes on poll_votes.option_id = poll_options.id
+where poll_options.chirp_id in (?`+strings.Repeat(", ?", len(batch)-1)+`)
+group by poll_options.id
+order by poll_options.chirp_id, poll_options.position`, args...)
+		if err != nil {
+			return nil, err
+		}
+
+		for rows.Next() {
+			var i database.ListPollOptionsForChirpsRow
+			if err := rows.Scan(&i.ID, &i.ChirpID, &i.Position, &i.Text, &i.Votes); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			options = append(options, i)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	return options, nil
+}
+
+func (s *SQLite) ListPollVotesForUser(ctx context.Context, arg database.ListPollVotesForUserParams) ([]database.PollVote, error) {
+	var votes []database.PollVote
+
+	chirpIds := arg.ChirpIds
+	for len(chirpIds) > 0 {
+		batch := chirpIds[:min(len(chirpIds), sqliteMaxParams)]
+		chirpIds = chirpIds[len(batch):]
+
+		args := []any{arg.UserID}
+		for _, id := range batch {
+			args = append(args, id)
+		}
+
+		rows, err := s.db.QueryContext(ctx, `-- name: ListPollVotesForUser :many
+select chirp_id, user_id, option_id, created_at from poll_votes
+where user_id = ? and chirp_id in (?`+strings.Repeat(", ?", len(batch)-1)+`)`, args...)
+		if err != nil {
+			return nil, err
+		}
+
+		for rows.Next() {
+			var i database.PollVote
+			if err := rows.Scan(&i.ChirpID, &i.UserID, &i.OptionID, &i.CreatedAt); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			votes = append(votes, i)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	return votes, nil
+}
+
+func (s *SQLite) VotePoll(ctx context.Context, arg database.VotePollParams) (database.PollVote, error) {
+	row := s.db.QueryRowContext(ctx, `-- name: VotePoll :one
+insert into poll_votes (chirp_id, user_id, option_id, created_at)
+select polls.chirp_id, ?2, ?3, ?4
+from polls
+where polls.chirp_id = ?1 and polls.closes_at > ?4
+returning chirp_id, user_id, option_id, created_at`, arg.ChirpID, arg.UserID, arg.OptionID, now())
+
+	var i database.PollVote
+	err := row.Scan(&i.ChirpID, &i.UserID, &i.OptionID, &i.CreatedAt)
+	return i, err
+}
@@ -0,0 +1,301 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/mattn/go-sqlite3"
+	"github.com/vemolista/chirpy/v2/internal/database"
+)
+
+// Store is the persistence layer used by the handlers. Its methods mirror
+// the sqlc queries so that Postgres is *database.Queries plus transactions;
+// Memory and SQLite implement the same contract for tests and local
+// development. Lookups that find nothing return sql.ErrNoRows.
+type Store interface {
+	// InTx runs fn in a transaction, which is committed if fn returns nil
+	// and rolled back otherwise. The store's methods take part in it when
+	// called with the context fn is given. Calls nested in fn join its
+	// transaction.
+	InTx(ctx context.Context, fn func(ctx context.Context) error) error
+
+	UserStore
+	ChirpStore
+	RefreshTokenStore
+	IdempotencyKeyStore
+	FollowStore
+	BlockStore
+	ChirpEventStore
+	NotificationStore
+	ChirpEntityStore
+	MediaStore
+	LinkPreviewStore
+	ReportStore
+	BookmarkStore
+	PinnedChirpStore
+	PollStore
+}
+
+type UserStore interface {
+	CreateUser(ctx context.Context, arg database.CreateUserParams) (database.User, error)
+	GetUser(ctx context.Context, id uuid.UUID) (database.User, error)
+	GetUserByEmail(ctx context.Context, email string) (database.User, error)
+	GetUserByUsername(ctx context.Context, username sql.NullString) (database.User, error)
+	// ListUsersByIDs skips ids that match no user.
+	ListUsersByIDs(ctx context.Context, ids []uuid.UUID) ([]database.User, error)
+	UpdateUser(ctx context.Context, arg database.UpdateUserParams) (database.User, error)
+	UpdateUserProfile(ctx context.Context, arg database.UpdateUserProfileParams) (database.User, error)
+	GetUserCounts(ctx context.Context, userID uuid.UUID) (database.GetUserCountsRow, error)
+	DeleteUsers(ctx context.Context) error
+	UpgradeToChirpyRed(ctx context.Context, id uuid.UUID) (database.User, error)
+	SetUserRole(ctx context.Context, arg database.SetUserRoleParams) (database.User, error)
+	// SuspendUser keeps the suspended_at of a user already suspended.
+	SuspendUser(ctx context.Context, id uuid.UUID) (database.User, error)
+}
+
+// ChirpStore keeps chirps. Drafts and scheduled chirps are only returned by
+// GetChirp and ListUnpublishedChirps; the other lists and counts are of
+// published chirps. Deleted chirps are only returned by GetDeletedChirp.
+type ChirpStore interface {
+	CreateChirp(ctx context.Context, arg database.CreateChirpParams) (database.Chirp, error)
+	// ListChirps leaves out the chirps of users who blocked the viewer or
+	// whom the viewer muted. The viewer is uuid.Nil for anonymous requests.
+	ListChirps(ctx context.Context, viewerID uuid.UUID) ([]database.Chirp, error)
+	// ListChirpsForAuthor returns nothing if the author blocked the viewer.
+	ListChirpsForAuthor(ctx context.Context, arg database.ListChirpsForAuthorParams) ([]database.Chirp, error)
+	ListUnpublishedChirps(ctx context.Context, userID uuid.UUID) ([]database.Chirp, error)
+	GetChirp(ctx context.Context, id uuid.UUID) (database.Chirp, error)
+	GetDeletedChirp(ctx context.Context, id uuid.UUID) (database.Chirp, error)
+	// DeleteChirp deletes a chirp for good, unlike SoftDeleteChirp.
+	DeleteChirp(ctx context.Context, id uuid.UUID) error
+	// UpdateChirp and SoftDeleteChirp only touch the chirp if its
+	// updated_at still matches, so concurrent edits can't clobber each
+	// other. UpdateChirp returns sql.ErrNoRows otherwise.
+	UpdateChirp(ctx context.Context, arg database.UpdateChirpParams) (database.Chirp, error)
+	SoftDeleteChirp(ctx context.Context, arg database.SoftDeleteChirpParams) (int64, error)
+	// RestoreChirp undeletes a chirp deleted after DeletedAfter, unless it
+	// is hidden, and returns sql.ErrNoRows if there is none.
+	RestoreChirp(ctx context.Context, arg database.RestoreChirpParams) (database.Chirp, error)
+	// HideChirp deletes a chirp, if it isn't already, and marks it hidden
+	// by a moderator.
+	HideChirp(ctx context.Context, id uuid.UUID) (database.Chirp, error)
+	// PurgeDeletedChirps deletes for good the chirps deleted before a time.
+	PurgeDeletedChirps(ctx context.Context, before time.Time) (int64, error)
+	// PublishChirp publishes a draft or scheduled chirp if its updated_at
+	// still matches, and returns sql.ErrNoRows otherwise. Publishing resets
+	// created_at.
+	PublishChirp(ctx context.Context, arg database.PublishChirpParams) (database.Chirp, error)
+	// PublishDueChirps publishes up to Limit scheduled chirps due at Now
+	// and returns them. Concurrent calls never return the same chirp.
+	PublishDueChirps(ctx context.Context, arg database.PublishDueChirpsParams) ([]database.Chirp, error)
+}
+
+type RefreshTokenStore interface {
+	CreateRefreshToken(ctx context.Context, arg database.CreateRefreshTokenParams) (database.RefreshToken, error)
+	GetRefreshToken(ctx context.Context, token string) (database.RefreshToken, error)
+	RevokeToken(ctx context.Context, token string) error
+}
+
+// IdempotencyKeyStore keeps the responses to requests sent with an
+// Idempotency-Key header. A key is created before the request is handled,
+// so a concurrent duplicate fails with a unique violation, and completed
+// with the response afterwards.
+type IdempotencyKeyStore interface {
+	CreateIdempotencyKey(ctx context.Context, arg database.CreateIdempotencyKeyParams) (database.IdempotencyKey, error)
+	GetIdempotencyKey(ctx context.Context, arg database.GetIdempotencyKeyParams) (database.IdempotencyKey, error)
+	CompleteIdempotencyKey(ctx context.Context, arg database.CompleteIdempotencyKeyParams) error
+	DeleteIdempotencyKey(ctx context.Context, arg database.DeleteIdempotencyKeyParams) error
+	DeleteExpiredIdempotencyKeys(ctx context.Context, expiresAt time.Time) error
+}
+
+// FollowStore records which users follow which.
+type FollowStore interface {
+	// FollowUser returns 0 when the follow already existed.
+	FollowUser(ctx context.Context, arg database.FollowUserParams) (int64, error)
+	UnfollowUser(ctx context.Context, arg database.UnfollowUserParams) error
+	ListFollowees(ctx context.Context, followerID uuid.UUID) ([]uuid.UUID, error)
+}
+
+// BlockStore records which users block and mute which.
+type BlockStore interface {
+	// BlockUser and MuteUser return 0 when the block or mute already
+	// existed.
+	BlockUser(ctx context.Context, arg database.BlockUserParams) (int64, error)
+	UnblockUser(ctx context.Context, arg database.UnblockUserParams) error
+	MuteUser(ctx context.Context, arg database.MuteUserParams) (int64, error)
+	UnmuteUser(ctx context.Context, arg database.UnmuteUserParams) error
+	// GetRelationship returns whether OtherID blocked UserID and whether
+	// UserID muted OtherID.
+	GetRelationship(ctx context.Context, arg database.GetRelationshipParams) (database.GetRelationshipRow, error)
+	// ListBlockers returns the users who blocked blockedID, and
+	// ListMutedUsers the users muterID muted, for filtering many events
+	// without a GetRelationship each.
+	ListBlockers(ctx context.Context, blockedID uuid.UUID) ([]uuid.UUID, error)
+	ListMutedUsers(ctx context.Context, muterID uuid.UUID) ([]uuid.UUID, error)
+}
+
+// ChirpEventStore is the log of chirp events behind the event stream. IDs
+// increase in commit order, even for events recorded in transactions, so
+// clients can resume after the last one they saw.
+type ChirpEventStore interface {
+	CreateChirpEvent(ctx context.Context, arg database.CreateChirpEventParams) (database.ChirpEvent, error)
+	GetChirpEvent(ctx context.Context, id int64) (database.ChirpEvent, error)
+	ListChirpEventsAfter(ctx context.Context, arg database.ListChirpEventsAfterParams) ([]database.ChirpEvent, error)
+	DeleteChirpEventsBefore(ctx context.Context, createdAt time.Time) error
+}
+
+// NotificationStore is each user's inbox of notifications and their
+// preferences for which types they get. A missing preference means the
+// type is enabled.
+type NotificationStore interface {
+	CreateNotification(ctx context.Context, arg database.CreateNotificationParams) (database.Notification, error)
+	GetNotification(ctx context.Context, id uuid.UUID) (database.Notification, error)
+	ListNotifications(ctx context.Context, arg database.ListNotificationsParams) ([]database.Notification, error)
+	ListNotificationsBefore(ctx context.Context, arg database.ListNotificationsBeforeParams) ([]database.Notification, error)
+	CountUnreadNotifications(ctx context.Context, userID uuid.UUID) (int64, error)
+	MarkNotificationRead(ctx context.Context, arg database.MarkNotificationReadParams) (int64, error)
+	MarkAllNotificationsRead(ctx context.Context, userID uuid.UUID) (int64, error)
+	ListNotificationPreferences(ctx context.Context, userID uuid.UUID) ([]database.NotificationPreference, error)
+	SetNotificationPreference(ctx context.Context, arg database.SetNotificationPreferenceParams) error
+}
+
+// ChirpEntityStore indexes the mentions, hashtags and URLs in chirps.
+type ChirpEntityStore interface {
+	CreateChirpEntity(ctx context.Context, arg database.CreateChirpEntityParams) error
+	ListChirpEntities(ctx context.Context, chirpIds []uuid.UUID) ([]database.ChirpEntity, error)
+	DeleteChirpEntities(ctx context.Context, chirpID uuid.UUID) error
+	// ResolveMentions points the unresolved mentions of a handle at a user,
+	// except in the chirps of authors the user blocked.
+	ResolveMentions(ctx context.Context, arg database.ResolveMentionsParams) (int64, error)
+	// ListChirpsForHashtag filters blocks and mutes like ListChirps.
+	ListChirpsForHashtag(ctx context.Context, arg database.ListChirpsForHashtagParams) ([]database.Chirp, error)
+}
+
+type MediaStore interface {
+	CreateMedia(ctx context.Context, arg database.CreateMediaParams) (database.Medium, error)
+	GetMedia(ctx context.Context, id uuid.UUID) (database.Medium, error)
+	// AttachMedia returns 0 when the media doesn't belong to the user or
+	// is already attached.
+	AttachMedia(ctx context.Context, arg database.AttachMediaParams) (int64, error)
+	ListMediaForChirps(ctx context.Context, chirpIds []uuid.UUID) ([]database.Medium, error)
+	ListUnattachedMediaBefore(ctx context.Context, createdAt time.Time) ([]database.Medium, error)
+	// DeleteUnattachedMedia returns 0 when the media was attached in the
+	// meantime.
+	DeleteUnattachedMedia(ctx context.Context, id uuid.UUID) (int64, error)
+}
+
+// LinkPreviewStore caches the previews fetched for URLs in chirps.
+type LinkPreviewStore interface {
+	// UpsertLinkPreview replaces any preview of the URL and sets its
+	// fetched_at to now.
+	UpsertLinkPreview(ctx context.Context, arg database.UpsertLinkPreviewParams) error
+	GetLinkPreview(ctx context.Context, url string) (database.LinkPreview, error)
+	// ListLinkPreviews skips urls that have no preview.
+	ListLinkPreviews(ctx context.Context, urls []string) ([]database.LinkPreview, error)
+}
+
+// ReportStore keeps reports and the log of moderation actions, which is
+// only ever appended to.
+type ReportStore interface {
+	CreateReport(ctx context.Context, arg database.CreateReportParams) (database.Report, error)
+	GetReport(ctx context.Context, id uuid.UUID) (database.Report, error)
+	// ListOpenReports returns the oldest unresolved reports first.
+	ListOpenReports(ctx context.Context, limit int32) ([]database.Report, error)
+	// ResolveReport returns sql.ErrNoRows if the report is missing or
+	// already resolved, so that only one decision is made on it.
+	ResolveReport(ctx context.Context, id uuid.UUID) (database.Report, error)
+	CreateModerationAction(ctx context.Context, arg database.CreateModerationActionParams) (database.ModerationAction, error)
+	// ListModerationActions returns the newest actions first, on any user
+	// when UserID is null.
+	ListModerationActions(ctx context.Context, arg database.ListModerationActionsParams) ([]database.ModerationAction, error)
+}
+
+// BookmarkStore keeps each user's private bookmarks. Lists skip deleted
+// chirps and those whose author blocked the user, and are newest bookmark
+// first.
+type BookmarkStore interface {
+	// BookmarkChirp returns 0 when the bookmark already existed.
+	BookmarkChirp(ctx context.Context, arg database.BookmarkChirpParams) (int64, error)
+	UnbookmarkChirp(ctx context.Context, arg database.UnbookmarkChirpParams) error
+	GetBookmark(ctx context.Context, arg database.GetBookmarkParams) (database.Bookmark, error)
+	ListBookmarkedChirps(ctx context.Context, arg database.ListBookmarkedChirpsParams) ([]database.Chirp, error)
+	ListBookmarkedChirpsBefore(ctx context.Context, arg database.ListBookmarkedChirpsBeforeParams) ([]database.Chirp, error)
+}
+
+// PinnedChirpStore keeps the one chirp each user may pin.
+type PinnedChirpStore interface {
+	// PinChirp replaces any chirp the user already pinned.
+	PinChirp(ctx context.Context, arg database.PinChirpParams) error
+	// UnpinChirp returns 0 if the chirp wasn't the user's pinned chirp.
+	UnpinChirp(ctx context.Context, arg database.UnpinChirpParams) (int64, error)
+	// GetPinnedChirp returns sql.ErrNoRows if nothing is pinned or the
+	// pinned chirp is deleted.
+	GetPinnedChirp(ctx context.Context, userID uuid.UUID) (database.Chirp, error)
+}
+
+// PollStore keeps the polls attached to chirps and their votes.
+type PollStore interface {
+	CreatePoll(ctx context.Context, arg database.CreatePollParams) (database.Poll, error)
+	CreatePollOption(ctx context.Context, arg database.CreatePollOptionParams) (database.PollOption, error)
+	ListPollsForChirps(ctx context.Context, chirpIds []uuid.UUID) ([]database.Poll, error)
+	// ListPollOptionsForChirps returns the options of each poll in order,
+	// with their vote counts.
+	ListPollOptionsForChirps(ctx context.Context, chirpIds []uuid.UUID) ([]database.ListPollOptionsForChirpsRow, error)
+	ListPollVotesForUser(ctx context.Context, arg database.ListPollVotesForUserParams) ([]database.PollVote, error)
+	// VotePoll returns sql.ErrNoRows if the poll is missing or closed, a
+	// unique violation if the user already voted and a foreign key
+	// violation if the option isn't one of the poll's.
+	VotePoll(ctx context.Context, arg database.VotePollParams) (database.PollVote, error)
+}
+
+// The in-memory store returns these where Postgres would reject a write
+// with a constraint violation.
+var (
+	ErrUniqueViolation     = errors.New("unique constraint violation")
+	ErrForeignKeyViolation = errors.New("foreign key constraint violation")
+)
+
+// IsUniqueViolation reports whether err was caused by a unique constraint in
+// any of the backends.
+func IsUniqueViolation(err error) bool {
+	if errors.Is(err, ErrUniqueViolation) {
+		return true
+	}
+
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return pqErr.Code == "23505"
+	}
+
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) {
+		return sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique ||
+			sqliteErr.ExtendedCode == sqlite3.ErrConstraintPrimaryKey
+	}
+
+	return false
+}
+
+// IsForeignKeyViolation reports whether err was caused by a foreign key
+// constraint in any of the backends.
+func IsForeignKeyViolation(err error) bool {
+	if errors.Is(err, ErrForeignKeyViolation) {
+		return true
+	}
+
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return pqErr.Code == "23503"
+	}
+
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) {
+		return sqliteErr.ExtendedCode == sqlite3.ErrConstraintForeignKey
+	}
+
+	return false
+}
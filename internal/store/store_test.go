@@ -0,0 +1,1758 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pressly/goose/v3"
+	"github.com/vemolista/chirpy/v2/internal/database"
+)
+
+// The conformance suite runs against every backend. Postgres is only tested
+// when CHIRPY_TEST_DB_URL points at a disposable database.
+
+func TestMemoryStore(t *testing.T) {
+	runConformance(t, func(t *testing.T) Store {
+		return NewMemory()
+	})
+}
+
+func TestSQLiteStore(t *testing.T) {
+	runConformance(t, func(t *testing.T) Store {
+		db, err := OpenSQLite(context.Background(), ":memory:")
+		if err != nil {
+			t.Fatalf("expected to open sqlite: %v", err)
+		}
+		t.Cleanup(func() { db.Close() })
+
+		return NewSQLite(NewDB(db, nil))
+	})
+}
+
+func TestPostgresStore(t *testing.T) {
+	dbUrl := os.Getenv("CHIRPY_TEST_DB_URL")
+	if dbUrl == "" {
+		t.Skip("CHIRPY_TEST_DB_URL not set")
+	}
+
+	db, err := sql.Open("postgres", dbUrl)
+	if err != nil {
+		t.Fatalf("expected to open postgres: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	provider, err := goose.NewProvider(goose.DialectPostgres, db, os.DirFS("../../sql/migrations"))
+	if err != nil {
+		t.Fatalf("expected migration provider: %v", err)
+	}
+
+	_, err = provider.Up(context.Background())
+	if err != nil {
+		t.Fatalf("expected migrations to apply: %v", err)
+	}
+
+	runConformance(t, func(t *testing.T) Store {
+		postgres := NewPostgres(NewDB(db, nil))
+		err := postgres.DeleteUsers(context.Background())
+		if err != nil {
+			t.Fatalf("expected to reset database: %v", err)
+		}
+
+		return postgres
+	})
+}
+
+func runConformance(t *testing.T, newStore func(t *testing.T) Store) {
+	tests := map[string]func(t *testing.T, s Store){
+		"users":          testUsers,
+		"chirps":         testChirps,
+		"refresh tokens": testRefreshTokens,
+		"chirp versions": testChirpVersions,
+		"scheduled":      testScheduledChirps,
+		"deleted chirps": testDeletedChirps,
+		"delete users":   testDeleteUsersCascades,
+		"idempotency":    testIdempotencyKeys,
+		"follows":        testFollows,
+		"blocks":         testBlocks,
+		"bookmarks":      testBookmarks,
+		"pinned chirps":  testPinnedChirps,
+		"polls":          testPolls,
+		"chirp events":   testChirpEvents,
+		"notifications":  testNotifications,
+		"chirp entities": testChirpEntities,
+		"user profiles":  testUserProfiles,
+		"media":          testMedia,
+		"link previews":  testLinkPreviews,
+		"reports":        testReports,
+		"transactions":   testTransactions,
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			test(t, newStore(t))
+		})
+	}
+}
+
+func mustCreateUser(t *testing.T, s Store, email string) database.User {
+	t.Helper()
+
+	user, err := s.CreateUser(context.Background(), database.CreateUserParams{
+		Email:          email,
+		HashedPassword: "hash",
+	})
+	if err != nil {
+		t.Fatalf("expected to create user: %v", err)
+	}
+
+	return user
+}
+
+func testUsers(t *testing.T, s Store) {
+	ctx := context.Background()
+
+	user := mustCreateUser(t, s, "a@example.com")
+	if user.ID == uuid.Nil || user.IsChirpyRed || user.Role != "user" {
+		t.Errorf("expected a new user with an ID, no Chirpy Red and the user role, instead got %+v", user)
+	}
+
+	_, err := s.CreateUser(ctx, database.CreateUserParams{Email: "a@example.com", HashedPassword: "hash"})
+	if !IsUniqueViolation(err) {
+		t.Errorf("expected a unique violation for a duplicate email, instead got %v", err)
+	}
+
+	found, err := s.GetUserByEmail(ctx, "a@example.com")
+	if err != nil || found.ID != user.ID {
+		t.Errorf("expected to find user by email, instead got %+v, %v", found, err)
+	}
+
+	found, err = s.GetUser(ctx, user.ID)
+	if err != nil || found.Email != user.Email {
+		t.Errorf("expected to find user by ID, instead got %+v, %v", found, err)
+	}
+
+	_, err = s.GetUser(ctx, uuid.New())
+	if !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected sql.ErrNoRows for a missing user ID, instead got %v", err)
+	}
+
+	_, err = s.GetUserByEmail(ctx, "missing@example.com")
+	if !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected sql.ErrNoRows for a missing user, instead got %v", err)
+	}
+
+	updated, err := s.UpdateUser(ctx, database.UpdateUserParams{
+		ID:             user.ID,
+		Email:          "b@example.com",
+		HashedPassword: "new hash",
+	})
+	if err != nil || updated.Email != "b@example.com" || updated.HashedPassword != "new hash" {
+		t.Errorf("expected user to be updated, instead got %+v, %v", updated, err)
+	}
+
+	upgraded, err := s.UpgradeToChirpyRed(ctx, user.ID)
+	if err != nil || !upgraded.IsChirpyRed {
+		t.Errorf("expected user to be upgraded, instead got %+v, %v", upgraded, err)
+	}
+
+	_, err = s.UpgradeToChirpyRed(ctx, uuid.New())
+	if !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected sql.ErrNoRows upgrading a missing user, instead got %v", err)
+	}
+
+	moderator, err := s.SetUserRole(ctx, database.SetUserRoleParams{ID: user.ID, Role: "moderator"})
+	if err != nil || moderator.Role != "moderator" {
+		t.Errorf("expected user to become a moderator, instead got %+v, %v", moderator, err)
+	}
+
+	_, err = s.SetUserRole(ctx, database.SetUserRoleParams{ID: uuid.New(), Role: "moderator"})
+	if !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected sql.ErrNoRows setting the role of a missing user, instead got %v", err)
+	}
+}
+
+func testChirps(t *testing.T, s Store) {
+	ctx := context.Background()
+
+	alice := mustCreateUser(t, s, "alice@example.com")
+	bob := mustCreateUser(t, s, "bob@example.com")
+
+	var created []database.Chirp
+	for _, author := range []database.User{alice, bob, alice} {
+		chirp, err := s.CreateChirp(ctx, database.CreateChirpParams{Body: "hello", UserID: author.ID, Status: "published"})
+		if err != nil {
+			t.Fatalf("expected to create chirp: %v", err)
+		}
+		created = append(created, chirp)
+		time.Sleep(time.Millisecond)
+	}
+
+	all, err := s.ListChirps(ctx, uuid.Nil)
+	if err != nil || len(all) != 3 {
+		t.Fatalf("expected 3 chirps, instead got %d, %v", len(all), err)
+	}
+
+	for i := range all {
+		if all[i].ID != created[i].ID {
+			t.Errorf("expected chirps in creation order")
+		}
+	}
+
+	alices, err := s.ListChirpsForAuthor(ctx, database.ListChirpsForAuthorParams{UserID: alice.ID})
+	if err != nil || len(alices) != 2 {
+		t.Errorf("expected 2 chirps for alice, instead got %d, %v", len(alices), err)
+	}
+
+	got, err := s.GetChirp(ctx, created[1].ID)
+	if err != nil || got.UserID != bob.ID || got.Body != "hello" {
+		t.Errorf("expected to get bob's chirp, instead got %+v, %v", got, err)
+	}
+
+	err = s.DeleteChirp(ctx, created[1].ID)
+	if err != nil {
+		t.Errorf("expected to delete chirp: %v", err)
+	}
+
+	_, err = s.GetChirp(ctx, created[1].ID)
+	if !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected sql.ErrNoRows for a deleted chirp, instead got %v", err)
+	}
+}
+
+func testScheduledChirps(t *testing.T, s Store) {
+	ctx := context.Background()
+	alice := mustCreateUser(t, s, "alice@example.com")
+	now := time.Now().UTC().Truncate(time.Second)
+
+	create := func(status string, publishIn time.Duration) database.Chirp {
+		t.Helper()
+
+		params := database.CreateChirpParams{Body: status, UserID: alice.ID, Status: status}
+		if publishIn != 0 {
+			params.PublishAt = sql.NullTime{Time: now.Add(publishIn), Valid: true}
+		}
+
+		chirp, err := s.CreateChirp(ctx, params)
+		if err != nil {
+			t.Fatalf("expected to create %s chirp: %v", status, err)
+		}
+
+		return chirp
+	}
+
+	published := create("published", 0)
+	draft := create("draft", 0)
+	soon := create("scheduled", time.Hour)
+	later := create("scheduled", 2*time.Hour)
+
+	all, err := s.ListChirps(ctx, uuid.Nil)
+	if err != nil || len(all) != 1 || all[0].ID != published.ID {
+		t.Errorf("expected only the published chirp, instead got %+v, %v", all, err)
+	}
+
+	alices, err := s.ListChirpsForAuthor(ctx, database.ListChirpsForAuthorParams{UserID: alice.ID})
+	if err != nil || len(alices) != 1 {
+		t.Errorf("expected one published chirp for alice, instead got %+v, %v", alices, err)
+	}
+
+	counts, err := s.GetUserCounts(ctx, alice.ID)
+	if err != nil || counts.ChirpCount != 1 {
+		t.Errorf("expected to count only published chirps, instead got %+v, %v", counts, err)
+	}
+
+	unpublished, err := s.ListUnpublishedChirps(ctx, alice.ID)
+	if err != nil || len(unpublished) != 3 || unpublished[0].ID != draft.ID || !unpublished[1].PublishAt.Time.Equal(soon.PublishAt.Time) {
+		t.Errorf("expected the draft and scheduled chirps, instead got %+v, %v", unpublished, err)
+	}
+
+	due, err := s.PublishDueChirps(ctx, database.PublishDueChirpsParams{Now: now.Add(90 * time.Minute), Limit: 10})
+	if err != nil || len(due) != 1 || due[0].ID != soon.ID || due[0].Status != "published" || due[0].CreatedAt.Before(soon.CreatedAt) {
+		t.Errorf("expected to publish the chirp that is due, instead got %+v, %v", due, err)
+	}
+
+	due, err = s.PublishDueChirps(ctx, database.PublishDueChirpsParams{Now: now.Add(90 * time.Minute), Limit: 10})
+	if err != nil || len(due) != 0 {
+		t.Errorf("expected to publish nothing twice, instead got %+v, %v", due, err)
+	}
+
+	create("scheduled", time.Hour)
+	due, err = s.PublishDueChirps(ctx, database.PublishDueChirpsParams{Now: now.Add(3 * time.Hour), Limit: 1})
+	if err != nil || len(due) != 1 || due[0].ID == later.ID {
+		t.Errorf("expected to publish the earliest due chirp up to the limit, instead got %+v, %v", due, err)
+	}
+
+	_, err = s.PublishChirp(ctx, database.PublishChirpParams{ID: draft.ID, UpdatedAt: draft.UpdatedAt.Add(time.Second)})
+	if !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected sql.ErrNoRows publishing a stale version, instead got %v", err)
+	}
+
+	got, err := s.PublishChirp(ctx, database.PublishChirpParams{ID: draft.ID, UpdatedAt: draft.UpdatedAt})
+	if err != nil || got.Status != "published" {
+		t.Errorf("expected to publish the draft, instead got %+v, %v", got, err)
+	}
+
+	_, err = s.PublishChirp(ctx, database.PublishChirpParams{ID: got.ID, UpdatedAt: got.UpdatedAt})
+	if !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected sql.ErrNoRows publishing a published chirp, instead got %v", err)
+	}
+
+	all, err = s.ListChirps(ctx, uuid.Nil)
+	if err != nil || len(all) != 4 {
+		t.Errorf("expected 4 published chirps, instead got %d, %v", len(all), err)
+	}
+}
+
+func testChirpVersions(t *testing.T, s Store) {
+	ctx := context.Background()
+
+	user := mustCreateUser(t, s, "versions@example.com")
+	chirp, err := s.CreateChirp(ctx, database.CreateChirpParams{Body: "first", UserID: user.ID, Status: "published"})
+	if err != nil {
+		t.Fatalf("expected to create chirp: %v", err)
+	}
+	time.Sleep(time.Millisecond)
+
+	updated, err := s.UpdateChirp(ctx, database.UpdateChirpParams{ID: chirp.ID, Body: "second", UpdatedAt: chirp.UpdatedAt})
+	if err != nil || updated.Body != "second" || !updated.UpdatedAt.After(chirp.UpdatedAt) {
+		t.Fatalf("expected chirp to be updated, instead got %+v, %v", updated, err)
+	}
+
+	_, err = s.UpdateChirp(ctx, database.UpdateChirpParams{ID: chirp.ID, Body: "stale", UpdatedAt: chirp.UpdatedAt})
+	if !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected sql.ErrNoRows updating a stale version, instead got %v", err)
+	}
+
+	deleted, err := s.SoftDeleteChirp(ctx, database.SoftDeleteChirpParams{ID: chirp.ID, UpdatedAt: chirp.UpdatedAt})
+	if err != nil || deleted != 0 {
+		t.Errorf("expected a stale version not to be deleted, instead got %d, %v", deleted, err)
+	}
+
+	deleted, err = s.SoftDeleteChirp(ctx, database.SoftDeleteChirpParams{ID: chirp.ID, UpdatedAt: updated.UpdatedAt})
+	if err != nil || deleted != 1 {
+		t.Errorf("expected the current version to be deleted, instead got %d, %v", deleted, err)
+	}
+
+	_, err = s.UpdateChirp(ctx, database.UpdateChirpParams{ID: chirp.ID, Body: "third", UpdatedAt: updated.UpdatedAt})
+	if !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected sql.ErrNoRows updating a deleted chirp, instead got %v", err)
+	}
+}
+
+func testDeletedChirps(t *testing.T, s Store) {
+	ctx := context.Background()
+
+	user := mustCreateUser(t, s, "deleted@example.com")
+	chirp, err := s.CreateChirp(ctx, database.CreateChirpParams{Body: "#oops", UserID: user.ID, Status: "published"})
+	if err != nil {
+		t.Fatalf("expected to create chirp: %v", err)
+	}
+
+	err = s.CreateChirpEntity(ctx, database.CreateChirpEntityParams{
+		ChirpID:   chirp.ID,
+		Type:      "hashtag",
+		EndOffset: 5,
+		Text:      "oops",
+	})
+	if err != nil {
+		t.Fatalf("expected to create chirp entity: %v", err)
+	}
+
+	_, err = s.GetDeletedChirp(ctx, chirp.ID)
+	if !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected sql.ErrNoRows getting a chirp that isn't deleted as deleted, instead got %v", err)
+	}
+
+	deleted, err := s.SoftDeleteChirp(ctx, database.SoftDeleteChirpParams{ID: chirp.ID, UpdatedAt: chirp.UpdatedAt})
+	if err != nil || deleted != 1 {
+		t.Fatalf("expected chirp to be deleted, instead got %d, %v", deleted, err)
+	}
+
+	_, err = s.GetChirp(ctx, chirp.ID)
+	if !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected sql.ErrNoRows getting a deleted chirp, instead got %v", err)
+	}
+
+	all, err := s.ListChirps(ctx, uuid.Nil)
+	if err != nil || len(all) != 0 {
+		t.Errorf("expected no chirps listed, instead got %d, %v", len(all), err)
+	}
+
+	byAuthor, err := s.ListChirpsForAuthor(ctx, database.ListChirpsForAuthorParams{UserID: user.ID})
+	if err != nil || len(byAuthor) != 0 {
+		t.Errorf("expected no chirps listed for the author, instead got %d, %v", len(byAuthor), err)
+	}
+
+	tagged, err := s.ListChirpsForHashtag(ctx, database.ListChirpsForHashtagParams{Text: "oops"})
+	if err != nil || len(tagged) != 0 {
+		t.Errorf("expected no chirps listed for the hashtag, instead got %d, %v", len(tagged), err)
+	}
+
+	counts, err := s.GetUserCounts(ctx, user.ID)
+	if err != nil || counts.ChirpCount != 0 {
+		t.Errorf("expected the deleted chirp not to be counted, instead got %+v, %v", counts, err)
+	}
+
+	got, err := s.GetDeletedChirp(ctx, chirp.ID)
+	if err != nil || got.Body != "#oops" || !got.DeletedAt.Valid {
+		t.Errorf("expected to get the deleted chirp, instead got %+v, %v", got, err)
+	}
+
+	_, err = s.RestoreChirp(ctx, database.RestoreChirpParams{ID: chirp.ID, DeletedAfter: time.Now().Add(time.Hour)})
+	if !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected sql.ErrNoRows restoring a chirp deleted before the window, instead got %v", err)
+	}
+
+	restored, err := s.RestoreChirp(ctx, database.RestoreChirpParams{ID: chirp.ID, DeletedAfter: time.Now().Add(-time.Hour)})
+	if err != nil || restored.DeletedAt.Valid {
+		t.Fatalf("expected chirp to be restored, instead got %+v, %v", restored, err)
+	}
+
+	tagged, err = s.ListChirpsForHashtag(ctx, database.ListChirpsForHashtagParams{Text: "oops"})
+	if err != nil || len(tagged) != 1 {
+		t.Errorf("expected the restored chirp listed for the hashtag, instead got %d, %v", len(tagged), err)
+	}
+
+	_, err = s.SoftDeleteChirp(ctx, database.SoftDeleteChirpParams{ID: chirp.ID, UpdatedAt: restored.UpdatedAt})
+	if err != nil {
+		t.Fatalf("expected chirp to be deleted: %v", err)
+	}
+
+	purged, err := s.PurgeDeletedChirps(ctx, time.Now().Add(-time.Hour))
+	if err != nil || purged != 0 {
+		t.Errorf("expected a recently deleted chirp to be kept, instead got %d, %v", purged, err)
+	}
+
+	purged, err = s.PurgeDeletedChirps(ctx, time.Now().Add(time.Hour))
+	if err != nil || purged != 1 {
+		t.Errorf("expected the deleted chirp to be purged, instead got %d, %v", purged, err)
+	}
+
+	_, err = s.GetDeletedChirp(ctx, chirp.ID)
+	if !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected sql.ErrNoRows getting a purged chirp, instead got %v", err)
+	}
+}
+
+func testTransactions(t *testing.T, s Store) {
+	ctx := context.Background()
+
+	author := mustCreateUser(t, s, "tx@example.com")
+
+	var committed database.Chirp
+	err := s.InTx(ctx, func(ctx context.Context) error {
+		var err error
+		committed, err = s.CreateChirp(ctx, database.CreateChirpParams{Body: "committed", UserID: author.ID, Status: "published"})
+		if err != nil {
+			return err
+		}
+
+		// A nested transaction joins this one.
+		return s.InTx(ctx, func(ctx context.Context) error {
+			_, err := s.CreateChirpEvent(ctx, database.CreateChirpEventParams{Type: "chirp.created", UserID: author.ID, Data: "{}"})
+			return err
+		})
+	})
+	if err != nil {
+		t.Fatalf("expected the transaction to commit: %v", err)
+	}
+
+	_, err = s.GetChirp(ctx, committed.ID)
+	if err != nil {
+		t.Errorf("expected the committed chirp, instead got %v", err)
+	}
+
+	events, err := s.ListChirpEventsAfter(ctx, database.ListChirpEventsAfterParams{ID: 0, Limit: 10})
+	if err != nil || len(events) != 1 {
+		t.Errorf("expected the committed event, instead got %+v, %v", events, err)
+	}
+
+	failed := errors.New("failed")
+	var rolledBack database.Chirp
+	err = s.InTx(ctx, func(ctx context.Context) error {
+		var err error
+		rolledBack, err = s.CreateChirp(ctx, database.CreateChirpParams{Body: "rolled back", UserID: author.ID, Status: "published"})
+		if err != nil {
+			return err
+		}
+
+		_, err = s.CreateChirpEvent(ctx, database.CreateChirpEventParams{Type: "chirp.created", UserID: author.ID, Data: "{}"})
+		if err != nil {
+			return err
+		}
+
+		_, err = s.GetChirp(ctx, rolledBack.ID)
+		if err != nil {
+			t.Errorf("expected the transaction to see its own chirp, instead got %v", err)
+		}
+
+		return failed
+	})
+	if !errors.Is(err, failed) {
+		t.Errorf("expected the error of the transaction, instead got %v", err)
+	}
+
+	_, err = s.GetChirp(ctx, rolledBack.ID)
+	if !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected the chirp to be rolled back, instead got %v", err)
+	}
+
+	events, err = s.ListChirpEventsAfter(ctx, database.ListChirpEventsAfterParams{ID: 0, Limit: 10})
+	if err != nil || len(events) != 1 {
+		t.Errorf("expected the event to be rolled back, instead got %+v, %v", events, err)
+	}
+}
+
+func testRefreshTokens(t *testing.T, s Store) {
+	ctx := context.Background()
+
+	user := mustCreateUser(t, s, "tokens@example.com")
+	expiresAt := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+
+	_, err := s.CreateRefreshToken(ctx, database.CreateRefreshTokenParams{
+		Token:     "token",
+		UserID:    user.ID,
+		ExpiresAt: expiresAt,
+	})
+	if err != nil {
+		t.Fatalf("expected to create refresh token: %v", err)
+	}
+
+	token, err := s.GetRefreshToken(ctx, "token")
+	if err != nil || token.UserID != user.ID || token.RevokedAt.Valid {
+		t.Errorf("expected an unrevoked token for the user, instead got %+v, %v", token, err)
+	}
+
+	if !token.ExpiresAt.Equal(expiresAt) {
+		t.Errorf("expected expiry %v, instead got %v", expiresAt, token.ExpiresAt)
+	}
+
+	err = s.RevokeToken(ctx, "token")
+	if err != nil {
+		t.Errorf("expected to revoke token: %v", err)
+	}
+
+	token, err = s.GetRefreshToken(ctx, "token")
+	if err != nil || !token.RevokedAt.Valid {
+		t.Errorf("expected token to be revoked, instead got %+v, %v", token, err)
+	}
+
+	_, err = s.GetRefreshToken(ctx, "missing")
+	if !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected sql.ErrNoRows for a missing token, instead got %v", err)
+	}
+}
+
+func testDeleteUsersCascades(t *testing.T, s Store) {
+	ctx := context.Background()
+
+	user := mustCreateUser(t, s, "cascade@example.com")
+	_, err := s.CreateChirp(ctx, database.CreateChirpParams{Body: "bye", UserID: user.ID, Status: "published"})
+	if err != nil {
+		t.Fatalf("expected to create chirp: %v", err)
+	}
+
+	_, err = s.CreateRefreshToken(ctx, database.CreateRefreshTokenParams{
+		Token:     "cascade",
+		UserID:    user.ID,
+		ExpiresAt: time.Now().Add(time.Hour),
+	})
+	if err != nil {
+		t.Fatalf("expected to create refresh token: %v", err)
+	}
+
+	_, err = s.CreateModerationAction(ctx, database.CreateModerationActionParams{
+		ModeratorID: user.ID,
+		UserID:      user.ID,
+		Action:      "warn",
+	})
+	if err != nil {
+		t.Fatalf("expected to create moderation action: %v", err)
+	}
+
+	err = s.DeleteUsers(ctx)
+	if err != nil {
+		t.Fatalf("expected to delete users: %v", err)
+	}
+
+	chirps, err := s.ListChirps(ctx, uuid.Nil)
+	if err != nil || len(chirps) != 0 {
+		t.Errorf("expected chirps to be deleted with their users, instead got %d, %v", len(chirps), err)
+	}
+
+	_, err = s.GetRefreshToken(ctx, "cascade")
+	if !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected refresh tokens to be deleted with their users, instead got %v", err)
+	}
+
+	actions, err := s.ListModerationActions(ctx, database.ListModerationActionsParams{LimitCount: 10})
+	if err != nil || len(actions) != 0 {
+		t.Errorf("expected the moderation log to be emptied with the users, instead got %d, %v", len(actions), err)
+	}
+}
+
+func testIdempotencyKeys(t *testing.T, s Store) {
+	ctx := context.Background()
+
+	scope := "user:" + uuid.NewString()
+	id := database.GetIdempotencyKeyParams{Scope: scope, Key: "key"}
+	expiresAt := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+
+	created, err := s.CreateIdempotencyKey(ctx, database.CreateIdempotencyKeyParams{
+		Scope:       scope,
+		Key:         "key",
+		RequestHash: "hash",
+		ExpiresAt:   expiresAt,
+	})
+	if err != nil || created.ResponseStatus.Valid {
+		t.Fatalf("expected an incomplete idempotency key, instead got %+v, %v", created, err)
+	}
+
+	_, err = s.CreateIdempotencyKey(ctx, database.CreateIdempotencyKeyParams{
+		Scope:       scope,
+		Key:         "key",
+		RequestHash: "other hash",
+		ExpiresAt:   expiresAt,
+	})
+	if !IsUniqueViolation(err) {
+		t.Errorf("expected a unique violation for a duplicate key, instead got %v", err)
+	}
+
+	err = s.CompleteIdempotencyKey(ctx, database.CompleteIdempotencyKeyParams{
+		Scope:           scope,
+		Key:             "key",
+		ResponseStatus:  sql.NullInt32{Int32: 201, Valid: true},
+		ResponseBody:    []byte(`{"id":1}`),
+		ResponseHeaders: sql.NullString{String: `{"Etag":["W/\"1\""]}`, Valid: true},
+	})
+	if err != nil {
+		t.Fatalf("expected to complete idempotency key: %v", err)
+	}
+
+	key, err := s.GetIdempotencyKey(ctx, id)
+	if err != nil || key.RequestHash != "hash" || key.ResponseStatus.Int32 != 201 || string(key.ResponseBody) != `{"id":1}` || key.ResponseHeaders.String != `{"Etag":["W/\"1\""]}` {
+		t.Errorf("expected the stored response, instead got %+v, %v", key, err)
+	}
+
+	if !key.ExpiresAt.Equal(expiresAt) {
+		t.Errorf("expected expiry %v, instead got %v", expiresAt, key.ExpiresAt)
+	}
+
+	err = s.DeleteExpiredIdempotencyKeys(ctx, expiresAt.Add(-time.Minute))
+	if err != nil {
+		t.Fatalf("expected to delete expired keys: %v", err)
+	}
+
+	_, err = s.GetIdempotencyKey(ctx, id)
+	if err != nil {
+		t.Errorf("expected an unexpired key to be kept, instead got %v", err)
+	}
+
+	err = s.DeleteExpiredIdempotencyKeys(ctx, expiresAt.Add(time.Minute))
+	if err != nil {
+		t.Fatalf("expected to delete expired keys: %v", err)
+	}
+
+	_, err = s.GetIdempotencyKey(ctx, id)
+	if !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected an expired key to be deleted, instead got %v", err)
+	}
+
+	_, err = s.CreateIdempotencyKey(ctx, database.CreateIdempotencyKeyParams{
+		Scope:       scope,
+		Key:         "key",
+		RequestHash: "hash",
+		ExpiresAt:   expiresAt,
+	})
+	if err != nil {
+		t.Fatalf("expected to reuse a deleted key: %v", err)
+	}
+
+	err = s.DeleteIdempotencyKey(ctx, database.DeleteIdempotencyKeyParams{Scope: scope, Key: "key"})
+	if err != nil {
+		t.Fatalf("expected to delete idempotency key: %v", err)
+	}
+
+	_, err = s.GetIdempotencyKey(ctx, id)
+	if !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected a deleted key to be gone, instead got %v", err)
+	}
+}
+
+func testFollows(t *testing.T, s Store) {
+	ctx := context.Background()
+	alice := mustCreateUser(t, s, "alice@example.com")
+	bob := mustCreateUser(t, s, "bob@example.com")
+	carol := mustCreateUser(t, s, "carol@example.com")
+
+	for i, followee := range []database.User{bob, carol, bob} {
+		followed, err := s.FollowUser(ctx, database.FollowUserParams{FollowerID: alice.ID, FolloweeID: followee.ID})
+		if err != nil {
+			t.Fatalf("expected to follow user: %v", err)
+		}
+
+		expected := int64(1)
+		if i == 2 {
+			expected = 0
+		}
+		if followed != expected {
+			t.Errorf("expected follow %d to affect %d rows, instead got %d", i, expected, followed)
+		}
+	}
+
+	followees, err := s.ListFollowees(ctx, alice.ID)
+	if err != nil || len(followees) != 2 {
+		t.Fatalf("expected following twice to be a no-op, instead got %v, %v", followees, err)
+	}
+
+	_, err = s.FollowUser(ctx, database.FollowUserParams{FollowerID: alice.ID, FolloweeID: uuid.New()})
+	if !IsForeignKeyViolation(err) {
+		t.Errorf("expected a foreign key violation for an unknown user, instead got %v", err)
+	}
+
+	err = s.UnfollowUser(ctx, database.UnfollowUserParams{FollowerID: alice.ID, FolloweeID: bob.ID})
+	if err != nil {
+		t.Fatalf("expected to unfollow user: %v", err)
+	}
+
+	followees, err = s.ListFollowees(ctx, alice.ID)
+	if err != nil || len(followees) != 1 || followees[0] != carol.ID {
+		t.Errorf("expected to follow only carol, instead got %v, %v", followees, err)
+	}
+
+	followees, err = s.ListFollowees(ctx, bob.ID)
+	if err != nil || len(followees) != 0 {
+		t.Errorf("expected bob to follow nobody, instead got %v, %v", followees, err)
+	}
+}
+
+func testBlocks(t *testing.T, s Store) {
+	ctx := context.Background()
+	alice := mustCreateUser(t, s, "alice@example.com")
+	bob := mustCreateUser(t, s, "bob@example.com")
+	carol := mustCreateUser(t, s, "carol@example.com")
+
+	for _, author := range []database.User{alice, bob, carol} {
+		chirp, err := s.CreateChirp(ctx, database.CreateChirpParams{Body: "hi #go", UserID: author.ID, Status: "published"})
+		if err != nil {
+			t.Fatalf("expected to create chirp: %v", err)
+		}
+
+		err = s.CreateChirpEntity(ctx, database.CreateChirpEntityParams{ChirpID: chirp.ID, Type: "hashtag", StartOffset: 3, EndOffset: 6, Text: "go"})
+		if err != nil {
+			t.Fatalf("expected to create chirp entity: %v", err)
+		}
+	}
+
+	// Alice blocks bob and mutes carol.
+	for i := 0; i < 2; i++ {
+		blocked, err := s.BlockUser(ctx, database.BlockUserParams{BlockerID: alice.ID, BlockedID: bob.ID})
+		if err != nil || blocked != int64(1-i) {
+			t.Errorf("expected block %d to affect %d rows, instead got %d, %v", i, 1-i, blocked, err)
+		}
+
+		muted, err := s.MuteUser(ctx, database.MuteUserParams{MuterID: alice.ID, MutedID: carol.ID})
+		if err != nil || muted != int64(1-i) {
+			t.Errorf("expected mute %d to affect %d rows, instead got %d, %v", i, 1-i, muted, err)
+		}
+	}
+
+	_, err := s.BlockUser(ctx, database.BlockUserParams{BlockerID: alice.ID, BlockedID: uuid.New()})
+	if !IsForeignKeyViolation(err) {
+		t.Errorf("expected a foreign key violation for an unknown user, instead got %v", err)
+	}
+
+	relationship, err := s.GetRelationship(ctx, database.GetRelationshipParams{UserID: bob.ID, OtherID: alice.ID})
+	if err != nil || !relationship.BlockedBy || relationship.Muting {
+		t.Errorf("expected bob to be blocked by alice, instead got %+v, %v", relationship, err)
+	}
+
+	relationship, err = s.GetRelationship(ctx, database.GetRelationshipParams{UserID: alice.ID, OtherID: carol.ID})
+	if err != nil || relationship.BlockedBy || !relationship.Muting {
+		t.Errorf("expected alice to be muting carol, instead got %+v, %v", relationship, err)
+	}
+
+	blockers, err := s.ListBlockers(ctx, bob.ID)
+	if err != nil || len(blockers) != 1 || blockers[0] != alice.ID {
+		t.Errorf("expected bob to be blocked by alice only, instead got %v, %v", blockers, err)
+	}
+
+	mutedUsers, err := s.ListMutedUsers(ctx, alice.ID)
+	if err != nil || len(mutedUsers) != 1 || mutedUsers[0] != carol.ID {
+		t.Errorf("expected alice to be muting carol only, instead got %v, %v", mutedUsers, err)
+	}
+
+	blockers, err = s.ListBlockers(ctx, carol.ID)
+	if err != nil || len(blockers) != 0 {
+		t.Errorf("expected carol not to be blocked, instead got %v, %v", blockers, err)
+	}
+
+	// Bob doesn't see alice's chirps, and alice doesn't see carol's.
+	for _, c := range []struct {
+		viewer   uuid.UUID
+		expected int
+	}{{uuid.Nil, 3}, {alice.ID, 2}, {bob.ID, 2}, {carol.ID, 3}} {
+		all, err := s.ListChirps(ctx, c.viewer)
+		if err != nil || len(all) != c.expected {
+			t.Errorf("expected %s to see %d chirps, instead got %d, %v", c.viewer, c.expected, len(all), err)
+		}
+
+		tagged, err := s.ListChirpsForHashtag(ctx, database.ListChirpsForHashtagParams{Text: "go", ViewerID: c.viewer})
+		if err != nil || len(tagged) != c.expected {
+			t.Errorf("expected %s to see %d #go chirps, instead got %d, %v", c.viewer, c.expected, len(tagged), err)
+		}
+	}
+
+	byAuthor, err := s.ListChirpsForAuthor(ctx, database.ListChirpsForAuthorParams{UserID: alice.ID, ViewerID: bob.ID})
+	if err != nil || len(byAuthor) != 0 {
+		t.Errorf("expected bob not to see alice's chirps, instead got %d, %v", len(byAuthor), err)
+	}
+
+	byAuthor, err = s.ListChirpsForAuthor(ctx, database.ListChirpsForAuthorParams{UserID: carol.ID, ViewerID: alice.ID})
+	if err != nil || len(byAuthor) != 1 {
+		t.Errorf("expected muted chirps when asking for their author, instead got %d, %v", len(byAuthor), err)
+	}
+
+	err = s.UnblockUser(ctx, database.UnblockUserParams{BlockerID: alice.ID, BlockedID: bob.ID})
+	if err != nil {
+		t.Fatalf("expected to unblock user: %v", err)
+	}
+
+	err = s.UnmuteUser(ctx, database.UnmuteUserParams{MuterID: alice.ID, MutedID: carol.ID})
+	if err != nil {
+		t.Fatalf("expected to unmute user: %v", err)
+	}
+
+	for _, viewer := range []uuid.UUID{alice.ID, bob.ID} {
+		all, err := s.ListChirps(ctx, viewer)
+		if err != nil || len(all) != 3 {
+			t.Errorf("expected %s to see every chirp again, instead got %d, %v", viewer, len(all), err)
+		}
+	}
+}
+
+func testBookmarks(t *testing.T, s Store) {
+	ctx := context.Background()
+	alice := mustCreateUser(t, s, "alice@example.com")
+	bob := mustCreateUser(t, s, "bob@example.com")
+
+	var chirps []database.Chirp
+	for i := 0; i < 3; i++ {
+		chirp, err := s.CreateChirp(ctx, database.CreateChirpParams{Body: "hello", UserID: bob.ID, Status: "published"})
+		if err != nil {
+			t.Fatalf("expected to create chirp: %v", err)
+		}
+		chirps = append(chirps, chirp)
+
+		bookmarked, err := s.BookmarkChirp(ctx, database.BookmarkChirpParams{UserID: alice.ID, ChirpID: chirp.ID})
+		if err != nil || bookmarked != 1 {
+			t.Fatalf("expected to bookmark chirp, instead got %d, %v", bookmarked, err)
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	bookmarked, err := s.BookmarkChirp(ctx, database.BookmarkChirpParams{UserID: alice.ID, ChirpID: chirps[0].ID})
+	if err != nil || bookmarked != 0 {
+		t.Errorf("expected bookmarking again to be a no-op, instead got %d, %v", bookmarked, err)
+	}
+
+	_, err = s.BookmarkChirp(ctx, database.BookmarkChirpParams{UserID: alice.ID, ChirpID: uuid.New()})
+	if !IsForeignKeyViolation(err) {
+		t.Errorf("expected a foreign key violation for an unknown chirp, instead got %v", err)
+	}
+
+	page, err := s.ListBookmarkedChirps(ctx, database.ListBookmarkedChirpsParams{UserID: alice.ID, Limit: 2})
+	if err != nil || len(page) != 2 || page[0].ID != chirps[2].ID || page[1].ID != chirps[1].ID {
+		t.Fatalf("expected the newest 2 bookmarks, instead got %+v, %v", page, err)
+	}
+
+	last, err := s.GetBookmark(ctx, database.GetBookmarkParams{UserID: alice.ID, ChirpID: page[1].ID})
+	if err != nil {
+		t.Fatalf("expected to get bookmark: %v", err)
+	}
+
+	page, err = s.ListBookmarkedChirpsBefore(ctx, database.ListBookmarkedChirpsBeforeParams{
+		UserID:    alice.ID,
+		Limit:     2,
+		CreatedAt: last.CreatedAt,
+		ChirpID:   last.ChirpID,
+	})
+	if err != nil || len(page) != 1 || page[0].ID != chirps[0].ID {
+		t.Errorf("expected the oldest bookmark on the next page, instead got %+v, %v", page, err)
+	}
+
+	deleted, err := s.SoftDeleteChirp(ctx, database.SoftDeleteChirpParams{ID: chirps[2].ID, UpdatedAt: chirps[2].UpdatedAt})
+	if err != nil || deleted != 1 {
+		t.Fatalf("expected to delete chirp, instead got %d, %v", deleted, err)
+	}
+
+	err = s.UnbookmarkChirp(ctx, database.UnbookmarkChirpParams{UserID: alice.ID, ChirpID: chirps[1].ID})
+	if err != nil {
+		t.Fatalf("expected to remove bookmark: %v", err)
+	}
+
+	page, err = s.ListBookmarkedChirps(ctx, database.ListBookmarkedChirpsParams{UserID: alice.ID, Limit: 10})
+	if err != nil || len(page) != 1 || page[0].ID != chirps[0].ID {
+		t.Errorf("expected deleted and removed bookmarks to be left out, instead got %+v, %v", page, err)
+	}
+
+	_, err = s.GetBookmark(ctx, database.GetBookmarkParams{UserID: alice.ID, ChirpID: chirps[1].ID})
+	if !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected sql.ErrNoRows for a removed bookmark, instead got %v", err)
+	}
+
+	_, err = s.PurgeDeletedChirps(ctx, time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatalf("expected to purge deleted chirps: %v", err)
+	}
+
+	_, err = s.GetBookmark(ctx, database.GetBookmarkParams{UserID: alice.ID, ChirpID: chirps[2].ID})
+	if !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected bookmarks of purged chirps to go, instead got %v", err)
+	}
+}
+
+func testPinnedChirps(t *testing.T, s Store) {
+	ctx := context.Background()
+	user := mustCreateUser(t, s, "pins@example.com")
+
+	var chirps []database.Chirp
+	for i := 0; i < 2; i++ {
+		chirp, err := s.CreateChirp(ctx, database.CreateChirpParams{Body: "hello", UserID: user.ID, Status: "published"})
+		if err != nil {
+			t.Fatalf("expected to create chirp: %v", err)
+		}
+		chirps = append(chirps, chirp)
+	}
+
+	_, err := s.GetPinnedChirp(ctx, user.ID)
+	if !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected sql.ErrNoRows with nothing pinned, instead got %v", err)
+	}
+
+	for _, chirp := range chirps {
+		err = s.PinChirp(ctx, database.PinChirpParams{UserID: user.ID, ChirpID: chirp.ID})
+		if err != nil {
+			t.Fatalf("expected to pin chirp: %v", err)
+		}
+	}
+
+	pinned, err := s.GetPinnedChirp(ctx, user.ID)
+	if err != nil || pinned.ID != chirps[1].ID {
+		t.Errorf("expected pinning to replace the pinned chirp, instead got %+v, %v", pinned, err)
+	}
+
+	unpinned, err := s.UnpinChirp(ctx, database.UnpinChirpParams{UserID: user.ID, ChirpID: chirps[0].ID})
+	if err != nil || unpinned != 0 {
+		t.Errorf("expected unpinning a chirp that isn't pinned to be a no-op, instead got %d, %v", unpinned, err)
+	}
+
+	deleted, err := s.SoftDeleteChirp(ctx, database.SoftDeleteChirpParams{ID: chirps[1].ID, UpdatedAt: chirps[1].UpdatedAt})
+	if err != nil || deleted != 1 {
+		t.Fatalf("expected to delete chirp, instead got %d, %v", deleted, err)
+	}
+
+	_, err = s.GetPinnedChirp(ctx, user.ID)
+	if !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected sql.ErrNoRows for a deleted pinned chirp, instead got %v", err)
+	}
+
+	err = s.PinChirp(ctx, database.PinChirpParams{UserID: user.ID, ChirpID: chirps[0].ID})
+	if err != nil {
+		t.Fatalf("expected to pin chirp: %v", err)
+	}
+
+	unpinned, err = s.UnpinChirp(ctx, database.UnpinChirpParams{UserID: user.ID, ChirpID: chirps[0].ID})
+	if err != nil || unpinned != 1 {
+		t.Errorf("expected to unpin chirp, instead got %d, %v", unpinned, err)
+	}
+
+	_, err = s.GetPinnedChirp(ctx, user.ID)
+	if !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected sql.ErrNoRows after unpinning, instead got %v", err)
+	}
+}
+
+func testPolls(t *testing.T, s Store) {
+	ctx := context.Background()
+	author := mustCreateUser(t, s, "poller@example.com")
+	voter := mustCreateUser(t, s, "voter@example.com")
+
+	var polls []database.Poll
+	for _, closesAt := range []time.Time{time.Now().Add(time.Hour), time.Now().Add(-time.Hour)} {
+		chirp, err := s.CreateChirp(ctx, database.CreateChirpParams{Body: "which?", UserID: author.ID, Status: "published"})
+		if err != nil {
+			t.Fatalf("expected to create chirp: %v", err)
+		}
+
+		poll, err := s.CreatePoll(ctx, database.CreatePollParams{ChirpID: chirp.ID, ClosesAt: closesAt})
+		if err != nil {
+			t.Fatalf("expected to create poll: %v", err)
+		}
+		polls = append(polls, poll)
+
+		for i, text := range []string{"yes", "no"} {
+			_, err = s.CreatePollOption(ctx, database.CreatePollOptionParams{ChirpID: chirp.ID, Position: int32(i), Text: text})
+			if err != nil {
+				t.Fatalf("expected to create poll option: %v", err)
+			}
+		}
+	}
+	open, closed := polls[0], polls[1]
+
+	_, err := s.CreatePoll(ctx, database.CreatePollParams{ChirpID: open.ChirpID, ClosesAt: time.Now()})
+	if !IsUniqueViolation(err) {
+		t.Errorf("expected a unique violation for a second poll on a chirp, instead got %v", err)
+	}
+
+	options, err := s.ListPollOptionsForChirps(ctx, []uuid.UUID{open.ChirpID, closed.ChirpID})
+	if err != nil || len(options) != 4 {
+		t.Fatalf("expected the options of both polls, instead got %+v, %v", options, err)
+	}
+	if options[0].ChirpID != options[1].ChirpID || options[0].Position != 0 || options[1].Position != 1 {
+		t.Errorf("expected options in order, instead got %+v", options)
+	}
+
+	// Options are grouped by chirp, in an order that depends on the IDs.
+	openOption, closedOption := options[0], options[2]
+	if openOption.ChirpID != open.ChirpID {
+		openOption, closedOption = closedOption, openOption
+	}
+
+	_, err = s.VotePoll(ctx, database.VotePollParams{ChirpID: open.ChirpID, UserID: voter.ID, OptionID: closedOption.ID})
+	if !IsForeignKeyViolation(err) {
+		t.Errorf("expected a foreign key violation voting for another poll's option, instead got %v", err)
+	}
+
+	_, err = s.VotePoll(ctx, database.VotePollParams{ChirpID: closed.ChirpID, UserID: voter.ID, OptionID: closedOption.ID})
+	if !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected sql.ErrNoRows voting on a closed poll, instead got %v", err)
+	}
+
+	// Only one of concurrent votes by the same user may count.
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	voted := 0
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			_, err := s.VotePoll(ctx, database.VotePollParams{ChirpID: open.ChirpID, UserID: voter.ID, OptionID: openOption.ID})
+			mu.Lock()
+			defer mu.Unlock()
+			if err == nil {
+				voted++
+			} else if !IsUniqueViolation(err) {
+				t.Errorf("expected a unique violation voting twice, instead got %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if voted != 1 {
+		t.Errorf("expected exactly one vote to count, instead got %d", voted)
+	}
+
+	_, err = s.VotePoll(ctx, database.VotePollParams{ChirpID: open.ChirpID, UserID: author.ID, OptionID: openOption.ID})
+	if err != nil {
+		t.Fatalf("expected the author to vote: %v", err)
+	}
+
+	options, err = s.ListPollOptionsForChirps(ctx, []uuid.UUID{open.ChirpID})
+	if err != nil || len(options) != 2 || options[0].Votes != 2 || options[1].Votes != 0 {
+		t.Errorf("expected the vote counts, instead got %+v, %v", options, err)
+	}
+
+	votes, err := s.ListPollVotesForUser(ctx, database.ListPollVotesForUserParams{
+		UserID:   voter.ID,
+		ChirpIds: []uuid.UUID{open.ChirpID, closed.ChirpID},
+	})
+	if err != nil || len(votes) != 1 || votes[0].OptionID != openOption.ID {
+		t.Errorf("expected the voter's vote, instead got %+v, %v", votes, err)
+	}
+
+	err = s.DeleteChirp(ctx, open.ChirpID)
+	if err != nil {
+		t.Fatalf("expected to delete chirp: %v", err)
+	}
+
+	remaining, err := s.ListPollsForChirps(ctx, []uuid.UUID{open.ChirpID, closed.ChirpID})
+	if err != nil || len(remaining) != 1 || remaining[0].ChirpID != closed.ChirpID {
+		t.Errorf("expected the poll to be deleted with its chirp, instead got %+v, %v", remaining, err)
+	}
+
+	votes, err = s.ListPollVotesForUser(ctx, database.ListPollVotesForUserParams{UserID: voter.ID, ChirpIds: []uuid.UUID{open.ChirpID}})
+	if err != nil || len(votes) != 0 {
+		t.Errorf("expected the votes to be deleted with the poll, instead got %+v, %v", votes, err)
+	}
+}
+
+func testChirpEvents(t *testing.T, s Store) {
+	ctx := context.Background()
+	user := mustCreateUser(t, s, "events@example.com")
+
+	var created []database.ChirpEvent
+	for _, eventType := range []string{"chirp.created", "chirp.created", "chirp.deleted"} {
+		event, err := s.CreateChirpEvent(ctx, database.CreateChirpEventParams{
+			Type:   eventType,
+			UserID: user.ID,
+			Data:   `{}`,
+		})
+		if err != nil {
+			t.Fatalf("expected to create chirp event: %v", err)
+		}
+		if len(created) > 0 && event.ID <= created[len(created)-1].ID {
+			t.Errorf("expected event ids to increase, instead got %d after %d", event.ID, created[len(created)-1].ID)
+		}
+		created = append(created, event)
+	}
+
+	event, err := s.GetChirpEvent(ctx, created[2].ID)
+	if err != nil || event.Type != "chirp.deleted" || event.UserID != user.ID {
+		t.Errorf("expected the deleted event, instead got %+v, %v", event, err)
+	}
+
+	_, err = s.GetChirpEvent(ctx, created[2].ID+1000)
+	if !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected sql.ErrNoRows for a missing event, instead got %v", err)
+	}
+
+	events, err := s.ListChirpEventsAfter(ctx, database.ListChirpEventsAfterParams{ID: created[0].ID, Limit: 1})
+	if err != nil || len(events) != 1 || events[0].ID != created[1].ID {
+		t.Errorf("expected the second event, instead got %+v, %v", events, err)
+	}
+
+	// An event recorded while a transaction that recorded one is open
+	// commits after it, so a client resuming from it misses nothing.
+	recorded := make(chan struct{})
+	release := make(chan struct{})
+	committed := make(chan error)
+	go func() {
+		committed <- s.InTx(ctx, func(ctx context.Context) error {
+			_, err := s.CreateChirpEvent(ctx, database.CreateChirpEventParams{Type: "chirp.created", UserID: user.ID, Data: `"first"`})
+			close(recorded)
+			<-release
+			return err
+		})
+	}()
+	<-recorded
+
+	second := make(chan database.ChirpEvent, 1)
+	go func() {
+		event, err := s.CreateChirpEvent(ctx, database.CreateChirpEventParams{Type: "chirp.created", UserID: user.ID, Data: `"second"`})
+		if err != nil {
+			t.Errorf("expected to create chirp event: %v", err)
+		}
+		second <- event
+	}()
+
+	select {
+	case event := <-second:
+		t.Errorf("expected the event to wait for the open transaction, instead got %+v", event)
+		second <- event
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+	err = <-committed
+	if err != nil {
+		t.Fatalf("expected the transaction to commit: %v", err)
+	}
+	last := <-second
+
+	events, err = s.ListChirpEventsAfter(ctx, database.ListChirpEventsAfterParams{ID: created[2].ID, Limit: 10})
+	if err != nil || len(events) != 2 || events[0].Data != `"first"` || events[1].ID != last.ID {
+		t.Errorf("expected the events in commit order, instead got %+v, %v", events, err)
+	}
+
+	err = s.DeleteChirpEventsBefore(ctx, time.Now().Add(-time.Hour))
+	if err != nil {
+		t.Fatalf("expected to delete old events: %v", err)
+	}
+
+	events, err = s.ListChirpEventsAfter(ctx, database.ListChirpEventsAfterParams{ID: created[0].ID - 1, Limit: 10})
+	if err != nil || len(events) != 5 {
+		t.Errorf("expected recent events to be kept, instead got %+v, %v", events, err)
+	}
+
+	err = s.DeleteChirpEventsBefore(ctx, time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatalf("expected to delete old events: %v", err)
+	}
+
+	events, err = s.ListChirpEventsAfter(ctx, database.ListChirpEventsAfterParams{ID: 0, Limit: 10})
+	if err != nil || len(events) != 0 {
+		t.Errorf("expected every event to be deleted, instead got %+v, %v", events, err)
+	}
+}
+
+func testNotifications(t *testing.T, s Store) {
+	ctx := context.Background()
+	alice := mustCreateUser(t, s, "alice@example.com")
+	bob := mustCreateUser(t, s, "bob@example.com")
+
+	chirp, err := s.CreateChirp(ctx, database.CreateChirpParams{Body: "hi @alice", UserID: bob.ID, Status: "published"})
+	if err != nil {
+		t.Fatalf("expected to create chirp: %v", err)
+	}
+
+	var created []database.Notification
+	for _, chirpID := range []uuid.NullUUID{{}, {UUID: chirp.ID, Valid: true}, {}} {
+		notification, err := s.CreateNotification(ctx, database.CreateNotificationParams{
+			UserID:  alice.ID,
+			ActorID: bob.ID,
+			Type:    "follow",
+			ChirpID: chirpID,
+		})
+		if err != nil {
+			t.Fatalf("expected to create notification: %v", err)
+		}
+		created = append(created, notification)
+	}
+
+	_, err = s.CreateNotification(ctx, database.CreateNotificationParams{UserID: alice.ID, ActorID: uuid.New(), Type: "follow"})
+	if !IsForeignKeyViolation(err) {
+		t.Errorf("expected a foreign key violation for an unknown actor, instead got %v", err)
+	}
+
+	notification, err := s.GetNotification(ctx, created[1].ID)
+	if err != nil || notification.ChirpID.UUID != chirp.ID || notification.ReadAt.Valid {
+		t.Errorf("expected an unread notification about the chirp, instead got %+v, %v", notification, err)
+	}
+
+	first, err := s.ListNotifications(ctx, database.ListNotificationsParams{UserID: alice.ID, Limit: 2})
+	if err != nil || len(first) != 2 {
+		t.Fatalf("expected a first page of 2, instead got %+v, %v", first, err)
+	}
+
+	rest, err := s.ListNotificationsBefore(ctx, database.ListNotificationsBeforeParams{
+		UserID:    alice.ID,
+		Limit:     2,
+		CreatedAt: first[1].CreatedAt,
+		ID:        first[1].ID,
+	})
+	if err != nil || len(rest) != 1 {
+		t.Fatalf("expected a second page of 1, instead got %+v, %v", rest, err)
+	}
+
+	seen := map[uuid.UUID]bool{}
+	for _, n := range append(first, rest...) {
+		seen[n.ID] = true
+	}
+	if len(seen) != 3 {
+		t.Errorf("expected the pages to cover every notification once, instead got %v", seen)
+	}
+
+	marked, err := s.MarkNotificationRead(ctx, database.MarkNotificationReadParams{ID: created[0].ID, UserID: bob.ID})
+	if err != nil || marked != 0 {
+		t.Errorf("expected other users not to mark notifications read, instead got %d, %v", marked, err)
+	}
+
+	marked, err = s.MarkNotificationRead(ctx, database.MarkNotificationReadParams{ID: created[0].ID, UserID: alice.ID})
+	if err != nil || marked != 1 {
+		t.Errorf("expected to mark one notification read, instead got %d, %v", marked, err)
+	}
+
+	unread, err := s.CountUnreadNotifications(ctx, alice.ID)
+	if err != nil || unread != 2 {
+		t.Errorf("expected 2 unread notifications, instead got %d, %v", unread, err)
+	}
+
+	marked, err = s.MarkAllNotificationsRead(ctx, alice.ID)
+	if err != nil || marked != 2 {
+		t.Errorf("expected to mark the other 2 read, instead got %d, %v", marked, err)
+	}
+
+	err = s.DeleteChirp(ctx, chirp.ID)
+	if err != nil {
+		t.Fatalf("expected to delete chirp: %v", err)
+	}
+
+	_, err = s.GetNotification(ctx, created[1].ID)
+	if !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected notifications to be deleted with their chirp, instead got %v", err)
+	}
+
+	for _, enabled := range []bool{false, true, false} {
+		err = s.SetNotificationPreference(ctx, database.SetNotificationPreferenceParams{UserID: alice.ID, Type: "follow", Enabled: enabled})
+		if err != nil {
+			t.Fatalf("expected to set preference: %v", err)
+		}
+	}
+
+	preferences, err := s.ListNotificationPreferences(ctx, alice.ID)
+	if err != nil || len(preferences) != 1 || preferences[0].Type != "follow" || preferences[0].Enabled {
+		t.Errorf("expected follow notifications to be disabled, instead got %+v, %v", preferences, err)
+	}
+}
+
+func testChirpEntities(t *testing.T, s Store) {
+	ctx := context.Background()
+	user := mustCreateUser(t, s, "entities@example.com")
+
+	var chirps []database.Chirp
+	for _, body := range []string{"#go @bob", "#rust", "#go"} {
+		chirp, err := s.CreateChirp(ctx, database.CreateChirpParams{Body: body, UserID: user.ID, Status: "published"})
+		if err != nil {
+			t.Fatalf("expected to create chirp: %v", err)
+		}
+		chirps = append(chirps, chirp)
+	}
+
+	entities := []database.CreateChirpEntityParams{
+		{ChirpID: chirps[0].ID, Type: "mention", StartOffset: 4, EndOffset: 8, Text: "bob", UserID: uuid.NullUUID{UUID: user.ID, Valid: true}},
+		{ChirpID: chirps[0].ID, Type: "hashtag", StartOffset: 0, EndOffset: 3, Text: "go"},
+		{ChirpID: chirps[1].ID, Type: "hashtag", StartOffset: 0, EndOffset: 5, Text: "rust"},
+		{ChirpID: chirps[2].ID, Type: "hashtag", StartOffset: 0, EndOffset: 3, Text: "go"},
+	}
+	for _, entity := range entities {
+		err := s.CreateChirpEntity(ctx, entity)
+		if err != nil {
+			t.Fatalf("expected to create chirp entity: %v", err)
+		}
+	}
+
+	err := s.CreateChirpEntity(ctx, entities[0])
+	if !IsUniqueViolation(err) {
+		t.Errorf("expected a unique violation for a duplicate offset, instead got %v", err)
+	}
+
+	listed, err := s.ListChirpEntities(ctx, []uuid.UUID{chirps[0].ID, chirps[1].ID})
+	if err != nil || len(listed) != 3 {
+		t.Fatalf("expected 3 entities, instead got %+v, %v", listed, err)
+	}
+
+	for _, entity := range listed {
+		if entity.ChirpID == chirps[0].ID && entity.Type == "mention" && entity.UserID.UUID != user.ID {
+			t.Errorf("expected the mention to keep its user, instead got %+v", entity)
+		}
+	}
+
+	if listed[0].ChirpID == chirps[0].ID && listed[0].StartOffset != 0 {
+		t.Errorf("expected entities ordered by offset, instead got %+v", listed)
+	}
+
+	err = s.CreateChirpEntity(ctx, database.CreateChirpEntityParams{ChirpID: chirps[1].ID, Type: "mention", StartOffset: 6, EndOffset: 10, Text: "bob"})
+	if err != nil {
+		t.Fatalf("expected to create chirp entity: %v", err)
+	}
+
+	bob := mustCreateUser(t, s, "bob@example.com")
+
+	blocked := mustCreateUser(t, s, "blocked@example.com")
+	blockedChirp, err := s.CreateChirp(ctx, database.CreateChirpParams{Body: "@bob", UserID: blocked.ID, Status: "published"})
+	if err != nil {
+		t.Fatalf("expected to create chirp: %v", err)
+	}
+	err = s.CreateChirpEntity(ctx, database.CreateChirpEntityParams{ChirpID: blockedChirp.ID, Type: "mention", StartOffset: 0, EndOffset: 4, Text: "bob"})
+	if err != nil {
+		t.Fatalf("expected to create chirp entity: %v", err)
+	}
+	_, err = s.BlockUser(ctx, database.BlockUserParams{BlockerID: bob.ID, BlockedID: blocked.ID})
+	if err != nil {
+		t.Fatalf("expected to block user: %v", err)
+	}
+
+	for i, expected := range []int64{1, 0} {
+		resolved, err := s.ResolveMentions(ctx, database.ResolveMentionsParams{Text: "bob", UserID: uuid.NullUUID{UUID: bob.ID, Valid: true}})
+		if err != nil || resolved != expected {
+			t.Errorf("expected resolving %d to resolve %d mentions, instead got %d, %v", i, expected, resolved, err)
+		}
+	}
+
+	listed, err = s.ListChirpEntities(ctx, []uuid.UUID{chirps[0].ID, chirps[1].ID})
+	if err != nil {
+		t.Fatalf("expected to list chirp entities: %v", err)
+	}
+
+	for _, entity := range listed {
+		if entity.Type != "mention" {
+			continue
+		}
+
+		expected := bob.ID
+		if entity.ChirpID == chirps[0].ID {
+			expected = user.ID
+		}
+		if entity.UserID.UUID != expected {
+			t.Errorf("expected only the unresolved mention to resolve, instead got %+v", entity)
+		}
+	}
+
+	listed, err = s.ListChirpEntities(ctx, []uuid.UUID{blockedChirp.ID})
+	if err != nil || len(listed) != 1 || listed[0].UserID.Valid {
+		t.Errorf("expected the mention by a blocked author to stay unresolved, instead got %+v, %v", listed, err)
+	}
+
+	tagged, err := s.ListChirpsForHashtag(ctx, database.ListChirpsForHashtagParams{Text: "go"})
+	if err != nil || len(tagged) != 2 || tagged[0].ID != chirps[0].ID || tagged[1].ID != chirps[2].ID {
+		t.Errorf("expected the two #go chirps in order, instead got %+v, %v", tagged, err)
+	}
+
+	err = s.DeleteChirpEntities(ctx, chirps[0].ID)
+	if err != nil {
+		t.Fatalf("expected to delete chirp entities: %v", err)
+	}
+
+	err = s.DeleteChirp(ctx, chirps[2].ID)
+	if err != nil {
+		t.Fatalf("expected to delete chirp: %v", err)
+	}
+
+	tagged, err = s.ListChirpsForHashtag(ctx, database.ListChirpsForHashtagParams{Text: "go"})
+	if err != nil || len(tagged) != 0 {
+		t.Errorf("expected no #go chirps left, instead got %+v, %v", tagged, err)
+	}
+
+	listed, err = s.ListChirpEntities(ctx, nil)
+	if err != nil || len(listed) != 0 {
+		t.Errorf("expected no entities for no chirps, instead got %+v, %v", listed, err)
+	}
+}
+
+func testUserProfiles(t *testing.T, s Store) {
+	ctx := context.Background()
+	alice := sql.NullString{String: "alice", Valid: true}
+
+	user, err := s.CreateUser(ctx, database.CreateUserParams{Email: "alice@example.com", HashedPassword: "hash", Username: alice})
+	if err != nil || user.Username != alice || user.DisplayName != "" {
+		t.Fatalf("expected a user with a username and an empty profile, instead got %+v, %v", user, err)
+	}
+
+	_, err = s.CreateUser(ctx, database.CreateUserParams{Email: "other@example.com", HashedPassword: "hash", Username: alice})
+	if !IsUniqueViolation(err) {
+		t.Errorf("expected a unique violation for a duplicate username, instead got %v", err)
+	}
+
+	// Users without usernames don't conflict with each other.
+	bob := mustCreateUser(t, s, "bob@example.com")
+	carol := mustCreateUser(t, s, "carol@example.com")
+
+	found, err := s.GetUserByUsername(ctx, alice)
+	if err != nil || found.ID != user.ID {
+		t.Errorf("expected to find alice by username, instead got %+v, %v", found, err)
+	}
+
+	_, err = s.GetUserByUsername(ctx, sql.NullString{})
+	if !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected no user for a null username, instead got %v", err)
+	}
+
+	updated, err := s.UpdateUserProfile(ctx, database.UpdateUserProfileParams{
+		ID:          bob.ID,
+		Username:    sql.NullString{String: "bob", Valid: true},
+		DisplayName: "Bob",
+		Bio:         "builder",
+		AvatarUrl:   "https://example.com/bob.png",
+	})
+	if err != nil || updated.Username.String != "bob" || updated.Bio != "builder" || updated.AvatarUrl != "https://example.com/bob.png" {
+		t.Errorf("expected bob's profile to be updated, instead got %+v, %v", updated, err)
+	}
+
+	_, err = s.UpdateUserProfile(ctx, database.UpdateUserProfileParams{ID: carol.ID, Username: alice})
+	if !IsUniqueViolation(err) {
+		t.Errorf("expected a unique violation taking alice's username, instead got %v", err)
+	}
+
+	_, err = s.UpdateUserProfile(ctx, database.UpdateUserProfileParams{ID: uuid.New()})
+	if !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected no rows updating a missing user, instead got %v", err)
+	}
+
+	users, err := s.ListUsersByIDs(ctx, []uuid.UUID{user.ID, uuid.New(), bob.ID})
+	if err != nil || len(users) != 2 {
+		t.Errorf("expected the two existing users, instead got %+v, %v", users, err)
+	}
+
+	_, err = s.CreateChirp(ctx, database.CreateChirpParams{Body: "hi", UserID: user.ID, Status: "published"})
+	if err != nil {
+		t.Fatalf("expected to create chirp: %v", err)
+	}
+
+	for _, follower := range []database.User{bob, carol} {
+		_, err = s.FollowUser(ctx, database.FollowUserParams{FollowerID: follower.ID, FolloweeID: user.ID})
+		if err != nil {
+			t.Fatalf("expected to follow user: %v", err)
+		}
+	}
+
+	counts, err := s.GetUserCounts(ctx, user.ID)
+	if err != nil || counts != (database.GetUserCountsRow{ChirpCount: 1, FollowerCount: 2}) {
+		t.Errorf("expected 1 chirp and 2 followers, instead got %+v, %v", counts, err)
+	}
+
+	counts, err = s.GetUserCounts(ctx, bob.ID)
+	if err != nil || counts != (database.GetUserCountsRow{FollowingCount: 1}) {
+		t.Errorf("expected bob to follow 1 user, instead got %+v, %v", counts, err)
+	}
+}
+
+func testMedia(t *testing.T, s Store) {
+	ctx := context.Background()
+	alice := mustCreateUser(t, s, "alice@example.com")
+	bob := mustCreateUser(t, s, "bob@example.com")
+
+	chirp, err := s.CreateChirp(ctx, database.CreateChirpParams{Body: "look", UserID: alice.ID, Status: "published"})
+	if err != nil {
+		t.Fatalf("expected to create chirp: %v", err)
+	}
+
+	var media []database.Medium
+	for range 3 {
+		item, err := s.CreateMedia(ctx, database.CreateMediaParams{
+			ID:          uuid.New(),
+			UserID:      alice.ID,
+			ContentType: "image/png",
+			Size:        100,
+			Width:       20,
+			Height:      10,
+		})
+		if err != nil {
+			t.Fatalf("expected to create media: %v", err)
+		}
+		media = append(media, item)
+	}
+
+	got, err := s.GetMedia(ctx, media[0].ID)
+	if err != nil || got.ChirpID.Valid || got.Width != 20 || got.ContentType != "image/png" {
+		t.Errorf("expected unattached media, instead got %+v, %v", got, err)
+	}
+
+	_, err = s.CreateMedia(ctx, database.CreateMediaParams{ID: uuid.New(), UserID: uuid.New(), ContentType: "image/png"})
+	if !IsForeignKeyViolation(err) {
+		t.Errorf("expected a foreign key violation for a missing user, instead got %v", err)
+	}
+
+	chirpID := uuid.NullUUID{UUID: chirp.ID, Valid: true}
+	attach := func(media database.Medium, user database.User, position int32) int64 {
+		t.Helper()
+
+		attached, err := s.AttachMedia(ctx, database.AttachMediaParams{ChirpID: chirpID, Position: position, ID: media.ID, UserID: user.ID})
+		if err != nil {
+			t.Fatalf("expected to attach media: %v", err)
+		}
+
+		return attached
+	}
+
+	if attach(media[1], bob, 0) != 0 {
+		t.Errorf("expected bob not to attach alice's media")
+	}
+	if attach(media[1], alice, 0) != 1 || attach(media[0], alice, 1) != 1 {
+		t.Errorf("expected alice to attach her media")
+	}
+	if attach(media[1], alice, 2) != 0 {
+		t.Errorf("expected attached media not to be attached again")
+	}
+
+	listed, err := s.ListMediaForChirps(ctx, []uuid.UUID{chirp.ID})
+	if err != nil || len(listed) != 2 || listed[0].ID != media[1].ID || listed[1].ID != media[0].ID {
+		t.Errorf("expected the attached media in position order, instead got %+v, %v", listed, err)
+	}
+
+	unattached, err := s.ListUnattachedMediaBefore(ctx, time.Now().UTC().Add(time.Minute))
+	if err != nil || len(unattached) != 1 || unattached[0].ID != media[2].ID {
+		t.Errorf("expected only the unattached media, instead got %+v, %v", unattached, err)
+	}
+
+	deleted, err := s.DeleteUnattachedMedia(ctx, media[0].ID)
+	if err != nil || deleted != 0 {
+		t.Errorf("expected attached media not to be deleted, instead got %d, %v", deleted, err)
+	}
+
+	err = s.DeleteChirp(ctx, chirp.ID)
+	if err != nil {
+		t.Fatalf("expected to delete chirp: %v", err)
+	}
+
+	unattached, err = s.ListUnattachedMediaBefore(ctx, time.Now().UTC().Add(time.Minute))
+	if err != nil || len(unattached) != 3 {
+		t.Errorf("expected deleting the chirp to detach its media, instead got %+v, %v", unattached, err)
+	}
+
+	deleted, err = s.DeleteUnattachedMedia(ctx, media[0].ID)
+	if err != nil || deleted != 1 {
+		t.Errorf("expected detached media to be deleted, instead got %d, %v", deleted, err)
+	}
+}
+
+func testLinkPreviews(t *testing.T, s Store) {
+	ctx := context.Background()
+	// Previews aren't reset with the users, so use URLs no other run has.
+	page := "https://example.com/" + uuid.NewString()
+	other := "https://example.com/" + uuid.NewString()
+
+	_, err := s.GetLinkPreview(ctx, page)
+	if !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected sql.ErrNoRows for a missing preview, instead got %v", err)
+	}
+
+	err = s.UpsertLinkPreview(ctx, database.UpsertLinkPreviewParams{Url: page, Status: "failed"})
+	if err != nil {
+		t.Fatalf("expected to store preview: %v", err)
+	}
+
+	failed, err := s.GetLinkPreview(ctx, page)
+	if err != nil || failed.Status != "failed" || failed.FetchedAt.IsZero() {
+		t.Errorf("expected the failed preview, instead got %+v, %v", failed, err)
+	}
+
+	err = s.UpsertLinkPreview(ctx, database.UpsertLinkPreviewParams{
+		Url:         page,
+		Status:      "ok",
+		Title:       "Title",
+		Description: "Description",
+		ImageUrl:    "https://example.com/card.png",
+		SiteName:    "Example",
+	})
+	if err != nil {
+		t.Fatalf("expected to replace preview: %v", err)
+	}
+
+	got, err := s.GetLinkPreview(ctx, page)
+	if err != nil || got.Status != "ok" || got.Title != "Title" || got.ImageUrl != "https://example.com/card.png" || got.FetchedAt.Before(failed.FetchedAt) {
+		t.Errorf("expected the replaced preview, instead got %+v, %v", got, err)
+	}
+
+	listed, err := s.ListLinkPreviews(ctx, []string{page, other})
+	if err != nil || len(listed) != 1 || listed[0].Url != page || listed[0].SiteName != "Example" {
+		t.Errorf("expected only the stored preview, instead got %+v, %v", listed, err)
+	}
+}
+
+func testReports(t *testing.T, s Store) {
+	ctx := context.Background()
+
+	reporter := mustCreateUser(t, s, "reporter@example.com")
+	author := mustCreateUser(t, s, "author@example.com")
+	moderator := mustCreateUser(t, s, "moderator@example.com")
+	chirp, err := s.CreateChirp(ctx, database.CreateChirpParams{Body: "rude", UserID: author.ID, Status: "published"})
+	if err != nil {
+		t.Fatalf("expected to create chirp: %v", err)
+	}
+
+	first, err := s.CreateReport(ctx, database.CreateReportParams{
+		ReporterID: uuid.NullUUID{UUID: reporter.ID, Valid: true},
+		UserID:     author.ID,
+		ChirpID:    uuid.NullUUID{UUID: chirp.ID, Valid: true},
+		ChirpBody:  chirp.Body,
+		Reason:     "harassment",
+	})
+	if err != nil || first.ResolvedAt.Valid || first.ChirpBody != "rude" {
+		t.Fatalf("expected an open report, instead got %+v, %v", first, err)
+	}
+	time.Sleep(time.Millisecond)
+
+	second, err := s.CreateReport(ctx, database.CreateReportParams{UserID: author.ID, Reason: "spam", Details: "bot"})
+	if err != nil || second.ReporterID.Valid || second.ChirpID.Valid {
+		t.Fatalf("expected an account report without reporter, instead got %+v, %v", second, err)
+	}
+
+	open, err := s.ListOpenReports(ctx, 10)
+	if err != nil || len(open) != 2 || open[0].ID != first.ID || open[1].ID != second.ID {
+		t.Errorf("expected both reports oldest first, instead got %+v, %v", open, err)
+	}
+
+	open, err = s.ListOpenReports(ctx, 1)
+	if err != nil || len(open) != 1 {
+		t.Errorf("expected the limit to apply, instead got %d, %v", len(open), err)
+	}
+
+	resolved, err := s.ResolveReport(ctx, first.ID)
+	if err != nil || !resolved.ResolvedAt.Valid {
+		t.Errorf("expected report to be resolved, instead got %+v, %v", resolved, err)
+	}
+
+	_, err = s.ResolveReport(ctx, first.ID)
+	if !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected sql.ErrNoRows resolving a resolved report, instead got %v", err)
+	}
+
+	got, err := s.GetReport(ctx, first.ID)
+	if err != nil || !got.ResolvedAt.Valid {
+		t.Errorf("expected to get the resolved report, instead got %+v, %v", got, err)
+	}
+
+	open, err = s.ListOpenReports(ctx, 10)
+	if err != nil || len(open) != 1 || open[0].ID != second.ID {
+		t.Errorf("expected only the open report, instead got %+v, %v", open, err)
+	}
+
+	hidden, err := s.HideChirp(ctx, chirp.ID)
+	if err != nil || !hidden.Hidden || !hidden.DeletedAt.Valid {
+		t.Errorf("expected chirp to be hidden and deleted, instead got %+v, %v", hidden, err)
+	}
+
+	_, err = s.RestoreChirp(ctx, database.RestoreChirpParams{ID: chirp.ID, DeletedAfter: time.Now().Add(-time.Hour)})
+	if !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected sql.ErrNoRows restoring a hidden chirp, instead got %v", err)
+	}
+
+	suspended, err := s.SuspendUser(ctx, author.ID)
+	if err != nil || !suspended.SuspendedAt.Valid {
+		t.Errorf("expected user to be suspended, instead got %+v, %v", suspended, err)
+	}
+
+	again, err := s.SuspendUser(ctx, author.ID)
+	if err != nil || !again.SuspendedAt.Time.Equal(suspended.SuspendedAt.Time) {
+		t.Errorf("expected suspending again to keep suspended_at, instead got %+v, %v", again, err)
+	}
+
+	_, err = s.CreateModerationAction(ctx, database.CreateModerationActionParams{
+		ModeratorID: moderator.ID,
+		UserID:      author.ID,
+		ChirpID:     uuid.NullUUID{UUID: chirp.ID, Valid: true},
+		ReportID:    uuid.NullUUID{UUID: first.ID, Valid: true},
+		Action:      "hide_chirp",
+	})
+	if err != nil {
+		t.Fatalf("expected to create moderation action: %v", err)
+	}
+	time.Sleep(time.Millisecond)
+
+	_, err = s.CreateModerationAction(ctx, database.CreateModerationActionParams{
+		ModeratorID: moderator.ID,
+		UserID:      reporter.ID,
+		Action:      "warn",
+		Note:        "false reports",
+	})
+	if err != nil {
+		t.Fatalf("expected to create moderation action: %v", err)
+	}
+
+	actions, err := s.ListModerationActions(ctx, database.ListModerationActionsParams{LimitCount: 10})
+	if err != nil || len(actions) != 2 || actions[0].Action != "warn" || actions[1].Action != "hide_chirp" {
+		t.Errorf("expected both actions newest first, instead got %+v, %v", actions, err)
+	}
+
+	actions, err = s.ListModerationActions(ctx, database.ListModerationActionsParams{
+		UserID:     uuid.NullUUID{UUID: author.ID, Valid: true},
+		LimitCount: 10,
+	})
+	if err != nil || len(actions) != 1 || actions[0].ReportID.UUID != first.ID || actions[0].ChirpID.UUID != chirp.ID {
+		t.Errorf("expected the action on the author, instead got %+v, %v", actions, err)
+	}
+
+	_, err = s.PurgeDeletedChirps(ctx, time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatalf("expected to purge chirps: %v", err)
+	}
+
+	purged, err := s.GetReport(ctx, first.ID)
+	if err != nil || purged.ChirpID.Valid || purged.ChirpBody != first.ChirpBody {
+		t.Errorf("expected the report to outlive its chirp with the body it quotes, instead got %+v, %v", purged, err)
+	}
+
+	actions, err = s.ListModerationActions(ctx, database.ListModerationActionsParams{
+		UserID:     uuid.NullUUID{UUID: author.ID, Valid: true},
+		LimitCount: 10,
+	})
+	if err != nil || len(actions) != 1 || actions[0].ReportID.UUID != first.ID || actions[0].ChirpID.UUID != chirp.ID {
+		t.Errorf("expected the action to outlive the chirp, instead got %+v, %v", actions, err)
+	}
+}
@@ -0,0 +1,107 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/vemolista/chirpy/v2/internal/database"
+)
+
+// DB is the connection of the SQL stores. Queries run in the transaction
+// InTx began if their context carries one, and on the pool otherwise, so
+// code running in a transaction only has to pass its context along.
+type DB struct {
+	conn *sql.DB
+	// instrument wraps the pool and each transaction, for metrics and
+	// tracing.
+	instrument func(database.DBTX) database.DBTX
+	pool       database.DBTX
+}
+
+var _ database.DBTX = (*DB)(nil)
+
+// NewDB returns a DB on conn. instrument may be nil.
+func NewDB(conn *sql.DB, instrument func(database.DBTX) database.DBTX) *DB {
+	if instrument == nil {
+		instrument = func(db database.DBTX) database.DBTX { return db }
+	}
+
+	return &DB{conn: conn, instrument: instrument, pool: instrument(conn)}
+}
+
+// txKey is the context key of the transaction InTx began.
+type txKey struct{}
+
+type dbTx struct {
+	db *DB
+	tx database.DBTX
+}
+
+// InTx runs fn in a transaction, which is committed if fn returns nil and
+// rolled back otherwise. fn must use the context it is given. Calls nested
+// in fn join its transaction.
+func (d *DB) InTx(ctx context.Context, fn func(ctx context.Context) error) error {
+	if current, ok := ctx.Value(txKey{}).(dbTx); ok && current.db == d {
+		return fn(ctx)
+	}
+
+	tx, err := d.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	err = fn(context.WithValue(ctx, txKey{}, dbTx{db: d, tx: d.instrument(tx)}))
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("error committing transaction: %w", err)
+	}
+
+	return nil
+}
+
+func (d *DB) db(ctx context.Context) database.DBTX {
+	if current, ok := ctx.Value(txKey{}).(dbTx); ok && current.db == d {
+		return current.tx
+	}
+
+	return d.pool
+}
+
+func (d *DB) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	return d.db(ctx).ExecContext(ctx, query, args...)
+}
+
+func (d *DB) PrepareContext(ctx context.Context, query string) (*sql.Stmt, error) {
+	return d.db(ctx).PrepareContext(ctx, query)
+}
+
+func (d *DB) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	return d.db(ctx).QueryContext(ctx, query, args...)
+}
+
+func (d *DB) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	return d.db(ctx).QueryRowContext(ctx, query, args...)
+}
+
+// Postgres is the Postgres Store: the sqlc queries, run on a DB so that
+// they can take part in transactions.
+type Postgres struct {
+	*database.Queries
+	db *DB
+}
+
+var _ Store = (*Postgres)(nil)
+
+func NewPostgres(db *DB) *Postgres {
+	return &Postgres{Queries: database.New(db), db: db}
+}
+
+func (p *Postgres) InTx(ctx context.Context, fn func(ctx context.Context) error) error {
+	return p.db.InTx(ctx, fn)
+}
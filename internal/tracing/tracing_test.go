@@ -0,0 +1,53 @@
+package tracing
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestMiddlewarePropagatesTraceparent(t *testing.T) {
+	if _, err := Setup(context.Background(), Config{Exporter: "none"}); err != nil {
+		t.Fatalf("expected setup to succeed: %v", err)
+	}
+
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	otel.SetTracerProvider(provider)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/chirps/{chirpId}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	const traceId = "4bf92f3577b34da6a3ce929d0e0e4736"
+	r := httptest.NewRequest("GET", "/api/chirps/123", nil)
+	r.Header.Set("traceparent", "00-"+traceId+"-00f067aa0ba902b7-01")
+
+	Middleware(mux).ServeHTTP(httptest.NewRecorder(), r)
+
+	spans := recorder.Ended()
+	if len(spans) != 1 {
+		t.Fatalf("expected one span, instead got %d", len(spans))
+	}
+
+	if spans[0].Name() != "GET /api/chirps/{chirpId}" {
+		t.Errorf("expected span to be named after the route, instead got %q", spans[0].Name())
+	}
+
+	if spans[0].SpanContext().TraceID().String() != traceId {
+		t.Errorf("expected span to continue trace %s, instead got %s", traceId, spans[0].SpanContext().TraceID())
+	}
+}
+
+func TestSetupRejectsUnknownExporter(t *testing.T) {
+	_, err := Setup(context.Background(), Config{Exporter: "carrier-pigeon"})
+	if err == nil {
+		t.Errorf("expected an error for an unknown exporter")
+	}
+}
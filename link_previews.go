@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/vemolista/chirpy/v2/internal/database"
+	"github.com/vemolista/chirpy/v2/internal/entities"
+)
+
+const (
+	linkPreviewOK     = "ok"
+	linkPreviewFailed = "failed"
+
+	// linkPreviewMaxAge is how long a fetched preview is used before it is
+	// fetched again, and linkPreviewRetryAfter the same for a failed one.
+	linkPreviewMaxAge     = 24 * time.Hour
+	linkPreviewRetryAfter = time.Hour
+
+	linkPreviewQueueSize = 256
+	linkPreviewWorkers   = 4
+	linkPreviewUserAgent = "Chirpy-LinkPreview/1.0"
+)
+
+// LinkPreview is the card for a URL in a chirp.
+type LinkPreview struct {
+	Url         string `json:"url"`
+	Title       string `json:"title"`
+	Description string `json:"description"`
+	ImageUrl    string `json:"image_url"`
+	SiteName    string `json:"site_name"`
+}
+
+// queueLinkPreviews asks the fetchers for previews of the URLs in a chirp's
+// entities. It never blocks: when the queue is full the URLs are dropped,
+// and fetched the next time a chirp links them.
+func (cfg *apiConfig) queueLinkPreviews(ctx context.Context, rows []database.ChirpEntity) {
+	if cfg.linkPreviewQueue == nil {
+		return
+	}
+
+	for _, row := range rows {
+		if row.Type != entities.URL {
+			continue
+		}
+
+		select {
+		case cfg.linkPreviewQueue <- row.Text:
+		default:
+			log.Printf("request %s: link preview queue is full, dropping %s", requestID(ctx), row.Text)
+		}
+	}
+}
+
+// fetchLinkPreviews fetches the previews of queued URLs until ctx is done.
+func (cfg *apiConfig) fetchLinkPreviews(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case url := <-cfg.linkPreviewQueue:
+			cfg.refreshLinkPreview(ctx, url)
+		}
+	}
+}
+
+// refreshLinkPreview fetches and stores the preview of url unless a recent
+// enough one is stored already. Failures are stored too, so that a broken
+// link isn't fetched for every chirp that has it.
+func (cfg *apiConfig) refreshLinkPreview(ctx context.Context, url string) {
+	cached, err := cfg.db.GetLinkPreview(ctx, url)
+	if err == nil {
+		maxAge := linkPreviewMaxAge
+		if cached.Status != linkPreviewOK {
+			maxAge = linkPreviewRetryAfter
+		}
+		if time.Since(cached.FetchedAt) < maxAge {
+			return
+		}
+	} else if !errors.Is(err, sql.ErrNoRows) {
+		log.Printf("Error getting link preview for %s: %v", url, err)
+		return
+	}
+
+	params := database.UpsertLinkPreviewParams{Url: url, Status: linkPreviewOK}
+	preview, err := cfg.linkPreviews.Fetch(ctx, url)
+	if err != nil {
+		log.Printf("Error fetching link preview for %s: %v", url, err)
+		params.Status = linkPreviewFailed
+	} else {
+		params.Title = preview.Title
+		params.Description = preview.Description
+		params.ImageUrl = preview.ImageURL
+		params.SiteName = preview.SiteName
+	}
+
+	err = cfg.db.UpsertLinkPreview(ctx, params)
+	if err != nil {
+		log.Printf("Error storing link preview for %s: %v", url, err)
+	}
+}
+
+// storedLinkPreviews returns the fetched previews of the URLs in entity
+// rows, by URL.
+func (cfg *apiConfig) storedLinkPreviews(ctx context.Context, rows []database.ChirpEntity) (map[string]LinkPreview, error) {
+	var urls []string
+	for _, row := range rows {
+		if row.Type == entities.URL {
+			urls = append(urls, row.Text)
+		}
+	}
+
+	previews := map[string]LinkPreview{}
+	if len(urls) == 0 {
+		return previews, nil
+	}
+
+	stored, err := cfg.db.ListLinkPreviews(ctx, urls)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, preview := range stored {
+		if preview.Status != linkPreviewOK {
+			continue
+		}
+
+		previews[preview.Url] = LinkPreview{
+			Url:         preview.Url,
+			Title:       preview.Title,
+			Description: preview.Description,
+			ImageUrl:    preview.ImageUrl,
+			SiteName:    preview.SiteName,
+		}
+	}
+
+	return previews, nil
+}
+
+// knownLinkPreviews returns the previews already fetched for a chirp that
+// was just saved. Errors are logged rather than failing the request, as the
+// chirp is saved either way.
+func (cfg *apiConfig) knownLinkPreviews(ctx context.Context, rows []database.ChirpEntity) []LinkPreview {
+	previews, err := cfg.storedLinkPreviews(ctx, rows)
+	if err != nil {
+		log.Printf("request %s: error getting link previews: %v", requestID(ctx), err)
+	}
+
+	return chirpLinkPreviews(rows, previews)
+}
+
+// chirpLinkPreviews returns the previews of the URLs in one chirp's entity
+// rows, in the order they appear and without repeats.
+func chirpLinkPreviews(rows []database.ChirpEntity, previews map[string]LinkPreview) []LinkPreview {
+	linked := []LinkPreview{}
+	seen := map[string]bool{}
+	for _, row := range rows {
+		preview, ok := previews[row.Text]
+		if row.Type != entities.URL || !ok || seen[row.Text] {
+			continue
+		}
+
+		seen[row.Text] = true
+		linked = append(linked, preview)
+	}
+
+	return linked
+}
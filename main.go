@@ -1,83 +1,248 @@
 package main
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
+	"log"
 	"net/http"
+	"net/netip"
 	"os"
+	"os/signal"
 	"sync/atomic"
+	"syscall"
+	"time"
 
 	"github.com/joho/godotenv"
 	_ "github.com/lib/pq"
-	"github.com/vemolista/chirpy/v2/internal/database"
+	"github.com/pressly/goose/v3"
+	"github.com/vemolista/chirpy/v2/internal/blob"
+	"github.com/vemolista/chirpy/v2/internal/config"
+	"github.com/vemolista/chirpy/v2/internal/events"
+	"github.com/vemolista/chirpy/v2/internal/linkpreview"
+	"github.com/vemolista/chirpy/v2/internal/metrics"
+	"github.com/vemolista/chirpy/v2/internal/ratelimit"
+	"github.com/vemolista/chirpy/v2/internal/realtime"
+	"github.com/vemolista/chirpy/v2/internal/store"
+	"github.com/vemolista/chirpy/v2/internal/tracing"
 )
 
-const PORT = ":8080"
-
 type apiConfig struct {
-	fileserverHits atomic.Int32
-	db             *database.Queries
-	platform       string
-	secret         string
-	polkaKey       string
+	metrics      *metrics.Metrics
+	db           store.Store
+	dbConn       *sql.DB
+	migrations   *goose.Provider
+	shuttingDown atomic.Bool
+	// rateLimiter is nil when rate limiting is disabled.
+	rateLimiter    ratelimit.Store
+	rateLimits     config.RateLimitConfig
+	trustedProxies []netip.Prefix
+	events         *events.Hub
+	// notifyEvents is set when Postgres notifies every replica of new chirp
+	// events, so they must not also be published in process.
+	notifyEvents bool
+	realtime     *realtime.Hub
+	blobs        blob.Store
+	media        config.MediaConfig
+	chirps       config.ChirpsConfig
+	// linkPreviews fetches the URLs sent to linkPreviewQueue, which is nil
+	// when link previews are disabled.
+	linkPreviews     *linkpreview.Fetcher
+	linkPreviewQueue chan string
+	platform         string
+	secret           string
+	polkaKey         string
 }
 
 func main() {
 	godotenv.Load()
-	dbUrl := os.Getenv("DB_URL")
-	platform := os.Getenv("PLATFORM")
-	secret := os.Getenv("SECRET")
-	polkaKey := os.Getenv("POLKA_KEY")
-	dbConnection, err := sql.Open("postgres", dbUrl)
 
+	args := os.Args[1:]
+	migrateCommand := ""
+	if len(args) > 0 && args[0] == "migrate" {
+		if len(args) < 2 {
+			log.Fatal(migrateUsage)
+		}
+
+		migrateCommand, args = args[1], args[2:]
+	}
+
+	var roleArgs []string
+	if len(args) > 0 && args[0] == "role" {
+		if len(args) < 3 {
+			log.Fatal(roleUsage)
+		}
+
+		roleArgs, args = args[1:3], args[3:]
+	}
+
+	conf, err := config.Load(args, os.Getenv)
 	if err != nil {
-		panic("Error opening a database connection")
+		log.Fatalf("Invalid configuration:\n%v", err)
 	}
 
-	dbQueries := database.New(dbConnection)
+	log.Printf("Loaded configuration:\n%s", conf.Redacted())
+	if conf.GeneratedSecret {
+		log.Println("No SECRET set, using a random one; tokens will not survive a restart")
+	}
 
-	cfg := apiConfig{
-		fileserverHits: atomic.Int32{},
-		db:             dbQueries,
-		platform:       platform,
-		secret:         secret,
-		polkaKey:       polkaKey,
+	shutdownTracing, err := tracing.Setup(context.Background(), tracing.Config{
+		ServiceName: "chirpy",
+		Exporter:    conf.Tracing.Exporter,
+		Endpoint:    conf.Tracing.Endpoint,
+		File:        conf.Tracing.File,
+	})
+	if err != nil {
+		log.Fatalf("Error setting up tracing: %v", err)
 	}
+	defer shutdownTracing(context.Background())
 
-	serveMux := http.NewServeMux()
+	appMetrics := metrics.New()
 
-	appHandler := http.StripPrefix("/app/", http.FileServer(http.Dir(".")))
-	serveMux.Handle("/app/", cfg.middlewareMetricsInc(appHandler))
+	db, dbConnection, err := openStore(context.Background(), conf, appMetrics)
+	if err != nil {
+		log.Fatal(err)
+	}
 
-	serveMux.HandleFunc("GET /api/healthz", healthHandler)
-	serveMux.HandleFunc("POST /api/chirps", cfg.createChirpHandler)
-	serveMux.HandleFunc("GET /api/chirps", cfg.listChirpsHandler)
-	serveMux.HandleFunc("GET /api/chirps/{chirpId}", cfg.getChirpHandler)
-	serveMux.HandleFunc("DELETE /api/chirps/{chirpId}", cfg.deleteChirpHandler)
-	serveMux.HandleFunc("POST /api/users", cfg.createUserHandler)
-	serveMux.HandleFunc("PUT /api/users", cfg.updateUserHandler)
-	serveMux.HandleFunc("POST /api/login", cfg.loginHandler)
-	serveMux.HandleFunc("POST /api/refresh", cfg.refreshHandler)
-	serveMux.HandleFunc("POST /api/revoke", cfg.revokeHandler)
+	var migrations *goose.Provider
+	if conf.Storage == config.StoragePostgres {
+		migrations, err = newMigrationProvider(dbConnection)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
 
-	serveMux.HandleFunc("POST /api/polka/webhooks", cfg.polkaWebhookHandler)
+	if migrateCommand != "" {
+		if migrations == nil {
+			log.Fatalf("migrate requires %s storage", config.StoragePostgres)
+		}
 
-	serveMux.HandleFunc("GET /admin/metrics", cfg.metricsHandler)
-	serveMux.HandleFunc("POST /admin/reset", cfg.resetMetricsHandler)
+		err = runMigrateCommand(context.Background(), migrations, migrateCommand)
+		if err != nil {
+			log.Fatalf("Error running migrate %s: %v", migrateCommand, err)
+		}
+		return
+	}
 
-	httpServer := http.Server{
-		Handler: serveMux,
-		Addr:    PORT,
+	if migrations != nil {
+		if conf.AutoMigrate {
+			_, err = migrations.Up(context.Background())
+			if err != nil {
+				log.Fatalf("Error applying migrations: %v", err)
+			}
+		}
+
+		err = checkSchema(context.Background(), migrations)
+		if err != nil {
+			log.Fatalf("Refusing to serve: %v; run `chirpy migrate up` or start with -auto-migrate", err)
+		}
 	}
 
-	fmt.Printf("Listening on port %v\n", PORT)
-	httpServer.ListenAndServe()
-}
+	if roleArgs != nil {
+		if conf.Storage == config.StorageMemory {
+			log.Fatalf("role requires %s or %s storage", config.StoragePostgres, config.StorageSQLite)
+		}
 
-func (cfg *apiConfig) middlewareMetricsInc(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		cfg.fileserverHits.Add(1)
+		err = runRoleCommand(context.Background(), db, roleArgs[0], roleArgs[1])
+		if err != nil {
+			log.Fatalf("Error running role: %v", err)
+		}
+		return
+	}
 
-		next.ServeHTTP(w, r)
-	})
+	trustedProxies, err := ratelimit.ParseTrustedProxies(conf.RateLimit.TrustedProxies)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	cfg := &apiConfig{
+		metrics:          appMetrics,
+		db:               db,
+		dbConn:           dbConnection,
+		migrations:       migrations,
+		rateLimiter:      newRateLimiter(conf.RateLimit, dbConnection),
+		rateLimits:       conf.RateLimit,
+		trustedProxies:   trustedProxies,
+		events:           events.NewHub(),
+		notifyEvents:     conf.Storage == config.StoragePostgres,
+		realtime:         realtime.NewHub(),
+		blobs:            blob.NewFS(conf.Media.Dir),
+		media:            conf.Media,
+		chirps:           conf.Chirps,
+		linkPreviews:     linkpreview.NewFetcher(linkpreview.Options{UserAgent: linkPreviewUserAgent}),
+		linkPreviewQueue: make(chan string, linkPreviewQueueSize),
+		platform:         conf.Platform,
+		secret:           conf.Secret,
+		polkaKey:         conf.PolkaKey,
+	}
+
+	httpServer := &http.Server{
+		Handler:           cfg.routes(),
+		Addr:              conf.Addr(),
+		ReadHeaderTimeout: conf.Server.ReadHeaderTimeout,
+		ReadTimeout:       conf.Server.ReadTimeout,
+		WriteTimeout:      conf.Server.WriteTimeout,
+		IdleTimeout:       conf.Server.IdleTimeout,
+	}
+	httpServer.RegisterOnShutdown(cfg.events.Close)
+	// Shutdown doesn't track hijacked connections, so end them explicitly.
+	httpServer.RegisterOnShutdown(cfg.realtime.Close)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	go cfg.sweepIdempotencyKeys(ctx, time.Hour)
+	go cfg.sweepChirpEvents(ctx, time.Hour)
+	go cfg.sweepMedia(ctx, time.Hour)
+	go cfg.publishScheduledChirps(ctx, 10*time.Second)
+	go cfg.purgeDeletedChirps(ctx, time.Hour)
+	for range linkPreviewWorkers {
+		go cfg.fetchLinkPreviews(ctx)
+	}
+	go cfg.relayChirpEvents(ctx, cfg.events.Subscribe())
+
+	if cfg.notifyEvents {
+		go func() {
+			err := events.Listen(ctx, conf.DBURL, db, cfg.events)
+			if err != nil {
+				log.Printf("Error listening for chirp events: %v", err)
+			}
+		}()
+	}
+
+	serverErr := make(chan error, 1)
+	go func() {
+		fmt.Printf("Listening on port %v\n", conf.Port)
+		serverErr <- httpServer.ListenAndServe()
+	}()
+
+	select {
+	case err := <-serverErr:
+		if !errors.Is(err, http.ErrServerClosed) {
+			log.Fatalf("Error running server: %v", err)
+		}
+		return
+	case <-ctx.Done():
+	}
+	stop()
+
+	log.Printf("Shutting down, draining for %v", conf.Server.DrainPeriod)
+	cfg.shuttingDown.Store(true)
+	time.Sleep(conf.Server.DrainPeriod)
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), conf.Server.ShutdownTimeout)
+	defer cancel()
+
+	err = httpServer.Shutdown(shutdownCtx)
+	if err != nil {
+		log.Printf("Error shutting down server: %v", err)
+	}
+
+	if dbConnection != nil {
+		err = dbConnection.Close()
+		if err != nil {
+			log.Printf("Error closing database connection: %v", err)
+		}
+	}
 }
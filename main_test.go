@@ -0,0 +1,220 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/vemolista/chirpy/v2/internal/blob"
+	"github.com/vemolista/chirpy/v2/internal/config"
+	"github.com/vemolista/chirpy/v2/internal/events"
+	"github.com/vemolista/chirpy/v2/internal/metrics"
+	"github.com/vemolista/chirpy/v2/internal/realtime"
+	"github.com/vemolista/chirpy/v2/internal/store"
+)
+
+// The handler tests run against the in-memory store by default. Set
+// CHIRPY_TEST_DB_URL to a disposable Postgres database to run them against
+// the sqlc queries instead; the database is migrated and emptied first.
+
+const (
+	testSecret   = "test-secret"
+	testPolkaKey = "test-polka-key"
+)
+
+type testServer struct {
+	t      *testing.T
+	cfg    *apiConfig
+	server *httptest.Server
+}
+
+func newTestStore(t *testing.T) store.Store {
+	t.Helper()
+
+	dbUrl := os.Getenv("CHIRPY_TEST_DB_URL")
+	if dbUrl == "" {
+		return store.NewMemory()
+	}
+
+	db, err := sql.Open("postgres", dbUrl)
+	if err != nil {
+		t.Fatalf("expected to open test database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	migrations, err := newMigrationProvider(db)
+	if err != nil {
+		t.Fatalf("expected migration provider: %v", err)
+	}
+
+	_, err = migrations.Up(context.Background())
+	if err != nil {
+		t.Fatalf("expected migrations to apply: %v", err)
+	}
+
+	postgres := store.NewPostgres(store.NewDB(db, nil))
+	err = postgres.DeleteUsers(context.Background())
+	if err != nil {
+		t.Fatalf("expected to empty test database: %v", err)
+	}
+
+	return postgres
+}
+
+func newTestServer(t *testing.T) *testServer {
+	t.Helper()
+
+	cfg := &apiConfig{
+		metrics:  metrics.New(),
+		db:       newTestStore(t),
+		events:   events.NewHub(),
+		realtime: realtime.NewHub(),
+		blobs:    blob.NewFS(t.TempDir()),
+		media:    config.Default().Media,
+		chirps:   config.Default().Chirps,
+		platform: config.PlatformDev,
+		secret:   testSecret,
+		polkaKey: testPolkaKey,
+	}
+
+	server := httptest.NewServer(cfg.routes())
+	t.Cleanup(server.Close)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go cfg.relayChirpEvents(ctx, cfg.events.Subscribe())
+
+	return &testServer{t: t, cfg: cfg, server: server}
+}
+
+type testResponse struct {
+	status int
+	header http.Header
+	body   []byte
+}
+
+func (r testResponse) decode(t *testing.T, v any) {
+	t.Helper()
+
+	err := json.Unmarshal(r.body, v)
+	if err != nil {
+		t.Fatalf("expected JSON response, instead got %q: %v", r.body, err)
+	}
+}
+
+// do sends a request with body encoded as JSON, unless it is already a
+// string, and an Authorization header when authorization is not empty.
+func (s *testServer) do(method, path, authorization string, body any) testResponse {
+	s.t.Helper()
+
+	header := http.Header{}
+	if authorization != "" {
+		header.Set("Authorization", authorization)
+	}
+
+	return s.send(method, path, header, body)
+}
+
+// send is do with arbitrary request headers.
+func (s *testServer) send(method, path string, header http.Header, body any) testResponse {
+	s.t.Helper()
+
+	var reader io.Reader
+	switch b := body.(type) {
+	case nil:
+	case string:
+		reader = bytes.NewBufferString(b)
+	default:
+		data, err := json.Marshal(b)
+		if err != nil {
+			s.t.Fatalf("expected to marshal request body: %v", err)
+		}
+		reader = bytes.NewBuffer(data)
+	}
+
+	req, err := http.NewRequest(method, s.server.URL+path, reader)
+	if err != nil {
+		s.t.Fatalf("expected to build request: %v", err)
+	}
+
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	for name, values := range header {
+		req.Header[name] = values
+	}
+
+	res, err := s.server.Client().Do(req)
+	if err != nil {
+		s.t.Fatalf("expected %s %s to succeed: %v", method, path, err)
+	}
+	defer res.Body.Close()
+
+	data, err := io.ReadAll(res.Body)
+	if err != nil {
+		s.t.Fatalf("expected to read response body: %v", err)
+	}
+
+	return testResponse{status: res.StatusCode, header: res.Header, body: data}
+}
+
+func bearer(token string) string {
+	return "Bearer " + token
+}
+
+type loginResponse struct {
+	Id           string `json:"id"`
+	Email        string `json:"email"`
+	Token        string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+	IsChirpyRed  bool   `json:"is_chirpy_red"`
+}
+
+func (s *testServer) signup(email, password string) UserResponse {
+	s.t.Helper()
+
+	res := s.do("POST", "/api/users", "", map[string]string{"email": email, "password": password})
+	if res.status != http.StatusCreated {
+		s.t.Fatalf("expected signup to return 201, instead got %d: %s", res.status, res.body)
+	}
+
+	var user UserResponse
+	res.decode(s.t, &user)
+
+	return user
+}
+
+func (s *testServer) login(email, password string) loginResponse {
+	s.t.Helper()
+
+	res := s.do("POST", "/api/login", "", map[string]string{"email": email, "password": password})
+	if res.status != http.StatusOK {
+		s.t.Fatalf("expected login to return 200, instead got %d: %s", res.status, res.body)
+	}
+
+	var login loginResponse
+	res.decode(s.t, &login)
+
+	return login
+}
+
+func (s *testServer) createChirp(token, body string) Chirp {
+	s.t.Helper()
+
+	res := s.do("POST", "/api/chirps", bearer(token), map[string]string{"body": body})
+	if res.status != http.StatusCreated {
+		s.t.Fatalf("expected chirp creation to return 201, instead got %d: %s", res.status, res.body)
+	}
+
+	var chirp Chirp
+	res.decode(s.t, &chirp)
+
+	return chirp
+}
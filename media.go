@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/vemolista/chirpy/v2/internal/database"
+)
+
+const (
+	// maxChirpMedia is how many media a chirp may have.
+	maxChirpMedia = 4
+	// unattachedMediaRetention is how long uploads may wait for a chirp.
+	unattachedMediaRetention = 24 * time.Hour
+	// mediaCacheControl lets clients and shared caches keep the media of
+	// published chirps, but like chirpCacheControl makes them revalidate
+	// every use, so that deleting the chirp or blocking a viewer takes
+	// effect. A media ID always refers to the same bytes, so the ETag
+	// makes that cheap.
+	mediaCacheControl = "public, no-cache"
+)
+
+type Media struct {
+	Id           uuid.UUID `json:"id"`
+	ContentType  string    `json:"content_type"`
+	Size         int64     `json:"size"`
+	Width        int32     `json:"width"`
+	Height       int32     `json:"height"`
+	Url          string    `json:"url"`
+	ThumbnailUrl string    `json:"thumbnail_url"`
+}
+
+func newMedia(media database.Medium) Media {
+	return Media{
+		Id:           media.ID,
+		ContentType:  media.ContentType,
+		Size:         media.Size,
+		Width:        media.Width,
+		Height:       media.Height,
+		Url:          "/api/media/" + media.ID.String(),
+		ThumbnailUrl: "/api/media/" + media.ID.String() + "/thumbnail",
+	}
+}
+
+// checkAttachments returns the media with ids if the user may attach them
+// to a new chirp.
+func (cfg *apiConfig) checkAttachments(ctx context.Context, userId uuid.UUID, ids []uuid.UUID) ([]database.Medium, error) {
+	if len(ids) > maxChirpMedia {
+		return nil, validationError("validation_failed", "Request body failed validation", fieldError{
+			Field:   "media_ids",
+			Code:    "max_items",
+			Message: fmt.Sprintf("must have at most %d items", maxChirpMedia),
+		})
+	}
+
+	var attachments []database.Medium
+	for i, id := range ids {
+		if slices.Contains(ids[:i], id) {
+			return nil, validationError("validation_failed", "Request body failed validation", fieldError{
+				Field:   "media_ids",
+				Code:    "duplicate",
+				Message: "must not repeat a media",
+			})
+		}
+
+		// Other users' media are reported as missing, like missing ones.
+		media, err := cfg.db.GetMedia(ctx, id)
+		if errors.Is(err, sql.ErrNoRows) || (err == nil && media.UserID != userId) {
+			return nil, validationError("media_not_found", fmt.Sprintf("No media with Id %s", id), fieldError{
+				Field:   "media_ids",
+				Code:    "not_found",
+				Message: fmt.Sprintf("has no media with Id %s", id),
+			})
+		}
+		if err != nil {
+			return nil, internalError("Error getting media", err)
+		}
+
+		if media.ChirpID.Valid {
+			return nil, conflictError("media_attached", fmt.Sprintf("Media %s is already attached to a chirp", id), nil)
+		}
+
+		attachments = append(attachments, media)
+	}
+
+	return attachments, nil
+}
+
+// attachMedia attaches media checked by checkAttachments to a new chirp, in
+// the transaction that creates the chirp. It fails if one was attached to
+// another chirp in the meantime, so that the transaction is rolled back.
+func (cfg *apiConfig) attachMedia(ctx context.Context, chirp database.Chirp, attachments []database.Medium) error {
+	for i, media := range attachments {
+		attached, err := cfg.db.AttachMedia(ctx, database.AttachMediaParams{
+			ChirpID:  uuid.NullUUID{UUID: chirp.ID, Valid: true},
+			Position: int32(i),
+			ID:       media.ID,
+			UserID:   chirp.UserID,
+		})
+		if err == nil && attached == 0 {
+			err = conflictError("media_attached", fmt.Sprintf("Media %s is already attached to a chirp", media.ID), nil)
+		}
+		if err != nil {
+			var apiErr *apiError
+			if errors.As(err, &apiErr) {
+				return apiErr
+			}
+			return internalError("Error attaching media", err)
+		}
+	}
+
+	return nil
+}
+
+// mediaKey and thumbnailKey name the blobs of a media.
+func mediaKey(id uuid.UUID) string {
+	return "media/" + id.String()
+}
+
+func thumbnailKey(id uuid.UUID) string {
+	return "thumbnails/" + id.String()
+}
+
+// sweepMedia deletes media that have not been attached to a chirp within
+// unattachedMediaRetention, and their blobs, every interval until ctx is
+// done.
+func (cfg *apiConfig) sweepMedia(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		cfg.deleteUnattachedMedia(ctx, time.Now().UTC().Add(-unattachedMediaRetention))
+	}
+}
+
+func (cfg *apiConfig) deleteUnattachedMedia(ctx context.Context, before time.Time) {
+	expired, err := cfg.db.ListUnattachedMediaBefore(ctx, before)
+	if err != nil {
+		log.Printf("Error listing unattached media: %v", err)
+		return
+	}
+
+	for _, media := range expired {
+		// The row goes first, so that media attached in the meantime keep
+		// their blobs.
+		deleted, err := cfg.db.DeleteUnattachedMedia(ctx, media.ID)
+		if err != nil {
+			log.Printf("Error deleting media %s: %v", media.ID, err)
+			continue
+		}
+
+		if deleted == 0 {
+			continue
+		}
+
+		for _, key := range []string{mediaKey(media.ID), thumbnailKey(media.ID)} {
+			err = cfg.blobs.Delete(ctx, key)
+			if err != nil {
+				log.Printf("Error deleting blob %s: %v", key, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"time"
+
+	"github.com/pressly/goose/v3"
+	"github.com/pressly/goose/v3/lock"
+)
+
+//go:embed sql/migrations/*.sql
+var embeddedMigrations embed.FS
+
+// newMigrationProvider returns a goose provider for the embedded migrations.
+// Up and down runs hold a Postgres advisory lock so that replicas migrating
+// on boot at the same time apply each migration only once.
+func newMigrationProvider(db *sql.DB) (*goose.Provider, error) {
+	migrations, err := fs.Sub(embeddedMigrations, "sql/migrations")
+	if err != nil {
+		return nil, fmt.Errorf("error opening embedded migrations: %w", err)
+	}
+
+	locker, err := lock.NewPostgresSessionLocker()
+	if err != nil {
+		return nil, fmt.Errorf("error creating migration lock: %w", err)
+	}
+
+	provider, err := goose.NewProvider(goose.DialectPostgres, db, migrations, goose.WithSessionLocker(locker))
+	if err != nil {
+		return nil, fmt.Errorf("error creating migration provider: %w", err)
+	}
+
+	return provider, nil
+}
+
+const migrateUsage = "usage: chirpy migrate up|down|status|redo [flags]"
+
+func runMigrateCommand(ctx context.Context, provider *goose.Provider, command string) error {
+	switch command {
+	case "up":
+		results, err := provider.Up(ctx)
+		printMigrationResults(results)
+		return err
+	case "down":
+		result, err := provider.Down(ctx)
+		printMigrationResults([]*goose.MigrationResult{result})
+		return err
+	case "redo":
+		result, err := provider.Down(ctx)
+		printMigrationResults([]*goose.MigrationResult{result})
+		if err != nil {
+			return err
+		}
+
+		result, err = provider.UpByOne(ctx)
+		printMigrationResults([]*goose.MigrationResult{result})
+		return err
+	case "status":
+		statuses, err := provider.Status(ctx)
+		if err != nil {
+			return err
+		}
+
+		for _, status := range statuses {
+			appliedAt := "Pending"
+			if status.State == goose.StateApplied {
+				appliedAt = status.AppliedAt.Format(time.DateTime)
+			}
+
+			fmt.Printf("%-20s %s\n", appliedAt, status.Source.Path)
+		}
+
+		return nil
+	default:
+		return fmt.Errorf("unknown migrate command %q\n%s", command, migrateUsage)
+	}
+}
+
+func printMigrationResults(results []*goose.MigrationResult) {
+	for _, result := range results {
+		if result != nil {
+			fmt.Println(result)
+		}
+	}
+}
+
+// checkSchema fails if the embedded migrations are ahead of the database.
+func checkSchema(ctx context.Context, provider *goose.Provider) error {
+	pending, err := provider.HasPending(ctx)
+	if err != nil {
+		return fmt.Errorf("error reading migration status: %w", err)
+	}
+
+	if pending {
+		current, target, err := provider.GetVersions(ctx)
+		if err != nil {
+			return fmt.Errorf("error reading migration versions: %w", err)
+		}
+
+		return fmt.Errorf("database schema at version %d, expected %d", current, target)
+	}
+
+	return nil
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/vemolista/chirpy/v2/internal/database"
+)
+
+// Report reasons users can pick.
+const (
+	reportSpam           = "spam"
+	reportHarassment     = "harassment"
+	reportHate           = "hate"
+	reportViolence       = "violence"
+	reportSexualContent  = "sexual_content"
+	reportMisinformation = "misinformation"
+	reportOther          = "other"
+
+	// reportFilteredWords is filed by the server, not a user, when
+	// cleanChirp censors a chirp.
+	reportFilteredWords = "filtered_words"
+)
+
+// Moderation actions, which resolve a report.
+const (
+	moderationHideChirp = "hide_chirp"
+	moderationWarn      = "warn"
+	moderationSuspend   = "suspend"
+	moderationDismiss   = "dismiss"
+)
+
+const (
+	defaultModerationLimit = 50
+	maxModerationLimit     = 200
+)
+
+type Report struct {
+	Id uuid.UUID `json:"id"`
+	// ReporterId is nil for reports filed by the server.
+	ReporterId *uuid.UUID `json:"reporter_id"`
+	UserId     uuid.UUID  `json:"user_id"`
+	ChirpId    *uuid.UUID `json:"chirp_id,omitempty"`
+	// ChirpBody is the chirp as reported, so that later edits can't hide
+	// what was reported.
+	ChirpBody  string     `json:"chirp_body,omitempty"`
+	Reason     string     `json:"reason"`
+	Details    string     `json:"details,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ResolvedAt *time.Time `json:"resolved_at"`
+}
+
+func newReport(report database.Report) Report {
+	r := Report{
+		Id:        report.ID,
+		UserId:    report.UserID,
+		ChirpBody: report.ChirpBody,
+		Reason:    report.Reason,
+		Details:   report.Details,
+		CreatedAt: report.CreatedAt,
+	}
+
+	if report.ReporterID.Valid {
+		r.ReporterId = &report.ReporterID.UUID
+	}
+
+	if report.ChirpID.Valid {
+		r.ChirpId = &report.ChirpID.UUID
+	}
+
+	if report.ResolvedAt.Valid {
+		r.ResolvedAt = &report.ResolvedAt.Time
+	}
+
+	return r
+}
+
+// ModerationAction is an entry in the decision log, which is never changed
+// once written.
+type ModerationAction struct {
+	Id          uuid.UUID  `json:"id"`
+	ModeratorId uuid.UUID  `json:"moderator_id"`
+	UserId      uuid.UUID  `json:"user_id"`
+	ChirpId     *uuid.UUID `json:"chirp_id,omitempty"`
+	ReportId    *uuid.UUID `json:"report_id,omitempty"`
+	Action      string     `json:"action"`
+	Note        string     `json:"note,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+}
+
+func newModerationAction(action database.ModerationAction) ModerationAction {
+	a := ModerationAction{
+		Id:          action.ID,
+		ModeratorId: action.ModeratorID,
+		UserId:      action.UserID,
+		Action:      action.Action,
+		Note:        action.Note,
+		CreatedAt:   action.CreatedAt,
+	}
+
+	if action.ChirpID.Valid {
+		a.ChirpId = &action.ChirpID.UUID
+	}
+
+	if action.ReportID.Valid {
+		a.ReportId = &action.ReportID.UUID
+	}
+
+	return a
+}
+
+// requireModerator returns the moderator making the request, or a 403 for
+// anyone else.
+func (cfg *apiConfig) requireModerator(r *http.Request) (database.User, error) {
+	user, err := cfg.authenticateUser(r)
+	if err != nil {
+		return database.User{}, err
+	}
+
+	if user.Role != roleModerator {
+		return database.User{}, forbiddenError("not_moderator", "Only moderators can do this")
+	}
+
+	return user, nil
+}
+
+// reportFilteredChirp files a report on a chirp that cleanChirp censored,
+// keeping the body as it was written. Failures are logged: the chirp has
+// already been saved.
+func (cfg *apiConfig) reportFilteredChirp(ctx context.Context, chirp database.Chirp, body string) {
+	_, err := cfg.db.CreateReport(ctx, database.CreateReportParams{
+		UserID:    chirp.UserID,
+		ChirpID:   uuid.NullUUID{UUID: chirp.ID, Valid: true},
+		ChirpBody: body,
+		Reason:    reportFilteredWords,
+	})
+	if err != nil {
+		log.Printf("request %s: error reporting filtered chirp: %v", requestID(ctx), err)
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"log"
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/vemolista/chirpy/v2/internal/database"
+)
+
+// Notification types.
+const (
+	notificationFollow  = "follow"
+	notificationMention = "mention"
+	// notificationWarning is a moderator's warning. It can't be turned off
+	// and its actor is the warned user, so as not to name the moderator.
+	notificationWarning = "warning"
+)
+
+// notificationTypes are the types users can turn on and off.
+var notificationTypes = []string{notificationFollow, notificationMention}
+
+type Notification struct {
+	Id        uuid.UUID  `json:"id"`
+	Type      string     `json:"type"`
+	ActorId   uuid.UUID  `json:"actor_id"`
+	ChirpId   *uuid.UUID `json:"chirp_id,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+	ReadAt    *time.Time `json:"read_at"`
+}
+
+func newNotification(notification database.Notification) Notification {
+	n := Notification{
+		Id:        notification.ID,
+		Type:      notification.Type,
+		ActorId:   notification.ActorID,
+		CreatedAt: notification.CreatedAt,
+	}
+
+	if notification.ChirpID.Valid {
+		n.ChirpId = &notification.ChirpID.UUID
+	}
+
+	if notification.ReadAt.Valid {
+		n.ReadAt = &notification.ReadAt.Time
+	}
+
+	return n
+}
+
+// notify tells userId that actorId did something, unless it was userId
+// themselves, they blocked actorId or they turned the type off, and pushes
+// the notification to their realtime connections. Failures are logged: the
+// action that caused the notification has already happened.
+func (cfg *apiConfig) notify(ctx context.Context, userId, actorId uuid.UUID, kind string, chirpId uuid.NullUUID) {
+	if userId == actorId {
+		return
+	}
+
+	relationship, err := cfg.db.GetRelationship(ctx, database.GetRelationshipParams{
+		UserID:  actorId,
+		OtherID: userId,
+	})
+	if err != nil {
+		log.Printf("request %s: error getting relationship: %v", requestID(ctx), err)
+		return
+	}
+
+	if relationship.BlockedBy {
+		return
+	}
+
+	preferences, err := cfg.notificationPreferences(ctx, userId)
+	if err != nil {
+		log.Printf("request %s: error getting notification preferences: %v", requestID(ctx), err)
+		return
+	}
+
+	if !preferences[kind] {
+		return
+	}
+
+	cfg.createNotification(ctx, userId, actorId, kind, chirpId)
+}
+
+// createNotification stores a notification and pushes it to the user's
+// realtime connections, logging any failure.
+func (cfg *apiConfig) createNotification(ctx context.Context, userId, actorId uuid.UUID, kind string, chirpId uuid.NullUUID) {
+	notification, err := cfg.db.CreateNotification(ctx, database.CreateNotificationParams{
+		UserID:  userId,
+		ActorID: actorId,
+		Type:    kind,
+		ChirpID: chirpId,
+	})
+	if err != nil {
+		log.Printf("request %s: error creating %s notification: %v", requestID(ctx), kind, err)
+		return
+	}
+
+	cfg.publishEvent(ctx, notificationEventCreated, userId, newNotification(notification))
+}
+
+// notificationPreferences returns whether each notification type is on for
+// userId. Types are on unless the user turned them off.
+func (cfg *apiConfig) notificationPreferences(ctx context.Context, userId uuid.UUID) (map[string]bool, error) {
+	preferences := make(map[string]bool, len(notificationTypes))
+	for _, kind := range notificationTypes {
+		preferences[kind] = true
+	}
+
+	stored, err := cfg.db.ListNotificationPreferences(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, preference := range stored {
+		if slices.Contains(notificationTypes, preference.Type) {
+			preferences[preference.Type] = preference.Enabled
+		}
+	}
+
+	return preferences, nil
+}
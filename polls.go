@@ -0,0 +1,351 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"github.com/vemolista/chirpy/v2/internal/database"
+	"github.com/vemolista/chirpy/v2/internal/store"
+)
+
+const (
+	minPollOptions      = 2
+	maxPollOptions      = 4
+	maxPollOptionLength = 25
+	// maxPollDuration is how long a poll may stay open after its chirp is
+	// published.
+	maxPollDuration = 7 * 24 * time.Hour
+)
+
+type Poll struct {
+	Options []PollOption `json:"options"`
+	// TotalVotes and the votes of each option are null until the viewer
+	// has voted or the poll has closed.
+	TotalVotes *int64    `json:"total_votes"`
+	ClosesAt   time.Time `json:"closes_at"`
+	Closed     bool      `json:"closed"`
+	// VotedOptionId is the option the viewer voted for, if any.
+	VotedOptionId *uuid.UUID `json:"voted_option_id"`
+}
+
+type PollOption struct {
+	Id    uuid.UUID `json:"id"`
+	Text  string    `json:"text"`
+	Votes *int64    `json:"votes"`
+}
+
+// newPoll returns the poll as the user who cast vote sees it at now. vote
+// is nil for anonymous viewers and those who haven't voted.
+func newPoll(poll database.Poll, options []database.ListPollOptionsForChirpsRow, vote *database.PollVote, now time.Time) *Poll {
+	response := &Poll{
+		Options:  make([]PollOption, len(options)),
+		ClosesAt: poll.ClosesAt,
+		Closed:   !poll.ClosesAt.After(now),
+	}
+	if vote != nil {
+		response.VotedOptionId = &vote.OptionID
+	}
+
+	showResults := response.Closed || vote != nil
+	var total int64
+	for i, option := range options {
+		response.Options[i] = PollOption{Id: option.ID, Text: option.Text}
+		if showResults {
+			response.Options[i].Votes = &option.Votes
+			total += option.Votes
+		}
+	}
+	if showResults {
+		response.TotalVotes = &total
+	}
+
+	return response
+}
+
+// pollParameters is the poll in a request to create a chirp.
+type pollParameters struct {
+	Options  []string  `json:"options"`
+	ClosesAt time.Time `json:"closes_at" validate:"required"`
+}
+
+// checkPoll validates the poll of a new chirp with the status and
+// publish_at worked out by chirpSchedule, and trims its options. Drafts
+// can't have polls because they don't know when they will be published.
+func checkPoll(poll *pollParameters, status string, publishAt sql.NullTime, now time.Time) error {
+	if status == chirpStatusDraft {
+		return validationError("validation_failed", "Request body failed validation", fieldError{
+			Field:   "poll",
+			Code:    "not_allowed",
+			Message: "must not be set on a draft",
+		})
+	}
+
+	if len(poll.Options) < minPollOptions || len(poll.Options) > maxPollOptions {
+		return validationError("validation_failed", "Request body failed validation", fieldError{
+			Field:   "poll.options",
+			Code:    "item_count",
+			Message: fmt.Sprintf("must have between %d and %d items", minPollOptions, maxPollOptions),
+		})
+	}
+
+	for i, option := range poll.Options {
+		option = strings.TrimSpace(option)
+		poll.Options[i] = option
+
+		if option == "" {
+			return validationError("validation_failed", "Request body failed validation", fieldError{
+				Field:   "poll.options",
+				Code:    "required",
+				Message: "must not have empty items",
+			})
+		}
+
+		if utf8.RuneCountInString(option) > maxPollOptionLength {
+			return validationError("validation_failed", "Request body failed validation", fieldError{
+				Field:   "poll.options",
+				Code:    "max_length",
+				Message: fmt.Sprintf("must have items of at most %d characters", maxPollOptionLength),
+			})
+		}
+
+		for _, previous := range poll.Options[:i] {
+			if strings.EqualFold(previous, option) {
+				return validationError("validation_failed", "Request body failed validation", fieldError{
+					Field:   "poll.options",
+					Code:    "duplicate",
+					Message: "must not repeat an item",
+				})
+			}
+		}
+	}
+
+	opensAt := now
+	if publishAt.Valid {
+		opensAt = publishAt.Time
+	}
+
+	if !poll.ClosesAt.After(opensAt) {
+		return validationError("validation_failed", "Request body failed validation", fieldError{
+			Field:   "poll.closes_at",
+			Code:    "future",
+			Message: "must be after the chirp is published",
+		})
+	}
+
+	if poll.ClosesAt.Sub(opensAt) > maxPollDuration {
+		return validationError("validation_failed", "Request body failed validation", fieldError{
+			Field:   "poll.closes_at",
+			Code:    "max",
+			Message: fmt.Sprintf("must be at most %s after the chirp is published", maxPollDuration),
+		})
+	}
+
+	return nil
+}
+
+// createPoll attaches a poll checked by checkPoll to a new chirp, in the
+// transaction that creates the chirp.
+func (cfg *apiConfig) createPoll(ctx context.Context, chirp database.Chirp, params *pollParameters) (*Poll, error) {
+	poll, options, err := cfg.insertPoll(ctx, chirp, params)
+	if err != nil {
+		return nil, internalError("Error creating poll", err)
+	}
+
+	return newPoll(poll, options, nil, time.Now()), nil
+}
+
+func (cfg *apiConfig) insertPoll(ctx context.Context, chirp database.Chirp, params *pollParameters) (database.Poll, []database.ListPollOptionsForChirpsRow, error) {
+	poll, err := cfg.db.CreatePoll(ctx, database.CreatePollParams{
+		ChirpID:  chirp.ID,
+		ClosesAt: params.ClosesAt.UTC(),
+	})
+	if err != nil {
+		return database.Poll{}, nil, fmt.Errorf("error creating poll: %w", err)
+	}
+
+	var options []database.ListPollOptionsForChirpsRow
+	for i, text := range params.Options {
+		option, err := cfg.db.CreatePollOption(ctx, database.CreatePollOptionParams{
+			ChirpID:  chirp.ID,
+			Position: int32(i),
+			Text:     cleanChirp(badWords, text),
+		})
+		if err != nil {
+			return database.Poll{}, nil, fmt.Errorf("error creating poll option: %w", err)
+		}
+
+		options = append(options, database.ListPollOptionsForChirpsRow{
+			ID:       option.ID,
+			ChirpID:  option.ChirpID,
+			Position: option.Position,
+			Text:     option.Text,
+		})
+	}
+
+	return poll, options, nil
+}
+
+// chirpPolls returns the polls of the chirps with ids that have one, as
+// viewerId sees them. viewerId is uuid.Nil for anonymous viewers.
+func (cfg *apiConfig) chirpPolls(ctx context.Context, ids []uuid.UUID, viewerId uuid.UUID) (map[uuid.UUID]*Poll, error) {
+	response := map[uuid.UUID]*Poll{}
+
+	polls, err := cfg.db.ListPollsForChirps(ctx, ids)
+	if err != nil {
+		return nil, fmt.Errorf("error getting polls: %w", err)
+	}
+	if len(polls) == 0 {
+		return response, nil
+	}
+
+	pollIds := make([]uuid.UUID, len(polls))
+	for i, poll := range polls {
+		pollIds[i] = poll.ChirpID
+	}
+
+	options, err := cfg.db.ListPollOptionsForChirps(ctx, pollIds)
+	if err != nil {
+		return nil, fmt.Errorf("error getting poll options: %w", err)
+	}
+
+	optionsByPoll := map[uuid.UUID][]database.ListPollOptionsForChirpsRow{}
+	for _, option := range options {
+		optionsByPoll[option.ChirpID] = append(optionsByPoll[option.ChirpID], option)
+	}
+
+	votes := map[uuid.UUID]*database.PollVote{}
+	if viewerId != uuid.Nil {
+		rows, err := cfg.db.ListPollVotesForUser(ctx, database.ListPollVotesForUserParams{
+			UserID:   viewerId,
+			ChirpIds: pollIds,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("error getting poll votes: %w", err)
+		}
+
+		for _, vote := range rows {
+			votes[vote.ChirpID] = &vote
+		}
+	}
+
+	now := time.Now()
+	for _, poll := range polls {
+		response[poll.ChirpID] = newPoll(poll, optionsByPoll[poll.ChirpID], votes[poll.ChirpID], now)
+	}
+
+	return response, nil
+}
+
+// votePollHandler casts the user's vote on the poll of a chirp and returns
+// the poll with its results.
+func (cfg *apiConfig) votePollHandler(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		OptionId uuid.UUID `json:"option_id" validate:"required"`
+	}
+
+	userId, err := cfg.authenticate(r)
+	if err != nil {
+		respondWithError(w, r, err)
+		return
+	}
+
+	chirpId, err := parseUUID("chirpId", r.PathValue("chirpId"))
+	if err != nil {
+		respondWithError(w, r, err)
+		return
+	}
+
+	var params parameters
+	err = decodeJSON(w, r, &params)
+	if err != nil {
+		respondWithError(w, r, err)
+		return
+	}
+
+	notFound := notFoundError("chirp_not_found", fmt.Sprintf("No chirp with Id %s", chirpId), nil)
+
+	chirp, err := cfg.db.GetChirp(r.Context(), chirpId)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && (chirp.Status != chirpStatusPublished || !cfg.canSeeChirp(r, chirp))) {
+		respondWithError(w, r, notFound)
+		return
+	}
+	if err != nil {
+		respondWithError(w, r, internalError("Error getting chirp from db", err))
+		return
+	}
+
+	polls, err := cfg.chirpPolls(r.Context(), []uuid.UUID{chirp.ID}, userId)
+	if err != nil {
+		respondWithError(w, r, internalError("Error getting poll", err))
+		return
+	}
+
+	poll, ok := polls[chirp.ID]
+	if !ok {
+		respondWithError(w, r, notFoundError("poll_not_found", fmt.Sprintf("Chirp %s has no poll", chirpId), nil))
+		return
+	}
+
+	if poll.VotedOptionId != nil {
+		respondWithError(w, r, conflictError("already_voted", "You already voted in this poll", nil))
+		return
+	}
+
+	if poll.Closed {
+		respondWithError(w, r, conflictError("poll_closed", "The poll has closed", nil))
+		return
+	}
+
+	hasOption := false
+	for _, option := range poll.Options {
+		hasOption = hasOption || option.Id == params.OptionId
+	}
+	if !hasOption {
+		respondWithError(w, r, validationError("validation_failed", "Request body failed validation", fieldError{
+			Field:   "option_id",
+			Code:    "not_found",
+			Message: "must be one of the poll's options",
+		}))
+		return
+	}
+
+	// The checks above are for better errors: the store enforces them even
+	// when requests race.
+	_, err = cfg.db.VotePoll(r.Context(), database.VotePollParams{
+		ChirpID:  chirp.ID,
+		UserID:   userId,
+		OptionID: params.OptionId,
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, r, conflictError("poll_closed", "The poll has closed", nil))
+		return
+	}
+	if store.IsUniqueViolation(err) {
+		respondWithError(w, r, conflictError("already_voted", "You already voted in this poll", err))
+		return
+	}
+	if store.IsForeignKeyViolation(err) {
+		// Deleted since we read it.
+		respondWithError(w, r, notFound)
+		return
+	}
+	if err != nil {
+		respondWithError(w, r, internalError("Error voting", err))
+		return
+	}
+
+	polls, err = cfg.chirpPolls(r.Context(), []uuid.UUID{chirp.ID}, userId)
+	if err != nil {
+		respondWithError(w, r, internalError("Error getting poll", err))
+		return
+	}
+
+	respondWithJson(w, http.StatusOK, polls[chirp.ID])
+}
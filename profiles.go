@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/vemolista/chirpy/v2/internal/database"
+	"github.com/vemolista/chirpy/v2/internal/entities"
+)
+
+const minUsernameLen = 3
+
+// Usernames are at most as long as a handle in a mention, so that every
+// user can be mentioned.
+var usernamePattern = regexp.MustCompile(fmt.Sprintf(`^[a-z0-9_]{%d,%d}$`, minUsernameLen, entities.MaxHandleLen))
+
+// reservedUsernames can't be taken, because they would be confused with the
+// service itself or with paths under /api/users.
+var reservedUsernames = []string{
+	"admin", "administrator", "api", "app", "chirpy", "help", "me",
+	"moderator", "root", "settings", "support", "system",
+}
+
+// normalizeUsername returns username in the form it is stored and looked up
+// in: usernames are case-insensitive.
+func normalizeUsername(username string) string {
+	return strings.ToLower(username)
+}
+
+func checkUsername(username string) (fieldError, bool) {
+	username = normalizeUsername(username)
+
+	if !usernamePattern.MatchString(username) {
+		return fieldError{
+			Code:    "username",
+			Message: fmt.Sprintf("must be %d to %d letters, digits or underscores", minUsernameLen, entities.MaxHandleLen),
+		}, false
+	}
+
+	if slices.Contains(reservedUsernames, username) {
+		return fieldError{Code: "username_reserved", Message: "is reserved"}, false
+	}
+
+	return fieldError{}, true
+}
+
+func nullUsername(username string) sql.NullString {
+	if username == "" {
+		return sql.NullString{}
+	}
+
+	return sql.NullString{String: normalizeUsername(username), Valid: true}
+}
+
+func usernamePointer(username sql.NullString) *string {
+	if !username.Valid {
+		return nil
+	}
+
+	return &username.String
+}
+
+// Author is the public summary of a user embedded in chirps. It must never
+// include private fields such as the email.
+type Author struct {
+	Id          uuid.UUID `json:"id"`
+	Username    *string   `json:"username"`
+	DisplayName string    `json:"display_name"`
+	AvatarUrl   string    `json:"avatar_url"`
+}
+
+func newAuthor(user database.User) Author {
+	return Author{
+		Id:          user.ID,
+		Username:    usernamePointer(user.Username),
+		DisplayName: user.DisplayName,
+		AvatarUrl:   user.AvatarUrl,
+	}
+}
+
+// authors returns the authors of chirps by id, with one query.
+func (cfg *apiConfig) authors(ctx context.Context, chirps []database.Chirp) (map[uuid.UUID]Author, error) {
+	seen := map[uuid.UUID]bool{}
+	var ids []uuid.UUID
+	for _, chirp := range chirps {
+		if !seen[chirp.UserID] {
+			seen[chirp.UserID] = true
+			ids = append(ids, chirp.UserID)
+		}
+	}
+
+	users, err := cfg.db.ListUsersByIDs(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	authors := make(map[uuid.UUID]Author, len(users))
+	for _, user := range users {
+		authors[user.ID] = newAuthor(user)
+	}
+
+	return authors, nil
+}
+
+// resolveMentions points earlier mentions of a username that matched nobody
+// at the user who just took it. It doesn't notify, as the mentions predate
+// the user's claim to the name. Failures are logged: the username is
+// already saved.
+func (cfg *apiConfig) resolveMentions(ctx context.Context, user database.User) {
+	if !user.Username.Valid {
+		return
+	}
+
+	_, err := cfg.db.ResolveMentions(ctx, database.ResolveMentionsParams{
+		Text:   user.Username.String,
+		UserID: uuid.NullUUID{UUID: user.ID, Valid: true},
+	})
+	if err != nil {
+		log.Printf("request %s: error resolving mentions of %s: %v", requestID(ctx), user.Username.String, err)
+	}
+}
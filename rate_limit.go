@@ -0,0 +1,115 @@
+package main
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+
+	"github.com/vemolista/chirpy/v2/internal/auth"
+	"github.com/vemolista/chirpy/v2/internal/config"
+	"github.com/vemolista/chirpy/v2/internal/ratelimit"
+)
+
+// Route groups that share a rate limit.
+const (
+	rateLimitAuth    = "auth"
+	rateLimitWrite   = "write"
+	rateLimitRead    = "read"
+	rateLimitWebhook = "webhook"
+)
+
+// newRateLimiter returns the configured limiter store, or nil when rate
+// limiting is disabled.
+func newRateLimiter(conf config.RateLimitConfig, db *sql.DB) ratelimit.Store {
+	if !conf.Enabled {
+		return nil
+	}
+
+	if conf.Store == config.StoragePostgres {
+		return ratelimit.NewPostgres(db)
+	}
+
+	return ratelimit.NewMemory()
+}
+
+func (cfg *apiConfig) rateLimitFor(group string) ratelimit.Limit {
+	switch group {
+	case rateLimitAuth:
+		return cfg.rateLimits.Auth
+	case rateLimitWrite:
+		return cfg.rateLimits.Write
+	case rateLimitRead:
+		return cfg.rateLimits.Read
+	case rateLimitWebhook:
+		return cfg.rateLimits.Webhook
+	}
+
+	return ratelimit.Limit{}
+}
+
+// rateLimit limits next to the group's limit. Requests carrying a valid
+// access token are counted per user, with a higher limit for Chirpy Red
+// users; anything else, and every request in the auth group, is counted
+// per client IP. If the limiter store fails, the request is let through.
+func (cfg *apiConfig) rateLimit(group string, next http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		limit := cfg.rateLimitFor(group)
+		if cfg.rateLimiter == nil || limit.Unlimited() {
+			next(w, r)
+			return
+		}
+
+		key := "ip:" + ratelimit.ClientIP(r, cfg.trustedProxies)
+		if group != rateLimitAuth {
+			if userKey, red, ok := cfg.rateLimitUser(r); ok {
+				key = userKey
+				if red {
+					limit = limit.Scale(cfg.rateLimits.RedMultiplier)
+				}
+			}
+		}
+
+		result, err := cfg.rateLimiter.Take(r.Context(), group+":"+key, limit)
+		if err != nil {
+			log.Printf("request %s: error checking rate limit: %v", requestID(r.Context()), err)
+			next(w, r)
+			return
+		}
+
+		result.SetHeaders(w.Header())
+
+		if !result.Allowed {
+			cfg.metrics.RateLimited(group)
+			respondWithError(w, r, &apiError{
+				Status: http.StatusTooManyRequests,
+				Code:   "rate_limited",
+				Detail: "Too many requests, try again later",
+			})
+			return
+		}
+
+		next(w, r)
+	})
+}
+
+// rateLimitUser returns the rate limit key for the user whose access token
+// is on r, and whether they have Chirpy Red. Invalid tokens are left for the
+// handler to reject.
+func (cfg *apiConfig) rateLimitUser(r *http.Request) (key string, red bool, ok bool) {
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		return "", false, false
+	}
+
+	userId, err := auth.ValidateJWTContext(r.Context(), token, cfg.secret)
+	if err != nil {
+		return "", false, false
+	}
+
+	user, err := cfg.db.GetUser(r.Context(), userId)
+	if err != nil {
+		return "user:" + userId.String(), false, true
+	}
+
+	return "user:" + userId.String(), user.IsChirpyRed, true
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/vemolista/chirpy/v2/internal/auth"
+	"github.com/vemolista/chirpy/v2/internal/database"
+)
+
+// authenticate returns the ID of the user whose access token is in the
+// Authorization header.
+func (cfg *apiConfig) authenticate(r *http.Request) (uuid.UUID, error) {
+	user, err := cfg.authenticateUser(r)
+	if err != nil {
+		return uuid.Nil, err
+	}
+
+	return user.ID, nil
+}
+
+// authenticateUser returns the user whose access token is in the
+// Authorization header. Suspended users are refused even though their
+// token is still valid.
+func (cfg *apiConfig) authenticateUser(r *http.Request) (database.User, error) {
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		return database.User{}, unauthorizedError("missing_token", "A Bearer token is required", err)
+	}
+
+	userId, err := auth.ValidateJWTContext(r.Context(), token, cfg.secret)
+	if err != nil {
+		return database.User{}, unauthorizedError("invalid_token", "The access token is invalid or expired", err)
+	}
+
+	return cfg.activeUser(r.Context(), userId)
+}
+
+// activeUser returns the user with an ID taken from a token, unless they
+// have since been deleted or suspended.
+func (cfg *apiConfig) activeUser(ctx context.Context, userId uuid.UUID) (database.User, error) {
+	user, err := cfg.db.GetUser(ctx, userId)
+	if errors.Is(err, sql.ErrNoRows) {
+		return database.User{}, unauthorizedError("invalid_token", "The token's user no longer exists", err)
+	}
+	if err != nil {
+		return database.User{}, internalError("Error getting user", err)
+	}
+
+	if user.SuspendedAt.Valid {
+		return database.User{}, suspendedError()
+	}
+
+	return user, nil
+}
+
+// parseLimit parses the limit query parameter, which must be from 1 to max
+// and defaults to fallback.
+func parseLimit(r *http.Request, fallback, max int) (int, error) {
+	value := r.URL.Query().Get("limit")
+	if value == "" {
+		return fallback, nil
+	}
+
+	limit, err := strconv.Atoi(value)
+	if err != nil || limit < 1 || limit > max {
+		return 0, validationError("invalid_query", "Malformed limit parameter", fieldError{
+			Field:   "limit",
+			Code:    "range",
+			Message: fmt.Sprintf("must be a number from 1 to %d", max),
+		})
+	}
+
+	return limit, nil
+}
+
+// parseUUID parses a UUID taken from the named path or query parameter.
+func parseUUID(field, value string) (uuid.UUID, error) {
+	id, err := uuid.Parse(value)
+	if err != nil {
+		return uuid.Nil, validationError("invalid_id", "Malformed ID", fieldError{
+			Field:   field,
+			Code:    "uuid",
+			Message: "must be a UUID",
+		})
+	}
+
+	return id, nil
+}
+
+// maxBodyBytes caps the size of a JSON request body.
+const maxBodyBytes = 1 << 20
+
+// decodeJSON decodes a JSON request body into dst and validates it against
+// dst's `validate` tags. The body must be a single JSON value of at most
+// maxBodyBytes, sent as application/json, with no fields dst doesn't know
+// about.
+func decodeJSON(w http.ResponseWriter, r *http.Request, dst any) error {
+	return decodeJSONBody(w, r, dst, true)
+}
+
+// decodeWebhook is decodeJSON for webhook payloads, which tolerate unknown
+// fields because the sender may add new ones at any time.
+func decodeWebhook(w http.ResponseWriter, r *http.Request, dst any) error {
+	return decodeJSONBody(w, r, dst, false)
+}
+
+func decodeJSONBody(w http.ResponseWriter, r *http.Request, dst any, strict bool) error {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || mediaType != "application/json" {
+		return &apiError{
+			Status: http.StatusUnsupportedMediaType,
+			Code:   "unsupported_media_type",
+			Detail: "Request body must be application/json",
+		}
+	}
+
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodyBytes))
+	if strict {
+		decoder.DisallowUnknownFields()
+	}
+
+	err = decoder.Decode(dst)
+	if err != nil {
+		return decodeError(err)
+	}
+
+	_, err = decoder.Token()
+	if !errors.Is(err, io.EOF) {
+		apiErr := validationError("malformed_json", "Request body must contain a single JSON value")
+		apiErr.Err = err
+
+		return apiErr
+	}
+
+	fields := validateStruct(dst)
+	if len(fields) > 0 {
+		return validationError("validation_failed", "Request body failed validation", fields...)
+	}
+
+	return nil
+}
+
+// decodeError maps an error from json.Decoder.Decode onto an apiError.
+func decodeError(err error) *apiError {
+	var maxBytesErr *http.MaxBytesError
+	var typeErr *json.UnmarshalTypeError
+
+	switch {
+	case errors.As(err, &maxBytesErr):
+		return &apiError{
+			Status: http.StatusRequestEntityTooLarge,
+			Code:   "body_too_large",
+			Detail: fmt.Sprintf("Request body must be at most %d bytes", maxBytesErr.Limit),
+			Err:    err,
+		}
+
+	case errors.Is(err, io.EOF):
+		return validationError("empty_body", "Request body is empty")
+
+	case errors.As(err, &typeErr):
+		return validationError("invalid_type", "Request body has a field of the wrong type", fieldError{
+			Field:   typeErr.Field,
+			Code:    "type",
+			Message: "must be a " + typeErr.Type.String(),
+		})
+
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		field := strings.Trim(strings.TrimPrefix(err.Error(), "json: unknown field "), `"`)
+		return validationError("unknown_field", "Request body has an unknown field", fieldError{
+			Field:   field,
+			Code:    "unknown",
+			Message: "is not allowed",
+		})
+	}
+
+	return malformedJSONError(err)
+}
+
+func malformedJSONError(err error) *apiError {
+	apiErr := validationError("malformed_json", "Request body is not valid JSON")
+	apiErr.Err = err
+
+	return apiErr
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestDecodeJSON(t *testing.T) {
+	type nested struct {
+		Name string `json:"name" validate:"required"`
+	}
+
+	type parameters struct {
+		Email    string  `json:"email" validate:"required,email"`
+		Body     string  `json:"body" validate:"max=5"`
+		Nested   nested  `json:"nested"`
+		Username string  `json:"username" validate:"username"`
+		Website  *string `json:"website" validate:"url"`
+		Color    string  `json:"color" validate:"oneof=red green"`
+		Optional *nested `json:"optional"`
+	}
+
+	cases := []struct {
+		name        string
+		contentType string
+		body        string
+		status      int
+		code        string
+		fields      []string
+	}{
+		{"valid", "application/json", `{"email":"a@example.com","body":"héllo","nested":{"name":"n"}}`, 0, "", nil},
+		{"charset parameter", "application/json; charset=utf-8", `{"email":"a@example.com","nested":{"name":"n"}}`, 0, "", nil},
+		{"missing content type", "", `{}`, http.StatusUnsupportedMediaType, "unsupported_media_type", nil},
+		{"wrong content type", "text/plain", `{}`, http.StatusUnsupportedMediaType, "unsupported_media_type", nil},
+		{"empty body", "application/json", ``, http.StatusBadRequest, "empty_body", nil},
+		{"syntax error", "application/json", `{"email":`, http.StatusBadRequest, "malformed_json", nil},
+		{"trailing garbage", "application/json", `{"email":"a@example.com","nested":{"name":"n"}} x`, http.StatusBadRequest, "malformed_json", nil},
+		{"second value", "application/json", `{"email":"a@example.com","nested":{"name":"n"}}{}`, http.StatusBadRequest, "malformed_json", nil},
+		{"unknown field", "application/json", `{"email":"a@example.com","admin":true}`, http.StatusBadRequest, "unknown_field", []string{"admin"}},
+		{"wrong type", "application/json", `{"email":1}`, http.StatusBadRequest, "invalid_type", []string{"email"}},
+		{"too large", "application/json", `{"body":"` + strings.Repeat("a", maxBodyBytes) + `"}`, http.StatusRequestEntityTooLarge, "body_too_large", nil},
+		{"failed validation", "application/json", `{"email":"nope","body":"too long"}`, http.StatusBadRequest, "validation_failed", []string{"email", "body", "nested.name"}},
+		{"valid username and url", "application/json", `{"email":"a@example.com","nested":{"name":"n"},"username":"Alice_1","website":"https://example.com"}`, 0, "", nil},
+		{"invalid username and url", "application/json", `{"email":"a@example.com","nested":{"name":"n"},"username":"a-b","website":"javascript:alert(1)"}`, http.StatusBadRequest, "validation_failed", []string{"username", "website"}},
+		{"allowed value", "application/json", `{"email":"a@example.com","nested":{"name":"n"},"color":"green"}`, 0, "", nil},
+		{"disallowed value", "application/json", `{"email":"a@example.com","nested":{"name":"n"},"color":"blue"}`, http.StatusBadRequest, "validation_failed", []string{"color"}},
+		{"optional nested", "application/json", `{"email":"a@example.com","nested":{"name":"n"},"optional":{"name":"o"}}`, 0, "", nil},
+		{"invalid optional nested", "application/json", `{"email":"a@example.com","nested":{"name":"n"},"optional":{}}`, http.StatusBadRequest, "validation_failed", []string{"optional.name"}},
+		{"reserved username", "application/json", `{"email":"a@example.com","nested":{"name":"n"},"username":"Admin"}`, http.StatusBadRequest, "validation_failed", []string{"username"}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/", strings.NewReader(c.body))
+			if c.contentType != "" {
+				r.Header.Set("Content-Type", c.contentType)
+			}
+
+			var params parameters
+			err := decodeJSON(httptest.NewRecorder(), r, &params)
+			if c.status == 0 {
+				if err != nil {
+					t.Errorf("expected no error, instead got %v", err)
+				}
+				return
+			}
+
+			apiErr := asAPIError(err)
+			if apiErr.Status != c.status || apiErr.Code != c.code {
+				t.Fatalf("expected %d %s, instead got %d %s", c.status, c.code, apiErr.Status, apiErr.Code)
+			}
+
+			if len(apiErr.Fields) != len(c.fields) {
+				t.Fatalf("expected fields %v, instead got %+v", c.fields, apiErr.Fields)
+			}
+
+			for i, field := range c.fields {
+				if apiErr.Fields[i].Field != field {
+					t.Errorf("expected field %s, instead got %s", field, apiErr.Fields[i].Field)
+				}
+			}
+		})
+	}
+}
+
+func TestDecodeWebhookAllowsUnknownFields(t *testing.T) {
+	var params struct {
+		Event string `json:"event" validate:"required"`
+	}
+
+	r := httptest.NewRequest("POST", "/", strings.NewReader(`{"event":"user.upgraded","sent_at":"now"}`))
+	r.Header.Set("Content-Type", "application/json")
+
+	err := decodeWebhook(httptest.NewRecorder(), r, &params)
+	if err != nil || params.Event != "user.upgraded" {
+		t.Errorf("expected webhook to decode, instead got %+v, %v", params, err)
+	}
+}
@@ -6,22 +6,47 @@ import (
 	"net/http"
 )
 
-type errorResponse struct {
-	Error string `json:"error"`
+// problem is an RFC 7807 problem details document. Code, RequestID and
+// Errors are extension members.
+type problem struct {
+	Type      string       `json:"type"`
+	Title     string       `json:"title"`
+	Status    int          `json:"status"`
+	Detail    string       `json:"detail,omitempty"`
+	Instance  string       `json:"instance,omitempty"`
+	Code      string       `json:"code"`
+	RequestID string       `json:"request_id,omitempty"`
+	Errors    []fieldError `json:"errors,omitempty"`
 }
 
-func respondWithError(w http.ResponseWriter, code int, msg string, err error) {
-	if err != nil {
-		log.Println(err)
+func respondWithError(w http.ResponseWriter, r *http.Request, err error) {
+	apiErr := asAPIError(err)
+	id := requestID(r.Context())
+
+	if apiErr.Status >= http.StatusInternalServerError {
+		log.Printf("request %s: %s %s: %v", id, r.Method, r.URL.Path, apiErr)
+	} else if apiErr.Err != nil {
+		log.Printf("request %s: %v", id, apiErr)
 	}
 
-	respondWithJson(w, code, errorResponse{
-		Error: msg,
+	respondWithContentType(w, apiErr.Status, "application/problem+json", problem{
+		Type:      "about:blank",
+		Title:     http.StatusText(apiErr.Status),
+		Status:    apiErr.Status,
+		Detail:    apiErr.Detail,
+		Instance:  r.URL.Path,
+		Code:      apiErr.Code,
+		RequestID: id,
+		Errors:    apiErr.Fields,
 	})
 }
 
 func respondWithJson(w http.ResponseWriter, code int, payload interface{}) {
-	w.Header().Set("Content-Type", "application/json")
+	respondWithContentType(w, code, "application/json", payload)
+}
+
+func respondWithContentType(w http.ResponseWriter, code int, contentType string, payload interface{}) {
+	w.Header().Set("Content-Type", contentType)
 	data, err := json.Marshal(payload)
 	if err != nil {
 		log.Printf("Error marshalling JSON: %s", err)
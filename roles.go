@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/vemolista/chirpy/v2/internal/database"
+	"github.com/vemolista/chirpy/v2/internal/store"
+)
+
+const (
+	roleUser      = "user"
+	roleModerator = "moderator"
+)
+
+const roleUsage = "usage: chirpy role <email> user|moderator [flags]"
+
+// isModerator reports whether the request is authenticated as a moderator.
+// Anonymous requests and invalid tokens are simply not moderators.
+func (cfg *apiConfig) isModerator(r *http.Request) (bool, error) {
+	user, err := cfg.authenticateUser(r)
+	var apiErr *apiError
+	if errors.As(err, &apiErr) && apiErr.Status != http.StatusInternalServerError {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return user.Role == roleModerator, nil
+}
+
+// runRoleCommand gives the user with email a role, for `chirpy role`.
+func runRoleCommand(ctx context.Context, db store.Store, email, role string) error {
+	if role != roleUser && role != roleModerator {
+		return fmt.Errorf("unknown role %q\n%s", role, roleUsage)
+	}
+
+	user, err := db.GetUserByEmail(ctx, email)
+	if errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("no user with email %s", email)
+	}
+	if err != nil {
+		return fmt.Errorf("error getting user: %w", err)
+	}
+
+	_, err = db.SetUserRole(ctx, database.SetUserRoleParams{ID: user.ID, Role: role})
+	if err != nil {
+		return fmt.Errorf("error setting role: %w", err)
+	}
+
+	fmt.Printf("%s is now a %s\n", email, role)
+	return nil
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/vemolista/chirpy/v2/internal/tracing"
+)
+
+// routes builds the application's HTTP handler, including the middleware
+// that wraps every request. Middleware that replaces the request, such as
+// middlewareRequestID, must stay outside the metrics and tracing middleware
+// so that they can see the pattern the ServeMux matched.
+func (cfg *apiConfig) routes() http.Handler {
+	serveMux := http.NewServeMux()
+
+	appHandler := http.StripPrefix("/app/", http.FileServer(http.Dir(".")))
+	serveMux.Handle("/app/", cfg.metrics.FileserverMiddleware(appHandler))
+
+	serveMux.HandleFunc("GET /api/healthz", healthHandler)
+	serveMux.HandleFunc("GET /api/readyz", cfg.readyHandler)
+	serveMux.Handle("POST /api/chirps", cfg.rateLimit(rateLimitWrite, cfg.idempotent(cfg.createChirpHandler)))
+	serveMux.Handle("GET /api/chirps", cfg.rateLimit(rateLimitRead, cfg.listChirpsHandler))
+	serveMux.Handle("GET /api/chirps/stream", cfg.rateLimit(rateLimitRead, cfg.streamChirpsHandler))
+	serveMux.Handle("GET /api/chirps/drafts", cfg.rateLimit(rateLimitRead, cfg.listDraftChirpsHandler))
+	serveMux.Handle("GET /api/chirps/{chirpId}", cfg.rateLimit(rateLimitRead, cfg.getChirpHandler))
+	serveMux.Handle("PUT /api/chirps/{chirpId}", cfg.rateLimit(rateLimitWrite, cfg.updateChirpHandler))
+	serveMux.Handle("DELETE /api/chirps/{chirpId}", cfg.rateLimit(rateLimitWrite, cfg.deleteChirpHandler))
+	serveMux.Handle("POST /api/chirps/{chirpId}/publish", cfg.rateLimit(rateLimitWrite, cfg.publishChirpHandler))
+	serveMux.Handle("POST /api/chirps/{chirpId}/restore", cfg.rateLimit(rateLimitWrite, cfg.restoreChirpHandler))
+	serveMux.Handle("POST /api/chirps/{chirpId}/bookmark", cfg.rateLimit(rateLimitWrite, cfg.idempotent(cfg.bookmarkChirpHandler)))
+	serveMux.Handle("DELETE /api/chirps/{chirpId}/bookmark", cfg.rateLimit(rateLimitWrite, cfg.unbookmarkChirpHandler))
+	serveMux.Handle("POST /api/chirps/{chirpId}/pin", cfg.rateLimit(rateLimitWrite, cfg.pinChirpHandler))
+	serveMux.Handle("DELETE /api/chirps/{chirpId}/pin", cfg.rateLimit(rateLimitWrite, cfg.unpinChirpHandler))
+	serveMux.Handle("POST /api/chirps/{chirpId}/poll/votes", cfg.rateLimit(rateLimitWrite, cfg.idempotent(cfg.votePollHandler)))
+	serveMux.Handle("GET /api/bookmarks", cfg.rateLimit(rateLimitRead, cfg.listBookmarksHandler))
+	serveMux.Handle("POST /api/media", cfg.rateLimit(rateLimitWrite, cfg.idempotentUpTo(max(cfg.media.MaxBytes, cfg.media.RedMaxBytes)+multipartOverhead, cfg.uploadMediaHandler)))
+	serveMux.Handle("GET /api/media/{mediaId}", cfg.rateLimit(rateLimitRead, cfg.getMediaHandler))
+	serveMux.Handle("GET /api/media/{mediaId}/thumbnail", cfg.rateLimit(rateLimitRead, cfg.getMediaThumbnailHandler))
+	serveMux.Handle("GET /api/hashtags/{tag}/chirps", cfg.rateLimit(rateLimitRead, cfg.listHashtagChirpsHandler))
+	serveMux.Handle("POST /api/users", cfg.rateLimit(rateLimitAuth, cfg.idempotent(cfg.createUserHandler)))
+	serveMux.Handle("PUT /api/users", cfg.rateLimit(rateLimitWrite, cfg.updateUserHandler))
+	serveMux.Handle("PATCH /api/users/me", cfg.rateLimit(rateLimitWrite, cfg.updateProfileHandler))
+	serveMux.Handle("GET /api/users/{username}", cfg.rateLimit(rateLimitRead, cfg.getProfileHandler))
+	serveMux.Handle("POST /api/users/{userId}/follow", cfg.rateLimit(rateLimitWrite, cfg.followUserHandler))
+	serveMux.Handle("DELETE /api/users/{userId}/follow", cfg.rateLimit(rateLimitWrite, cfg.unfollowUserHandler))
+	serveMux.Handle("POST /api/users/{userId}/block", cfg.rateLimit(rateLimitWrite, cfg.blockUserHandler))
+	serveMux.Handle("DELETE /api/users/{userId}/block", cfg.rateLimit(rateLimitWrite, cfg.unblockUserHandler))
+	serveMux.Handle("POST /api/users/{userId}/mute", cfg.rateLimit(rateLimitWrite, cfg.muteUserHandler))
+	serveMux.Handle("DELETE /api/users/{userId}/mute", cfg.rateLimit(rateLimitWrite, cfg.unmuteUserHandler))
+	serveMux.Handle("POST /api/login", cfg.rateLimit(rateLimitAuth, cfg.loginHandler))
+	serveMux.Handle("POST /api/refresh", cfg.rateLimit(rateLimitAuth, cfg.refreshHandler))
+	serveMux.Handle("POST /api/revoke", cfg.rateLimit(rateLimitAuth, cfg.revokeHandler))
+
+	serveMux.Handle("GET /api/notifications", cfg.rateLimit(rateLimitRead, cfg.listNotificationsHandler))
+	serveMux.Handle("POST /api/notifications/read", cfg.rateLimit(rateLimitWrite, cfg.readNotificationsHandler))
+	serveMux.Handle("GET /api/notifications/preferences", cfg.rateLimit(rateLimitRead, cfg.getNotificationPreferencesHandler))
+	serveMux.Handle("PUT /api/notifications/preferences", cfg.rateLimit(rateLimitWrite, cfg.updateNotificationPreferencesHandler))
+	serveMux.Handle("GET /api/realtime", cfg.rateLimit(rateLimitRead, cfg.realtimeHandler))
+
+	serveMux.Handle("POST /api/reports", cfg.rateLimit(rateLimitWrite, cfg.idempotent(cfg.createReportHandler)))
+	serveMux.Handle("GET /api/moderation/reports", cfg.rateLimit(rateLimitRead, cfg.listReportsHandler))
+	serveMux.Handle("POST /api/moderation/reports/{reportId}/decision", cfg.rateLimit(rateLimitWrite, cfg.decideReportHandler))
+	serveMux.Handle("GET /api/moderation/actions", cfg.rateLimit(rateLimitRead, cfg.listModerationActionsHandler))
+
+	serveMux.Handle("POST /api/polka/webhooks", cfg.rateLimit(rateLimitWebhook, cfg.idempotent(cfg.polkaWebhookHandler)))
+
+	serveMux.HandleFunc("GET /admin/metrics", cfg.metricsHandler)
+	serveMux.HandleFunc("POST /admin/reset", cfg.resetMetricsHandler)
+
+	serveMux.Handle("GET /metrics", cfg.metrics.Handler())
+
+	return middlewareRequestID(tracing.Middleware(cfg.metrics.Middleware(serveMux)))
+}
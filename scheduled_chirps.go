@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/vemolista/chirpy/v2/internal/database"
+)
+
+const (
+	chirpStatusDraft     = "draft"
+	chirpStatusScheduled = "scheduled"
+	chirpStatusPublished = "published"
+
+	// publishBatchSize is how many due chirps the scheduler publishes per
+	// query.
+	publishBatchSize = 100
+)
+
+// chirpSchedule works out the status of a new chirp from the status and
+// publish_at in the request. A chirp with a publish_at is scheduled unless
+// it says otherwise, and one without is published.
+func chirpSchedule(status string, publishAt *time.Time, now time.Time) (string, sql.NullTime, error) {
+	if status == "" {
+		status = chirpStatusPublished
+		if publishAt != nil {
+			status = chirpStatusScheduled
+		}
+	}
+
+	switch status {
+	case chirpStatusScheduled:
+		if publishAt == nil {
+			return "", sql.NullTime{}, validationError("validation_failed", "Request body failed validation", fieldError{
+				Field:   "publish_at",
+				Code:    "required",
+				Message: "is required to schedule a chirp",
+			})
+		}
+
+		if !publishAt.After(now) {
+			return "", sql.NullTime{}, validationError("validation_failed", "Request body failed validation", fieldError{
+				Field:   "publish_at",
+				Code:    "future",
+				Message: "must be in the future",
+			})
+		}
+
+		return status, sql.NullTime{Time: publishAt.UTC(), Valid: true}, nil
+	case chirpStatusDraft, chirpStatusPublished:
+		if publishAt != nil {
+			return "", sql.NullTime{}, validationError("validation_failed", "Request body failed validation", fieldError{
+				Field:   "publish_at",
+				Code:    "not_allowed",
+				Message: "must only be set to schedule a chirp",
+			})
+		}
+
+		return status, sql.NullTime{}, nil
+	default:
+		return "", sql.NullTime{}, validationError("validation_failed", "Request body failed validation", fieldError{
+			Field:   "status",
+			Code:    "one_of",
+			Message: "must be one of draft, scheduled or published",
+		})
+	}
+}
+
+// canSeeChirp reports whether the request may see a chirp. Drafts and
+// scheduled chirps are only visible to their authors, and users don't see
+// the chirps of those who blocked them.
+func (cfg *apiConfig) canSeeChirp(r *http.Request, chirp database.Chirp) bool {
+	userId, err := cfg.authenticate(r)
+	if err != nil {
+		return chirp.Status == chirpStatusPublished
+	}
+
+	if chirp.Status != chirpStatusPublished {
+		return userId == chirp.UserID
+	}
+
+	return !cfg.hidesChirps(r.Context(), userId, chirp.UserID, false)
+}
+
+// recordPublished records the events of chirps just published from drafts
+// or schedules, which were held back until now, and returns the chirps.
+// Call it in the transaction that published them, and announcePublished
+// once that commits.
+func (cfg *apiConfig) recordPublished(ctx context.Context, published []database.Chirp) ([]Chirp, []database.ChirpEvent, error) {
+	// The events go to everyone, so their polls are shown to nobody in
+	// particular.
+	chirps, err := cfg.chirpResponses(ctx, published, uuid.Nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	events := make([]database.ChirpEvent, len(chirps))
+	for i, chirp := range chirps {
+		events[i], err = cfg.recordEvent(ctx, chirpEventCreated, published[i].UserID, chirp)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return chirps, events, nil
+}
+
+// announcePublished delivers the events recordPublished recorded and
+// notifies the users the chirps mention. Failures are logged: the chirps
+// are already published.
+func (cfg *apiConfig) announcePublished(ctx context.Context, published []database.Chirp, events []database.ChirpEvent) {
+	for _, event := range events {
+		cfg.deliverEvent(event)
+	}
+
+	ids := make([]uuid.UUID, len(published))
+	for i, chirp := range published {
+		ids[i] = chirp.ID
+	}
+
+	rows, err := cfg.db.ListChirpEntities(ctx, ids)
+	if err != nil {
+		log.Printf("request %s: error getting chirp entities: %v", requestID(ctx), err)
+		return
+	}
+
+	byChirp := map[uuid.UUID][]database.ChirpEntity{}
+	for _, row := range rows {
+		byChirp[row.ChirpID] = append(byChirp[row.ChirpID], row)
+	}
+
+	for _, chirp := range published {
+		cfg.notifyMentions(ctx, chirp, byChirp[chirp.ID], nil)
+	}
+}
+
+// publishScheduledChirps publishes scheduled chirps once they are due,
+// checking every interval until ctx is done.
+func (cfg *apiConfig) publishScheduledChirps(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		cfg.publishDueChirps(ctx, time.Now().UTC())
+	}
+}
+
+func (cfg *apiConfig) publishDueChirps(ctx context.Context, now time.Time) {
+	for {
+		var due []database.Chirp
+		var events []database.ChirpEvent
+		err := cfg.db.InTx(ctx, func(ctx context.Context) error {
+			var err error
+			due, err = cfg.db.PublishDueChirps(ctx, database.PublishDueChirpsParams{
+				Now:   now,
+				Limit: publishBatchSize,
+			})
+			if err != nil {
+				return err
+			}
+
+			_, events, err = cfg.recordPublished(ctx, due)
+			return err
+		})
+		if err != nil {
+			log.Printf("Error publishing scheduled chirps: %v", err)
+			return
+		}
+
+		cfg.announcePublished(ctx, due, events)
+
+		if len(due) < publishBatchSize {
+			return
+		}
+	}
+}
@@ -0,0 +1,12 @@
+-- +goose Up
+create table rate_limit_buckets (
+    key text primary key,
+    tokens double precision not null,
+    updated_at timestamp not null,
+    full_at timestamp not null
+);
+
+create index rate_limit_buckets_full_at_idx on rate_limit_buckets (full_at);
+
+-- +goose Down
+drop table rate_limit_buckets;
@@ -0,0 +1,17 @@
+-- +goose Up
+create table idempotency_keys (
+    scope text not null,
+    key text not null,
+    request_hash text not null,
+    created_at timestamp not null,
+    expires_at timestamp not null,
+    response_status integer,
+    response_content_type text,
+    response_body bytea,
+    primary key (scope, key)
+);
+
+create index idempotency_keys_expires_at_idx on idempotency_keys (expires_at);
+
+-- +goose Down
+drop table idempotency_keys;
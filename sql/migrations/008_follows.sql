@@ -0,0 +1,10 @@
+-- +goose Up
+create table follows (
+    follower_id uuid not null references users(id) on delete cascade,
+    followee_id uuid not null references users(id) on delete cascade,
+    created_at timestamp not null,
+    primary key (follower_id, followee_id)
+);
+
+-- +goose Down
+drop table follows;
@@ -0,0 +1,28 @@
+-- +goose Up
+create table chirp_events (
+    id bigserial primary key,
+    type text not null,
+    user_id uuid not null references users(id) on delete cascade,
+    data text not null,
+    created_at timestamp not null
+);
+
+create index chirp_events_created_at_idx on chirp_events (created_at);
+
+-- +goose StatementBegin
+create function notify_chirp_event() returns trigger as $$
+begin
+    perform pg_notify('chirp_events', new.id::text);
+    return new;
+end;
+$$ language plpgsql;
+-- +goose StatementEnd
+
+create trigger chirp_events_notify
+after insert on chirp_events
+for each row execute function notify_chirp_event();
+
+-- +goose Down
+drop trigger chirp_events_notify on chirp_events;
+drop function notify_chirp_event();
+drop table chirp_events;
@@ -0,0 +1,23 @@
+-- +goose Up
+create table notifications (
+    id uuid primary key,
+    user_id uuid not null references users(id) on delete cascade,
+    actor_id uuid not null references users(id) on delete cascade,
+    type text not null,
+    chirp_id uuid references chirps(id) on delete cascade,
+    created_at timestamp not null,
+    read_at timestamp
+);
+
+create index notifications_inbox on notifications (user_id, created_at desc, id desc);
+
+create table notification_preferences (
+    user_id uuid not null references users(id) on delete cascade,
+    type text not null,
+    enabled boolean not null,
+    primary key (user_id, type)
+);
+
+-- +goose Down
+drop table notification_preferences;
+drop table notifications;
@@ -0,0 +1,16 @@
+-- +goose Up
+create table chirp_entities (
+    chirp_id uuid not null references chirps(id) on delete cascade,
+    type text not null,
+    start_offset integer not null,
+    end_offset integer not null,
+    text text not null,
+    -- The mentioned user, once the handle resolves to one.
+    user_id uuid references users(id) on delete set null,
+    primary key (chirp_id, start_offset)
+);
+
+create index chirp_entities_text on chirp_entities (type, text);
+
+-- +goose Down
+drop table chirp_entities;
@@ -0,0 +1,15 @@
+-- +goose Up
+-- Usernames are stored lowercased, so the unique constraint makes them
+-- unique regardless of case. Users from before usernames have none.
+alter table users
+    add column username text unique,
+    add column display_name text not null default '',
+    add column bio text not null default '',
+    add column avatar_url text not null default '';
+
+-- +goose Down
+alter table users
+    drop column avatar_url,
+    drop column bio,
+    drop column display_name,
+    drop column username;
@@ -0,0 +1,21 @@
+-- +goose Up
+-- Media are uploaded before the chirp they are attached to exists. Media
+-- that stay unattached, including those whose chirp was deleted, are swept
+-- along with their blobs.
+create table media (
+    id uuid primary key,
+    user_id uuid not null references users(id) on delete cascade,
+    chirp_id uuid references chirps(id) on delete set null,
+    position integer not null default 0,
+    content_type text not null,
+    size bigint not null,
+    width integer not null,
+    height integer not null,
+    created_at timestamp not null
+);
+
+create index media_chirp on media (chirp_id, position);
+create index media_unattached on media (created_at) where chirp_id is null;
+
+-- +goose Down
+drop table media;
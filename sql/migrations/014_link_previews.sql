@@ -0,0 +1,15 @@
+-- +goose Up
+-- A cache of the cards for URLs in chirps, keyed by the URL as written.
+-- Failed fetches are kept too, so they aren't retried on every chirp.
+create table link_previews (
+    url text primary key,
+    status text not null,
+    title text not null default '',
+    description text not null default '',
+    image_url text not null default '',
+    site_name text not null default '',
+    fetched_at timestamp not null
+);
+
+-- +goose Down
+drop table link_previews;
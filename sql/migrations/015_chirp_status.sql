@@ -0,0 +1,17 @@
+-- +goose Up
+-- Drafts and scheduled chirps are only visible to their authors. Chirps
+-- from before drafts are all published. A chirp's created_at is reset when
+-- it is published, so it takes its place in timelines then rather than when
+-- it was written.
+alter table chirps
+    add column status text not null default 'published',
+    add column publish_at timestamp;
+
+create index chirps_scheduled on chirps (publish_at) where status = 'scheduled';
+
+-- +goose Down
+drop index chirps_scheduled;
+
+alter table chirps
+    drop column publish_at,
+    drop column status;
@@ -0,0 +1,12 @@
+-- +goose Up
+-- Deleting a chirp only marks it deleted, so that its author can restore it
+-- for a while and moderators can still see it. Deleted chirps are purged
+-- for good after a retention period.
+alter table chirps add column deleted_at timestamp;
+
+create index chirps_deleted on chirps (deleted_at) where deleted_at is not null;
+
+-- +goose Down
+drop index chirps_deleted;
+
+alter table chirps drop column deleted_at;
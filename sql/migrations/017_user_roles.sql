@@ -0,0 +1,7 @@
+-- +goose Up
+-- A user's role is user or moderator. Moderators are appointed with
+-- `chirpy role`.
+alter table users add column role text not null default 'user';
+
+-- +goose Down
+alter table users drop column role;
@@ -0,0 +1,47 @@
+-- +goose Up
+-- Users report chirps and accounts, and the word filter reports chirps it
+-- censors, with no reporter. Moderators resolve reports by hiding the
+-- chirp, warning or suspending its author, or dismissing the report, and
+-- each decision is logged in moderation_actions, which is only ever
+-- appended to.
+alter table users add column suspended_at timestamp;
+
+-- A hidden chirp is deleted by a moderator, and can't be restored by its
+-- author.
+alter table chirps add column hidden boolean not null default false;
+
+create table reports (
+    id uuid primary key,
+    reporter_id uuid references users(id) on delete cascade,
+    user_id uuid not null references users(id) on delete cascade,
+    chirp_id uuid references chirps(id) on delete cascade,
+    -- chirp_body is the chirp as it was reported, in case it is edited.
+    chirp_body text not null default '',
+    reason text not null,
+    details text not null default '',
+    created_at timestamp not null,
+    resolved_at timestamp
+);
+
+create index reports_open on reports (created_at) where resolved_at is null;
+
+create table moderation_actions (
+    id uuid primary key,
+    moderator_id uuid not null references users(id) on delete cascade,
+    user_id uuid not null references users(id) on delete cascade,
+    -- chirp_id outlives the chirp, which is eventually purged once hidden.
+    chirp_id uuid,
+    report_id uuid references reports(id) on delete set null,
+    action text not null,
+    note text not null default '',
+    created_at timestamp not null
+);
+
+create index moderation_actions_user on moderation_actions (user_id, created_at);
+
+-- +goose Down
+drop table moderation_actions;
+drop table reports;
+
+alter table chirps drop column hidden;
+alter table users drop column suspended_at;
@@ -0,0 +1,24 @@
+-- +goose Up
+-- A blocked user can't see, follow or mention the user who blocked them. A
+-- muted user's chirps are left out of the muter's timeline and hashtag
+-- listings, and nobody else's.
+create table blocks (
+    blocker_id uuid not null references users(id) on delete cascade,
+    blocked_id uuid not null references users(id) on delete cascade,
+    created_at timestamp not null,
+    primary key (blocker_id, blocked_id)
+);
+
+-- Timelines look blocks up by the viewer, who is the blocked user.
+create index blocks_blocked on blocks (blocked_id, blocker_id);
+
+create table mutes (
+    muter_id uuid not null references users(id) on delete cascade,
+    muted_id uuid not null references users(id) on delete cascade,
+    created_at timestamp not null,
+    primary key (muter_id, muted_id)
+);
+
+-- +goose Down
+drop table mutes;
+drop table blocks;
@@ -0,0 +1,19 @@
+-- +goose Up
+-- Bookmarks are private to the user who made them. Each user can pin one of
+-- their chirps to the top of their listing.
+create table bookmarks (
+    user_id uuid not null references users(id) on delete cascade,
+    chirp_id uuid not null references chirps(id) on delete cascade,
+    created_at timestamp not null,
+    primary key (user_id, chirp_id)
+);
+
+create table pinned_chirps (
+    user_id uuid primary key references users(id) on delete cascade,
+    chirp_id uuid not null references chirps(id) on delete cascade,
+    created_at timestamp not null
+);
+
+-- +goose Down
+drop table pinned_chirps;
+drop table bookmarks;
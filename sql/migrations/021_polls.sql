@@ -0,0 +1,35 @@
+-- +goose Up
+-- A chirp may carry one poll. Options are numbered by position, and each
+-- user votes once per poll, which the primary key of poll_votes enforces
+-- even when votes race.
+create table polls (
+    chirp_id uuid primary key references chirps(id) on delete cascade,
+    closes_at timestamp not null,
+    created_at timestamp not null
+);
+
+create table poll_options (
+    id uuid primary key,
+    chirp_id uuid not null references polls(chirp_id) on delete cascade,
+    position integer not null,
+    text text not null,
+    unique (chirp_id, position),
+    -- Lets votes check that their option belongs to the poll.
+    unique (chirp_id, id)
+);
+
+create table poll_votes (
+    chirp_id uuid not null references polls(chirp_id) on delete cascade,
+    user_id uuid not null references users(id) on delete cascade,
+    option_id uuid not null,
+    created_at timestamp not null,
+    primary key (chirp_id, user_id),
+    foreign key (chirp_id, option_id) references poll_options(chirp_id, id) on delete cascade
+);
+
+create index poll_votes_option on poll_votes (option_id);
+
+-- +goose Down
+drop table poll_votes;
+drop table poll_options;
+drop table polls;
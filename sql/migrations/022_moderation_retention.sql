@@ -0,0 +1,29 @@
+-- +goose Up
+-- Reports and the moderation log outlive what they are about. A purged
+-- chirp leaves its reports behind with the body they quote, and the log
+-- keeps the users a decision was about or made by, like it already keeps
+-- chirp_id.
+alter table reports
+    drop constraint reports_chirp_id_fkey,
+    add constraint reports_chirp_id_fkey
+        foreign key (chirp_id) references chirps(id) on delete set null;
+
+alter table moderation_actions
+    drop constraint moderation_actions_moderator_id_fkey,
+    drop constraint moderation_actions_user_id_fkey;
+
+-- +goose Down
+delete from moderation_actions
+where moderator_id not in (select id from users)
+    or user_id not in (select id from users);
+
+alter table moderation_actions
+    add constraint moderation_actions_moderator_id_fkey
+        foreign key (moderator_id) references users(id) on delete cascade,
+    add constraint moderation_actions_user_id_fkey
+        foreign key (user_id) references users(id) on delete cascade;
+
+alter table reports
+    drop constraint reports_chirp_id_fkey,
+    add constraint reports_chirp_id_fkey
+        foreign key (chirp_id) references chirps(id) on delete cascade;
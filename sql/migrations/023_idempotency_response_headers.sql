@@ -0,0 +1,20 @@
+-- +goose Up
+-- A replayed response needs every header the handler set, such as the ETag
+-- of a created chirp, not just its Content-Type. They are stored as a JSON
+-- object of header values.
+alter table idempotency_keys add column response_headers text;
+
+update idempotency_keys
+set response_headers = json_build_object('Content-Type', json_build_array(response_content_type))::text
+where response_content_type is not null;
+
+alter table idempotency_keys drop column response_content_type;
+
+-- +goose Down
+alter table idempotency_keys add column response_content_type text;
+
+update idempotency_keys
+set response_content_type = response_headers::json -> 'Content-Type' ->> 0
+where response_headers is not null;
+
+alter table idempotency_keys drop column response_headers;
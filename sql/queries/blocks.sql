@@ -0,0 +1,42 @@
+-- name: BlockUser :execrows
+insert into blocks (blocker_id, blocked_id, created_at)
+values (
+    $1,
+    $2,
+    now()
+)
+on conflict (blocker_id, blocked_id) do nothing;
+
+-- name: UnblockUser :exec
+delete from blocks
+where blocker_id = $1 and blocked_id = $2;
+
+-- name: MuteUser :execrows
+insert into mutes (muter_id, muted_id, created_at)
+values (
+    $1,
+    $2,
+    now()
+)
+on conflict (muter_id, muted_id) do nothing;
+
+-- name: UnmuteUser :exec
+delete from mutes
+where muter_id = $1 and muted_id = $2;
+
+-- name: GetRelationship :one
+select
+    exists (
+        select 1 from blocks
+        where blocker_id = @other_id and blocked_id = @user_id
+    ) as blocked_by,
+    exists (
+        select 1 from mutes
+        where muter_id = @user_id and muted_id = @other_id
+    ) as muting;
+
+-- name: ListBlockers :many
+select blocker_id from blocks where blocked_id = $1;
+
+-- name: ListMutedUsers :many
+select muted_id from mutes where muter_id = $1;
@@ -0,0 +1,43 @@
+-- name: BookmarkChirp :execrows
+insert into bookmarks (user_id, chirp_id, created_at)
+values (
+    $1,
+    $2,
+    now()
+)
+on conflict (user_id, chirp_id) do nothing;
+
+-- name: UnbookmarkChirp :exec
+delete from bookmarks
+where user_id = $1 and chirp_id = $2;
+
+-- name: GetBookmark :one
+select * from bookmarks
+where user_id = $1 and chirp_id = $2;
+
+-- name: ListBookmarkedChirps :many
+select chirps.* from bookmarks
+join chirps on chirps.id = bookmarks.chirp_id
+where
+    bookmarks.user_id = $1
+    and chirps.status = 'published' and chirps.deleted_at is null
+    and not exists (
+        select 1 from blocks
+        where blocker_id = chirps.user_id and blocked_id = bookmarks.user_id
+    )
+order by bookmarks.created_at desc, bookmarks.chirp_id desc
+limit $2;
+
+-- name: ListBookmarkedChirpsBefore :many
+select chirps.* from bookmarks
+join chirps on chirps.id = bookmarks.chirp_id
+where
+    bookmarks.user_id = $1
+    and (bookmarks.created_at, bookmarks.chirp_id) < (sqlc.arg(created_at)::timestamp, sqlc.arg(chirp_id)::uuid)
+    and chirps.status = 'published' and chirps.deleted_at is null
+    and not exists (
+        select 1 from blocks
+        where blocker_id = chirps.user_id and blocked_id = bookmarks.user_id
+    )
+order by bookmarks.created_at desc, bookmarks.chirp_id desc
+limit $2;
@@ -0,0 +1,39 @@
+-- name: CreateChirpEntity :exec
+insert into chirp_entities (chirp_id, type, start_offset, end_offset, text, user_id)
+values ($1, $2, $3, $4, $5, $6);
+
+-- name: ListChirpEntities :many
+select * from chirp_entities
+where chirp_id = any(sqlc.arg(chirp_ids)::uuid[])
+order by chirp_id, start_offset;
+
+-- name: DeleteChirpEntities :exec
+delete from chirp_entities
+where chirp_id = $1;
+
+-- name: ResolveMentions :execrows
+update chirp_entities
+set user_id = $2
+where type = 'mention' and text = $1 and user_id is null
+    and not exists (
+        select 1 from chirps
+        join blocks on blocks.blocked_id = chirps.user_id
+        where chirps.id = chirp_entities.chirp_id and blocks.blocker_id = $2
+    );
+
+-- name: ListChirpsForHashtag :many
+select * from chirps
+where
+    status = 'published' and deleted_at is null and id in (
+        select chirp_id from chirp_entities
+        where type = 'hashtag' and text = @text
+    )
+    and not exists (
+        select 1 from blocks
+        where blocker_id = chirps.user_id and blocked_id = @viewer_id
+    )
+    and not exists (
+        select 1 from mutes
+        where muter_id = @viewer_id and muted_id = chirps.user_id
+    )
+order by created_at asc;
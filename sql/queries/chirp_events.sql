@@ -0,0 +1,43 @@
+-- name: CreateChirpEvent :one
+-- Streams resume after the last ID they saw, so IDs must increase in
+-- commit order. The lock is taken before the ID and held until the
+-- transaction commits, so an event waits for any transaction that
+-- recorded one before it.
+insert into chirp_events (type, user_id, data, created_at)
+select
+    $1,
+    $2,
+    $3,
+    now()
+from (select pg_advisory_xact_lock(hashtext('chirp_events'))) as commit_order
+returning *;
+
+-- name: GetChirpEvent :one
+select
+    id,
+    type,
+    user_id,
+    data,
+    created_at
+from
+    chirp_events
+where
+    id = $1;
+
+-- name: ListChirpEventsAfter :many
+select
+    id,
+    type,
+    user_id,
+    data,
+    created_at
+from
+    chirp_events
+where
+    id > $1
+order by id asc
+limit $2;
+
+-- name: DeleteChirpEventsBefore :exec
+delete from chirp_events
+where created_at < $1;
@@ -1,50 +1,125 @@
 -- name: CreateChirp :one
-insert into chirps (id, created_at, updated_at, body, user_id)
+insert into chirps (id, created_at, updated_at, body, user_id, status, publish_at)
 values (
     gen_random_uuid(),
     now(),
     now(),
     $1,
-    $2
+    $2,
+    $3,
+    $4
 )
 returning *;
 
 -- name: ListChirps :many
-select
-    id,
-    created_at,
-    updated_at,
-    body,
-    user_id
-from
-    chirps
+select * from chirps
+where
+    status = 'published' and deleted_at is null
+    and not exists (
+        select 1 from blocks
+        where blocker_id = chirps.user_id and blocked_id = @viewer_id
+    )
+    and not exists (
+        select 1 from mutes
+        where muter_id = @viewer_id and muted_id = chirps.user_id
+    )
 order by created_at asc;
 
 -- name: ListChirpsForAuthor :many
-select
-    id,
-    created_at,
-    updated_at,
-    body,
-    user_id
-from
-    chirps
-where
-    user_id = $1
+select * from chirps
+where
+    user_id = @user_id and status = 'published' and deleted_at is null
+    and not exists (
+        select 1 from blocks
+        where blocker_id = @user_id and blocked_id = @viewer_id
+    )
+order by created_at asc;
+
+-- name: ListUnpublishedChirps :many
+select * from chirps
+where
+    user_id = $1 and status <> 'published' and deleted_at is null
 order by created_at asc;
 
 -- name: GetChirp :one
-select
-    id,
-    created_at,
-    updated_at,
-    body,
-    user_id
-from
-    chirps
+select * from chirps
+where
+    id = $1 and deleted_at is null;
+
+-- name: GetDeletedChirp :one
+select * from chirps
 where
-    id = $1;
+    id = $1 and deleted_at is not null;
 
 -- name: DeleteChirp :exec
 delete from chirps
-where id = $1;
\ No newline at end of file
+where id = $1;
+
+-- name: UpdateChirp :one
+update chirps
+set
+    body = $1,
+    updated_at = now()
+where
+    id = $2 and updated_at = $3 and deleted_at is null
+returning *;
+
+-- name: SoftDeleteChirp :execrows
+update chirps
+set
+    deleted_at = now()
+where
+    id = $1 and updated_at = $2 and deleted_at is null;
+
+-- name: RestoreChirp :one
+update chirps
+set
+    deleted_at = null
+where
+    id = $1 and deleted_at > sqlc.arg(deleted_after)::timestamp and not hidden
+returning *;
+
+-- name: HideChirp :one
+update chirps
+set
+    hidden = true,
+    deleted_at = coalesce(deleted_at, now())
+where
+    id = $1
+returning *;
+
+-- name: PurgeDeletedChirps :execrows
+delete from chirps
+where deleted_at < sqlc.arg(before)::timestamp;
+
+-- name: PublishChirp :one
+update chirps
+set
+    status = 'published',
+    created_at = now(),
+    updated_at = now()
+where
+    id = $1 and updated_at = $2 and status <> 'published' and deleted_at is null
+returning *;
+
+-- name: PublishDueChirps :many
+-- Every replica runs the scheduler. skip locked lets them share the due
+-- chirps rather than wait on each other, and the update returns each chirp
+-- to exactly one of them.
+with due as (
+    select id from chirps
+    where
+        status = 'scheduled' and publish_at <= sqlc.arg(now)::timestamp
+        and deleted_at is null
+    order by publish_at
+    limit $2
+    for update skip locked
+)
+update chirps
+set
+    status = 'published',
+    created_at = now(),
+    updated_at = now()
+from due
+where chirps.id = due.id
+returning chirps.*;
@@ -0,0 +1,21 @@
+-- name: FollowUser :execrows
+insert into follows (follower_id, followee_id, created_at)
+values (
+    $1,
+    $2,
+    now()
+)
+on conflict (follower_id, followee_id) do nothing;
+
+-- name: UnfollowUser :exec
+delete from follows
+where follower_id = $1 and followee_id = $2;
+
+-- name: ListFollowees :many
+select
+    followee_id
+from
+    follows
+where
+    follower_id = $1
+order by created_at asc;
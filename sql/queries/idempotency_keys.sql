@@ -0,0 +1,46 @@
+-- name: CreateIdempotencyKey :one
+insert into idempotency_keys (
+    scope,
+    key,
+    request_hash,
+    created_at,
+    expires_at
+) values (
+    $1,
+    $2,
+    $3,
+    now(),
+    $4
+) returning *;
+
+-- name: GetIdempotencyKey :one
+select
+    scope,
+    key,
+    request_hash,
+    created_at,
+    expires_at,
+    response_status,
+    response_body,
+    response_headers
+from
+    idempotency_keys
+where
+    scope = $1 and key = $2;
+
+-- name: CompleteIdempotencyKey :exec
+update idempotency_keys
+set
+    response_status = $3,
+    response_body = $4,
+    response_headers = $5
+where
+    scope = $1 and key = $2;
+
+-- name: DeleteIdempotencyKey :exec
+delete from idempotency_keys
+where scope = $1 and key = $2;
+
+-- name: DeleteExpiredIdempotencyKeys :exec
+delete from idempotency_keys
+where expires_at < $1;
@@ -0,0 +1,27 @@
+-- name: UpsertLinkPreview :exec
+insert into link_previews (url, status, title, description, image_url, site_name, fetched_at)
+values (
+    $1,
+    $2,
+    $3,
+    $4,
+    $5,
+    $6,
+    now()
+)
+on conflict (url) do update
+set
+    status = excluded.status,
+    title = excluded.title,
+    description = excluded.description,
+    image_url = excluded.image_url,
+    site_name = excluded.site_name,
+    fetched_at = excluded.fetched_at;
+
+-- name: GetLinkPreview :one
+select * from link_previews
+where url = $1;
+
+-- name: ListLinkPreviews :many
+select * from link_previews
+where url = any(sqlc.arg(urls)::text[]);
@@ -0,0 +1,38 @@
+-- name: CreateMedia :one
+insert into media (id, user_id, content_type, size, width, height, created_at)
+values (
+    $1,
+    $2,
+    $3,
+    $4,
+    $5,
+    $6,
+    now()
+)
+returning *;
+
+-- name: GetMedia :one
+select * from media
+where id = $1;
+
+-- name: AttachMedia :execrows
+update media
+set
+    chirp_id = $1,
+    position = $2
+where
+    id = $3 and user_id = $4 and chirp_id is null;
+
+-- name: ListMediaForChirps :many
+select * from media
+where chirp_id = any(sqlc.arg(chirp_ids)::uuid[])
+order by chirp_id, position;
+
+-- name: ListUnattachedMediaBefore :many
+select * from media
+where chirp_id is null and created_at < $1
+order by created_at;
+
+-- name: DeleteUnattachedMedia :execrows
+delete from media
+where id = $1 and chirp_id is null;
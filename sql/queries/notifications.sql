@@ -0,0 +1,49 @@
+-- name: CreateNotification :one
+insert into notifications (id, user_id, actor_id, type, chirp_id, created_at)
+values (
+    gen_random_uuid(),
+    $1,
+    $2,
+    $3,
+    $4,
+    now()
+)
+returning *;
+
+-- name: GetNotification :one
+select * from notifications
+where id = $1;
+
+-- name: ListNotifications :many
+select * from notifications
+where user_id = $1
+order by created_at desc, id desc
+limit $2;
+
+-- name: ListNotificationsBefore :many
+select * from notifications
+where user_id = $1 and (created_at, id) < (sqlc.arg(created_at)::timestamp, sqlc.arg(id)::uuid)
+order by created_at desc, id desc
+limit $2;
+
+-- name: CountUnreadNotifications :one
+select count(*) from notifications
+where user_id = $1 and read_at is null;
+
+-- name: MarkNotificationRead :execrows
+update notifications set read_at = now()
+where id = $1 and user_id = $2 and read_at is null;
+
+-- name: MarkAllNotificationsRead :execrows
+update notifications set read_at = now()
+where user_id = $1 and read_at is null;
+
+-- name: ListNotificationPreferences :many
+select * from notification_preferences
+where user_id = $1
+order by type asc;
+
+-- name: SetNotificationPreference :exec
+insert into notification_preferences (user_id, type, enabled)
+values ($1, $2, $3)
+on conflict (user_id, type) do update set enabled = excluded.enabled;
@@ -0,0 +1,18 @@
+-- name: PinChirp :exec
+insert into pinned_chirps (user_id, chirp_id, created_at)
+values (
+    $1,
+    $2,
+    now()
+)
+on conflict (user_id) do update
+set chirp_id = excluded.chirp_id, created_at = excluded.created_at;
+
+-- name: UnpinChirp :execrows
+delete from pinned_chirps
+where user_id = $1 and chirp_id = $2;
+
+-- name: GetPinnedChirp :one
+select chirps.* from pinned_chirps
+join chirps on chirps.id = pinned_chirps.chirp_id
+where pinned_chirps.user_id = $1 and chirps.deleted_at is null;
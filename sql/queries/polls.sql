@@ -0,0 +1,42 @@
+-- name: CreatePoll :one
+insert into polls (chirp_id, closes_at, created_at)
+values (
+    $1,
+    $2,
+    now()
+)
+returning *;
+
+-- name: CreatePollOption :one
+insert into poll_options (id, chirp_id, position, text)
+values (
+    gen_random_uuid(),
+    $1,
+    $2,
+    $3
+)
+returning *;
+
+-- name: ListPollsForChirps :many
+select * from polls
+where chirp_id = any($1::uuid[]);
+
+-- name: ListPollOptionsForChirps :many
+select poll_options.*, count(poll_votes.user_id) as votes
+from poll_options
+left join poll_votes on poll_votes.option_id = poll_options.id
+where poll_options.chirp_id = any($1::uuid[])
+group by poll_options.id
+order by poll_options.chirp_id, poll_options.position;
+
+-- name: ListPollVotesForUser :many
+select * from poll_votes
+where user_id = $1 and chirp_id = any(sqlc.arg(chirp_ids)::uuid[]);
+
+-- name: VotePoll :one
+-- Inserts nothing, and so returns no rows, once the poll has closed.
+insert into poll_votes (chirp_id, user_id, option_id, created_at)
+select polls.chirp_id, $2, $3, now()
+from polls
+where polls.chirp_id = $1 and polls.closes_at > now()
+returning *;
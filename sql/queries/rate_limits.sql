@@ -0,0 +1,34 @@
+-- name: CreateRateLimitBucket :exec
+insert into rate_limit_buckets (key, tokens, updated_at, full_at)
+values (
+    $1,
+    $2,
+    $3,
+    $3
+)
+on conflict (key) do nothing;
+
+-- name: GetRateLimitBucketForUpdate :one
+select
+    key,
+    tokens,
+    updated_at,
+    full_at
+from
+    rate_limit_buckets
+where
+    key = $1
+for update;
+
+-- name: UpdateRateLimitBucket :exec
+update rate_limit_buckets
+set
+    tokens = $2,
+    updated_at = $3,
+    full_at = $4
+where
+    key = $1;
+
+-- name: DeleteFullRateLimitBuckets :exec
+delete from rate_limit_buckets
+where full_at < $1;
@@ -0,0 +1,51 @@
+-- name: CreateReport :one
+insert into reports (id, reporter_id, user_id, chirp_id, chirp_body, reason, details, created_at)
+values (
+    gen_random_uuid(),
+    $1,
+    $2,
+    $3,
+    $4,
+    $5,
+    $6,
+    now()
+)
+returning *;
+
+-- name: GetReport :one
+select * from reports
+where id = $1;
+
+-- name: ListOpenReports :many
+select * from reports
+where resolved_at is null
+order by created_at asc, id asc
+limit $1;
+
+-- name: ResolveReport :one
+update reports
+set
+    resolved_at = now()
+where
+    id = $1 and resolved_at is null
+returning *;
+
+-- name: CreateModerationAction :one
+insert into moderation_actions (id, moderator_id, user_id, chirp_id, report_id, action, note, created_at)
+values (
+    gen_random_uuid(),
+    $1,
+    $2,
+    $3,
+    $4,
+    $5,
+    $6,
+    now()
+)
+returning *;
+
+-- name: ListModerationActions :many
+select * from moderation_actions
+where sqlc.narg(user_id)::uuid is null or user_id = sqlc.narg(user_id)::uuid
+order by created_at desc, id desc
+limit sqlc.arg(limit_count);
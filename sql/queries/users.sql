@@ -1,11 +1,12 @@
 -- name: CreateUser :one
-insert into users (id, created_at, updated_at, email, hashed_password)
+insert into users (id, created_at, updated_at, email, hashed_password, username)
 values (
     gen_random_uuid(),
     now(),
     now(),
     $1,
-    $2
+    $2,
+    $3
 )
 returning *;
 
@@ -16,12 +17,37 @@ select
     updated_at,
     email,
     hashed_password,
-    is_chirpy_red
+    is_chirpy_red,
+    username,
+    display_name,
+    bio,
+    avatar_url,
+    role,
+    suspended_at
 from 
     users
 where
     email = $1;
 
+-- name: GetUser :one
+select
+    id,
+    created_at,
+    updated_at,
+    email,
+    hashed_password,
+    is_chirpy_red,
+    username,
+    display_name,
+    bio,
+    avatar_url,
+    role,
+    suspended_at
+from
+    users
+where
+    id = $1;
+
 -- name: UpdateUser :one
 update users
 set
@@ -33,6 +59,10 @@ where
 returning *;
 
 -- name: DeleteUsers :exec
+-- The moderation log doesn't cascade with users, so it is emptied too.
+with deleted_actions as (
+    delete from moderation_actions
+)
 delete from users;
 
 -- name: UpgradeToChirpyRed :one
@@ -40,4 +70,77 @@ update users
 set
     is_chirpy_red = true
 where id = $1
-returning *;
\ No newline at end of file
+returning *;
+
+-- name: GetUserByUsername :one
+select
+    id,
+    created_at,
+    updated_at,
+    email,
+    hashed_password,
+    is_chirpy_red,
+    username,
+    display_name,
+    bio,
+    avatar_url,
+    role,
+    suspended_at
+from
+    users
+where
+    username = $1;
+
+-- name: ListUsersByIDs :many
+select
+    id,
+    created_at,
+    updated_at,
+    email,
+    hashed_password,
+    is_chirpy_red,
+    username,
+    display_name,
+    bio,
+    avatar_url,
+    role,
+    suspended_at
+from
+    users
+where
+    id = any(sqlc.arg(ids)::uuid[]);
+
+-- name: UpdateUserProfile :one
+update users
+set
+    username = $1,
+    display_name = $2,
+    bio = $3,
+    avatar_url = $4,
+    updated_at = now()
+where
+    id = $5
+returning *;
+
+-- name: GetUserCounts :one
+select
+    (select count(*) from chirps where user_id = $1 and status = 'published' and deleted_at is null) as chirp_count,
+    (select count(*) from follows where followee_id = $1) as follower_count,
+    (select count(*) from follows where follower_id = $1) as following_count;
+
+-- name: SetUserRole :one
+update users
+set
+    role = $1,
+    updated_at = now()
+where
+    id = $2
+returning *;
+
+-- name: SuspendUser :one
+update users
+set
+    suspended_at = coalesce(suspended_at, now())
+where
+    id = $1
+returning *;
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/vemolista/chirpy/v2/internal/config"
+	"github.com/vemolista/chirpy/v2/internal/database"
+	"github.com/vemolista/chirpy/v2/internal/metrics"
+	"github.com/vemolista/chirpy/v2/internal/store"
+	"github.com/vemolista/chirpy/v2/internal/tracing"
+)
+
+// openStore opens the configured storage backend. The returned connection
+// is nil for the in-memory store.
+func openStore(ctx context.Context, conf config.Config, appMetrics *metrics.Metrics) (store.Store, *sql.DB, error) {
+	instrument := func(db database.DBTX) database.DBTX {
+		return tracing.InstrumentDB(appMetrics.InstrumentDB(db))
+	}
+
+	switch conf.Storage {
+	case config.StorageMemory:
+		return store.NewMemory(), nil, nil
+	case config.StorageSQLite:
+		conn, err := store.OpenSQLite(ctx, conf.DBURL)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		appMetrics.RegisterDB(conn, "chirpy")
+
+		return store.NewSQLite(store.NewDB(conn, instrument)), conn, nil
+	default:
+		conn, err := sql.Open("postgres", conf.DBURL)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error opening a database connection: %w", err)
+		}
+
+		appMetrics.RegisterDB(conn, "chirpy")
+
+		return store.NewPostgres(store.NewDB(conn, instrument)), conn, nil
+	}
+}
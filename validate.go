@@ -0,0 +1,157 @@
+package main
+
+import (
+	"fmt"
+	"net/mail"
+	"net/url"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// validateStruct checks the `validate` tags on the fields of the struct v
+// points to, descending into nested structs and non-nil pointers to them.
+// The supported rules are:
+//
+//	required  the field must not be its zero value
+//	email     a non-empty string must be a bare email address
+//	max=N     a string must be at most N characters long
+//	username  a non-empty string must be an available username, see
+//	          checkUsername
+//	url       a non-empty string must be an absolute http or https URL
+//	oneof=A B a non-empty string must be one of the space-separated values
+//
+// The other rules skip nil pointers and check what non-nil ones point to.
+// Fields are reported by their JSON name, joined with dots for nested
+// structs.
+func validateStruct(v any) []fieldError {
+	value := reflect.Indirect(reflect.ValueOf(v))
+	if value.Kind() != reflect.Struct {
+		return nil
+	}
+
+	return validateFields(value, "")
+}
+
+func validateFields(value reflect.Value, prefix string) []fieldError {
+	var errs []fieldError
+
+	for i := 0; i < value.NumField(); i++ {
+		field := value.Type().Field(i)
+		if !field.IsExported() {
+			continue
+		}
+
+		name := prefix + jsonName(field)
+		fieldValue := value.Field(i)
+
+		for _, rule := range strings.Split(field.Tag.Get("validate"), ",") {
+			if rule == "" {
+				continue
+			}
+
+			if fieldErr, ok := checkRule(fieldValue, rule); !ok {
+				fieldErr.Field = name
+				errs = append(errs, fieldErr)
+				break
+			}
+		}
+
+		nested := fieldValue
+		if nested.Kind() == reflect.Pointer && !nested.IsNil() {
+			nested = nested.Elem()
+		}
+
+		if nested.Kind() == reflect.Struct {
+			errs = append(errs, validateFields(nested, name+".")...)
+		}
+	}
+
+	return errs
+}
+
+func checkRule(value reflect.Value, rule string) (fieldError, bool) {
+	name, arg, _ := strings.Cut(rule, "=")
+
+	if value.Kind() == reflect.Pointer && name != "required" {
+		if value.IsNil() {
+			return fieldError{}, true
+		}
+
+		value = value.Elem()
+	}
+
+	switch name {
+	case "required":
+		if value.IsZero() {
+			return fieldError{Code: "required", Message: "is required"}, false
+		}
+
+	case "email":
+		s := value.String()
+		if s == "" {
+			return fieldError{}, true
+		}
+
+		addr, err := mail.ParseAddress(s)
+		if err != nil || addr.Address != s {
+			return fieldError{Code: "email", Message: "must be an email address"}, false
+		}
+
+	case "max":
+		limit, err := strconv.Atoi(arg)
+		if err != nil {
+			panic(fmt.Sprintf("validate: bad max rule %q", rule))
+		}
+
+		if utf8.RuneCountInString(value.String()) > limit {
+			return fieldError{Code: "max_length", Message: fmt.Sprintf("must be at most %d characters", limit)}, false
+		}
+
+	case "username":
+		s := value.String()
+		if s == "" {
+			return fieldError{}, true
+		}
+
+		return checkUsername(s)
+
+	case "url":
+		s := value.String()
+		if s == "" {
+			return fieldError{}, true
+		}
+
+		u, err := url.Parse(s)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fieldError{Code: "url", Message: "must be an http or https URL"}, false
+		}
+
+	case "oneof":
+		s := value.String()
+		if s == "" {
+			return fieldError{}, true
+		}
+
+		allowed := strings.Fields(arg)
+		if !slices.Contains(allowed, s) {
+			return fieldError{Code: "one_of", Message: "must be one of " + strings.Join(allowed, ", ")}, false
+		}
+
+	default:
+		panic(fmt.Sprintf("validate: unknown rule %q", rule))
+	}
+
+	return fieldError{}, true
+}
+
+func jsonName(field reflect.StructField) string {
+	name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+	if name == "" || name == "-" {
+		return field.Name
+	}
+
+	return name
+}